@@ -0,0 +1,65 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users_urls DROP CONSTRAINT FK_USERS_UR_REFERENCE_SHORT_TO;
+ALTER TABLE users_urls DROP CONSTRAINT PK_USERS_URLS;
+ALTER TABLE url_redirects DROP CONSTRAINT PK_SHORT_TO_FULL_URL_MAP;
+
+ALTER TABLE url_redirects
+    ALTER COLUMN original_url TYPE TEXT,
+    ADD COLUMN original_url_hash CHAR(64);
+
+UPDATE url_redirects
+    SET original_url_hash = encode(sha256(convert_to(original_url, 'UTF8')), 'hex');
+
+ALTER TABLE url_redirects
+    ALTER COLUMN original_url_hash SET NOT NULL,
+    ADD CONSTRAINT PK_SHORT_TO_FULL_URL_MAP PRIMARY KEY (original_url_hash);
+
+ALTER TABLE users_urls
+    ADD COLUMN url_hash CHAR(64);
+
+UPDATE users_urls
+    SET url_hash = encode(sha256(convert_to(url, 'UTF8')), 'hex');
+
+ALTER TABLE users_urls
+    ALTER COLUMN url_hash SET NOT NULL,
+    DROP COLUMN url,
+    ADD CONSTRAINT PK_USERS_URLS PRIMARY KEY (user_id, url_hash);
+
+ALTER TABLE users_urls
+    ADD CONSTRAINT FK_USERS_UR_REFERENCE_SHORT_TO FOREIGN KEY (url_hash)
+        REFERENCES url_redirects (original_url_hash)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- Fails if URLs longer than 255 characters have been stored since the upgrade.
+-- +goose StatementBegin
+ALTER TABLE users_urls DROP CONSTRAINT FK_USERS_UR_REFERENCE_SHORT_TO;
+ALTER TABLE users_urls DROP CONSTRAINT PK_USERS_URLS;
+
+ALTER TABLE users_urls
+    ADD COLUMN url VARCHAR(255);
+
+UPDATE users_urls
+    SET url = url_redirects.original_url
+    FROM url_redirects
+    WHERE url_redirects.original_url_hash = users_urls.url_hash;
+
+ALTER TABLE url_redirects DROP CONSTRAINT PK_SHORT_TO_FULL_URL_MAP;
+
+ALTER TABLE url_redirects
+    DROP COLUMN original_url_hash,
+    ALTER COLUMN original_url TYPE VARCHAR(255),
+    ADD CONSTRAINT PK_SHORT_TO_FULL_URL_MAP PRIMARY KEY (original_url);
+
+ALTER TABLE users_urls
+    ALTER COLUMN url SET NOT NULL,
+    DROP COLUMN url_hash,
+    ADD CONSTRAINT PK_USERS_URLS PRIMARY KEY (user_id, url);
+
+ALTER TABLE users_urls
+    ADD CONSTRAINT FK_USERS_UR_REFERENCE_SHORT_TO FOREIGN KEY (url)
+        REFERENCES url_redirects (original_url)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+-- +goose StatementEnd
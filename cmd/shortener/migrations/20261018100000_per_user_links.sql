@@ -0,0 +1,93 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users_urls DROP CONSTRAINT FK_USERS_UR_REFERENCE_SHORT_TO;
+ALTER TABLE users_urls DROP CONSTRAINT PK_USERS_URLS;
+ALTER TABLE url_redirects DROP CONSTRAINT PK_SHORT_TO_FULL_URL_MAP;
+DROP INDEX uq_short;
+
+ALTER TABLE url_redirects
+    ADD COLUMN owner_id UUID NULL,
+    ADD CONSTRAINT PK_SHORT_TO_FULL_URL_MAP PRIMARY KEY (short);
+
+ALTER TABLE url_redirects
+    ADD CONSTRAINT FK_URL_REDI_REFERENCE_USERS FOREIGN KEY (owner_id)
+        REFERENCES users (user_id)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- Shared links (no owner) are deduplicated globally, owned links per owner.
+CREATE UNIQUE INDEX uq_shared_original_url_hash ON url_redirects (original_url_hash) WHERE owner_id IS NULL;
+CREATE UNIQUE INDEX uq_owned_original_url_hash ON url_redirects (original_url_hash, owner_id) WHERE owner_id IS NOT NULL;
+
+-- Users are now linked to short keys, each link carrying its own deletion state.
+ALTER TABLE users_urls
+    ADD COLUMN short      VARCHAR(255),
+    ADD COLUMN is_deleted BOOL NOT NULL DEFAULT FALSE;
+
+UPDATE users_urls
+    SET short      = url_redirects.short,
+        is_deleted = url_redirects.is_deleted
+    FROM url_redirects
+    WHERE url_redirects.original_url_hash = users_urls.url_hash;
+
+ALTER TABLE users_urls
+    ALTER COLUMN short SET NOT NULL,
+    DROP COLUMN url_hash,
+    ADD CONSTRAINT PK_USERS_URLS PRIMARY KEY (user_id, short);
+
+ALTER TABLE users_urls
+    ADD CONSTRAINT FK_USERS_UR_REFERENCE_SHORT_TO FOREIGN KEY (short)
+        REFERENCES url_redirects (short)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- Per-user links are collapsed into a single shared link per original URL.
+-- +goose StatementBegin
+ALTER TABLE users_urls DROP CONSTRAINT FK_USERS_UR_REFERENCE_SHORT_TO;
+ALTER TABLE users_urls DROP CONSTRAINT PK_USERS_URLS;
+
+ALTER TABLE users_urls
+    ADD COLUMN url_hash CHAR(64);
+
+UPDATE users_urls
+    SET url_hash = url_redirects.original_url_hash
+    FROM url_redirects
+    WHERE url_redirects.short = users_urls.short;
+
+DELETE FROM url_redirects
+    WHERE short NOT IN (
+        SELECT DISTINCT ON (original_url_hash) short
+            FROM url_redirects
+            ORDER BY original_url_hash, owner_id NULLS FIRST
+    );
+
+DELETE FROM users_urls
+    WHERE ctid NOT IN (
+        SELECT DISTINCT ON (user_id, url_hash) ctid
+            FROM users_urls
+            ORDER BY user_id, url_hash
+    );
+
+ALTER TABLE users_urls
+    DROP COLUMN short,
+    DROP COLUMN is_deleted,
+    ALTER COLUMN url_hash SET NOT NULL,
+    ADD CONSTRAINT PK_USERS_URLS PRIMARY KEY (user_id, url_hash);
+
+DROP INDEX uq_owned_original_url_hash;
+DROP INDEX uq_shared_original_url_hash;
+
+ALTER TABLE url_redirects DROP CONSTRAINT FK_URL_REDI_REFERENCE_USERS;
+ALTER TABLE url_redirects DROP CONSTRAINT PK_SHORT_TO_FULL_URL_MAP;
+
+ALTER TABLE url_redirects
+    DROP COLUMN owner_id,
+    ADD CONSTRAINT PK_SHORT_TO_FULL_URL_MAP PRIMARY KEY (original_url_hash);
+
+CREATE UNIQUE INDEX uq_short ON url_redirects (short);
+
+ALTER TABLE users_urls
+    ADD CONSTRAINT FK_USERS_UR_REFERENCE_SHORT_TO FOREIGN KEY (url_hash)
+        REFERENCES url_redirects (original_url_hash)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE url_redirects_history
+(
+    id           BIGSERIAL    NOT NULL,
+    short        VARCHAR(255) NOT NULL,
+    original_url TEXT         NOT NULL,
+    changed_by   UUID         NULL,
+    changed_at   TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    CONSTRAINT PK_URL_REDIRECTS_HISTORY PRIMARY KEY (id)
+);
+
+CREATE INDEX ix_url_redirects_history_short ON url_redirects_history (short);
+
+ALTER TABLE url_redirects_history
+    ADD CONSTRAINT FK_URL_REDI_HIST_REFERENCE_URL_REDI FOREIGN KEY (short)
+        REFERENCES url_redirects (short)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE url_redirects_history
+    ADD CONSTRAINT FK_URL_REDI_HIST_REFERENCE_USERS FOREIGN KEY (changed_by)
+        REFERENCES users (user_id)
+        ON DELETE SET NULL ON UPDATE CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE url_redirects_history;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE users_urls_tags
+(
+    user_id UUID         NOT NULL,
+    short   VARCHAR(255) NOT NULL,
+    tag     VARCHAR(64)  NOT NULL,
+    CONSTRAINT PK_USERS_URLS_TAGS PRIMARY KEY (user_id, short, tag)
+);
+
+-- Tags are attached to the user's link, so they go away together with it.
+ALTER TABLE users_urls_tags
+    ADD CONSTRAINT FK_USERS_UR_TAGS_REFERENCE_USERS_UR FOREIGN KEY (user_id, short)
+        REFERENCES users_urls (user_id, short)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE INDEX ix_users_urls_tags_user_id_tag ON users_urls_tags (user_id, tag);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE users_urls_tags;
+-- +goose StatementEnd
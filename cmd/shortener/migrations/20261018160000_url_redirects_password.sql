@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- An empty hash means the link is not password-protected.
+ALTER TABLE url_redirects
+    ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    DROP COLUMN password_hash;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Zero max_clicks means the number of redirects is not limited.
+ALTER TABLE url_redirects
+    ADD COLUMN max_clicks  INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN clicks_left INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE url_redirects
+    ADD CONSTRAINT CK_URL_REDI_CLICKS CHECK (max_clicks >= 0 AND clicks_left >= 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    DROP CONSTRAINT CK_URL_REDI_CLICKS,
+    DROP COLUMN clicks_left,
+    DROP COLUMN max_clicks;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A link with active_from in the future does not redirect yet; NULL means it is always active.
+ALTER TABLE url_redirects
+    ADD COLUMN active_from TIMESTAMPTZ NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    DROP COLUMN active_from;
+-- +goose StatementEnd
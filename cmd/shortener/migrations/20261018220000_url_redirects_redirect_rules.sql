@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Ordered list of the rules sending matching visitors elsewhere than original_url, see models.RedirectRule.
+ALTER TABLE url_redirects
+    ADD COLUMN redirect_rules JSONB NOT NULL DEFAULT '[]';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    DROP COLUMN redirect_rules;
+-- +goose StatementEnd
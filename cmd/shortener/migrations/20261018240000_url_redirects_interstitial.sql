@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The "you are leaving" page is shown instead of redirecting to a domain that is not trusted.
+ALTER TABLE url_redirects
+    ADD COLUMN interstitial BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    DROP COLUMN interstitial;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Refresh tokens are kept as SHA-256 hashes, so that the table does not leak usable tokens.
+CREATE TABLE refresh_tokens
+(
+    token_hash VARCHAR(64) NOT NULL,
+    user_id    UUID        NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT PK_REFRESH_TOKENS PRIMARY KEY (token_hash)
+);
+
+ALTER TABLE refresh_tokens
+    ADD CONSTRAINT FK_REFRESH__REFERENCE_USERS FOREIGN KEY (user_id)
+        REFERENCES users (user_id)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE INDEX ix_refresh_tokens_expires_at ON refresh_tokens (expires_at);
+
+-- The IDs of the access tokens revoked before their expiry, kept until they expire.
+CREATE TABLE revoked_tokens
+(
+    token_id   UUID        NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    CONSTRAINT PK_REVOKED_TOKENS PRIMARY KEY (token_id)
+);
+
+CREATE INDEX ix_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE revoked_tokens;
+DROP TABLE refresh_tokens;
+-- +goose StatementEnd
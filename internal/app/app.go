@@ -20,12 +20,19 @@ import (
 	"github.com/patric-chuzhbe/urlshrt/internal/auth"
 	"github.com/patric-chuzhbe/urlshrt/internal/router"
 
+	"github.com/patric-chuzhbe/urlshrt/internal/attemptslimiter"
 	"github.com/patric-chuzhbe/urlshrt/internal/config"
 	"github.com/patric-chuzhbe/urlshrt/internal/db/jsondb"
 	"github.com/patric-chuzhbe/urlshrt/internal/db/memorystorage"
 	"github.com/patric-chuzhbe/urlshrt/internal/db/postgresdb"
+	"github.com/patric-chuzhbe/urlshrt/internal/domainpolicy"
 	"github.com/patric-chuzhbe/urlshrt/internal/logger"
 	"github.com/patric-chuzhbe/urlshrt/internal/models"
+	"github.com/patric-chuzhbe/urlshrt/internal/tokenspurger"
+	"github.com/patric-chuzhbe/urlshrt/internal/urlcanonicalizer"
+	"github.com/patric-chuzhbe/urlshrt/internal/urlsafety"
+	"github.com/patric-chuzhbe/urlshrt/internal/urlscanner"
+	"github.com/patric-chuzhbe/urlshrt/internal/urlspurger"
 	"github.com/patric-chuzhbe/urlshrt/internal/urlsremover"
 	"github.com/patric-chuzhbe/urlshrt/internal/user"
 )
@@ -38,32 +45,82 @@ type UserKeeper interface {
 
 	// GetUserByID retrieves a user by their ID. If not found, returns a user with an empty ID.
 	GetUserByID(ctx context.Context, userID string, transaction *sql.Tx) (*user.User, error)
+
+	// SetUserRole changes the role of the user to one of the user role constants.
+	SetUserRole(ctx context.Context, userID, role string) error
 }
 
 // UserUrlsKeeper is an interface that defines methods for managing URLs associated with users.
 type UserUrlsKeeper interface {
-	// GetUserUrls retrieves all short-to-full URL mappings for a given user.
+	// GetUserUrls retrieves the page of the user's short-to-full URL mappings defined by query.
 	// Optionally applies a formatter to each short URL before returning.
 	GetUserUrls(
 		ctx context.Context,
 		userID string,
+		query models.UserURLsQuery,
 		shortURLFormatter models.URLFormatter,
 	) (models.UserUrls, error)
 
-	// SaveUserUrls stores mappings between a user and a list of full URLs.
+	// SaveUserUrls stores mappings between a user and a list of short URLs.
 	// It uses an UPSERT strategy and runs within an existing transaction.
 	SaveUserUrls(
 		ctx context.Context,
 		userID string,
-		urls []string,
+		shortURLs []string,
 		transaction *sql.Tx,
 	) error
 
+	// RetargetUserURL changes the original URL of a short URL owned by the user
+	// and records the previous one in the redirects history.
+	RetargetUserURL(ctx context.Context, userID, short, full string) error
+
+	// SetUserURLActiveFrom changes the time before which a short URL owned by the user does not redirect.
+	SetUserURLActiveFrom(ctx context.Context, userID, short string, activeFrom time.Time) error
+
+	// SetUserURLRedirectRules replaces the redirect rules of a short URL owned by the user.
+	SetUserURLRedirectRules(ctx context.Context, userID, short string, rules models.RedirectRules) error
+
+	// GetUserURLRedirectRules returns the redirect rules of the user's short URL.
+	GetUserURLRedirectRules(ctx context.Context, userID, short string) (models.RedirectRules, error)
+
+	// SetUserURLDestinations replaces the A/B destinations of a short URL owned by the user.
+	SetUserURLDestinations(ctx context.Context, userID, short string, destinations models.Destinations) error
+
+	// GetUserURLDestinations returns the A/B destinations of the user's short URL.
+	GetUserURLDestinations(ctx context.Context, userID, short string) (models.Destinations, error)
+
 	// RemoveUsersUrls removes URLs for a given user.
 	RemoveUsersUrls(
 		ctx context.Context,
 		usersURLs map[string][]string,
 	) error
+
+	// RestoreUsersUrls undoes the deletion of the user's URLs deleted after deletedAfter
+	// and returns the restored ones.
+	RestoreUsersUrls(
+		ctx context.Context,
+		userID string,
+		shortURLs []string,
+		deletedAfter time.Time,
+	) ([]string, error)
+
+	// PurgeDeletedUrls permanently removes the URLs deleted before deletedBefore.
+	PurgeDeletedUrls(ctx context.Context, deletedBefore time.Time) (int64, error)
+
+	// AddUserURLsTags attaches the tags to the user's URLs and returns the tagged ones.
+	AddUserURLsTags(ctx context.Context, userID string, shortURLs []string, tags []string) ([]string, error)
+
+	// RemoveUserURLsTags detaches the tags from the user's URLs and returns the affected ones.
+	RemoveUserURLsTags(ctx context.Context, userID string, shortURLs []string, tags []string) ([]string, error)
+
+	// GetUserTags returns the tags of the user with the number of URLs carrying each.
+	GetUserTags(ctx context.Context, userID string) (models.UserTags, error)
+
+	// GetUserUTMDefaults returns the default UTM parameters of the user.
+	GetUserUTMDefaults(ctx context.Context, userID string) (models.UTMParams, error)
+
+	// SetUserUTMDefaults replaces the default UTM parameters of the user.
+	SetUserUTMDefaults(ctx context.Context, userID string, defaults models.UTMParams) error
 }
 
 // Transactioner defines methods for handling database transactions.
@@ -81,38 +138,121 @@ type Transactioner interface {
 // URLsMapper is an interface for mapping between full URLs and short URLs.
 type URLsMapper interface {
 	// FindShortsByFulls retrieves all known short URLs for the given list of full URLs.
+	// An empty ownerID limits the lookup to shared links.
 	FindShortsByFulls(
 		ctx context.Context,
 		originalUrls []string,
+		ownerID string,
 		transaction *sql.Tx,
 	) (map[string]string, error)
 
-	// SaveNewFullsAndShorts stores new full-to-short URL mappings.
+	// SaveNewFullsAndShorts stores new full-to-short URL mappings owned by ownerID,
+	// or shared when ownerID is empty, with the attributes given per full URL.
 	SaveNewFullsAndShorts(
 		ctx context.Context,
 		unexistentFullsToShortsMap map[string]string,
+		ownerID string,
+		attributes map[string]models.URLAttributes,
 		transaction *sql.Tx,
 	) error
 
-	// FindFullByShort retrieves the full URL associated with the given short URL.
-	FindFullByShort(ctx context.Context, short string) (string, bool, error)
+	// FindRedirectByShort retrieves the original URL and the link attributes of the given short URL.
+	FindRedirectByShort(ctx context.Context, short string) (models.URLRedirect, bool, error)
+
+	// ConsumeURLClick atomically takes one redirect from a short URL limited to a number of redirects
+	// and returns how many are left.
+	ConsumeURLClick(ctx context.Context, short string) (int, error)
 
 	// FindShortByFull retrieves the short URL associated with the given full URL.
+	// An empty ownerID limits the lookup to shared links.
 	FindShortByFull(
 		ctx context.Context,
 		full string,
+		ownerID string,
 		transaction *sql.Tx,
 	) (string, bool, error)
 
-	// InsertURLMapping stores a mapping from short to full URL.
+	// InsertURLMapping stores a mapping from short to full URL with its attributes,
+	// owned by ownerID, or shared when ownerID is empty.
 	InsertURLMapping(
 		ctx context.Context,
 		short,
 		full string,
+		ownerID string,
+		attributes models.URLAttributes,
 		transaction *sql.Tx,
 	) error
 }
 
+// AbuseReportsKeeper defines methods for the abuse reports on short URLs and their moderation.
+type AbuseReportsKeeper interface {
+	// ReportURL files an abuse report on the short URL; a user has at most one pending report per URL.
+	ReportURL(ctx context.Context, short, reporterID, reason string) error
+
+	// GetAbuseReportsQueue returns the short URLs with pending abuse reports, formatted using the provided function.
+	GetAbuseReportsQueue(ctx context.Context, formatter models.URLFormatter) (models.AbuseReportsQueue, error)
+
+	// DisableURL disables the short URL for everyone and marks its pending abuse reports as actioned.
+	DisableURL(ctx context.Context, short string) error
+
+	// DismissURLReports dismisses the pending abuse reports on the short URL and returns how many there were.
+	DismissURLReports(ctx context.Context, short string) (int64, error)
+}
+
+// AccountsKeeper defines methods for the accounts the users sign in to with an email and a password.
+type AccountsKeeper interface {
+	// RegisterUser gives the anonymous user an account to sign in with the email and the password hash.
+	RegisterUser(ctx context.Context, userID, email, passwordHash string) error
+
+	// GetUserByEmail retrieves the registered user by their email. If not found, returns a user with an empty ID.
+	GetUserByEmail(ctx context.Context, email string) (*user.User, error)
+
+	// ClaimUserURLs moves the links of the anonymous user to the registered user and returns how many
+	// the registered user did not have yet.
+	ClaimUserURLs(ctx context.Context, anonymousUserID, userID string) (int64, error)
+}
+
+// TokensKeeper defines methods for the refresh tokens and the revocation list of access tokens.
+type TokensKeeper interface {
+	// SaveRefreshToken stores the hash of a refresh token of the user, valid until expiresAt.
+	SaveRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+
+	// ConsumeRefreshToken deletes the refresh token of the given hash and returns the ID of its user,
+	// empty if there is no such token or it has expired.
+	ConsumeRefreshToken(ctx context.Context, tokenHash string) (string, error)
+
+	// GetRefreshTokenUserID returns the ID of the user of the refresh token of the given hash, leaving
+	// the token usable; empty if there is no such token or it has expired.
+	GetRefreshTokenUserID(ctx context.Context, tokenHash string) (string, error)
+
+	// DeleteRefreshToken deletes the refresh token of the given hash, if any.
+	DeleteRefreshToken(ctx context.Context, tokenHash string) error
+
+	// RevokeToken puts the ID of an access token on the revocation list until the token expires.
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+
+	// IsTokenRevoked tells whether the ID of an access token is on the revocation list.
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+
+	// PurgeExpiredTokens removes the refresh tokens and the revoked access token IDs expired before expiredBefore.
+	PurgeExpiredTokens(ctx context.Context, expiredBefore time.Time) (int64, error)
+}
+
+// URLsSearcher is an interface for searching all the short URLs, whoever they belong to.
+type URLsSearcher interface {
+	// SearchURLs retrieves the page defined by query of all the short URLs whose original URL
+	// or short URL contains the search string, formatted using the provided function.
+	SearchURLs(ctx context.Context, query models.UserURLsQuery, formatter models.URLFormatter) (models.AdminURLs, error)
+}
+
+// StoredURLsCanonicalizer is an interface for bringing the stored URLs to their canonical form.
+type StoredURLsCanonicalizer interface {
+	// CanonicalizeStoredURLs brings the stored original URLs to their canonical form and merges
+	// the links of the same owner whose original URLs turn out to be the same, returning how many
+	// were merged. The merges cannot be undone.
+	CanonicalizeStoredURLs(ctx context.Context, canonicalize func(string) (string, error)) (int64, error)
+}
+
 // Pinger is an interface for pinging a storage to check its health.
 type Pinger interface {
 	// Ping checks the storage's health.
@@ -126,6 +266,11 @@ type Storage interface {
 	UserUrlsKeeper
 	Transactioner
 	URLsMapper
+	AbuseReportsKeeper
+	AccountsKeeper
+	TokensKeeper
+	URLsSearcher
+	StoredURLsCanonicalizer
 	Pinger
 	Close() error
 }
@@ -142,22 +287,42 @@ type Remover interface {
 	EnqueueJob(job *models.URLDeleteJob)
 }
 
+// Purger is an interface for the background purge of deleted URLs.
+type Purger interface {
+	// ListenErrors listens for errors and passes them to the provided callback function.
+	ListenErrors(callback func(error))
+
+	// Run starts the background purging.
+	Run(ctx context.Context)
+}
+
 // App encapsulates the configuration, HTTP handler, Storage backend,
 // and background services (such as URL remover) needed to run the URL shortener service.
 type App struct {
-	cfg             *config.Config
-	db              Storage
-	urlsRemover     Remover
-	stopUrlsRemover context.CancelFunc
-	httpHandler     http.Handler
-	server          *http.Server
+	cfg              *config.Config
+	db               Storage
+	urlsRemover      Remover
+	stopUrlsRemover  context.CancelFunc
+	urlsPurger       Purger
+	stopUrlsPurger   context.CancelFunc
+	tokensPurger     Purger
+	stopTokensPurger context.CancelFunc
+	domainPolicy     *domainpolicy.Policy
+	stopDomainPolicy context.CancelFunc
+	httpHandler      http.Handler
+	server           *http.Server
 }
 
 // New initializes a new instance of App by:
 // - loading configuration
 // - initializing logger
 // - selecting and setting up Storage
-// - setting up the background URL remover
+// - granting the admin role to the configured admin users
+// - setting up the background URL remover and purger, and the purger of expired tokens
+// - loading the destination domain lists, if any, and watching them for changes
+// - setting up the URL safety checks and canonicalization unless disabled,
+// bringing the stored URLs to their canonical form if so configured
+// - setting up the scanners of new URLs, if any
 // - setting up the router and middleware
 func New() (*App, error) {
 	var err error
@@ -178,6 +343,11 @@ func New() (*App, error) {
 		return nil, err
 	}
 
+	err = grantAdminRole(context.Background(), app.db, app.cfg.AdminUserIDs)
+	if err != nil {
+		return nil, err
+	}
+
 	authCookieSigningSecretKey, err := base64.URLEncoding.DecodeString(app.cfg.AuthCookieSigningSecretKey)
 	if err != nil {
 		return nil, err
@@ -196,6 +366,101 @@ func New() (*App, error) {
 		logger.Log.Debugln("Error passed from the `app.urlsRemover.ListenErrors()`:", zap.Error(err))
 	})
 
+	app.urlsPurger = urlspurger.New(
+		app.db,
+		app.cfg.URLRestoreGracePeriod,
+		app.cfg.URLPurgeInterval,
+	)
+	urlsPurgerRunCtx, stopUrlsPurger := context.WithCancel(context.Background())
+	app.stopUrlsPurger = stopUrlsPurger
+
+	app.urlsPurger.Run(urlsPurgerRunCtx)
+	app.urlsPurger.ListenErrors(func(err error) {
+		logger.Log.Debugln("Error passed from the `app.urlsPurger.ListenErrors()`:", zap.Error(err))
+	})
+
+	app.tokensPurger = tokenspurger.New(app.db, app.cfg.TokenPurgeInterval)
+	tokensPurgerRunCtx, stopTokensPurger := context.WithCancel(context.Background())
+	app.stopTokensPurger = stopTokensPurger
+
+	app.tokensPurger.Run(tokensPurgerRunCtx)
+	app.tokensPurger.ListenErrors(func(err error) {
+		logger.Log.Debugln("Error passed from the `app.tokensPurger.ListenErrors()`:", zap.Error(err))
+	})
+
+	routerOptions := []router.InitOption{
+		router.WithMaxURLLength(app.cfg.MaxURLLength),
+		router.WithURLOwnershipMode(app.cfg.URLOwnershipMode),
+		router.WithURLRestoreGracePeriod(app.cfg.URLRestoreGracePeriod),
+		router.WithLinkPasswordAttemptsLimiter(attemptslimiter.New(
+			app.cfg.LinkPasswordMaxAttempts,
+			app.cfg.LinkPasswordAttemptsWindow,
+		)),
+		router.WithLoginAttemptsLimiter(attemptslimiter.New(
+			app.cfg.LoginMaxAttempts,
+			app.cfg.LoginAttemptsWindow,
+		)),
+		router.WithRedirectStatusCode(app.cfg.RedirectStatusCode),
+		router.WithPermanentRedirectMaxAge(app.cfg.PermanentRedirectMaxAge),
+		router.WithInterstitialForUntrustedDomains(app.cfg.InterstitialForUntrustedDomains),
+		router.WithTrustedDomains(app.cfg.TrustedDomains),
+		router.WithDisabledLinkStatusCode(app.cfg.DisabledLinkStatusCode),
+	}
+
+	app.stopDomainPolicy = func() {}
+	if app.cfg.DomainListsFile != "" {
+		app.domainPolicy, err = domainpolicy.New(app.cfg.DomainListsFile, app.cfg.DomainListsReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		domainPolicyRunCtx, stopDomainPolicy := context.WithCancel(context.Background())
+		app.stopDomainPolicy = stopDomainPolicy
+
+		app.domainPolicy.Run(domainPolicyRunCtx)
+		app.domainPolicy.ListenErrors(func(err error) {
+			logger.Log.Errorln("Error passed from the `app.domainPolicy.ListenErrors()`:", zap.Error(err))
+		})
+		routerOptions = append(routerOptions, router.WithDestinationPolicy(app.domainPolicy))
+	}
+
+	if !app.cfg.DisableURLSafetyChecks {
+		allowedNetworks, err := urlsafety.ParseNetworks(app.cfg.URLSafetyAllowedNetworks)
+		if err != nil {
+			return nil, err
+		}
+		routerOptions = append(routerOptions, router.WithURLSafetyPolicy(urlsafety.New(
+			urlsafety.WithAllowedSchemes(app.cfg.URLSafetyAllowedSchemes),
+			urlsafety.WithAllowedNetworks(allowedNetworks),
+		)))
+	}
+
+	if !app.cfg.DisableURLCanonicalization {
+		canonicalizer := urlcanonicalizer.New(
+			urlcanonicalizer.WithStripTrackingParams(app.cfg.StripTrackingParams),
+		)
+		if app.cfg.CanonicalizeStoredURLs {
+			merged, err := app.db.CanonicalizeStoredURLs(context.Background(), canonicalizer.Canonicalize)
+			if err != nil {
+				return nil, err
+			}
+			logger.Log.Infoln("The stored URLs are canonicalized, links merged:", merged)
+		}
+		routerOptions = append(routerOptions, router.WithURLCanonicalizer(canonicalizer))
+	}
+
+	urlScanner, err := getURLScanner(app.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if urlScanner != nil {
+		routerOptions = append(
+			routerOptions,
+			router.WithURLScanner(urlScanner),
+			router.WithURLScanTimeout(app.cfg.URLScanTimeout),
+			router.WithURLScanFailOpen(app.cfg.URLScanFailOpen),
+		)
+	}
+
 	app.httpHandler = router.New(
 		app.db,
 		app.cfg.ShortURLBase,
@@ -203,8 +468,13 @@ func New() (*App, error) {
 			app.db,
 			app.cfg.AuthCookieName,
 			authCookieSigningSecretKey,
+			auth.WithImpersonationTokenLifetime(app.cfg.ImpersonationTokenLifetime),
+			auth.WithAccessTokenLifetime(app.cfg.AccessTokenLifetime),
+			auth.WithRefreshTokenLifetime(app.cfg.RefreshTokenLifetime),
+			auth.WithLegacyTokensDeadline(app.cfg.LegacyTokensDeadline),
 		),
 		app.urlsRemover,
+		routerOptions...,
 	)
 
 	app.server = &http.Server{
@@ -242,6 +512,9 @@ func (a *App) Run() error {
 	case <-ctx.Done():
 		logger.Log.Infoln("Received shutdown signal. Saving database and exiting...")
 		a.stopUrlsRemover()
+		a.stopUrlsPurger()
+		a.stopTokensPurger()
+		a.stopDomainPolicy()
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
@@ -263,6 +536,32 @@ func (a *App) Close() {
 	}
 }
 
+// getURLScanner returns the chain of the configured scanners of new URLs, or nil if there are none.
+func getURLScanner(cfg *config.Config) (urlscanner.Scanner, error) {
+	var chain urlscanner.Chain
+
+	if cfg.URLScannerBlocklistFile != "" {
+		blocklistScanner, err := urlscanner.NewBlocklistScanner(cfg.URLScannerBlocklistFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, blocklistScanner)
+	}
+
+	if cfg.URLScannerWebhookURL != "" {
+		chain = append(chain, urlscanner.NewWebhookScanner(
+			cfg.URLScannerWebhookURL,
+			urlscanner.WithAuthToken(cfg.URLScannerWebhookToken),
+		))
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+
+	return chain, nil
+}
+
 func getAvailableStorageType(cfg *config.Config) int {
 	if cfg.DatabaseDSN != "" {
 		return models.StorageTypePostgresql
@@ -275,6 +574,23 @@ func getAvailableStorageType(cfg *config.Config) int {
 	return models.StorageTypeMemory
 }
 
+// grantAdminRole gives the admin role to the users of the given IDs. The users that do not exist
+// are skipped with a warning, and get the role on a later start once they do.
+func grantAdminRole(ctx context.Context, db UserKeeper, userIDs []string) error {
+	for _, userID := range userIDs {
+		err := db.SetUserRole(ctx, userID, user.RoleAdmin)
+		if errors.Is(err, models.ErrUserNotFound) {
+			logger.Log.Warnln("Cannot grant the admin role to a user that does not exist:", userID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func getStorageByType(cfg *config.Config) (Storage, error) {
 	switch getAvailableStorageType(cfg) {
 	case models.StorageTypeUnknown:
@@ -286,11 +602,13 @@ func getStorageByType(cfg *config.Config) (Storage, error) {
 			cfg.DatabaseDSN,
 			cfg.DBConnectionTimeout,
 			cfg.MigrationsDir,
+			postgresdb.WithReplicaDSN(cfg.DatabaseReplicaDSN),
+			postgresdb.WithURLOwnershipMode(cfg.URLOwnershipMode),
 		)
 
 	case models.StorageTypeFile:
-		return jsondb.New(cfg.DBFileName)
+		return jsondb.New(cfg.DBFileName, jsondb.WithURLOwnershipMode(cfg.URLOwnershipMode))
 	}
 
-	return memorystorage.New()
+	return memorystorage.New(jsondb.WithURLOwnershipMode(cfg.URLOwnershipMode))
 }
@@ -0,0 +1,85 @@
+// Package attemptslimiter limits the number of failed attempts per key, such as wrong passwords
+// entered for a short URL, within a fixed time window.
+package attemptslimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// AttemptsLimiter counts failed attempts per key and blocks further attempts once
+// the limit is reached, until the window started by the first failure is over.
+// It is safe for concurrent use.
+type AttemptsLimiter struct {
+	mutex       sync.Mutex
+	maxAttempts int
+	window      time.Duration
+	failures    map[string]*failures
+	nextSweep   time.Time
+}
+
+type failures struct {
+	count     int
+	windowEnd time.Time
+}
+
+// New initializes and returns a new instance of AttemptsLimiter allowing maxAttempts
+// failed attempts per key within window.
+func New(maxAttempts int, window time.Duration) *AttemptsLimiter {
+	return &AttemptsLimiter{
+		maxAttempts: maxAttempts,
+		window:      window,
+		failures:    map[string]*failures{},
+	}
+}
+
+// Allow tells whether another attempt for the key is allowed. If it is not,
+// it also returns the time left until attempts are allowed again.
+func (l *AttemptsLimiter) Allow(key string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	keyFailures, found := l.failures[key]
+	if !found || keyFailures.count < l.maxAttempts {
+		return true, 0
+	}
+
+	retryAfter := time.Until(keyFailures.windowEnd)
+	if retryAfter <= 0 {
+		delete(l.failures, key)
+
+		return true, 0
+	}
+
+	return false, retryAfter
+}
+
+// Fail records a failed attempt for the key.
+func (l *AttemptsLimiter) Fail(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	keyFailures, found := l.failures[key]
+	if !found || !now.Before(keyFailures.windowEnd) {
+		keyFailures = &failures{windowEnd: now.Add(l.window)}
+		l.failures[key] = keyFailures
+	}
+	keyFailures.count++
+}
+
+// sweep forgets the keys whose window is over, at most once per window.
+func (l *AttemptsLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+
+	for key, keyFailures := range l.failures {
+		if !now.Before(keyFailures.windowEnd) {
+			delete(l.failures, key)
+		}
+	}
+	l.nextSweep = now.Add(l.window)
+}
@@ -5,15 +5,22 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 
 	"github.com/patric-chuzhbe/urlshrt/internal/logger"
+	"github.com/patric-chuzhbe/urlshrt/internal/models"
 	"github.com/patric-chuzhbe/urlshrt/internal/user"
 )
 
@@ -22,24 +29,62 @@ type userKeeper interface {
 	GetUserByID(ctx context.Context, userID string, transaction *sql.Tx) (*user.User, error)
 }
 
+type tokensKeeper interface {
+	SaveRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	GetRefreshTokenUserID(ctx context.Context, tokenHash string) (string, error)
+	ConsumeRefreshToken(ctx context.Context, tokenHash string) (string, error)
+	DeleteRefreshToken(ctx context.Context, tokenHash string) error
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+type storage interface {
+	userKeeper
+	tokensKeeper
+}
+
 // Auth handles user authentication and JWT token management.
 // It supports retrieving user information and setting authorization cookies.
+//
+// The users are authenticated with short-lived access tokens (JWTs), renewed with refresh tokens
+// stored server-side. Browsers get both as cookies, and their access token is renewed from
+// the refresh cookie transparently; the other clients renew it with RefreshTokens.
+// Anonymous users get no refresh token, so that nothing is stored for each visitor: their
+// access token is valid as long as a refresh token would be.
 type Auth struct {
-	// db is the interface to the user data storage.
-	db userKeeper
+	// db is the interface to the user and token data storage.
+	db storage
 
 	// authCookieName is the name of the cookie used to store the JWT.
 	authCookieName string
 
 	// authCookieSigningSecretKey is the key used to sign JWTs.
 	authCookieSigningSecretKey []byte
+
+	// impersonationTokenLifetime is how long the tokens issued to admins to act as users are valid.
+	impersonationTokenLifetime time.Duration
+
+	// accessTokenLifetime is how long the access tokens are valid.
+	accessTokenLifetime time.Duration
+
+	// refreshTokenLifetime is how long the refresh tokens are valid.
+	refreshTokenLifetime time.Duration
+
+	// legacyTokensDeadline is the time until which the tokens issued without an expiry
+	// by the previous versions are accepted, forever if zero.
+	legacyTokensDeadline time.Time
 }
 
+// InitOption defines a functional option for configuring the Auth.
+type InitOption func(*Auth)
+
 // Claims represents the JWT claims used by the system.
 // It embeds standard JWT claims and adds a user-specific identifier.
+// The tokens are identified by the standard ID claim, to be revoked.
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID string `json:"user_id"`
+	UserID         string `json:"user_id"`
+	ImpersonatorID string `json:"impersonator_id,omitempty"` // ID of the admin the token was issued to, if impersonating
 }
 
 // ContextKey is a custom type for storing values in context to avoid collisions.
@@ -48,25 +93,215 @@ type ContextKey string
 // UserIDKey is the context key used to store and retrieve the authenticated user's ID.
 const UserIDKey ContextKey = "userID"
 
+// UserRoleKey is the context key used to store and retrieve the authenticated user's role.
+const UserRoleKey ContextKey = "userRole"
+
+// ImpersonatorIDKey is the context key used to store and retrieve the ID of the admin
+// impersonating the authenticated user, if any.
+const ImpersonatorIDKey ContextKey = "impersonatorID"
+
+// defaultImpersonationTokenLifetime is how long impersonation tokens are valid unless set otherwise.
+const defaultImpersonationTokenLifetime = time.Hour
+
+// defaultAccessTokenLifetime is how long access tokens are valid unless set otherwise.
+const defaultAccessTokenLifetime = 15 * time.Minute
+
+// defaultRefreshTokenLifetime is how long refresh tokens are valid unless set otherwise.
+const defaultRefreshTokenLifetime = 90 * 24 * time.Hour
+
+// refreshCookieNameSuffix is appended to the name of the auth cookie to name the refresh cookie.
+const refreshCookieNameSuffix = "_refresh"
+
+// ErrImpersonatingAdmin is returned when an impersonation token is requested for an admin.
+var ErrImpersonatingAdmin = errors.New("admins may not be impersonated")
+
+// ErrInvalidRefreshToken is returned when a refresh token is unknown, used up or expired.
+var ErrInvalidRefreshToken = errors.New("the refresh token is invalid or expired")
+
 var errInvalidTokenOrJwtParsing = errors.New("token is invalid or error while `jwt.ParseWithClaims()` calling")
 
 // New creates a new Auth handler with the given user data access layer,
 // cookie name, and JWT signing secret.
 func New(
-	db userKeeper,
+	db storage,
 	authCookieName string,
 	authCookieSigningSecretKey []byte,
+	optionsProto ...InitOption,
 ) *Auth {
-	return &Auth{
+	a := &Auth{
 		db:                         db,
 		authCookieName:             authCookieName,
 		authCookieSigningSecretKey: authCookieSigningSecretKey,
+		impersonationTokenLifetime: defaultImpersonationTokenLifetime,
+		accessTokenLifetime:        defaultAccessTokenLifetime,
+		refreshTokenLifetime:       defaultRefreshTokenLifetime,
+	}
+	for _, protoOption := range optionsProto {
+		protoOption(a)
+	}
+
+	return a
+}
+
+// WithImpersonationTokenLifetime sets how long the tokens issued to admins to act as users are valid.
+// Defaults to an hour.
+func WithImpersonationTokenLifetime(value time.Duration) InitOption {
+	return func(a *Auth) {
+		a.impersonationTokenLifetime = value
+	}
+}
+
+// WithAccessTokenLifetime sets how long the access tokens are valid. Defaults to 15 minutes.
+func WithAccessTokenLifetime(value time.Duration) InitOption {
+	return func(a *Auth) {
+		a.accessTokenLifetime = value
+	}
+}
+
+// WithRefreshTokenLifetime sets how long the refresh tokens are valid. Defaults to 90 days.
+func WithRefreshTokenLifetime(value time.Duration) InitOption {
+	return func(a *Auth) {
+		a.refreshTokenLifetime = value
+	}
+}
+
+// WithLegacyTokensDeadline sets the time until which the tokens issued without an expiry by
+// the previous versions are accepted. Until then the browsers sending one in the auth cookie get
+// expiring tokens in exchange, so that the deadline may be set once most of them did. A zero
+// deadline, the default, accepts them forever.
+func WithLegacyTokensDeadline(value time.Time) InitOption {
+	return func(a *Auth) {
+		a.legacyTokensDeadline = value
+	}
+}
+
+// IssueImpersonationToken builds a JWT authenticating its bearer as the user, for the admin
+// to act on the user's behalf in support cases. The token names the admin and expires after
+// the impersonation token lifetime; the requests made with it are audit-logged and never get
+// the admin role. Returns models.ErrUserNotFound if the user does not exist and
+// ErrImpersonatingAdmin if the user is an admin.
+func (a *Auth) IssueImpersonationToken(ctx context.Context, adminID, userID string) (string, time.Time, error) {
+	usr, err := a.db.GetUserByID(ctx, userID, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if usr.ID == "" {
+		return "", time.Time{}, models.ErrUserNotFound
 	}
+	if usr.IsAdmin() {
+		return "", time.Time{}, ErrImpersonatingAdmin
+	}
+
+	JWTString, expiresAt, err := a.buildExpiringJWTString(usr.ID, adminID, a.impersonationTokenLifetime)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return JWTString, expiresAt, nil
+}
+
+// IssueUserTokens signs the user in: it issues a new access token and refresh token, sets them
+// as cookies and the access token as Authorization header, and returns them.
+func (a *Auth) IssueUserTokens(
+	ctx context.Context,
+	response http.ResponseWriter,
+	userID string,
+) (models.AuthTokens, error) {
+	JWTString, expiresAt, err := a.buildExpiringJWTString(userID, "", a.accessTokenLifetime)
+	if err != nil {
+		return models.AuthTokens{}, err
+	}
+
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return models.AuthTokens{}, err
+	}
+	refreshTokenExpiresAt := time.Now().Add(a.refreshTokenLifetime)
+	err = a.db.SaveRefreshToken(ctx, userID, hashRefreshToken(refreshToken), refreshTokenExpiresAt)
+	if err != nil {
+		return models.AuthTokens{}, err
+	}
+
+	a.setToken(response, JWTString)
+	a.setRefreshToken(response, refreshToken, refreshTokenExpiresAt)
+
+	return models.AuthTokens{
+		Token:        JWTString,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// issueAnonymousUserToken issues an access token to the anonymous user, valid for the lifetime
+// of a refresh token, and sets it as cookie and Authorization header. No refresh token is issued.
+func (a *Auth) issueAnonymousUserToken(response http.ResponseWriter, userID string) error {
+	JWTString, _, err := a.buildExpiringJWTString(userID, "", a.refreshTokenLifetime)
+	if err != nil {
+		return err
+	}
+	a.setToken(response, JWTString)
+
+	return nil
+}
+
+// RefreshTokens exchanges the refresh token, or the one of the refresh cookie if empty, for new
+// tokens issued by IssueUserTokens. The refresh token is used up. Returns ErrInvalidRefreshToken
+// if it is unknown, used up or expired.
+func (a *Auth) RefreshTokens(
+	response http.ResponseWriter,
+	request *http.Request,
+	refreshToken string,
+) (models.AuthTokens, error) {
+	if refreshToken == "" {
+		refreshToken = a.getRefreshTokenFromCookie(request)
+	}
+	if refreshToken == "" {
+		return models.AuthTokens{}, ErrInvalidRefreshToken
+	}
+
+	userID, err := a.db.ConsumeRefreshToken(request.Context(), hashRefreshToken(refreshToken))
+	if err != nil {
+		return models.AuthTokens{}, err
+	}
+	if userID == "" {
+		return models.AuthTokens{}, ErrInvalidRefreshToken
+	}
+
+	return a.IssueUserTokens(request.Context(), response, userID)
+}
+
+// Logout signs the user out: it revokes the access token of the request until it expires, deletes
+// the refresh token, or the one of the refresh cookie if empty, and clears the auth cookies.
+// The tokens issued without an expiry by the previous versions cannot be revoked.
+func (a *Auth) Logout(response http.ResponseWriter, request *http.Request, refreshToken string) error {
+	claims, err := a.getClaimsFromAuthorizationHeaderOrCookie(request)
+	if err == nil && claims.ID != "" && claims.ExpiresAt != nil {
+		err = a.db.RevokeToken(request.Context(), claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			return err
+		}
+		logger.Log.Infow("audit: token revoked", "user_id", claims.UserID, "token_id", claims.ID)
+	}
+
+	if refreshToken == "" {
+		refreshToken = a.getRefreshTokenFromCookie(request)
+	}
+	if refreshToken != "" {
+		err = a.db.DeleteRefreshToken(request.Context(), hashRefreshToken(refreshToken))
+		if err != nil {
+			return err
+		}
+	}
+
+	http.SetCookie(response, &http.Cookie{Name: a.authCookieName, Path: "/", MaxAge: -1})
+	http.SetCookie(response, &http.Cookie{Name: a.authCookieName + refreshCookieNameSuffix, Path: "/", MaxAge: -1})
+
+	return nil
 }
 
 // RegisterNewUser is an HTTP middleware that registers a new user if none exists
-// in the context. It creates a user, sets a signed JWT as a cookie and Authorization header,
-// and adds the user ID to the request context.
+// in the context. It creates an anonymous user, sets the token issued by issueAnonymousUserToken
+// as cookie and Authorization header, and adds the user ID to the request context.
 func (a *Auth) RegisterNewUser(h http.Handler) http.Handler {
 	middleware := func(response http.ResponseWriter, request *http.Request) {
 		userID, ok := request.Context().Value(UserIDKey).(string)
@@ -83,24 +318,14 @@ func (a *Auth) RegisterNewUser(h http.Handler) http.Handler {
 			return
 		}
 
-		JWTString, err := a.buildJWTString(&Claims{UserID: userID})
+		err = a.issueAnonymousUserToken(response, userID)
 		if err != nil {
-			logger.Log.Debugln("Error calling the `a.buildJWTString()`: ", zap.Error(err))
+			logger.Log.Debugln("Error calling the `a.issueAnonymousUserToken()`: ", zap.Error(err))
 			response.WriteHeader(http.StatusInternalServerError)
 
 			return
 		}
 
-		response.Header().Set("Authorization", JWTString)
-
-		http.SetCookie(
-			response,
-			&http.Cookie{
-				Name:  a.authCookieName,
-				Value: JWTString,
-			},
-		)
-
 		ctx := context.WithValue(request.Context(), UserIDKey, userID)
 		requestWithCtx := request.WithContext(ctx)
 		h.ServeHTTP(response, requestWithCtx)
@@ -111,17 +336,35 @@ func (a *Auth) RegisterNewUser(h http.Handler) http.Handler {
 
 // AuthenticateUser is an HTTP middleware that authenticates incoming requests
 // using JWTs found in the Authorization header or cookies.
-// It fetches the user from storage and stores the user ID in the request context.
+// It fetches the user from storage and stores the user ID and role in the request context,
+// together with the ID of the admin impersonating the user, if any. Impersonated users
+// always have the user role, and their requests are audit-logged.
+//
+// Expired and revoked tokens are rejected. The requests of browsers, made without
+// the Authorization header, whose access token is missing or rejected are authenticated with
+// the refresh cookie instead, and get a new access token; the ones made with a token issued
+// without an expiry by the previous versions get expiring tokens in exchange.
 func (a *Auth) AuthenticateUser(h http.Handler) http.Handler {
 	middleware := func(response http.ResponseWriter, request *http.Request) {
-		userID, err := a.getUserIDFromAuthorizationHeaderOrCookie(request)
-		if err != nil && !errors.Is(err, errInvalidTokenOrJwtParsing) {
-			logger.Log.Debugln("Error calling the `a.getUserIDFromAuthorizationHeaderOrCookie()`: ", zap.Error(err))
+		claims, err := a.getClaimsOfAcceptedToken(request)
+		if err != nil {
+			logger.Log.Debugln("Error calling the `a.getClaimsOfAcceptedToken()`: ", zap.Error(err))
 			response.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if errors.Is(err, errInvalidTokenOrJwtParsing) {
-			logger.Log.Debugln("Error calling the `a.getUserIDFromAuthorizationHeader()`: ", zap.Error(err))
+
+		fromBrowser := request.Header.Get("Authorization") == ""
+		exchangeLegacyToken := fromBrowser && isLegacy(claims)
+		userID := claims.UserID
+		renewAccessToken := false
+		if userID == "" && fromBrowser {
+			userID, err = a.getRefreshCookieUserID(request)
+			if err != nil {
+				logger.Log.Debugln("Error calling the `a.getRefreshCookieUserID()`: ", zap.Error(err))
+				response.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			renewAccessToken = userID != ""
 		}
 
 		usr, err := a.db.GetUserByID(request.Context(), userID, nil)
@@ -131,7 +374,32 @@ func (a *Auth) AuthenticateUser(h http.Handler) http.Handler {
 			return
 		}
 
+		if usr.ID != "" {
+			err = a.renewTokens(request.Context(), response, usr, renewAccessToken, exchangeLegacyToken)
+			if err != nil {
+				logger.Log.Debugln("Error calling the `a.renewTokens()`: ", zap.Error(err))
+				response.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		role := usr.Role
+		if claims.ImpersonatorID != "" && usr.ID != "" {
+			role = user.RoleUser
+			logger.Log.Infow(
+				"audit: impersonated request",
+				"admin_id", claims.ImpersonatorID,
+				"user_id", usr.ID,
+				"method", request.Method,
+				"path", request.URL.Path,
+			)
+		}
+
 		ctx := context.WithValue(request.Context(), UserIDKey, usr.ID)
+		ctx = context.WithValue(ctx, UserRoleKey, role)
+		if usr.ID != "" {
+			ctx = context.WithValue(ctx, ImpersonatorIDKey, claims.ImpersonatorID)
+		}
 		requestWithCtx := request.WithContext(ctx)
 
 		h.ServeHTTP(response, requestWithCtx)
@@ -140,6 +408,77 @@ func (a *Auth) AuthenticateUser(h http.Handler) http.Handler {
 	return http.HandlerFunc(middleware)
 }
 
+// getClaimsOfAcceptedToken returns the claims of the token of the request, or empty claims
+// if it has none or it is rejected: invalid, expired, revoked, or issued without an expiry
+// after the legacy tokens deadline.
+func (a *Auth) getClaimsOfAcceptedToken(request *http.Request) (*Claims, error) {
+	claims, err := a.getClaimsFromAuthorizationHeaderOrCookie(request)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `a.getClaimsFromAuthorizationHeaderOrCookie()`: ", zap.Error(err))
+		return &Claims{}, nil
+	}
+
+	if isLegacy(claims) {
+		if !a.legacyTokensDeadline.IsZero() && time.Now().After(a.legacyTokensDeadline) {
+			logger.Log.Debugln("a token without an expiry is given after the legacy tokens deadline")
+			return &Claims{}, nil
+		}
+
+		return claims, nil
+	}
+
+	if claims.ID != "" {
+		revoked, err := a.db.IsTokenRevoked(request.Context(), claims.ID)
+		if err != nil {
+			return &Claims{}, err
+		}
+		if revoked {
+			logger.Log.Debugln("a revoked token is given", zap.String("token_id", claims.ID))
+			return &Claims{}, nil
+		}
+	}
+
+	return claims, nil
+}
+
+// getRefreshCookieUserID returns the ID of the user of the refresh cookie,
+// empty if there is no such cookie or its token is unknown, used up or expired.
+func (a *Auth) getRefreshCookieUserID(request *http.Request) (string, error) {
+	refreshToken := a.getRefreshTokenFromCookie(request)
+	if refreshToken == "" {
+		return "", nil
+	}
+
+	return a.db.GetRefreshTokenUserID(request.Context(), hashRefreshToken(refreshToken))
+}
+
+// renewTokens sends the user a new access token, or new tokens in exchange for a legacy one:
+// an anonymous user gets an access token only. The refresh token is kept on renewing the access
+// token, so that the concurrent requests of the same browser renewing it do not use it up for each other.
+func (a *Auth) renewTokens(
+	ctx context.Context,
+	response http.ResponseWriter,
+	usr *user.User,
+	renewAccessToken bool,
+	exchangeLegacyToken bool,
+) error {
+	switch {
+	case exchangeLegacyToken && !usr.IsRegistered():
+		return a.issueAnonymousUserToken(response, usr.ID)
+	case exchangeLegacyToken:
+		_, err := a.IssueUserTokens(ctx, response, usr.ID)
+		return err
+	case renewAccessToken:
+		JWTString, _, err := a.buildExpiringJWTString(usr.ID, "", a.accessTokenLifetime)
+		if err != nil {
+			return err
+		}
+		a.setToken(response, JWTString)
+	}
+
+	return nil
+}
+
 func (a *Auth) getTokenStringFromAuthorizationHeaderOrCookie(request *http.Request) string {
 	tokenString := request.Header.Get("Authorization")
 	if tokenString != "" {
@@ -153,7 +492,7 @@ func (a *Auth) getTokenStringFromAuthorizationHeaderOrCookie(request *http.Reque
 	return tokenString
 }
 
-func (a *Auth) getUserIDFromAuthorizationHeaderOrCookie(request *http.Request) (string, error) {
+func (a *Auth) getClaimsFromAuthorizationHeaderOrCookie(request *http.Request) (*Claims, error) {
 	tokenString := a.getTokenStringFromAuthorizationHeaderOrCookie(request)
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(
@@ -167,10 +506,69 @@ func (a *Auth) getUserIDFromAuthorizationHeaderOrCookie(request *http.Request) (
 		},
 	)
 	if err != nil || !token.Valid {
-		return "", fmt.Errorf("%w: %w", errInvalidTokenOrJwtParsing, err)
+		return &Claims{}, fmt.Errorf("%w: %w", errInvalidTokenOrJwtParsing, err)
 	}
 
-	return claims.UserID, nil
+	return claims, nil
+}
+
+// setToken sends the JWT to the client in the Authorization header and the auth cookie.
+func (a *Auth) setToken(response http.ResponseWriter, JWTString string) {
+	response.Header().Set("Authorization", JWTString)
+
+	http.SetCookie(
+		response,
+		&http.Cookie{
+			Name:  a.authCookieName,
+			Value: JWTString,
+			Path:  "/",
+		},
+	)
+}
+
+// setRefreshToken sends the refresh token to the client in the refresh cookie, kept until the token expires.
+func (a *Auth) setRefreshToken(response http.ResponseWriter, refreshToken string, expiresAt time.Time) {
+	http.SetCookie(
+		response,
+		&http.Cookie{
+			Name:     a.authCookieName + refreshCookieNameSuffix,
+			Value:    refreshToken,
+			Path:     "/",
+			Expires:  expiresAt,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	)
+}
+
+func (a *Auth) getRefreshTokenFromCookie(request *http.Request) string {
+	cookie, err := request.Cookie(a.authCookieName + refreshCookieNameSuffix)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+// buildExpiringJWTString builds a JWT authenticating the user, or the admin impersonating them,
+// for the given lifetime. Returns the JWT and its expiry.
+func (a *Auth) buildExpiringJWTString(userID, impersonatorID string, lifetime time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(lifetime)
+	JWTString, err := a.buildJWTString(&Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		UserID:         userID,
+		ImpersonatorID: impersonatorID,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return JWTString, expiresAt, nil
 }
 
 func (a *Auth) buildJWTString(claims *Claims) (string, error) {
@@ -183,3 +581,24 @@ func (a *Auth) buildJWTString(claims *Claims) (string, error) {
 
 	return tokenString, nil
 }
+
+// isLegacy tells whether the token was issued without an expiry, as the previous versions did.
+func isLegacy(claims *Claims) bool {
+	return claims.UserID != "" && claims.ExpiresAt == nil
+}
+
+func generateRefreshToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// hashRefreshToken returns the SHA-256 hash of the refresh token, the form it is stored in.
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/patric-chuzhbe/urlshrt/internal/db/memorystorage"
+	"github.com/patric-chuzhbe/urlshrt/internal/logger"
+	"github.com/patric-chuzhbe/urlshrt/internal/user"
+)
+
+const testCookieName = "auth"
+
+var testSigningKey = []byte("test signing key")
+
+func newTestAuth(t *testing.T, opts ...InitOption) (*Auth, *memorystorage.MemoryStorage) {
+	require.NoError(t, logger.Init("info"))
+	db, err := memorystorage.New()
+	require.NoError(t, err)
+
+	return New(db, testCookieName, testSigningKey, opts...), db
+}
+
+// authenticate serves the request through AuthenticateUser and returns the response
+// and the ID of the user the request was authenticated as.
+func authenticate(a *Auth, request *http.Request) (*httptest.ResponseRecorder, string) {
+	var userID string
+	handler := a.AuthenticateUser(http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+		userID, _ = request.Context().Value(UserIDKey).(string)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, request)
+
+	return rec, userID
+}
+
+func refreshCookieOf(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == testCookieName+refreshCookieNameSuffix {
+			return cookie
+		}
+	}
+
+	return nil
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	tests := []struct {
+		name              string
+		claims            func(tokenID string) jwt.RegisteredClaims
+		revoked           bool
+		deadline          time.Time
+		wantAuthenticated bool
+	}{
+		{
+			name:              "valid access token",
+			claims:            expiringIn(time.Minute),
+			wantAuthenticated: true,
+		},
+		{
+			name:              "expired access token",
+			claims:            expiringIn(-time.Minute),
+			wantAuthenticated: false,
+		},
+		{
+			name:              "revoked jti",
+			claims:            expiringIn(time.Minute),
+			revoked:           true,
+			wantAuthenticated: false,
+		},
+		{
+			name:              "legacy token without a deadline",
+			claims:            legacy,
+			wantAuthenticated: true,
+		},
+		{
+			name:              "legacy token before the deadline",
+			claims:            legacy,
+			deadline:          time.Now().Add(time.Hour),
+			wantAuthenticated: true,
+		},
+		{
+			name:              "legacy token after the deadline",
+			claims:            legacy,
+			deadline:          time.Now().Add(-time.Hour),
+			wantAuthenticated: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, db := newTestAuth(t, WithLegacyTokensDeadline(test.deadline))
+			userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+			require.NoError(t, err)
+
+			tokenID := uuid.NewString()
+			if test.revoked {
+				require.NoError(t, db.RevokeToken(context.Background(), tokenID, time.Now().Add(time.Minute)))
+			}
+			token, err := a.buildJWTString(&Claims{RegisteredClaims: test.claims(tokenID), UserID: userID})
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Authorization", token)
+			_, authenticatedID := authenticate(a, request)
+
+			if test.wantAuthenticated {
+				assert.Equal(t, userID, authenticatedID)
+			} else {
+				assert.Empty(t, authenticatedID)
+			}
+		})
+	}
+}
+
+func expiringIn(lifetime time.Duration) func(tokenID string) jwt.RegisteredClaims {
+	return func(tokenID string) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(lifetime)),
+		}
+	}
+}
+
+func legacy(string) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	a, db := newTestAuth(t)
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	issued, err := a.IssueUserTokens(context.Background(), httptest.NewRecorder(), userID)
+	require.NoError(t, err)
+
+	refresh := func(refreshToken string) (string, error) {
+		rec := httptest.NewRecorder()
+		tokens, err := a.RefreshTokens(rec, httptest.NewRequest(http.MethodPost, "/", nil), refreshToken)
+
+		return tokens.RefreshToken, err
+	}
+
+	rotated, err := refresh(issued.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, issued.RefreshToken, rotated)
+
+	_, err = refresh(issued.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken, "a used up refresh token is rejected")
+
+	_, err = refresh(rotated)
+	assert.NoError(t, err)
+
+	_, err = refresh("")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestAnonymousUsersGetNoRefreshToken(t *testing.T) {
+	a, db := newTestAuth(t)
+
+	var userID string
+	handler := a.RegisterNewUser(http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+		userID, _ = request.Context().Value(UserIDKey).(string)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NotEmpty(t, userID)
+	assert.NotEmpty(t, rec.Header().Get("Authorization"))
+	assert.Nil(t, refreshCookieOf(rec))
+
+	legacyToken, err := a.buildJWTString(&Claims{UserID: userID})
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.AddCookie(&http.Cookie{Name: testCookieName, Value: legacyToken})
+	rec, authenticatedID := authenticate(a, request)
+	assert.Equal(t, userID, authenticatedID)
+	assert.NotEmpty(t, rec.Header().Get("Authorization"), "the legacy token is exchanged")
+	assert.Nil(t, refreshCookieOf(rec))
+
+	registeredID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.RegisterUser(context.Background(), registeredID, "someone@example.com", "hash"))
+	legacyToken, err = a.buildJWTString(&Claims{UserID: registeredID})
+	require.NoError(t, err)
+	request = httptest.NewRequest(http.MethodGet, "/", nil)
+	request.AddCookie(&http.Cookie{Name: testCookieName, Value: legacyToken})
+	rec, _ = authenticate(a, request)
+	assert.NotNil(t, refreshCookieOf(rec), "registered users get a refresh token in exchange")
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"reflect"
@@ -20,21 +21,54 @@ import (
 // Config holds the application configuration loaded from environment variables
 // and optionally overridden by command-line flags.
 type Config struct {
-	RunAddr                    string        `env:"SERVER_ADDRESS" validate:"hostname_port" json:"server_address"`   // Server address and port (e.g., ":8080")
-	ShortURLBase               string        `env:"BASE_URL" validate:"url" json:"base_url"`                         // Base URL used to build short URLs
-	LogLevel                   string        `env:"LOG_LEVEL"  validate:"loglevel"`                                  // Logging level (e.g., "info", "debug")
-	DBFileName                 string        `env:"FILE_STORAGE_PATH"  validate:"filepath" json:"file_storage_path"` // Path to the JSON file storage (used if no DB DSN)
-	DatabaseDSN                string        `env:"DATABASE_DSN" json:"database_dsn"`                                // DSN for PostgreSQL database connection
-	DBConnectionTimeout        time.Duration `env:"DB_CONNECTION_TIMEOUT"`                                           // Timeout for DB connection attempts
-	AuthCookieName             string        `env:"AUTH_COOKIE_NAME"`                                                // Name of the authentication cookie
-	AuthCookieSigningSecretKey string        `env:"AUTH_COOKIE_SIGNING_SECRET_KEY"`                                  // Secret key for signing auth cookies
-	ChannelCapacity            int           `env:"CHANNEL_CAPACITY"`                                                // Channel capacity for background jobs
-	DelayBetweenQueueFetches   time.Duration `env:"DELAY_BETWEEN_QUEUE_FETCHES"`                                     // Delay between attempts to dequeue jobs
-	MigrationsDir              string        `env:"MIGRATIONS_DIR"`                                                  // Directory path for database migration files
-	EnableHTTPS                bool          `env:"ENABLE_HTTPS"  json:"enable_https"`
-	CertFile                   string        `env:"CERT_FILE"`
-	KeyFile                    string        `env:"KEY_FILE"`
-	JSONConfigFilePath         string        `env:"CONFIG"`
+	RunAddr                         string        `env:"SERVER_ADDRESS" validate:"hostname_port" json:"server_address"`   // Server address and port (e.g., ":8080")
+	ShortURLBase                    string        `env:"BASE_URL" validate:"url" json:"base_url"`                         // Base URL used to build short URLs
+	LogLevel                        string        `env:"LOG_LEVEL"  validate:"loglevel"`                                  // Logging level (e.g., "info", "debug")
+	DBFileName                      string        `env:"FILE_STORAGE_PATH"  validate:"filepath" json:"file_storage_path"` // Path to the JSON file storage (used if no DB DSN)
+	DatabaseDSN                     string        `env:"DATABASE_DSN" json:"database_dsn"`                                // DSN for PostgreSQL database connection
+	DatabaseReplicaDSN              string        `env:"DATABASE_REPLICA_DSN" json:"database_replica_dsn"`                // DSN for an optional PostgreSQL read replica
+	DBConnectionTimeout             time.Duration `env:"DB_CONNECTION_TIMEOUT"`                                           // Timeout for DB connection attempts
+	AuthCookieName                  string        `env:"AUTH_COOKIE_NAME"`                                                // Name of the authentication cookie
+	AuthCookieSigningSecretKey      string        `env:"AUTH_COOKIE_SIGNING_SECRET_KEY"`                                  // Secret key for signing auth cookies
+	ChannelCapacity                 int           `env:"CHANNEL_CAPACITY"`                                                // Channel capacity for background jobs
+	DelayBetweenQueueFetches        time.Duration `env:"DELAY_BETWEEN_QUEUE_FETCHES"`                                     // Delay between attempts to dequeue jobs
+	MigrationsDir                   string        `env:"MIGRATIONS_DIR"`                                                  // Directory path for database migration files
+	EnableHTTPS                     bool          `env:"ENABLE_HTTPS"  json:"enable_https"`
+	CertFile                        string        `env:"CERT_FILE"`
+	KeyFile                         string        `env:"KEY_FILE"`
+	JSONConfigFilePath              string        `env:"CONFIG"`
+	MaxURLLength                    int           `env:"MAX_URL_LENGTH" validate:"gte=0" json:"max_url_length"`                                  // Maximum length of a URL accepted for shortening
+	URLOwnershipMode                string        `env:"URL_OWNERSHIP_MODE" validate:"oneof=shared per_user" json:"url_ownership_mode"`          // "shared": one short link per URL for everyone, "per_user": one per user
+	URLRestoreGracePeriod           time.Duration `env:"URL_RESTORE_GRACE_PERIOD"`                                                               // Period during which deleted URLs can be restored before being purged
+	URLPurgeInterval                time.Duration `env:"URL_PURGE_INTERVAL"`                                                                     // Interval between purges of deleted URLs
+	LinkPasswordMaxAttempts         int           `env:"LINK_PASSWORD_MAX_ATTEMPTS" validate:"gt=0"`                                             // Number of wrong passwords allowed per password-protected link within the attempts window
+	LinkPasswordAttemptsWindow      time.Duration `env:"LINK_PASSWORD_ATTEMPTS_WINDOW"`                                                          // Window in which wrong passwords for a password-protected link are counted
+	LoginMaxAttempts                int           `env:"LOGIN_MAX_ATTEMPTS" validate:"gt=0"`                                                     // Number of wrong passwords allowed per account email within the attempts window
+	LoginAttemptsWindow             time.Duration `env:"LOGIN_ATTEMPTS_WINDOW"`                                                                  // Window in which wrong passwords to sign in to an account are counted
+	RedirectStatusCode              int           `env:"REDIRECT_STATUS_CODE" validate:"oneof=301 302 307 308" json:"redirect_status_code"`      // Status code of redirects from short URLs that do not override it
+	PermanentRedirectMaxAge         time.Duration `env:"PERMANENT_REDIRECT_MAX_AGE"`                                                             // How long clients may cache permanent (301, 308) redirects
+	InterstitialForUntrustedDomains bool          `env:"INTERSTITIAL_FOR_UNTRUSTED_DOMAINS" json:"interstitial_for_untrusted_domains"`           // Show the "you are leaving" page before redirecting to an untrusted domain from every short URL
+	TrustedDomains                  []string      `env:"TRUSTED_DOMAINS" validate:"dive,hostname" json:"trusted_domains"`                        // Comma-separated domains redirected to without the "you are leaving" page, subdomains included
+	DomainListsFile                 string        `env:"DOMAIN_LISTS_FILE" json:"domain_lists_file"`                                             // Path to the JSON file with the allow-list and deny-list of destination domains, none if empty
+	DomainListsReloadInterval       time.Duration `env:"DOMAIN_LISTS_RELOAD_INTERVAL"`                                                           // Interval between checks of the domain lists file for changes
+	DisableURLSafetyChecks          bool          `env:"DISABLE_URL_SAFETY_CHECKS" json:"disable_url_safety_checks"`                             // Allow shortening URLs leading to internal addresses or with any scheme
+	URLSafetyAllowedSchemes         []string      `env:"URL_SAFETY_ALLOWED_SCHEMES" validate:"dive,alpha" json:"url_safety_allowed_schemes"`     // Comma-separated URL schemes allowed, http and https if empty
+	URLSafetyAllowedNetworks        []string      `env:"URL_SAFETY_ALLOWED_NETWORKS" validate:"dive,cidr|ip" json:"url_safety_allowed_networks"` // Comma-separated internal networks (CIDRs or IPs) URLs may lead to nevertheless
+	DisableURLCanonicalization      bool          `env:"DISABLE_URL_CANONICALIZATION" json:"disable_url_canonicalization"`                       // Store URLs as given instead of in their canonical form, so that the ones written differently are shortened separately
+	StripTrackingParams             bool          `env:"STRIP_TRACKING_PARAMS" json:"strip_tracking_params"`                                     // Strip click tracking query parameters (fbclid, gclid...) from the URLs being canonicalized
+	CanonicalizeStoredURLs          bool          `env:"CANONICALIZE_STORED_URLS" json:"canonicalize_stored_urls"`                               // Bring the stored URLs to their canonical form at startup, merging the links that turn out the same; one-way, the merges cannot be undone
+	URLScannerBlocklistFile         string        `env:"URL_SCANNER_BLOCKLIST_FILE" json:"url_scanner_blocklist_file"`                           // Path to the file with the hosts and URLs new URLs are rejected for, none if empty
+	URLScannerWebhookURL            string        `env:"URL_SCANNER_WEBHOOK_URL" validate:"omitempty,url" json:"url_scanner_webhook_url"`        // Endpoint of the reputation service new URLs are checked with, none if empty
+	URLScannerWebhookToken          string        `env:"URL_SCANNER_WEBHOOK_TOKEN"`                                                              // Bearer token sent to the reputation service
+	URLScanTimeout                  time.Duration `env:"URL_SCAN_TIMEOUT"`                                                                       // How long the scan of a new URL, or of all the new URLs of a batch, may take
+	URLScanFailOpen                 bool          `env:"URL_SCAN_FAIL_OPEN" json:"url_scan_fail_open"`                                           // Accept the new URLs that could not be scanned instead of answering 503
+	AdminUserIDs                    []string      `env:"ADMIN_USER_IDS" validate:"dive,uuid" json:"admin_user_ids"`                              // Comma-separated IDs of the users granted the admin role at startup
+	ImpersonationTokenLifetime      time.Duration `env:"IMPERSONATION_TOKEN_LIFETIME"`                                                           // How long the tokens issued to admins to act as users are valid
+	AccessTokenLifetime             time.Duration `env:"ACCESS_TOKEN_LIFETIME"`                                                                  // How long the access tokens authenticating the users are valid
+	RefreshTokenLifetime            time.Duration `env:"REFRESH_TOKEN_LIFETIME"`                                                                 // How long the refresh tokens renewing the access tokens are valid
+	LegacyTokensDeadline            time.Time     `env:"LEGACY_TOKENS_DEADLINE" json:"legacy_tokens_deadline"`                                   // Time (RFC 3339) until which the tokens issued without an expiry are accepted, forever if empty
+	TokenPurgeInterval              time.Duration `env:"TOKEN_PURGE_INTERVAL"`                                                                   // Interval between purges of expired refresh tokens and revoked access token IDs
+	DisabledLinkStatusCode          int           `env:"DISABLED_LINK_STATUS_CODE" validate:"oneof=410 451" json:"disabled_link_status_code"`    // Status code answering the short URLs disabled by the moderators
 }
 
 var defaultConfig = Config{
@@ -52,6 +86,23 @@ var defaultConfig = Config{
 	CertFile:                   "../../cert/cert.pem",
 	KeyFile:                    "../../cert/key.pem",
 	JSONConfigFilePath:         "config.json",
+	MaxURLLength:               8192,
+	URLOwnershipMode:           "shared",
+	URLRestoreGracePeriod:      30 * 24 * time.Hour,
+	URLPurgeInterval:           time.Hour,
+	LinkPasswordMaxAttempts:    5,
+	LinkPasswordAttemptsWindow: 15 * time.Minute,
+	LoginMaxAttempts:           5,
+	LoginAttemptsWindow:        15 * time.Minute,
+	RedirectStatusCode:         http.StatusTemporaryRedirect,
+	PermanentRedirectMaxAge:    24 * time.Hour,
+	DomainListsReloadInterval:  10 * time.Second,
+	URLScanTimeout:             3 * time.Second,
+	DisabledLinkStatusCode:     http.StatusGone,
+	ImpersonationTokenLifetime: time.Hour,
+	AccessTokenLifetime:        15 * time.Minute,
+	RefreshTokenLifetime:       90 * 24 * time.Hour,
+	TokenPurgeInterval:         time.Hour,
 }
 
 type initOptions struct {
@@ -205,6 +256,7 @@ func parseFlags(config *Config) {
 	flag.StringVar(&config.LogLevel, "l", config.LogLevel, "logger level")
 	flag.StringVar(&config.DBFileName, "f", config.DBFileName, "JSON file name with database")
 	flag.StringVar(&config.DatabaseDSN, "d", config.DatabaseDSN, "A string with the database connection details")
+	flag.StringVar(&config.DatabaseReplicaDSN, "r", config.DatabaseReplicaDSN, "A string with the read replica connection details")
 	flag.BoolVar(&config.EnableHTTPS, "s", config.EnableHTTPS, "HTTPS enabling flag")
 
 	JSONConfigFilePathDesc := "JSON configuration file path"
@@ -54,6 +54,7 @@ const testJSON = `{
 	"base_url": "http://json-config.com",
 	"file_storage_path": "json_storage.json",
 	"database_dsn": "json-dsn",
+	"database_replica_dsn": "json-replica-dsn",
 	"enable_https": true
 }`
 
@@ -82,6 +83,7 @@ func TestConfigPriorityJSONOnly(t *testing.T) {
 	assert.Equal(t, "https://json-config.com", cfg.ShortURLBase)
 	assert.Equal(t, "json_storage.json", cfg.DBFileName)
 	assert.Equal(t, "json-dsn", cfg.DatabaseDSN)
+	assert.Equal(t, "json-replica-dsn", cfg.DatabaseReplicaDSN)
 	assert.True(t, cfg.EnableHTTPS)
 }
 
@@ -9,6 +9,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/thoas/go-funk"
@@ -18,27 +22,58 @@ import (
 )
 
 // JSONDB is a storage backend that keeps URL mappings and user associations
-// in-memory with persistence to a JSON file.
+// in-memory with persistence to a JSON file. It is safe for concurrent use.
 type JSONDB struct {
-	fileName string
-	Cache    CacheStruct
+	fileName         string
+	urlOwnershipMode string
+	cacheMutex       sync.RWMutex // Guards Cache, used by the HTTP handlers and the background jobs at once
+	Cache            CacheStruct
 }
 
 // CacheStruct represents the in-memory structure of the database cache.
 type CacheStruct struct {
-	ShortToFull        map[string]string
-	FullToShort        map[string]string
-	Users              map[string]*user.User
-	UsersIdsToUrlsMap  map[string][]string
-	UrlsToUsersIdsMap  map[string][]string
-	UrlsToIsDeletedMap map[string]bool
+	ShortToFull               map[string]string            // Short URL to original URL, for all links
+	FullToShort               map[string]string            // Original URL to short URL, for shared links
+	OwnersToFullsToShortsMap  map[string]map[string]string // Owner ID to original URL to short URL, for owned links
+	ShortsToOwnersMap         map[string]string            // Short URL to owner ID, for owned links
+	DedicatedShortsMap        map[string]bool              // Short URLs of the dedicated links, never found by their original URL
+	Users                     map[string]*user.User
+	UsersIdsToShortsMap       map[string][]string
+	ShortsToUsersIdsMap       map[string][]string
+	ShortsToIsDeletedMap      map[string]bool
+	UsersShortsToIsDeletedMap map[string]map[string]bool                   // User ID to short URL to the deletion state of the user's link
+	ShortsToHistoryMap        map[string][]models.URLRedirectHistoryRecord // Short URL to its previous original URLs
+	ShortsToDeletedAtMap      map[string]time.Time                         // Short URL to its deletion time
+	UsersShortsToDeletedAtMap map[string]map[string]time.Time              // User ID to short URL to the deletion time of the user's link
+	ShortsToCreatedAtMap      map[string]time.Time                         // Short URL to its creation time
+	ShortsToUpdatedAtMap      map[string]time.Time                         // Short URL to the time of the last change of its original URL
+	ShortsToAttributesMap     map[string]models.URLAttributes              // Short URL to its attributes
+	UsersShortsToTagsMap      map[string]map[string][]string               // User ID to short URL to the sorted tags of the user's link
+	ShortsToClicksLeftMap     map[string]int                               // Short URL to its redirects left, for links limited to a number of redirects
+	UsersToUTMDefaultsMap     map[string]models.UTMParams                  // User ID to the user's default UTM parameters
+	ShortsToAbuseReportsMap   map[string][]models.AbuseReportRecord        // Short URL to the abuse reports on it
+	ShortsToDisabledAtMap     map[string]time.Time                         // Short URL to the time it was disabled by the moderators
+	RefreshTokensMap          map[string]models.RefreshTokenRecord         // Refresh token hash to the user and the expiry of the token
+	RevokedTokensMap          map[string]time.Time                         // Revoked access token ID to the expiry of the token
+
+	// Legacy URL-keyed structures; migrated to the short-keyed ones on load.
+	UsersIdsToUrlsMap  map[string][]string `json:",omitempty"`
+	UrlsToUsersIdsMap  map[string][]string `json:",omitempty"`
+	UrlsToIsDeletedMap map[string]bool     `json:",omitempty"`
 }
 
+// InitOption defines a functional option for configuring a JSONDB.
+type InitOption func(*JSONDB)
+
 // New creates and initializes a new JSONDB instance with the specified file.
-func New(fileName string) (*JSONDB, error) {
+func New(fileName string, optionsProto ...InitOption) (*JSONDB, error) {
 	simpleJSONDB := JSONDB{
-		fileName: fileName,
-		Cache:    CacheStruct{},
+		fileName:         fileName,
+		urlOwnershipMode: models.URLOwnershipModeShared,
+		Cache:            CacheStruct{},
+	}
+	for _, protoOption := range optionsProto {
+		protoOption(&simpleJSONDB)
 	}
 
 	err := parseJSONFile(simpleJSONDB.fileName, &simpleJSONDB.Cache)
@@ -56,97 +91,967 @@ func New(fileName string) (*JSONDB, error) {
 		}
 	}
 
+	simpleJSONDB.Cache.migrateLegacyStructures()
+
 	return &simpleJSONDB, nil
 }
 
+// WithURLOwnershipMode sets the URL ownership mode (models.URLOwnershipModeShared
+// or models.URLOwnershipModePerUser), which defines how URL removal affects other users.
+func WithURLOwnershipMode(value string) InitOption {
+	return func(db *JSONDB) {
+		db.urlOwnershipMode = value
+	}
+}
+
 // RemoveUsersUrls marks specified URLs as deleted for the given users.
+// In the shared ownership mode the short URL is deleted for everyone linked to it;
+// in the per-user mode only the user's own link is deleted, and the short URL
+// itself once no other user links to it anymore.
 func (db *JSONDB) RemoveUsersUrls(
 	ctx context.Context,
 	usersURLs map[string][]string,
 ) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
 	for userID, shortURLs := range usersURLs {
 		for _, shortURL := range shortURLs {
-			fullURL := db.Cache.ShortToFull[shortURL]
-			usersIds, ok := db.Cache.UrlsToUsersIdsMap[fullURL]
-			if ok && funk.Contains(usersIds, userID) {
-				db.Cache.UrlsToIsDeletedMap[fullURL] = true
+			if !funk.ContainsString(db.Cache.ShortsToUsersIdsMap[shortURL], userID) {
+				continue
 			}
+			if db.urlOwnershipMode == models.URLOwnershipModePerUser {
+				db.removeUserLink(userID, shortURL)
+				continue
+			}
+			db.markShortAsDeleted(shortURL)
 		}
 	}
 
 	return nil
 }
 
-// SaveUserUrls associates a list of URLs with a user ID.
+// RestoreUsersUrls undoes the deletion of the user's short URLs deleted after deletedAfter
+// and returns the restored ones. In the shared ownership mode the short URL is restored for
+// everyone linked to it; in the per-user mode the user's own link is restored, together with
+// the short URL itself if it was deleted.
+func (db *JSONDB) RestoreUsersUrls(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	deletedAfter time.Time,
+) ([]string, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	restored := []string{}
+	for _, shortURL := range shortURLs {
+		if !funk.ContainsString(db.Cache.ShortsToUsersIdsMap[shortURL], userID) {
+			continue
+		}
+		if db.urlOwnershipMode == models.URLOwnershipModePerUser {
+			if !db.Cache.UsersShortsToIsDeletedMap[userID][shortURL] ||
+				db.Cache.UsersShortsToDeletedAtMap[userID][shortURL].Before(deletedAfter) {
+				continue
+			}
+			delete(db.Cache.UsersShortsToIsDeletedMap[userID], shortURL)
+			delete(db.Cache.UsersShortsToDeletedAtMap[userID], shortURL)
+		} else if !db.Cache.ShortsToIsDeletedMap[shortURL] ||
+			db.Cache.ShortsToDeletedAtMap[shortURL].Before(deletedAfter) {
+			continue
+		}
+		delete(db.Cache.ShortsToIsDeletedMap, shortURL)
+		delete(db.Cache.ShortsToDeletedAtMap, shortURL)
+		restored = append(restored, shortURL)
+	}
+
+	return restored, nil
+}
+
+// PurgeExpiredTokens removes the refresh tokens and the revoked access token IDs expired before
+// expiredBefore. Returns the number of removed records.
+func (db *JSONDB) PurgeExpiredTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	var purged int64
+	for tokenHash, refreshToken := range db.Cache.RefreshTokensMap {
+		if refreshToken.ExpiresAt.Before(expiredBefore) {
+			delete(db.Cache.RefreshTokensMap, tokenHash)
+			purged++
+		}
+	}
+	for tokenID, expiresAt := range db.Cache.RevokedTokensMap {
+		if expiresAt.Before(expiredBefore) {
+			delete(db.Cache.RevokedTokensMap, tokenID)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// PurgeDeletedUrls permanently removes the short URLs and user links deleted before deletedBefore.
+// Returns the number of removed records.
+func (db *JSONDB) PurgeDeletedUrls(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	var purged int64
+
+	for short, isDeleted := range db.Cache.ShortsToIsDeletedMap {
+		if isDeleted && db.Cache.ShortsToDeletedAtMap[short].Before(deletedBefore) {
+			db.purgeShort(short)
+			purged++
+		}
+	}
+
+	for userID, shortsToIsDeleted := range db.Cache.UsersShortsToIsDeletedMap {
+		for short, isDeleted := range shortsToIsDeleted {
+			if isDeleted && db.Cache.UsersShortsToDeletedAtMap[userID][short].Before(deletedBefore) {
+				db.unlinkUserFromShort(userID, short)
+				purged++
+			}
+		}
+	}
+
+	return purged, nil
+}
+
+// SaveUserUrls associates a list of short URLs with a user ID. The user's deleted links to
+// the short URLs are restored, and so are the deleted short URLs themselves, so that
+// shortening a URL again brings its link back.
 func (db *JSONDB) SaveUserUrls(
 	ctx context.Context,
 	userID string,
-	urls []string,
+	shortURLs []string,
 	transaction *sql.Tx,
 ) error {
-	for _, url := range urls {
-		_, exists := db.Cache.UsersIdsToUrlsMap[userID]
-		if !exists {
-			db.Cache.UsersIdsToUrlsMap[userID] = []string{}
-		}
-		db.Cache.UsersIdsToUrlsMap[userID] = append(db.Cache.UsersIdsToUrlsMap[userID], url)
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
 
-		_, exists = db.Cache.UrlsToUsersIdsMap[url]
-		if !exists {
-			db.Cache.UrlsToUsersIdsMap[url] = []string{}
-		}
-		db.Cache.UrlsToUsersIdsMap[url] = append(db.Cache.UrlsToUsersIdsMap[url], userID)
+	for _, short := range shortURLs {
+		db.Cache.linkUserToShort(userID, short)
+		delete(db.Cache.UsersShortsToIsDeletedMap[userID], short)
+		delete(db.Cache.UsersShortsToDeletedAtMap[userID], short)
+		delete(db.Cache.ShortsToIsDeletedMap, short)
+		delete(db.Cache.ShortsToDeletedAtMap, short)
 	}
 
 	return nil
 }
 
-// GetUserUrls retrieves a list of URLs associated with a user ID,
+// GetUserUrls retrieves the page of the user's URLs defined by query,
 // formatted using the provided function if available.
 func (db *JSONDB) GetUserUrls(
 	ctx context.Context,
 	userID string,
+	query models.UserURLsQuery,
 	shortURLFormatter models.URLFormatter, /*func(string) string*/
 ) (models.UserUrls, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
 	formatter := func(str string) string { return str }
 	if shortURLFormatter != nil {
 		formatter = shortURLFormatter
 	}
 
+	search := strings.ToLower(query.Search)
+	userURLs := models.UserUrls{}
+	for _, short := range db.Cache.UsersIdsToShortsMap[userID] {
+		if !db.isUserLinkLive(userID, short) {
+			continue
+		}
+		full := db.Cache.ShortToFull[short]
+		if !strings.Contains(strings.ToLower(full), search) {
+			continue
+		}
+		tags := db.Cache.UsersShortsToTagsMap[userID][short]
+		if query.Tag != "" && !funk.ContainsString(tags, query.Tag) {
+			continue
+		}
+		attributes := db.Cache.ShortsToAttributesMap[short]
+		userURL := models.UserURL{
+			ShortURL:    short,
+			OriginalURL: full,
+			Title:       attributes.Title,
+			Description: attributes.Description,
+			CreatedAt:   db.Cache.ShortsToCreatedAtMap[short],
+			UpdatedAt:   db.Cache.ShortsToUpdatedAtMap[short],
+			Tags:        tags,
+		}
+		if !attributes.ActiveFrom.IsZero() {
+			userURL.ActiveFrom = &attributes.ActiveFrom
+		}
+		userURLs = append(userURLs, userURL)
+	}
+
+	sort.Slice(userURLs, func(i, j int) bool {
+		return compareUserURLs(query, userURLs[i], toUserURLsCursor(userURLs[j])) < 0
+	})
+
 	result := models.UserUrls{}
-	urls, exists := db.Cache.UsersIdsToUrlsMap[userID]
-	if exists {
-		for _, url := range urls {
-			result = append(
-				result,
-				models.UserURL{
-					ShortURL:    formatter(db.Cache.FullToShort[url]),
-					OriginalURL: url,
-				},
-			)
+	for _, userURL := range userURLs {
+		if query.After != nil && compareUserURLs(query, userURL, *query.After) <= 0 {
+			continue
 		}
+		if query.Limit > 0 && len(result) == query.Limit {
+			break
+		}
+		userURL.ShortURL = formatter(userURL.ShortURL)
+		result = append(result, userURL)
 	}
 
 	return result, nil
 }
 
+// RetargetUserURL changes the original URL of the user's short URL and records the previous
+// one in the redirects history. Only the link owner may retarget it; a shared link counts as
+// owned while no other user links to it.
+// Returns models.ErrURLNotFound, models.ErrURLNotOwned or models.ErrURLAlreadyShortened
+// when the change is not possible.
+func (db *JSONDB) RetargetUserURL(ctx context.Context, userID, short, full string) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	ownerID, err := db.checkUserLinkOwnership(userID, short)
+	if err != nil {
+		return err
+	}
+
+	oldFull := db.Cache.ShortToFull[short]
+	if oldFull == full {
+		return nil
+	}
+
+	if _, found := db.findShortByFull(full, ownerID); found {
+		return models.ErrURLAlreadyShortened
+	}
+
+	db.Cache.ShortsToHistoryMap[short] = append(
+		db.Cache.ShortsToHistoryMap[short],
+		models.URLRedirectHistoryRecord{
+			OriginalURL: oldFull,
+			ChangedBy:   userID,
+			ChangedAt:   time.Now(),
+		},
+	)
+
+	db.Cache.ShortToFull[short] = full
+	db.Cache.ShortsToUpdatedAtMap[short] = time.Now()
+	if db.Cache.DedicatedShortsMap[short] {
+		return nil
+	}
+	if ownerID == "" {
+		delete(db.Cache.FullToShort, oldFull)
+		db.Cache.FullToShort[full] = short
+
+		return nil
+	}
+	delete(db.Cache.OwnersToFullsToShortsMap[ownerID], oldFull)
+	db.Cache.OwnersToFullsToShortsMap[ownerID][full] = short
+
+	return nil
+}
+
+// SetUserURLActiveFrom changes the time before which the user's short URL does not redirect;
+// a zero activeFrom makes it always active. The ownership rules are the ones of RetargetUserURL.
+// Returns models.ErrURLNotFound or models.ErrURLNotOwned when the change is not possible.
+// Setting the time makes the link dedicated for good, so that the later shortenings
+// of its original URL do not get it.
+func (db *JSONDB) SetUserURLActiveFrom(ctx context.Context, userID, short string, activeFrom time.Time) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	_, err := db.checkUserLinkOwnership(userID, short)
+	if err != nil {
+		return err
+	}
+
+	attributes := db.Cache.ShortsToAttributesMap[short]
+	attributes.ActiveFrom = activeFrom
+	db.Cache.ShortsToAttributesMap[short] = attributes
+	if !activeFrom.IsZero() {
+		db.Cache.dedicateLink(short)
+	}
+
+	return nil
+}
+
+// SetUserURLRedirectRules replaces the redirect rules of the user's short URL;
+// see checkUserLinkOwnership for the returned errors. Setting some makes the link dedicated
+// for good, so that the later shortenings of its original URL do not get it.
+func (db *JSONDB) SetUserURLRedirectRules(
+	ctx context.Context,
+	userID, short string,
+	rules models.RedirectRules,
+) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	_, err := db.checkUserLinkOwnership(userID, short)
+	if err != nil {
+		return err
+	}
+
+	attributes := db.Cache.ShortsToAttributesMap[short]
+	attributes.RedirectRules = rules
+	db.Cache.ShortsToAttributesMap[short] = attributes
+	if len(rules) > 0 {
+		db.Cache.dedicateLink(short)
+	}
+
+	return nil
+}
+
+// GetUserURLRedirectRules returns the redirect rules of the user's short URL,
+// or models.ErrURLNotFound if the user has no such live link.
+func (db *JSONDB) GetUserURLRedirectRules(ctx context.Context, userID, short string) (models.RedirectRules, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	if _, found := db.Cache.ShortToFull[short]; !found || !db.isUserLinkLive(userID, short) {
+		return nil, models.ErrURLNotFound
+	}
+
+	rules := db.Cache.ShortsToAttributesMap[short].RedirectRules
+	if rules == nil {
+		rules = models.RedirectRules{}
+	}
+
+	return rules, nil
+}
+
+// SetUserURLDestinations replaces the A/B destinations of the user's short URL;
+// see checkUserLinkOwnership for the returned errors. Setting some makes the link dedicated
+// for good, so that the later shortenings of its original URL do not get it.
+func (db *JSONDB) SetUserURLDestinations(
+	ctx context.Context,
+	userID, short string,
+	destinations models.Destinations,
+) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	_, err := db.checkUserLinkOwnership(userID, short)
+	if err != nil {
+		return err
+	}
+
+	attributes := db.Cache.ShortsToAttributesMap[short]
+	attributes.Destinations = destinations
+	db.Cache.ShortsToAttributesMap[short] = attributes
+	if len(destinations) > 0 {
+		db.Cache.dedicateLink(short)
+	}
+
+	return nil
+}
+
+// GetUserURLDestinations returns the A/B destinations of the user's short URL,
+// or models.ErrURLNotFound if the user has no such live link.
+func (db *JSONDB) GetUserURLDestinations(ctx context.Context, userID, short string) (models.Destinations, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	if _, found := db.Cache.ShortToFull[short]; !found || !db.isUserLinkLive(userID, short) {
+		return nil, models.ErrURLNotFound
+	}
+
+	destinations := db.Cache.ShortsToAttributesMap[short].Destinations
+	if destinations == nil {
+		destinations = models.Destinations{}
+	}
+
+	return destinations, nil
+}
+
+// AddUserURLsTags attaches the tags to the user's live short URLs among shortURLs
+// and returns those short URLs.
+func (db *JSONDB) AddUserURLsTags(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	tags []string,
+) ([]string, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	tagged := []string{}
+	for _, short := range shortURLs {
+		if !db.isUserLinkLive(userID, short) {
+			continue
+		}
+		if _, exists := db.Cache.UsersShortsToTagsMap[userID]; !exists {
+			db.Cache.UsersShortsToTagsMap[userID] = map[string][]string{}
+		}
+		shortTags := funk.UniqString(append(db.Cache.UsersShortsToTagsMap[userID][short], tags...))
+		sort.Strings(shortTags)
+		db.Cache.UsersShortsToTagsMap[userID][short] = shortTags
+		tagged = append(tagged, short)
+	}
+
+	return tagged, nil
+}
+
+// RemoveUserURLsTags detaches the tags from the user's live short URLs among shortURLs
+// and returns those short URLs.
+func (db *JSONDB) RemoveUserURLsTags(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	tags []string,
+) ([]string, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	untagged := []string{}
+	for _, short := range shortURLs {
+		if !db.isUserLinkLive(userID, short) {
+			continue
+		}
+		shortTags := funk.SubtractString(db.Cache.UsersShortsToTagsMap[userID][short], tags)
+		if len(shortTags) == 0 {
+			delete(db.Cache.UsersShortsToTagsMap[userID], short)
+		} else {
+			db.Cache.UsersShortsToTagsMap[userID][short] = shortTags
+		}
+		untagged = append(untagged, short)
+	}
+
+	return untagged, nil
+}
+
+// GetUserTags returns the tags of the user with the number of the user's live URLs carrying each, ordered by tag.
+func (db *JSONDB) GetUserTags(ctx context.Context, userID string) (models.UserTags, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	tagsToCounts := map[string]int64{}
+	for short, tags := range db.Cache.UsersShortsToTagsMap[userID] {
+		if !db.isUserLinkLive(userID, short) {
+			continue
+		}
+		for _, tag := range tags {
+			tagsToCounts[tag]++
+		}
+	}
+
+	result := models.UserTags{}
+	for tag, count := range tagsToCounts {
+		result = append(result, models.UserTag{Tag: tag, URLsCount: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Tag < result[j].Tag
+	})
+
+	return result, nil
+}
+
+// GetUserUTMDefaults returns the default UTM parameters of the user, all empty if the user has none.
+func (db *JSONDB) GetUserUTMDefaults(ctx context.Context, userID string) (models.UTMParams, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	return db.Cache.UsersToUTMDefaultsMap[userID], nil
+}
+
+// SetUserUTMDefaults replaces the default UTM parameters of the user.
+func (db *JSONDB) SetUserUTMDefaults(ctx context.Context, userID string, defaults models.UTMParams) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	db.Cache.UsersToUTMDefaultsMap[userID] = defaults
+
+	return nil
+}
+
+// ReportURL files an abuse report on the short URL. A repeated report of the same user is ignored
+// while their previous one is pending, and so are the reports on a disabled short URL.
+// Returns models.ErrURLNotFound if the short URL does not exist or is deleted.
+func (db *JSONDB) ReportURL(ctx context.Context, short, reporterID, reason string) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	if _, found := db.Cache.ShortToFull[short]; !found || db.Cache.ShortsToIsDeletedMap[short] {
+		return models.ErrURLNotFound
+	}
+	if _, disabled := db.Cache.ShortsToDisabledAtMap[short]; disabled {
+		return nil
+	}
+
+	for _, report := range db.Cache.ShortsToAbuseReportsMap[short] {
+		if report.ReporterID == reporterID && report.Status == models.AbuseReportStatusPending {
+			return nil
+		}
+	}
+
+	db.Cache.ShortsToAbuseReportsMap[short] = append(db.Cache.ShortsToAbuseReportsMap[short], models.AbuseReportRecord{
+		ReporterID: reporterID,
+		Reason:     reason,
+		Status:     models.AbuseReportStatusPending,
+		CreatedAt:  time.Now(),
+	})
+
+	return nil
+}
+
+// GetAbuseReportsQueue returns the short URLs with pending abuse reports, the most reported first,
+// formatted using the provided function.
+func (db *JSONDB) GetAbuseReportsQueue(
+	ctx context.Context,
+	formatter models.URLFormatter,
+) (models.AbuseReportsQueue, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	result := models.AbuseReportsQueue{}
+	for short, reports := range db.Cache.ShortsToAbuseReportsMap {
+		item := models.AbuseReportsQueueItem{
+			ShortURL:    short,
+			OriginalURL: db.Cache.ShortToFull[short],
+			Reasons:     []string{},
+		}
+		for _, report := range reports {
+			if report.Status != models.AbuseReportStatusPending {
+				continue
+			}
+			if item.ReportsCount == 0 || report.CreatedAt.Before(item.FirstReportedAt) {
+				item.FirstReportedAt = report.CreatedAt
+			}
+			if report.CreatedAt.After(item.LastReportedAt) {
+				item.LastReportedAt = report.CreatedAt
+			}
+			if report.Reason != "" && !funk.ContainsString(item.Reasons, report.Reason) {
+				item.Reasons = append(item.Reasons, report.Reason)
+			}
+			item.ReportsCount++
+		}
+		if item.ReportsCount == 0 {
+			continue
+		}
+		sort.Strings(item.Reasons)
+		result = append(result, item)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ReportsCount != result[j].ReportsCount {
+			return result[i].ReportsCount > result[j].ReportsCount
+		}
+		if !result[i].FirstReportedAt.Equal(result[j].FirstReportedAt) {
+			return result[i].FirstReportedAt.Before(result[j].FirstReportedAt)
+		}
+		return result[i].ShortURL < result[j].ShortURL
+	})
+
+	if formatter != nil {
+		for i := range result {
+			result[i].ShortURL = formatter(result[i].ShortURL)
+		}
+	}
+
+	return result, nil
+}
+
+// DisableURL disables the short URL for everyone and marks its pending abuse reports as actioned.
+// Disabling a disabled short URL keeps its original disabling time.
+// Returns models.ErrURLNotFound if the short URL does not exist.
+func (db *JSONDB) DisableURL(ctx context.Context, short string) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	if _, found := db.Cache.ShortToFull[short]; !found {
+		return models.ErrURLNotFound
+	}
+
+	if _, disabled := db.Cache.ShortsToDisabledAtMap[short]; !disabled {
+		db.Cache.ShortsToDisabledAtMap[short] = time.Now()
+	}
+	db.setPendingAbuseReportsStatus(short, models.AbuseReportStatusActioned)
+
+	return nil
+}
+
+// DismissURLReports dismisses the pending abuse reports on the short URL and returns how many there were.
+func (db *JSONDB) DismissURLReports(ctx context.Context, short string) (int64, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	return db.setPendingAbuseReportsStatus(short, models.AbuseReportStatusDismissed), nil
+}
+
 // CreateUser generates a new user ID, stores the user, and returns the ID.
 func (db *JSONDB) CreateUser(ctx context.Context, usr *user.User, transaction *sql.Tx) (string, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
 	usr.ID = uuid.New().String()
+	if usr.Role == "" {
+		usr.Role = user.RoleUser
+	}
 	db.Cache.Users[usr.ID] = usr
 	return usr.ID, nil
 }
 
 // GetUserByID retrieves a user by their ID. If not found, returns a user with an empty ID.
 func (db *JSONDB) GetUserByID(ctx context.Context, userID string, transaction *sql.Tx) (*user.User, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
 	usr, found := db.Cache.Users[userID]
 	if found {
-		return usr, nil
+		found := *usr
+
+		return &found, nil
 	}
 
 	return &user.User{ID: ""}, nil
 }
 
+// SetUserRole changes the role of the user to one of the user role constants.
+// Returns models.ErrUserNotFound if the user does not exist.
+func (db *JSONDB) SetUserRole(ctx context.Context, userID, role string) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	usr, found := db.Cache.Users[userID]
+	if !found {
+		return models.ErrUserNotFound
+	}
+	usr.Role = role
+
+	return nil
+}
+
+// RegisterUser gives the anonymous user an account to sign in with the email and the password hash.
+// Returns models.ErrUserNotFound if the user does not exist, models.ErrUserAlreadyRegistered if
+// the user has an account already, or models.ErrEmailTaken if another account has the email.
+func (db *JSONDB) RegisterUser(ctx context.Context, userID, email, passwordHash string) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	usr, found := db.Cache.Users[userID]
+	if !found {
+		return models.ErrUserNotFound
+	}
+	if usr.IsRegistered() {
+		return models.ErrUserAlreadyRegistered
+	}
+	if db.findUserByEmail(email) != nil {
+		return models.ErrEmailTaken
+	}
+
+	usr.Email = email
+	usr.PasswordHash = passwordHash
+
+	return nil
+}
+
+// GetUserByEmail retrieves the registered user by their email. If not found, returns a user with an empty ID.
+func (db *JSONDB) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	usr := db.findUserByEmail(email)
+	if usr == nil {
+		return &user.User{ID: ""}, nil
+	}
+	found := *usr
+
+	return &found, nil
+}
+
+func (db *JSONDB) findUserByEmail(email string) *user.User {
+	for _, usr := range db.Cache.Users {
+		if usr.IsRegistered() && usr.Email == email {
+			return usr
+		}
+	}
+
+	return nil
+}
+
+// ClaimUserURLs moves the links of the anonymous user to the registered user and returns how many
+// the registered user did not have yet. The tags and the deletion states of the links go along,
+// and so does the ownership of the owned short URLs, but for the ones whose original URL the
+// registered user owns a short URL for already. Nothing is claimed from registered users.
+func (db *JSONDB) ClaimUserURLs(ctx context.Context, anonymousUserID, userID string) (int64, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	anonymousUser, found := db.Cache.Users[anonymousUserID]
+	if !found || anonymousUser.IsRegistered() || anonymousUserID == userID {
+		return 0, nil
+	}
+
+	var claimed int64
+	for _, short := range db.Cache.UsersIdsToShortsMap[anonymousUserID] {
+		if !funk.ContainsString(db.Cache.ShortsToUsersIdsMap[short], userID) {
+			db.Cache.linkUserToShort(userID, short)
+			if db.Cache.UsersShortsToIsDeletedMap[anonymousUserID][short] {
+				db.copyUserLinkDeletion(anonymousUserID, userID, short)
+			}
+			claimed++
+		}
+
+		if tags := db.Cache.UsersShortsToTagsMap[anonymousUserID][short]; len(tags) > 0 {
+			if _, exists := db.Cache.UsersShortsToTagsMap[userID]; !exists {
+				db.Cache.UsersShortsToTagsMap[userID] = map[string][]string{}
+			}
+			shortTags := funk.UniqString(append(db.Cache.UsersShortsToTagsMap[userID][short], tags...))
+			sort.Strings(shortTags)
+			db.Cache.UsersShortsToTagsMap[userID][short] = shortTags
+		}
+
+		full := db.Cache.ShortToFull[short]
+		_, ownsFull := db.Cache.OwnersToFullsToShortsMap[userID][full]
+		if db.Cache.ShortsToOwnersMap[short] == anonymousUserID && db.Cache.DedicatedShortsMap[short] {
+			db.Cache.ShortsToOwnersMap[short] = userID
+		} else if db.Cache.ShortsToOwnersMap[short] == anonymousUserID && !ownsFull {
+			delete(db.Cache.OwnersToFullsToShortsMap[anonymousUserID], full)
+			if _, exists := db.Cache.OwnersToFullsToShortsMap[userID]; !exists {
+				db.Cache.OwnersToFullsToShortsMap[userID] = map[string]string{}
+			}
+			db.Cache.OwnersToFullsToShortsMap[userID][full] = short
+			db.Cache.ShortsToOwnersMap[short] = userID
+		}
+
+		db.unlinkUserFromShort(anonymousUserID, short)
+	}
+
+	return claimed, nil
+}
+
+// CanonicalizeStoredURLs brings the stored original URLs to their canonical form and merges
+// the links of the same owner whose original URLs turn out to be the same, returning how many
+// were merged: the users and the tags of the duplicates move to the link that already had
+// the canonical URL, or else to the oldest one. The duplicates themselves are kept with their
+// original URLs, so that the short URLs already handed out keep redirecting. The dedicated links
+// are left as they are. This is a one-way step: the merges cannot be undone.
+func (db *JSONDB) CanonicalizeStoredURLs(
+	ctx context.Context,
+	canonicalize func(string) (string, error),
+) (int64, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	shorts := funk.Keys(db.Cache.ShortToFull).([]string)
+	sort.Slice(shorts, func(i, j int) bool {
+		createdAtI, createdAtJ := db.Cache.ShortsToCreatedAtMap[shorts[i]], db.Cache.ShortsToCreatedAtMap[shorts[j]]
+		if !createdAtI.Equal(createdAtJ) {
+			return createdAtI.Before(createdAtJ)
+		}
+		return shorts[i] < shorts[j]
+	})
+
+	groups := map[string][]string{}
+	groupsCanonicalURLs := map[string]string{}
+	var groupKeys []string
+	for _, short := range shorts {
+		if db.Cache.DedicatedShortsMap[short] {
+			continue
+		}
+
+		full := db.Cache.ShortToFull[short]
+		canonicalURL, err := canonicalize(full)
+		if err != nil {
+			canonicalURL = full
+		}
+
+		groupKey := db.Cache.ShortsToOwnersMap[short] + " " + canonicalURL
+		if _, found := groups[groupKey]; !found {
+			groupKeys = append(groupKeys, groupKey)
+			groupsCanonicalURLs[groupKey] = canonicalURL
+		}
+		groups[groupKey] = append(groups[groupKey], short)
+	}
+
+	var merged int64
+	for _, groupKey := range groupKeys {
+		db.mergeCanonicalizedURLs(groups[groupKey], groupsCanonicalURLs[groupKey])
+		merged += int64(len(groups[groupKey]) - 1)
+	}
+
+	return merged, nil
+}
+
+// mergeCanonicalizedURLs moves the users of the short URLs with the same canonical URL to the one
+// having this URL already, or else to the first one, and gives it the canonical URL. The link is
+// restored if one of its users keeps it.
+func (db *JSONDB) mergeCanonicalizedURLs(shorts []string, canonicalURL string) {
+	survivor := shorts[0]
+	for _, short := range shorts {
+		if db.Cache.ShortToFull[short] == canonicalURL {
+			survivor = short
+			break
+		}
+	}
+
+	for _, duplicate := range shorts {
+		if duplicate == survivor {
+			continue
+		}
+		for _, userID := range append([]string{}, db.Cache.ShortsToUsersIdsMap[duplicate]...) {
+			db.moveUserLink(userID, duplicate, survivor)
+		}
+	}
+	for _, userID := range db.Cache.ShortsToUsersIdsMap[survivor] {
+		if !db.Cache.UsersShortsToIsDeletedMap[userID][survivor] {
+			delete(db.Cache.ShortsToIsDeletedMap, survivor)
+			delete(db.Cache.ShortsToDeletedAtMap, survivor)
+			break
+		}
+	}
+
+	full := db.Cache.ShortToFull[survivor]
+	if full == canonicalURL {
+		return
+	}
+
+	db.Cache.ShortToFull[survivor] = canonicalURL
+	if ownerID, owned := db.Cache.ShortsToOwnersMap[survivor]; owned {
+		if db.Cache.OwnersToFullsToShortsMap[ownerID][full] == survivor {
+			delete(db.Cache.OwnersToFullsToShortsMap[ownerID], full)
+		}
+		db.Cache.OwnersToFullsToShortsMap[ownerID][canonicalURL] = survivor
+	} else {
+		if db.Cache.FullToShort[full] == survivor {
+			delete(db.Cache.FullToShort, full)
+		}
+		db.Cache.FullToShort[canonicalURL] = survivor
+	}
+}
+
+// SaveRefreshToken stores the hash of a refresh token of the user, valid until expiresAt.
+func (db *JSONDB) SaveRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	db.Cache.RefreshTokensMap[tokenHash] = models.RefreshTokenRecord{UserID: userID, ExpiresAt: expiresAt}
+
+	return nil
+}
+
+// ConsumeRefreshToken deletes the refresh token of the given hash, so that it is used once,
+// and returns the ID of its user. Returns an empty ID if there is no such token or it has expired.
+func (db *JSONDB) ConsumeRefreshToken(ctx context.Context, tokenHash string) (string, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	refreshToken, found := db.Cache.RefreshTokensMap[tokenHash]
+	if !found {
+		return "", nil
+	}
+	delete(db.Cache.RefreshTokensMap, tokenHash)
+	if refreshToken.ExpiresAt.Before(time.Now()) {
+		return "", nil
+	}
+
+	return refreshToken.UserID, nil
+}
+
+// GetRefreshTokenUserID returns the ID of the user of the refresh token of the given hash,
+// leaving the token usable. Returns an empty ID if there is no such token or it has expired.
+func (db *JSONDB) GetRefreshTokenUserID(ctx context.Context, tokenHash string) (string, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	refreshToken, found := db.Cache.RefreshTokensMap[tokenHash]
+	if !found || refreshToken.ExpiresAt.Before(time.Now()) {
+		return "", nil
+	}
+
+	return refreshToken.UserID, nil
+}
+
+// DeleteRefreshToken deletes the refresh token of the given hash, if any.
+func (db *JSONDB) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	delete(db.Cache.RefreshTokensMap, tokenHash)
+
+	return nil
+}
+
+// RevokeToken puts the ID of an access token on the revocation list until the token expires.
+func (db *JSONDB) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	db.Cache.RevokedTokensMap[tokenID] = expiresAt
+
+	return nil
+}
+
+// IsTokenRevoked tells whether the ID of an access token is on the revocation list.
+func (db *JSONDB) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	_, revoked := db.Cache.RevokedTokensMap[tokenID]
+
+	return revoked, nil
+}
+
+// SearchURLs retrieves the page defined by query of all the short URLs, deleted and disabled ones
+// included, whose original URL or short URL contains the search string. The tag of the query is
+// ignored. Optionally applies a formatter to each short URL before returning.
+func (db *JSONDB) SearchURLs(
+	ctx context.Context,
+	query models.UserURLsQuery,
+	formatter models.URLFormatter,
+) (models.AdminURLs, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	search := strings.ToLower(query.Search)
+	found := models.UserUrls{}
+	for short, full := range db.Cache.ShortToFull {
+		if !strings.Contains(strings.ToLower(full), search) && !strings.Contains(strings.ToLower(short), search) {
+			continue
+		}
+		userURL := models.UserURL{
+			ShortURL:    short,
+			OriginalURL: full,
+			CreatedAt:   db.Cache.ShortsToCreatedAtMap[short],
+		}
+		if query.After != nil && compareUserURLs(query, userURL, *query.After) <= 0 {
+			continue
+		}
+		found = append(found, userURL)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return compareUserURLs(query, found[i], toUserURLsCursor(found[j])) < 0
+	})
+	if query.Limit > 0 && len(found) > query.Limit {
+		found = found[:query.Limit]
+	}
+
+	result := models.AdminURLs{}
+	for _, userURL := range found {
+		adminURL := models.AdminURL{
+			ShortURL:    userURL.ShortURL,
+			OriginalURL: userURL.OriginalURL,
+			OwnerID:     db.Cache.ShortsToOwnersMap[userURL.ShortURL],
+			CreatedBy:   db.Cache.ShortsToAttributesMap[userURL.ShortURL].CreatedBy,
+			CreatedAt:   userURL.CreatedAt,
+			IsDeleted:   db.Cache.ShortsToIsDeletedMap[userURL.ShortURL],
+		}
+		if disabledAt, disabled := db.Cache.ShortsToDisabledAtMap[userURL.ShortURL]; disabled {
+			adminURL.DisabledAt = &disabledAt
+		}
+		if formatter != nil {
+			adminURL.ShortURL = formatter(adminURL.ShortURL)
+		}
+		result = append(result, adminURL)
+	}
+
+	return result, nil
+}
+
 // CommitTransaction is a no-op method to match expected interfaces.
 func (db *JSONDB) CommitTransaction(transaction *sql.Tx) error {
 	return nil
@@ -163,34 +1068,39 @@ func (db *JSONDB) BeginTransaction() (*sql.Tx, error) {
 }
 
 // SaveNewFullsAndShorts stores new full-to-short URL mappings in the cache.
+// The mappings belong to ownerID, or are shared when ownerID is empty,
+// and get the attributes given for their full URLs.
 func (db *JSONDB) SaveNewFullsAndShorts(
 	ctx context.Context,
 	unexistentFullsToShortsMap map[string]string,
+	ownerID string,
+	attributes map[string]models.URLAttributes,
 	transaction *sql.Tx,
 ) error {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
 	for full, short := range unexistentFullsToShortsMap {
-		err := db.InsertURLMapping(ctx, short, full, transaction)
-		if err != nil {
-			return err
-		}
+		db.insertURLMapping(short, full, ownerID, attributes[full])
 	}
 
 	return nil
 }
 
 // FindShortsByFulls retrieves all known short URLs for the given list of full URLs.
+// See FindShortByFull for how ownerID affects the lookup.
 func (db *JSONDB) FindShortsByFulls(
 	ctx context.Context,
 	originalUrls []string,
+	ownerID string,
 	transaction *sql.Tx,
 ) (map[string]string, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
 	result := map[string]string{}
 	for _, full := range originalUrls {
-		short, found, err := db.FindShortByFull(ctx, full, transaction)
-		if err != nil {
-			return nil, err
-		}
-		if found {
+		if short, found := db.findShortByFull(full, ownerID); found {
 			result[full] = short
 		}
 	}
@@ -203,21 +1113,62 @@ func (db *JSONDB) Ping(ctx context.Context) error {
 	return nil
 }
 
-// InsertURLMapping stores a mapping from short to full URL in the cache.
+// InsertURLMapping stores a mapping from short to full URL with its attributes in the cache.
+// The mapping belongs to ownerID, or is shared when ownerID is empty. A dedicated link
+// (see models.URLAttributes.IsDedicated) is not found by its full URL afterwards.
 func (db *JSONDB) InsertURLMapping(
 	ctx context.Context,
 	short string,
 	full string,
+	ownerID string,
+	attributes models.URLAttributes,
 	transaction *sql.Tx,
 ) error {
-	db.Cache.ShortToFull[short] = full
-	db.Cache.FullToShort[full] = short
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	db.insertURLMapping(short, full, ownerID, attributes)
 
 	return nil
 }
 
+func (db *JSONDB) insertURLMapping(short, full, ownerID string, attributes models.URLAttributes) {
+	now := time.Now()
+	db.Cache.ShortToFull[short] = full
+	db.Cache.ShortsToCreatedAtMap[short] = now
+	db.Cache.ShortsToUpdatedAtMap[short] = now
+	db.Cache.ShortsToAttributesMap[short] = attributes
+	if attributes.MaxClicks > 0 {
+		db.Cache.ShortsToClicksLeftMap[short] = attributes.MaxClicks
+	}
+
+	if attributes.IsDedicated() {
+		db.Cache.DedicatedShortsMap[short] = true
+		if ownerID != "" {
+			db.Cache.ShortsToOwnersMap[short] = ownerID
+		}
+
+		return
+	}
+
+	if ownerID == "" {
+		db.Cache.FullToShort[full] = short
+
+		return
+	}
+
+	if _, exists := db.Cache.OwnersToFullsToShortsMap[ownerID]; !exists {
+		db.Cache.OwnersToFullsToShortsMap[ownerID] = map[string]string{}
+	}
+	db.Cache.OwnersToFullsToShortsMap[ownerID][full] = short
+	db.Cache.ShortsToOwnersMap[short] = ownerID
+}
+
 // Close flushes the in-memory cache to disk and closes the database.
 func (db *JSONDB) Close() error {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
 	err := writeToJSONFile(db.fileName, db.Cache)
 	if err != nil {
 		return err
@@ -229,36 +1180,449 @@ func (db *JSONDB) Close() error {
 // FindFullByShort returns the full URL associated with the given short URL.
 // It returns an error if the URL has been marked as deleted.
 func (db *JSONDB) FindFullByShort(ctx context.Context, short string) (full string, found bool, err error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	return db.findFullByShort(short)
+}
+
+func (db *JSONDB) findFullByShort(short string) (full string, found bool, err error) {
 	full, found = db.Cache.ShortToFull[short]
 	err = nil
 
-	isDeleted, ok := db.Cache.UrlsToIsDeletedMap[full]
-	if ok && isDeleted {
+	if db.Cache.ShortsToIsDeletedMap[short] {
 		err = models.ErrURLMarkedAsDeleted
 	}
 
 	return
 }
 
+// FindRedirectByShort returns the original URL and the link attributes of the given short URL.
+// It returns models.ErrURLDisabled if the URL has been disabled by the moderators,
+// models.ErrURLMarkedAsDeleted if it has been marked as deleted,
+// and models.ErrURLClicksExhausted if it has no redirects left.
+func (db *JSONDB) FindRedirectByShort(ctx context.Context, short string) (models.URLRedirect, bool, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	full, found, err := db.findFullByShort(short)
+	if !found {
+		return models.URLRedirect{}, false, nil
+	}
+
+	redirect := models.URLRedirect{
+		OriginalURL: full,
+		Attributes:  db.Cache.ShortsToAttributesMap[short],
+		CreatedAt:   db.Cache.ShortsToCreatedAtMap[short],
+	}
+	if _, disabled := db.Cache.ShortsToDisabledAtMap[short]; disabled {
+		return redirect, true, models.ErrURLDisabled
+	}
+	if err != nil {
+		return redirect, true, err
+	}
+
+	if redirect.Attributes.MaxClicks > 0 {
+		if db.Cache.ShortsToClicksLeftMap[short] == 0 {
+			return redirect, true, models.ErrURLClicksExhausted
+		}
+	}
+
+	return redirect, true, nil
+}
+
+// ConsumeURLClick takes one redirect from a short URL limited to a number of redirects
+// and returns how many are left. Returns models.ErrURLClicksExhausted if none were left.
+func (db *JSONDB) ConsumeURLClick(ctx context.Context, short string) (int, error) {
+	db.cacheMutex.Lock()
+	defer db.cacheMutex.Unlock()
+
+	clicksLeft := db.Cache.ShortsToClicksLeftMap[short]
+	if clicksLeft == 0 || db.Cache.ShortsToIsDeletedMap[short] {
+		return 0, models.ErrURLClicksExhausted
+	}
+	db.Cache.ShortsToClicksLeftMap[short] = clicksLeft - 1
+
+	return clicksLeft - 1, nil
+}
+
 // FindShortByFull returns the short URL associated with the given full URL.
+// With an empty ownerID only shared links are considered, otherwise the owner's
+// link or a shared link the owner is linked to.
 func (db *JSONDB) FindShortByFull(
 	ctx context.Context,
 	full string,
+	ownerID string,
 	transaction *sql.Tx,
 ) (short string, found bool, err error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
+	short, found = db.findShortByFull(full, ownerID)
+
+	return short, found, nil
+}
+
+func (db *JSONDB) findShortByFull(full, ownerID string) (string, bool) {
+	if ownerID == "" {
+		short, found := db.Cache.FullToShort[full]
+
+		return short, found
+	}
+
+	short, found := db.Cache.OwnersToFullsToShortsMap[ownerID][full]
+	if found {
+		return short, true
+	}
+
 	short, found = db.Cache.FullToShort[full]
-	err = nil
+	if found && funk.ContainsString(db.Cache.ShortsToUsersIdsMap[short], ownerID) {
+		return short, true
+	}
 
-	return
+	return "", false
 }
 
 // IsShortExists checks whether a short URL exists in the database.
 func (db *JSONDB) IsShortExists(ctx context.Context, short string) (bool, error) {
+	db.cacheMutex.RLock()
+	defer db.cacheMutex.RUnlock()
+
 	_, exists := db.Cache.ShortToFull[short]
 
 	return exists, nil
 }
 
+func (db *JSONDB) removeUserLink(userID, short string) {
+	if db.Cache.UsersShortsToIsDeletedMap[userID][short] {
+		return
+	}
+
+	if _, exists := db.Cache.UsersShortsToIsDeletedMap[userID]; !exists {
+		db.Cache.UsersShortsToIsDeletedMap[userID] = map[string]bool{}
+	}
+	db.Cache.UsersShortsToIsDeletedMap[userID][short] = true
+
+	if _, exists := db.Cache.UsersShortsToDeletedAtMap[userID]; !exists {
+		db.Cache.UsersShortsToDeletedAtMap[userID] = map[string]time.Time{}
+	}
+	db.Cache.UsersShortsToDeletedAtMap[userID][short] = time.Now()
+
+	for _, linkedUserID := range db.Cache.ShortsToUsersIdsMap[short] {
+		if !db.Cache.UsersShortsToIsDeletedMap[linkedUserID][short] {
+			return
+		}
+	}
+	db.markShortAsDeleted(short)
+}
+
+// copyUserLinkDeletion marks the link of the user to the short URL as deleted at the time
+// the link of the other user was.
+func (db *JSONDB) copyUserLinkDeletion(fromUserID, toUserID, short string) {
+	if _, exists := db.Cache.UsersShortsToIsDeletedMap[toUserID]; !exists {
+		db.Cache.UsersShortsToIsDeletedMap[toUserID] = map[string]bool{}
+	}
+	db.Cache.UsersShortsToIsDeletedMap[toUserID][short] = true
+
+	if _, exists := db.Cache.UsersShortsToDeletedAtMap[toUserID]; !exists {
+		db.Cache.UsersShortsToDeletedAtMap[toUserID] = map[string]time.Time{}
+	}
+	db.Cache.UsersShortsToDeletedAtMap[toUserID][short] = db.Cache.UsersShortsToDeletedAtMap[fromUserID][short]
+}
+
+// moveUserLink moves the link of the user, with its tags, from a short URL to another one.
+// A user linked to both keeps the link unless they deleted both.
+func (db *JSONDB) moveUserLink(userID, fromShort, toShort string) {
+	fromDeleted := db.Cache.UsersShortsToIsDeletedMap[userID][fromShort]
+	fromDeletedAt := db.Cache.UsersShortsToDeletedAtMap[userID][fromShort]
+	switch {
+	case !funk.ContainsString(db.Cache.ShortsToUsersIdsMap[toShort], userID):
+		db.Cache.linkUserToShort(userID, toShort)
+		if fromDeleted {
+			db.Cache.UsersShortsToIsDeletedMap[userID][toShort] = true
+			db.Cache.UsersShortsToDeletedAtMap[userID][toShort] = fromDeletedAt
+		}
+	case !fromDeleted:
+		delete(db.Cache.UsersShortsToIsDeletedMap[userID], toShort)
+		delete(db.Cache.UsersShortsToDeletedAtMap[userID], toShort)
+	case db.Cache.UsersShortsToIsDeletedMap[userID][toShort] && fromDeletedAt.After(db.Cache.UsersShortsToDeletedAtMap[userID][toShort]):
+		db.Cache.UsersShortsToDeletedAtMap[userID][toShort] = fromDeletedAt
+	}
+
+	if tags := db.Cache.UsersShortsToTagsMap[userID][fromShort]; len(tags) > 0 {
+		shortTags := funk.UniqString(append(db.Cache.UsersShortsToTagsMap[userID][toShort], tags...))
+		sort.Strings(shortTags)
+		db.Cache.UsersShortsToTagsMap[userID][toShort] = shortTags
+	}
+
+	db.unlinkUserFromShort(userID, fromShort)
+}
+
+func (db *JSONDB) markShortAsDeleted(short string) {
+	if db.Cache.ShortsToIsDeletedMap[short] {
+		return
+	}
+
+	db.Cache.ShortsToIsDeletedMap[short] = true
+	db.Cache.ShortsToDeletedAtMap[short] = time.Now()
+}
+
+// purgeShort removes the short URL together with everything referencing it.
+func (db *JSONDB) purgeShort(short string) {
+	full := db.Cache.ShortToFull[short]
+	if db.Cache.FullToShort[full] == short {
+		delete(db.Cache.FullToShort, full)
+	}
+	if ownerID, owned := db.Cache.ShortsToOwnersMap[short]; owned {
+		if db.Cache.OwnersToFullsToShortsMap[ownerID][full] == short {
+			delete(db.Cache.OwnersToFullsToShortsMap[ownerID], full)
+		}
+		delete(db.Cache.ShortsToOwnersMap, short)
+	}
+
+	for _, userID := range db.Cache.ShortsToUsersIdsMap[short] {
+		db.unlinkUserFromShort(userID, short)
+	}
+
+	delete(db.Cache.ShortToFull, short)
+	delete(db.Cache.DedicatedShortsMap, short)
+	delete(db.Cache.ShortsToIsDeletedMap, short)
+	delete(db.Cache.ShortsToDeletedAtMap, short)
+	delete(db.Cache.ShortsToHistoryMap, short)
+	delete(db.Cache.ShortsToCreatedAtMap, short)
+	delete(db.Cache.ShortsToUpdatedAtMap, short)
+	delete(db.Cache.ShortsToAttributesMap, short)
+	delete(db.Cache.ShortsToAbuseReportsMap, short)
+	delete(db.Cache.ShortsToDisabledAtMap, short)
+
+	delete(db.Cache.ShortsToClicksLeftMap, short)
+}
+
+func (db *JSONDB) unlinkUserFromShort(userID, short string) {
+	db.Cache.UsersIdsToShortsMap[userID] = funk.SubtractString(db.Cache.UsersIdsToShortsMap[userID], []string{short})
+	db.Cache.ShortsToUsersIdsMap[short] = funk.SubtractString(db.Cache.ShortsToUsersIdsMap[short], []string{userID})
+	if len(db.Cache.ShortsToUsersIdsMap[short]) == 0 {
+		delete(db.Cache.ShortsToUsersIdsMap, short)
+	}
+	delete(db.Cache.UsersShortsToIsDeletedMap[userID], short)
+	delete(db.Cache.UsersShortsToDeletedAtMap[userID], short)
+	delete(db.Cache.UsersShortsToTagsMap[userID], short)
+}
+
+// setPendingAbuseReportsStatus moves the pending abuse reports on the short URL to the given status
+// and returns how many there were.
+func (db *JSONDB) setPendingAbuseReportsStatus(short, status string) int64 {
+	var changed int64
+	for i, report := range db.Cache.ShortsToAbuseReportsMap[short] {
+		if report.Status == models.AbuseReportStatusPending {
+			db.Cache.ShortsToAbuseReportsMap[short][i].Status = status
+			changed++
+		}
+	}
+
+	return changed
+}
+
+// compareUserURLs compares the URL with the cursor position in the order defined by query,
+// returning a negative number, zero or a positive number if the URL goes before, at or after it.
+func compareUserURLs(query models.UserURLsQuery, userURL models.UserURL, cursor models.UserURLsCursor) int {
+	var result int
+	if query.SortBy == models.UserURLsSortByOriginalURL {
+		result = strings.Compare(userURL.OriginalURL, cursor.OriginalURL)
+	} else {
+		result = userURL.CreatedAt.Compare(cursor.CreatedAt)
+	}
+	if result == 0 {
+		result = strings.Compare(userURL.ShortURL, cursor.Short)
+	}
+
+	if query.Descending {
+		return -result
+	}
+
+	return result
+}
+
+func toUserURLsCursor(userURL models.UserURL) models.UserURLsCursor {
+	return models.UserURLsCursor{
+		CreatedAt:   userURL.CreatedAt,
+		OriginalURL: userURL.OriginalURL,
+		Short:       userURL.ShortURL,
+	}
+}
+
+// isUserLinkLive tells whether the user is linked to the short URL and neither the link nor the short URL is deleted.
+func (db *JSONDB) isUserLinkLive(userID, short string) bool {
+	return funk.ContainsString(db.Cache.ShortsToUsersIdsMap[short], userID) &&
+		!db.Cache.ShortsToIsDeletedMap[short] &&
+		!db.Cache.UsersShortsToIsDeletedMap[userID][short]
+}
+
+// checkUserLinkOwnership returns the owner ID of the user's live short URL, empty for a shared link.
+// Returns models.ErrURLNotFound if the user has no such link, or models.ErrURLNotOwned if the link
+// is owned by, or shared with, other users.
+func (db *JSONDB) checkUserLinkOwnership(userID, short string) (string, error) {
+	if _, found := db.Cache.ShortToFull[short]; !found || !db.isUserLinkLive(userID, short) {
+		return "", models.ErrURLNotFound
+	}
+
+	ownerID := db.Cache.ShortsToOwnersMap[short]
+	if ownerID != "" && ownerID != userID || ownerID == "" && db.hasOtherLinkedUsers(userID, short) {
+		return "", models.ErrURLNotOwned
+	}
+
+	return ownerID, nil
+}
+
+func (db *JSONDB) hasOtherLinkedUsers(userID, short string) bool {
+	for _, linkedUserID := range db.Cache.ShortsToUsersIdsMap[short] {
+		if linkedUserID != userID && !db.Cache.UsersShortsToIsDeletedMap[linkedUserID][short] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dedicateLink makes the link dedicated, so that it is not found by its original URL anymore.
+func (cache *CacheStruct) dedicateLink(short string) {
+	cache.DedicatedShortsMap[short] = true
+	full := cache.ShortToFull[short]
+	if cache.FullToShort[full] == short {
+		delete(cache.FullToShort, full)
+	}
+	if ownerID, owned := cache.ShortsToOwnersMap[short]; owned && cache.OwnersToFullsToShortsMap[ownerID][full] == short {
+		delete(cache.OwnersToFullsToShortsMap[ownerID], full)
+	}
+}
+
+func (cache *CacheStruct) linkUserToShort(userID, short string) {
+	if !funk.ContainsString(cache.UsersIdsToShortsMap[userID], short) {
+		cache.UsersIdsToShortsMap[userID] = append(cache.UsersIdsToShortsMap[userID], short)
+	}
+	if !funk.ContainsString(cache.ShortsToUsersIdsMap[short], userID) {
+		cache.ShortsToUsersIdsMap[short] = append(cache.ShortsToUsersIdsMap[short], userID)
+	}
+}
+
+// migrateLegacyStructures initializes the structures missing from files written by older versions
+// and moves the legacy URL-keyed user links and deletion states to their short-keyed counterparts.
+func (cache *CacheStruct) migrateLegacyStructures() {
+	if cache.OwnersToFullsToShortsMap == nil {
+		cache.OwnersToFullsToShortsMap = map[string]map[string]string{}
+	}
+	if cache.ShortsToOwnersMap == nil {
+		cache.ShortsToOwnersMap = map[string]string{}
+	}
+	if cache.UsersIdsToShortsMap == nil {
+		cache.UsersIdsToShortsMap = map[string][]string{}
+	}
+	if cache.ShortsToUsersIdsMap == nil {
+		cache.ShortsToUsersIdsMap = map[string][]string{}
+	}
+	if cache.ShortsToIsDeletedMap == nil {
+		cache.ShortsToIsDeletedMap = map[string]bool{}
+	}
+	if cache.UsersShortsToIsDeletedMap == nil {
+		cache.UsersShortsToIsDeletedMap = map[string]map[string]bool{}
+	}
+	if cache.ShortsToHistoryMap == nil {
+		cache.ShortsToHistoryMap = map[string][]models.URLRedirectHistoryRecord{}
+	}
+	if cache.ShortsToDeletedAtMap == nil {
+		cache.ShortsToDeletedAtMap = map[string]time.Time{}
+	}
+	if cache.UsersShortsToDeletedAtMap == nil {
+		cache.UsersShortsToDeletedAtMap = map[string]map[string]time.Time{}
+	}
+	if cache.ShortsToCreatedAtMap == nil {
+		cache.ShortsToCreatedAtMap = map[string]time.Time{}
+	}
+	if cache.ShortsToUpdatedAtMap == nil {
+		cache.ShortsToUpdatedAtMap = map[string]time.Time{}
+	}
+	if cache.ShortsToAttributesMap == nil {
+		cache.ShortsToAttributesMap = map[string]models.URLAttributes{}
+	}
+	if cache.UsersShortsToTagsMap == nil {
+		cache.UsersShortsToTagsMap = map[string]map[string][]string{}
+	}
+	if cache.ShortsToClicksLeftMap == nil {
+		cache.ShortsToClicksLeftMap = map[string]int{}
+	}
+	if cache.UsersToUTMDefaultsMap == nil {
+		cache.UsersToUTMDefaultsMap = map[string]models.UTMParams{}
+	}
+	if cache.ShortsToAbuseReportsMap == nil {
+		cache.ShortsToAbuseReportsMap = map[string][]models.AbuseReportRecord{}
+	}
+	if cache.ShortsToDisabledAtMap == nil {
+		cache.ShortsToDisabledAtMap = map[string]time.Time{}
+	}
+	if cache.RefreshTokensMap == nil {
+		cache.RefreshTokensMap = map[string]models.RefreshTokenRecord{}
+	}
+	if cache.RevokedTokensMap == nil {
+		cache.RevokedTokensMap = map[string]time.Time{}
+	}
+
+	// The links made before dedicated links existed become dedicated if their attributes ask
+	// for it, and stop being found by their original URL.
+	if cache.DedicatedShortsMap == nil {
+		cache.DedicatedShortsMap = map[string]bool{}
+		for short, attributes := range cache.ShortsToAttributesMap {
+			if attributes.IsDedicated() {
+				cache.dedicateLink(short)
+			}
+		}
+	}
+
+	for userID, urls := range cache.UsersIdsToUrlsMap {
+		for _, url := range urls {
+			if short, found := cache.FullToShort[url]; found {
+				cache.linkUserToShort(userID, short)
+			}
+		}
+	}
+	for url, isDeleted := range cache.UrlsToIsDeletedMap {
+		if short, found := cache.FullToShort[url]; found && isDeleted {
+			cache.ShortsToIsDeletedMap[short] = true
+		}
+	}
+
+	cache.UsersIdsToUrlsMap = nil
+	cache.UrlsToUsersIdsMap = nil
+	cache.UrlsToIsDeletedMap = nil
+
+	// URLs created before the creation time was recorded are considered created now.
+	now := time.Now()
+	for short := range cache.ShortToFull {
+		if _, found := cache.ShortsToCreatedAtMap[short]; !found {
+			cache.ShortsToCreatedAtMap[short] = now
+		}
+		if _, found := cache.ShortsToUpdatedAtMap[short]; !found {
+			cache.ShortsToUpdatedAtMap[short] = cache.ShortsToCreatedAtMap[short]
+		}
+	}
+
+	// URLs deleted before the deletion time was recorded start their restore grace period now.
+	for short, isDeleted := range cache.ShortsToIsDeletedMap {
+		if _, found := cache.ShortsToDeletedAtMap[short]; isDeleted && !found {
+			cache.ShortsToDeletedAtMap[short] = now
+		}
+	}
+	for userID, shortsToIsDeleted := range cache.UsersShortsToIsDeletedMap {
+		for short, isDeleted := range shortsToIsDeleted {
+			if _, found := cache.UsersShortsToDeletedAtMap[userID][short]; isDeleted && !found {
+				if _, exists := cache.UsersShortsToDeletedAtMap[userID]; !exists {
+					cache.UsersShortsToDeletedAtMap[userID] = map[string]time.Time{}
+				}
+				cache.UsersShortsToDeletedAtMap[userID][short] = now
+			}
+		}
+	}
+}
+
 func initDBFile(fileName string) error {
 	dbFile, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -267,10 +1631,27 @@ func initDBFile(fileName string) error {
 	_, err = fmt.Fprintln(dbFile, `{
 	"ShortToFull": {},
 	"FullToShort": {},
+	"OwnersToFullsToShortsMap": {},
+	"ShortsToOwnersMap": {},
+	"DedicatedShortsMap": {},
 	"Users": {},
-	"UsersIdsToUrlsMap": {},
-	"UrlsToUsersIdsMap": {},
-	"UrlsToIsDeletedMap": {}
+	"UsersIdsToShortsMap": {},
+	"ShortsToUsersIdsMap": {},
+	"ShortsToIsDeletedMap": {},
+	"UsersShortsToIsDeletedMap": {},
+	"ShortsToHistoryMap": {},
+	"ShortsToDeletedAtMap": {},
+	"UsersShortsToDeletedAtMap": {},
+	"ShortsToCreatedAtMap": {},
+	"ShortsToUpdatedAtMap": {},
+	"ShortsToAttributesMap": {},
+	"UsersShortsToTagsMap": {},
+	"ShortsToClicksLeftMap": {},
+	"UsersToUTMDefaultsMap": {},
+	"ShortsToAbuseReportsMap": {},
+	"ShortsToDisabledAtMap": {},
+	"RefreshTokensMap": {},
+	"RevokedTokensMap": {}
 }`)
 	if err != nil {
 		return err
@@ -2,8 +2,14 @@ package jsondb
 
 import (
 	"context"
+	"errors"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -16,6 +22,15 @@ const (
 	testDBFileName = "db_test.json"
 )
 
+func withoutTimestamps(userUrls models.UserUrls) models.UserUrls {
+	for i := range userUrls {
+		userUrls[i].CreatedAt = time.Time{}
+		userUrls[i].UpdatedAt = time.Time{}
+	}
+
+	return userUrls
+}
+
 func Test(t *testing.T) {
 	t.Run("The base jsondb package test", func(t *testing.T) {
 		theStorage, err := New(testDBFileName)
@@ -28,10 +43,10 @@ func Test(t *testing.T) {
 			require.NoError(t, err)
 		}()
 
-		err = theStorage.InsertURLMapping(context.Background(), "some short", "some full", nil)
+		err = theStorage.InsertURLMapping(context.Background(), "some short", "some full", "", models.URLAttributes{}, nil)
 		assert.NoError(t, err, "The `theStorage.Insert()` should not return error")
 
-		short, found, err := theStorage.FindShortByFull(context.Background(), "some full", nil)
+		short, found, err := theStorage.FindShortByFull(context.Background(), "some full", "", nil)
 		assert.NoError(t, err, "The `theStorage.Insert()` should not return error")
 		assert.True(t, found)
 		assert.Equal(t, "some short", short, "Should be equal to `some short`")
@@ -39,6 +54,7 @@ func Test(t *testing.T) {
 		shorts, err := theStorage.FindShortsByFulls(
 			context.Background(),
 			[]string{"some full", "some unexistent full"},
+			"",
 			nil,
 		)
 		assert.NoError(t, err, "The `theStorage.FindShortsByFulls()` should not return error")
@@ -56,6 +72,8 @@ func Test(t *testing.T) {
 				"two":   "2-2-2",
 				"three": "3-3-3",
 			},
+			"",
+			nil,
 			nil,
 		)
 		assert.NoError(t, err, "The `theStorage.SaveNewFullsAndShorts()` should not return error")
@@ -67,6 +85,7 @@ func Test(t *testing.T) {
 				"two",
 				"three",
 			},
+			"",
 			nil,
 		)
 		assert.NoError(t, err, "The `theStorage.FindShortsByFulls()` should not return error")
@@ -92,7 +111,7 @@ func Test(t *testing.T) {
 
 		usr, err := theStorage.GetUserByID(context.Background(), userID, nil)
 		assert.NoError(t, err)
-		assert.Equal(t, &user.User{ID: userID}, usr)
+		assert.Equal(t, &user.User{ID: userID, Role: user.RoleUser}, usr)
 
 		usr, err = theStorage.GetUserByID(context.Background(), "UNEXISTENT", nil)
 		assert.NoError(t, err)
@@ -105,8 +124,8 @@ func Test(t *testing.T) {
 			context.Background(),
 			userID,
 			[]string{
-				"one",
-				"two",
+				"1-1-1",
+				"2-2-2",
 			},
 			nil,
 		)
@@ -115,8 +134,8 @@ func Test(t *testing.T) {
 			context.Background(),
 			userID2,
 			[]string{
-				"three",
-				"some full",
+				"3-3-3",
+				"some short",
 			},
 			nil,
 		)
@@ -151,4 +170,393 @@ func Test(t *testing.T) {
 			assert.ErrorIs(t, err, models.ErrURLMarkedAsDeleted)
 		}
 	})
+
+	t.Run("Per-user links have independent deletion states", func(t *testing.T) {
+		theStorage, err := New(testDBFileName, WithURLOwnershipMode(models.URLOwnershipModePerUser))
+		require.NoError(t, err)
+		require.NotNil(t, theStorage)
+		defer func() {
+			err := theStorage.Close()
+			require.NoError(t, err)
+			err = os.Remove(testDBFileName)
+			require.NoError(t, err)
+		}()
+
+		userA, err := theStorage.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+		userB, err := theStorage.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+
+		for owner, short := range map[string]string{userA: "short-a", userB: "short-b"} {
+			_, found, err := theStorage.FindShortByFull(context.Background(), "https://example.com", owner, nil)
+			require.NoError(t, err)
+			require.False(t, found)
+
+			err = theStorage.InsertURLMapping(context.Background(), short, "https://example.com", owner, models.URLAttributes{}, nil)
+			require.NoError(t, err)
+			err = theStorage.SaveUserUrls(context.Background(), owner, []string{short}, nil)
+			require.NoError(t, err)
+		}
+
+		short, found, err := theStorage.FindShortByFull(context.Background(), "https://example.com", userB, nil)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "short-b", short)
+
+		err = theStorage.RemoveUsersUrls(context.Background(), map[string][]string{userA: {"short-a", "short-b"}})
+		require.NoError(t, err)
+
+		_, _, err = theStorage.FindFullByShort(context.Background(), "short-a")
+		assert.ErrorIs(t, err, models.ErrURLMarkedAsDeleted)
+
+		full, found, err := theStorage.FindFullByShort(context.Background(), "short-b")
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "https://example.com", full)
+
+		userUrls, err := theStorage.GetUserUrls(context.Background(), userB, models.UserURLsQuery{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserUrls{{ShortURL: "short-b", OriginalURL: "https://example.com"}}, withoutTimestamps(userUrls))
+	})
+
+	t.Run("Legacy URL-keyed structures are migrated on load", func(t *testing.T) {
+		err := os.WriteFile(testDBFileName, []byte(`{
+	"ShortToFull": {"1-1-1": "one", "2-2-2": "two"},
+	"FullToShort": {"one": "1-1-1", "two": "2-2-2"},
+	"Users": {"user": {"ID": "user"}},
+	"UsersIdsToUrlsMap": {"user": ["one", "two"]},
+	"UrlsToUsersIdsMap": {"one": ["user"], "two": ["user"]},
+	"UrlsToIsDeletedMap": {"two": true}
+}`), 0644)
+		require.NoError(t, err)
+
+		theStorage, err := New(testDBFileName)
+		require.NoError(t, err)
+		defer func() {
+			err := theStorage.Close()
+			require.NoError(t, err)
+			err = os.Remove(testDBFileName)
+			require.NoError(t, err)
+		}()
+
+		userUrls, err := theStorage.GetUserUrls(context.Background(), "user", models.UserURLsQuery{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserUrls{{ShortURL: "1-1-1", OriginalURL: "one"}}, withoutTimestamps(userUrls))
+
+		_, _, err = theStorage.FindFullByShort(context.Background(), "2-2-2")
+		assert.ErrorIs(t, err, models.ErrURLMarkedAsDeleted)
+	})
+	t.Run("Only the link owner can retarget it", func(t *testing.T) {
+		theStorage, err := New(testDBFileName)
+		require.NoError(t, err)
+		defer func() {
+			err := theStorage.Close()
+			require.NoError(t, err)
+			err = os.Remove(testDBFileName)
+			require.NoError(t, err)
+		}()
+
+		userA, err := theStorage.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+		userB, err := theStorage.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+
+		err = theStorage.SaveNewFullsAndShorts(
+			context.Background(),
+			map[string]string{"one": "1-1-1", "two": "2-2-2"},
+			"",
+			nil,
+			nil,
+		)
+		require.NoError(t, err)
+		err = theStorage.SaveUserUrls(context.Background(), userA, []string{"1-1-1", "2-2-2"}, nil)
+		require.NoError(t, err)
+		err = theStorage.SaveUserUrls(context.Background(), userB, []string{"2-2-2"}, nil)
+		require.NoError(t, err)
+
+		err = theStorage.RetargetUserURL(context.Background(), userB, "1-1-1", "three")
+		assert.ErrorIs(t, err, models.ErrURLNotFound)
+
+		err = theStorage.RetargetUserURL(context.Background(), userA, "2-2-2", "three")
+		assert.ErrorIs(t, err, models.ErrURLNotOwned)
+
+		err = theStorage.RetargetUserURL(context.Background(), userA, "1-1-1", "two")
+		assert.ErrorIs(t, err, models.ErrURLAlreadyShortened)
+
+		err = theStorage.RetargetUserURL(context.Background(), userA, "1-1-1", "three")
+		require.NoError(t, err)
+
+		full, found, err := theStorage.FindFullByShort(context.Background(), "1-1-1")
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "three", full)
+
+		short, found, err := theStorage.FindShortByFull(context.Background(), "three", "", nil)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "1-1-1", short)
+
+		_, found, err = theStorage.FindShortByFull(context.Background(), "one", "", nil)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		history := theStorage.Cache.ShortsToHistoryMap["1-1-1"]
+		require.Len(t, history, 1)
+		assert.Equal(t, "one", history[0].OriginalURL)
+		assert.Equal(t, userA, history[0].ChangedBy)
+	})
+	t.Run("Deleted links can be restored until purged", func(t *testing.T) {
+		theStorage, err := New(testDBFileName, WithURLOwnershipMode(models.URLOwnershipModePerUser))
+		require.NoError(t, err)
+		defer func() {
+			err := theStorage.Close()
+			require.NoError(t, err)
+			err = os.Remove(testDBFileName)
+			require.NoError(t, err)
+		}()
+
+		userA, err := theStorage.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+
+		err = theStorage.SaveNewFullsAndShorts(
+			context.Background(),
+			map[string]string{"one": "1-1-1", "two": "2-2-2"},
+			userA,
+			nil,
+			nil,
+		)
+		require.NoError(t, err)
+		err = theStorage.SaveUserUrls(context.Background(), userA, []string{"1-1-1", "2-2-2"}, nil)
+		require.NoError(t, err)
+
+		err = theStorage.RemoveUsersUrls(context.Background(), map[string][]string{userA: {"1-1-1", "2-2-2"}})
+		require.NoError(t, err)
+
+		restored, err := theStorage.RestoreUsersUrls(
+			context.Background(),
+			userA,
+			[]string{"1-1-1", "unknown"},
+			time.Now().Add(-time.Hour),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1-1-1"}, restored)
+
+		restored, err = theStorage.RestoreUsersUrls(context.Background(), userA, []string{"2-2-2"}, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, restored)
+
+		full, found, err := theStorage.FindFullByShort(context.Background(), "1-1-1")
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "one", full)
+
+		purged, err := theStorage.PurgeDeletedUrls(context.Background(), time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+
+		_, found, err = theStorage.FindFullByShort(context.Background(), "2-2-2")
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		_, found, err = theStorage.FindShortByFull(context.Background(), "two", userA, nil)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		userUrls, err := theStorage.GetUserUrls(context.Background(), userA, models.UserURLsQuery{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserUrls{{ShortURL: "1-1-1", OriginalURL: "one"}}, withoutTimestamps(userUrls))
+	})
+	t.Run("Tags are attached to the user's links", func(t *testing.T) {
+		theStorage, err := New(testDBFileName)
+		require.NoError(t, err)
+		defer func() {
+			err := theStorage.Close()
+			require.NoError(t, err)
+			err = os.Remove(testDBFileName)
+			require.NoError(t, err)
+		}()
+
+		userA, err := theStorage.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+		userB, err := theStorage.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+
+		err = theStorage.SaveNewFullsAndShorts(
+			context.Background(),
+			map[string]string{"one": "1-1-1", "two": "2-2-2", "three": "3-3-3"},
+			"",
+			nil,
+			nil,
+		)
+		require.NoError(t, err)
+		err = theStorage.SaveUserUrls(context.Background(), userA, []string{"1-1-1", "2-2-2", "3-3-3"}, nil)
+		require.NoError(t, err)
+		err = theStorage.SaveUserUrls(context.Background(), userB, []string{"1-1-1"}, nil)
+		require.NoError(t, err)
+
+		tagged, err := theStorage.AddUserURLsTags(
+			context.Background(),
+			userA,
+			[]string{"1-1-1", "2-2-2", "unknown"},
+			[]string{"work", "docs"},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1-1-1", "2-2-2"}, tagged)
+
+		untagged, err := theStorage.RemoveUserURLsTags(context.Background(), userA, []string{"2-2-2"}, []string{"docs"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2-2-2"}, untagged)
+
+		tags, err := theStorage.GetUserTags(context.Background(), userA)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserTags{{Tag: "docs", URLsCount: 1}, {Tag: "work", URLsCount: 2}}, tags)
+
+		tags, err = theStorage.GetUserTags(context.Background(), userB)
+		require.NoError(t, err)
+		assert.Empty(t, tags)
+
+		userUrls, err := theStorage.GetUserUrls(context.Background(), userA, models.UserURLsQuery{Tag: "docs"}, nil)
+		require.NoError(t, err)
+		require.Len(t, userUrls, 1)
+		assert.Equal(t, "1-1-1", userUrls[0].ShortURL)
+		assert.Equal(t, []string{"docs", "work"}, userUrls[0].Tags)
+
+		err = theStorage.RemoveUsersUrls(context.Background(), map[string][]string{userA: {"2-2-2"}})
+		require.NoError(t, err)
+
+		tags, err = theStorage.GetUserTags(context.Background(), userA)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserTags{{Tag: "docs", URLsCount: 1}, {Tag: "work", URLsCount: 1}}, tags)
+	})
+	t.Run("Stored URLs are canonicalized and their duplicates merged", func(t *testing.T) {
+		theStorage, err := New(testDBFileName)
+		require.NoError(t, err)
+		defer func() {
+			err := theStorage.Close()
+			require.NoError(t, err)
+			err = os.Remove(testDBFileName)
+			require.NoError(t, err)
+		}()
+
+		ctx := context.Background()
+		userA, err := theStorage.CreateUser(ctx, &user.User{}, nil)
+		require.NoError(t, err)
+		userB, err := theStorage.CreateUser(ctx, &user.User{}, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, theStorage.InsertURLMapping(ctx, "1-1-1", "HTTP://ONE", "", models.URLAttributes{}, nil))
+		require.NoError(t, theStorage.InsertURLMapping(ctx, "2-2-2", "http://one", "", models.URLAttributes{}, nil))
+		require.NoError(t, theStorage.InsertURLMapping(ctx, "3-3-3", "HTTP://TWO", "", models.URLAttributes{}, nil))
+		err = theStorage.InsertURLMapping(ctx, "4-4-4", "HTTP://ONE", "", models.URLAttributes{PasswordHash: "hash"}, nil)
+		require.NoError(t, err)
+		require.NoError(t, theStorage.SaveUserUrls(ctx, userA, []string{"1-1-1", "3-3-3", "4-4-4"}, nil))
+		require.NoError(t, theStorage.SaveUserUrls(ctx, userB, []string{"1-1-1", "2-2-2"}, nil))
+		_, err = theStorage.AddUserURLsTags(ctx, userA, []string{"1-1-1"}, []string{"work"})
+		require.NoError(t, err)
+		require.NoError(t, theStorage.RemoveUsersUrls(ctx, map[string][]string{userB: {"2-2-2"}}))
+
+		merged, err := theStorage.CanonicalizeStoredURLs(ctx, func(url string) (string, error) {
+			return strings.ToLower(url), nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), merged)
+
+		short, found, err := theStorage.FindShortByFull(ctx, "http://one", "", nil)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "2-2-2", short)
+		short, found, err = theStorage.FindShortByFull(ctx, "http://two", "", nil)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "3-3-3", short)
+
+		full, found, err := theStorage.FindFullByShort(ctx, "1-1-1")
+		require.NoError(t, err)
+		assert.True(t, found, "The merged short URL should keep redirecting")
+		assert.Equal(t, "HTTP://ONE", full)
+		full, _, err = theStorage.FindFullByShort(ctx, "4-4-4")
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP://ONE", full, "The dedicated link should be left as it is")
+
+		userUrls, err := theStorage.GetUserUrls(ctx, userA, models.UserURLsQuery{}, nil)
+		require.NoError(t, err)
+		tagsByShort := map[string][]string{}
+		for _, userURL := range userUrls {
+			tagsByShort[userURL.ShortURL] = userURL.Tags
+		}
+		assert.Equal(t, map[string][]string{"2-2-2": {"work"}, "3-3-3": nil, "4-4-4": nil}, tagsByShort)
+
+		userUrls, err = theStorage.GetUserUrls(ctx, userB, models.UserURLsQuery{}, nil)
+		require.NoError(t, err)
+		require.Len(t, userUrls, 1, "The user should keep the merged link unless they deleted both")
+		assert.Equal(t, "2-2-2", userUrls[0].ShortURL)
+	})
+	t.Run("Links limited to a number of redirects run out of clicks", func(t *testing.T) {
+		theStorage, err := New(testDBFileName)
+		require.NoError(t, err)
+		defer func() {
+			err := theStorage.Close()
+			require.NoError(t, err)
+			err = os.Remove(testDBFileName)
+			require.NoError(t, err)
+		}()
+
+		err = theStorage.InsertURLMapping(context.Background(), "1-1-1", "one", "", models.URLAttributes{MaxClicks: 5}, nil)
+		require.NoError(t, err)
+
+		var consumed atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := theStorage.ConsumeURLClick(context.Background(), "1-1-1")
+				if err == nil {
+					consumed.Add(1)
+				} else {
+					assert.ErrorIs(t, err, models.ErrURLClicksExhausted)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(5), consumed.Load())
+
+		redirect, found, err := theStorage.FindRedirectByShort(context.Background(), "1-1-1")
+		assert.ErrorIs(t, err, models.ErrURLClicksExhausted)
+		assert.True(t, found)
+		assert.Equal(t, "one", redirect.OriginalURL)
+	})
+	t.Run("Deleted links are purged while the links are used", func(t *testing.T) {
+		theStorage, err := New(testDBFileName)
+		require.NoError(t, err)
+		defer func() {
+			err := theStorage.Close()
+			require.NoError(t, err)
+			err = os.Remove(testDBFileName)
+			require.NoError(t, err)
+		}()
+
+		userA, err := theStorage.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 200; i++ {
+				_, err := theStorage.PurgeDeletedUrls(context.Background(), time.Now())
+				assert.NoError(t, err)
+			}
+		}()
+
+		for i := 0; i < 200; i++ {
+			short := strconv.Itoa(i)
+			err := theStorage.InsertURLMapping(context.Background(), short, "url-"+short, "", models.URLAttributes{}, nil)
+			require.NoError(t, err)
+			require.NoError(t, theStorage.SaveUserUrls(context.Background(), userA, []string{short}, nil))
+			require.NoError(t, theStorage.RemoveUsersUrls(context.Background(), map[string][]string{userA: {short}}))
+			_, _, err = theStorage.FindRedirectByShort(context.Background(), short)
+			assert.True(t, err == nil || errors.Is(err, models.ErrURLMarkedAsDeleted))
+		}
+		<-done
+	})
 }
@@ -5,8 +5,10 @@ package memorystorage
 
 import (
 	"context"
+	"time"
 
 	"github.com/patric-chuzhbe/urlshrt/internal/db/jsondb"
+	"github.com/patric-chuzhbe/urlshrt/internal/models"
 	"github.com/patric-chuzhbe/urlshrt/internal/user"
 )
 
@@ -18,19 +20,42 @@ type MemoryStorage struct {
 
 // New creates and initializes a new instance of MemoryStorage with empty maps
 // for all internal structures, making it ready for use immediately.
-func New() (*MemoryStorage, error) {
-	return &MemoryStorage{
+// The options are those of the underlying jsondb.JSONDB.
+func New(optionsProto ...jsondb.InitOption) (*MemoryStorage, error) {
+	theStorage := &MemoryStorage{
 		JSONDB: &jsondb.JSONDB{
 			Cache: jsondb.CacheStruct{
-				ShortToFull:        map[string]string{},
-				FullToShort:        map[string]string{},
-				Users:              map[string]*user.User{},
-				UsersIdsToUrlsMap:  map[string][]string{},
-				UrlsToUsersIdsMap:  map[string][]string{},
-				UrlsToIsDeletedMap: map[string]bool{},
+				ShortToFull:               map[string]string{},
+				FullToShort:               map[string]string{},
+				OwnersToFullsToShortsMap:  map[string]map[string]string{},
+				ShortsToOwnersMap:         map[string]string{},
+				DedicatedShortsMap:        map[string]bool{},
+				Users:                     map[string]*user.User{},
+				UsersIdsToShortsMap:       map[string][]string{},
+				ShortsToUsersIdsMap:       map[string][]string{},
+				ShortsToIsDeletedMap:      map[string]bool{},
+				UsersShortsToIsDeletedMap: map[string]map[string]bool{},
+				ShortsToHistoryMap:        map[string][]models.URLRedirectHistoryRecord{},
+				ShortsToDeletedAtMap:      map[string]time.Time{},
+				UsersShortsToDeletedAtMap: map[string]map[string]time.Time{},
+				ShortsToCreatedAtMap:      map[string]time.Time{},
+				ShortsToUpdatedAtMap:      map[string]time.Time{},
+				ShortsToAttributesMap:     map[string]models.URLAttributes{},
+				UsersShortsToTagsMap:      map[string]map[string][]string{},
+				ShortsToClicksLeftMap:     map[string]int{},
+				UsersToUTMDefaultsMap:     map[string]models.UTMParams{},
+				ShortsToAbuseReportsMap:   map[string][]models.AbuseReportRecord{},
+				ShortsToDisabledAtMap:     map[string]time.Time{},
+				RefreshTokensMap:          map[string]models.RefreshTokenRecord{},
+				RevokedTokensMap:          map[string]time.Time{},
 			},
 		},
-	}, nil
+	}
+	for _, protoOption := range optionsProto {
+		protoOption(theStorage.JSONDB)
+	}
+
+	return theStorage, nil
 }
 
 // Close is a no-op for MemoryStorage as there are no persistent resources to release.
@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/patric-chuzhbe/urlshrt/internal/models"
 )
 
 func Test(t *testing.T) {
@@ -12,10 +14,10 @@ func Test(t *testing.T) {
 		theStorage, err := New()
 		assert.NoError(t, err, "The memorystorage.New() should not return error")
 
-		err = theStorage.InsertURLMapping(context.Background(), "some short", "some full", nil)
+		err = theStorage.InsertURLMapping(context.Background(), "some short", "some full", "", models.URLAttributes{}, nil)
 		assert.NoError(t, err, "The `theStorage.InsertURLMapping()` should not return error")
 
-		short, found, err := theStorage.FindShortByFull(context.Background(), "some full", nil)
+		short, found, err := theStorage.FindShortByFull(context.Background(), "some full", "", nil)
 		assert.NoError(t, err, "The `theStorage.InsertURLMapping()` should not return error")
 		assert.True(t, found)
 		assert.Equal(t, "some short", short, "Should be equal to `some short`")
@@ -23,6 +25,7 @@ func Test(t *testing.T) {
 		shorts, err := theStorage.FindShortsByFulls(
 			context.Background(),
 			[]string{"some full", "some unexistent full"},
+			"",
 			nil,
 		)
 		assert.NoError(t, err, "The `theStorage.FindShortsByFulls()` should not return error")
@@ -40,6 +43,8 @@ func Test(t *testing.T) {
 				"two":   "2-2-2",
 				"three": "3-3-3",
 			},
+			"",
+			nil,
 			nil,
 		)
 		assert.NoError(t, err, "The `theStorage.SaveNewFullsAndShorts()` should not return error")
@@ -51,6 +56,7 @@ func Test(t *testing.T) {
 				"two",
 				"three",
 			},
+			"",
 			nil,
 		)
 		assert.NoError(t, err, "The `theStorage.FindShortsByFulls()` should not return error")
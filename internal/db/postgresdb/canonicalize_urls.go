@@ -0,0 +1,210 @@
+package postgresdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type canonicalizedURL struct {
+	short        string
+	originalURL  string
+	canonicalURL string
+}
+
+// CanonicalizeStoredURLs brings the stored original URLs to their canonical form and merges
+// the links of the same owner whose original URLs turn out to be the same, returning how many
+// were merged: the users and the tags of the duplicates move to the link that already had
+// the canonical URL, or else to the oldest one. The duplicates themselves are kept with their
+// original URLs, so that the short URLs already handed out keep redirecting. The dedicated links
+// are left as they are. This is a one-way step: the merges cannot be undone.
+func (db *PostgresDB) CanonicalizeStoredURLs(
+	ctx context.Context,
+	canonicalize func(string) (string, error),
+) (int64, error) {
+	tx, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"in internal/db/postgresdb/canonicalize_urls.go/CanonicalizeStoredURLs(): error while `db.database.BeginTx()` calling: %w",
+			err,
+		)
+	}
+
+	merged, err := canonicalizeURLs(ctx, tx, canonicalize)
+	if err != nil {
+		_ = tx.Rollback()
+
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf(
+			"in internal/db/postgresdb/canonicalize_urls.go/CanonicalizeStoredURLs(): error while `tx.Commit()` calling: %w",
+			err,
+		)
+	}
+
+	return merged, nil
+}
+
+func canonicalizeURLs(ctx context.Context, tx *sql.Tx, canonicalize func(string) (string, error)) (int64, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT short, original_url, COALESCE(owner_id::text, '')
+			FROM url_redirects
+			WHERE NOT dedicated
+			ORDER BY created_at, short`,
+	)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"in internal/db/postgresdb/canonicalize_urls.go/canonicalizeURLs(): error while `tx.QueryContext()` calling: %w",
+			err,
+		)
+	}
+	defer rows.Close()
+
+	groups := map[string][]canonicalizedURL{}
+	var groupKeys []string
+	for rows.Next() {
+		var link canonicalizedURL
+		var ownerID string
+		err = rows.Scan(&link.short, &link.originalURL, &ownerID)
+		if err != nil {
+			return 0, fmt.Errorf(
+				"in internal/db/postgresdb/canonicalize_urls.go/canonicalizeURLs(): error while `rows.Scan()` calling: %w",
+				err,
+			)
+		}
+
+		link.canonicalURL, err = canonicalize(link.originalURL)
+		if err != nil {
+			link.canonicalURL = link.originalURL
+		}
+
+		groupKey := ownerID + " " + link.canonicalURL
+		if _, found := groups[groupKey]; !found {
+			groupKeys = append(groupKeys, groupKey)
+		}
+		groups[groupKey] = append(groups[groupKey], link)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf(
+			"in internal/db/postgresdb/canonicalize_urls.go/canonicalizeURLs(): error while `rows.Err()` calling: %w",
+			err,
+		)
+	}
+	rows.Close()
+
+	var merged int64
+	for _, groupKey := range groupKeys {
+		err = mergeCanonicalizedURLs(ctx, tx, groups[groupKey])
+		if err != nil {
+			return 0, err
+		}
+		merged += int64(len(groups[groupKey]) - 1)
+	}
+
+	return merged, nil
+}
+
+func mergeCanonicalizedURLs(ctx context.Context, tx *sql.Tx, links []canonicalizedURL) error {
+	survivor := links[0]
+	for _, link := range links {
+		if link.originalURL == link.canonicalURL {
+			survivor = link
+			break
+		}
+	}
+
+	for _, duplicate := range links {
+		if duplicate.short == survivor.short {
+			continue
+		}
+
+		// A user keeps the merged link unless they deleted both.
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO users_urls (user_id, short, is_deleted, deleted_at)
+				SELECT user_id, $1, is_deleted, deleted_at FROM users_urls WHERE short = $2
+			ON CONFLICT (user_id, short) DO UPDATE
+				SET is_deleted = users_urls.is_deleted AND EXCLUDED.is_deleted,
+					deleted_at = CASE
+						WHEN users_urls.is_deleted AND EXCLUDED.is_deleted
+							THEN GREATEST(users_urls.deleted_at, EXCLUDED.deleted_at)
+					END`,
+			survivor.short,
+			duplicate.short,
+		)
+		if err != nil {
+			return fmt.Errorf(
+				"in internal/db/postgresdb/canonicalize_urls.go/mergeCanonicalizedURLs(): error while moving the users of %q: %w",
+				duplicate.short,
+				err,
+			)
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO users_urls_tags (user_id, short, tag)
+				SELECT user_id, $1, tag FROM users_urls_tags WHERE short = $2
+			ON CONFLICT DO NOTHING`,
+			survivor.short,
+			duplicate.short,
+		)
+		if err != nil {
+			return fmt.Errorf(
+				"in internal/db/postgresdb/canonicalize_urls.go/mergeCanonicalizedURLs(): error while moving the tags of %q: %w",
+				duplicate.short,
+				err,
+			)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM users_urls WHERE short = $1`, duplicate.short)
+		if err != nil {
+			return fmt.Errorf(
+				"in internal/db/postgresdb/canonicalize_urls.go/mergeCanonicalizedURLs(): error while unlinking %q: %w",
+				duplicate.short,
+				err,
+			)
+		}
+	}
+
+	// The link is restored if one of its users keeps it.
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE url_redirects SET is_deleted = false, deleted_at = NULL
+			WHERE short = $1
+				AND is_deleted
+				AND EXISTS (SELECT 1 FROM users_urls WHERE short = $1 AND NOT is_deleted)`,
+		survivor.short,
+	)
+	if err != nil {
+		return fmt.Errorf(
+			"in internal/db/postgresdb/canonicalize_urls.go/mergeCanonicalizedURLs(): error while restoring %q: %w",
+			survivor.short,
+			err,
+		)
+	}
+
+	if survivor.originalURL == survivor.canonicalURL {
+		return nil
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE url_redirects SET original_url = $1, original_url_hash = $2 WHERE short = $3`,
+		survivor.canonicalURL,
+		hashURL(survivor.canonicalURL),
+		survivor.short,
+	)
+	if err != nil {
+		return fmt.Errorf(
+			"in internal/db/postgresdb/canonicalize_urls.go/mergeCanonicalizedURLs(): error while canonicalizing %q: %w",
+			survivor.short,
+			err,
+		)
+	}
+
+	return nil
+}
@@ -5,17 +5,24 @@ package postgresdb
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 
 	"github.com/pressly/goose/v3"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 
+	"github.com/patric-chuzhbe/urlshrt/internal/logger"
 	"github.com/patric-chuzhbe/urlshrt/internal/models"
 	"github.com/patric-chuzhbe/urlshrt/internal/user"
 
@@ -24,16 +31,31 @@ import (
 
 // PostgresDB is a PostgreSQL-backed implementation of a URL shortener storage.
 // It handles all persistence operations via a PostgreSQL database connection.
+// Read-only calls made outside of a transaction are routed to an optional
+// read replica, falling back to the primary while the replica is unavailable.
 type PostgresDB struct {
-	database          *sql.DB
-	connectionTimeout time.Duration
-	queries           *sqlc.Queries
+	database             *sql.DB
+	connectionTimeout    time.Duration
+	queries              *sqlc.Queries
+	replica              *sql.DB
+	replicaQueries       *sqlc.Queries
+	replicaRetryInterval time.Duration
+	replicaDownUntil     atomic.Int64
+	urlOwnershipMode     string
 }
 
 type initOptions struct {
-	DBPreReset bool
+	DBPreReset           bool
+	ReplicaDSN           string
+	ReplicaRetryInterval time.Duration
+	URLOwnershipMode     string
 }
 
+const defaultReplicaRetryInterval = 5 * time.Second
+
+// uniqueViolationCode is the SQLSTATE of the errors violating a unique constraint.
+const uniqueViolationCode = "23505"
+
 // New establishes a connection to the PostgreSQL database,
 // runs schema migrations, and returns a configured PostgresDB instance.
 // Optionally accepts initialization options, such as WithDBPreReset.
@@ -45,7 +67,10 @@ func New(
 	optionsProto ...InitOption,
 ) (*PostgresDB, error) {
 	options := &initOptions{
-		DBPreReset: false,
+		DBPreReset:           false,
+		ReplicaDSN:           "",
+		ReplicaRetryInterval: defaultReplicaRetryInterval,
+		URLOwnershipMode:     models.URLOwnershipModeShared,
 	}
 	for _, protoOption := range optionsProto {
 		protoOption(options)
@@ -57,9 +82,23 @@ func New(
 	}
 
 	result := &PostgresDB{
-		database:          database,
-		connectionTimeout: connectionTimeout,
-		queries:           sqlc.New(database),
+		database:             database,
+		connectionTimeout:    connectionTimeout,
+		queries:              sqlc.New(database),
+		replicaRetryInterval: options.ReplicaRetryInterval,
+		urlOwnershipMode:     options.URLOwnershipMode,
+	}
+
+	if options.ReplicaDSN != "" {
+		result.replica, err = sql.Open("pgx", options.ReplicaDSN)
+		if err != nil {
+			return nil,
+				fmt.Errorf(
+					"in internal/db/postgresdb/postgresdb.go/New(): error while `sql.Open()` calling for the replica: %w",
+					err,
+				)
+		}
+		result.replicaQueries = sqlc.New(result.replica)
 	}
 
 	if options.DBPreReset {
@@ -93,6 +132,9 @@ func New(
 
 // RemoveUsersUrls marks a batch of URLs as deleted for specified user IDs.
 // It executes the updates within a transaction to ensure consistency.
+// In the shared ownership mode the short URL is deleted for everyone linked to it;
+// in the per-user mode only the user's own link is deleted, and the short URL
+// itself once no other user links to it anymore.
 func (db *PostgresDB) RemoveUsersUrls(
 	ctx context.Context,
 	usersURLs map[string][]string,
@@ -108,12 +150,17 @@ func (db *PostgresDB) RemoveUsersUrls(
 		for _, url := range urls {
 			userIDAsUUID, err := uuid.Parse(userID)
 			if err != nil {
+				_ = transaction.Rollback()
 				return err
 			}
-			err = qtx.RemoveUsersUrls(ctx, sqlc.RemoveUsersUrlsParams{
-				UserID:   userIDAsUUID, /* userID*/
-				ShortUrl: url,
-			})
+			if db.urlOwnershipMode == models.URLOwnershipModePerUser {
+				err = removeUserLink(ctx, qtx, userIDAsUUID, url)
+			} else {
+				err = qtx.RemoveUsersUrls(ctx, sqlc.RemoveUsersUrlsParams{
+					UserID:   userIDAsUUID, /* userID*/
+					ShortUrl: url,
+				})
+			}
 			if err != nil {
 				err2 := transaction.Rollback()
 				if err2 != nil {
@@ -121,10 +168,6 @@ func (db *PostgresDB) RemoveUsersUrls(
 				}
 				return err
 			}
-			//if err != nil {
-			//	tx.Rollback()
-			//	return err
-			//}
 		}
 	}
 
@@ -136,38 +179,141 @@ func (db *PostgresDB) RemoveUsersUrls(
 	return nil
 }
 
-// SaveUserUrls stores mappings between a user and a list of full URLs.
-// It uses an UPSERT strategy and runs within an existing transaction.
+// RestoreUsersUrls undoes the deletion of the user's short URLs deleted after deletedAfter
+// and returns the restored ones. In the shared ownership mode the short URL is restored for
+// everyone linked to it; in the per-user mode the user's own link is restored, together with
+// the short URL itself if it was deleted.
+func (db *PostgresDB) RestoreUsersUrls(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	deletedAfter time.Time,
+) ([]string, error) {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	qtx := db.queries.WithTx(transaction)
+
+	restored := []string{}
+	for _, short := range shortURLs {
+		var restoredRows int64
+		if db.urlOwnershipMode == models.URLOwnershipModePerUser {
+			restoredRows, err = restoreUserLink(ctx, qtx, userIDAsUUID, short, deletedAfter)
+		} else {
+			restoredRows, err = qtx.RestoreUsersUrl(ctx, sqlc.RestoreUsersUrlParams{
+				UserID:       userIDAsUUID,
+				ShortUrl:     short,
+				DeletedAfter: sql.NullTime{Time: deletedAfter, Valid: true},
+			})
+		}
+		if err != nil {
+			err2 := transaction.Rollback()
+			if err2 != nil {
+				return nil, err2
+			}
+			return nil, err
+		}
+		if restoredRows > 0 {
+			restored = append(restored, short)
+		}
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// PurgeExpiredTokens removes the refresh tokens and the revoked access token IDs expired before
+// expiredBefore. Returns the number of removed records.
+func (db *PostgresDB) PurgeExpiredTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	purgedRefreshTokens, err := db.queries.PurgeExpiredRefreshTokens(ctx, expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	purgedRevokedTokens, err := db.queries.PurgeExpiredRevokedTokens(ctx, expiredBefore)
+	if err != nil {
+		return purgedRefreshTokens, err
+	}
+
+	return purgedRefreshTokens + purgedRevokedTokens, nil
+}
+
+// PurgeDeletedUrls permanently removes the short URLs and user links deleted before deletedBefore.
+// Returns the number of removed records.
+func (db *PostgresDB) PurgeDeletedUrls(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	transaction, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	qtx := db.queries.WithTx(transaction)
+
+	purgedURLs, err := qtx.PurgeDeletedURLs(ctx, sql.NullTime{Time: deletedBefore, Valid: true})
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	purgedLinks, err := qtx.PurgeDeletedUserLinks(ctx, sql.NullTime{Time: deletedBefore, Valid: true})
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	return purgedURLs + purgedLinks, transaction.Commit()
+}
+
+// SaveUserUrls stores mappings between a user and a list of short URLs.
+// It uses an UPSERT strategy and runs within an existing transaction. The user's deleted links
+// to the short URLs are restored, and so are the deleted short URLs themselves, so that
+// shortening a URL again brings its link back.
 func (db *PostgresDB) SaveUserUrls(
 	ctx context.Context,
 	userID string,
-	urls []string,
+	shortURLs []string,
 	transaction *sql.Tx,
 ) error {
 	qtx := db.queries.WithTx(transaction)
 
-	for _, url := range urls {
+	for _, short := range shortURLs {
 		userIDAsUUID, err := uuid.Parse(userID)
 		if err != nil {
 			return err
 		}
 		err = qtx.SaveUserUrl(ctx, sqlc.SaveUserUrlParams{
 			UserID: userIDAsUUID, /* userID*/
-			Url:    url,
+			Short:  short,
 		})
 		if err != nil {
 			return err
 		}
+		err = qtx.RestoreLinkedURL(ctx, short)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// GetUserUrls retrieves all short-to-full URL mappings for a given user.
+// GetUserUrls retrieves the page of the user's short-to-full URL mappings defined by query.
 // Optionally applies a formatter to each short URL before returning.
+// Filtering, ordering and paging are done by the database.
 func (db *PostgresDB) GetUserUrls(
 	ctx context.Context,
 	userID string,
+	query models.UserURLsQuery,
 	shortURLFormatter models.URLFormatter, /*func(string) string*/
 ) (models.UserUrls, error) {
 	formatter := func(str string) string { return str }
@@ -175,73 +321,724 @@ func (db *PostgresDB) GetUserUrls(
 		formatter = shortURLFormatter
 	}
 
-	userIDAsUUID, err := uuid.Parse(userID)
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	params := sqlc.GetUserUrlsParams{
+		UserID:     userIDAsUUID,
+		Search:     query.Search,
+		Tag:        query.Tag,
+		SortBy:     query.SortBy,
+		Descending: query.Descending,
+		PageSize:   sql.NullInt32{Int32: int32(query.Limit), Valid: query.Limit > 0},
+	}
+	if query.After != nil {
+		params.AfterShort = query.After.Short
+		params.AfterOriginalUrl = query.After.OriginalURL
+		params.AfterCreatedAt = query.After.CreatedAt
+	}
+
+	var rows []sqlc.GetUserUrlsRow
+	err = db.withReadQueries(ctx, func(queries *sqlc.Queries) error {
+		var err error
+		rows, err = queries.GetUserUrls(ctx, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := models.UserUrls{}
+	for _, row := range rows {
+		userURL := models.UserURL{
+			ShortURL:    formatter(row.Short),
+			OriginalURL: row.OriginalUrl,
+			Title:       row.Title,
+			Description: row.Description,
+			CreatedAt:   row.CreatedAt,
+			UpdatedAt:   row.UpdatedAt,
+			Tags:        row.Tags,
+		}
+		if row.ActiveFrom.Valid {
+			userURL.ActiveFrom = &row.ActiveFrom.Time
+		}
+		result = append(result, userURL)
+	}
+
+	return result, nil
+}
+
+// RetargetUserURL changes the original URL of the user's short URL and records the previous
+// one in the redirects history. Only the link owner may retarget it; a shared link counts as
+// owned while no other user links to it.
+// Returns models.ErrURLNotFound, models.ErrURLNotOwned or models.ErrURLAlreadyShortened
+// when the change is not possible.
+func (db *PostgresDB) RetargetUserURL(ctx context.Context, userID, short, full string) error {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = retargetUserURL(ctx, db.queries.WithTx(transaction), userIDAsUUID, short, full)
+	if err != nil {
+		err2 := transaction.Rollback()
+		if err2 != nil {
+			return err2
+		}
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// SetUserURLActiveFrom changes the time before which the user's short URL does not redirect;
+// a zero activeFrom makes it always active. The ownership rules are the ones of RetargetUserURL.
+// Returns models.ErrURLNotFound or models.ErrURLNotOwned when the change is not possible.
+// Setting the time makes the link dedicated for good, so that the later shortenings
+// of its original URL do not get it.
+func (db *PostgresDB) SetUserURLActiveFrom(ctx context.Context, userID, short string, activeFrom time.Time) error {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	qtx := db.queries.WithTx(transaction)
+
+	_, err = lockOwnedUserLink(ctx, qtx, userIDAsUUID, short)
+	if err == nil {
+		err = qtx.SetURLActiveFrom(ctx, sqlc.SetURLActiveFromParams{
+			ActiveFrom: toNullTime(activeFrom),
+			Dedicated:  !activeFrom.IsZero(),
+			Short:      short,
+		})
+	}
+	if err != nil {
+		err2 := transaction.Rollback()
+		if err2 != nil {
+			return err2
+		}
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// SetUserURLRedirectRules replaces the redirect rules of the user's short URL;
+// see lockOwnedUserLink for the returned errors. Setting some makes the link dedicated
+// for good, so that the later shortenings of its original URL do not get it.
+func (db *PostgresDB) SetUserURLRedirectRules(
+	ctx context.Context,
+	userID, short string,
+	rules models.RedirectRules,
+) error {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	qtx := db.queries.WithTx(transaction)
+
+	_, err = lockOwnedUserLink(ctx, qtx, userIDAsUUID, short)
+	if err == nil {
+		err = qtx.SetURLRedirectRules(ctx, sqlc.SetURLRedirectRulesParams{
+			RedirectRules: rulesJSON,
+			Dedicated:     len(rules) > 0,
+			Short:         short,
+		})
+	}
+	if err != nil {
+		err2 := transaction.Rollback()
+		if err2 != nil {
+			return err2
+		}
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// GetUserURLRedirectRules returns the redirect rules of the user's short URL,
+// or models.ErrURLNotFound if the user has no such live link.
+func (db *PostgresDB) GetUserURLRedirectRules(ctx context.Context, userID, short string) (models.RedirectRules, error) {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rulesJSON json.RawMessage
+	err = db.withReadQueries(ctx, func(queries *sqlc.Queries) error {
+		var err error
+		rulesJSON, err = queries.GetUserURLRedirectRules(ctx, sqlc.GetUserURLRedirectRulesParams{
+			UserID: userIDAsUUID,
+			Short:  short,
+		})
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrURLNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toRedirectRules(rulesJSON)
+}
+
+// SetUserURLDestinations replaces the A/B destinations of the user's short URL;
+// see lockOwnedUserLink for the returned errors. Setting some makes the link dedicated
+// for good, so that the later shortenings of its original URL do not get it.
+func (db *PostgresDB) SetUserURLDestinations(
+	ctx context.Context,
+	userID, short string,
+	destinations models.Destinations,
+) error {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+
+	destinationsJSON, err := json.Marshal(destinations)
+	if err != nil {
+		return err
+	}
+
+	transaction, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	qtx := db.queries.WithTx(transaction)
+
+	_, err = lockOwnedUserLink(ctx, qtx, userIDAsUUID, short)
+	if err == nil {
+		err = qtx.SetURLDestinations(ctx, sqlc.SetURLDestinationsParams{
+			Destinations: destinationsJSON,
+			Dedicated:    len(destinations) > 0,
+			Short:        short,
+		})
+	}
+	if err != nil {
+		err2 := transaction.Rollback()
+		if err2 != nil {
+			return err2
+		}
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// GetUserURLDestinations returns the A/B destinations of the user's short URL,
+// or models.ErrURLNotFound if the user has no such live link.
+func (db *PostgresDB) GetUserURLDestinations(ctx context.Context, userID, short string) (models.Destinations, error) {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var destinationsJSON json.RawMessage
+	err = db.withReadQueries(ctx, func(queries *sqlc.Queries) error {
+		var err error
+		destinationsJSON, err = queries.GetUserURLDestinations(ctx, sqlc.GetUserURLDestinationsParams{
+			UserID: userIDAsUUID,
+			Short:  short,
+		})
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrURLNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toDestinations(destinationsJSON)
+}
+
+// AddUserURLsTags attaches the tags to the user's live short URLs among shortURLs
+// and returns those short URLs.
+func (db *PostgresDB) AddUserURLsTags(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	tags []string,
+) ([]string, error) {
+	return db.changeUserURLsTags(ctx, userID, shortURLs, func(queries *sqlc.Queries, userID uuid.UUID, shorts []string) error {
+		return queries.AddUserURLsTags(ctx, sqlc.AddUserURLsTagsParams{
+			UserID:    userID,
+			ShortUrls: shorts,
+			Tags:      tags,
+		})
+	})
+}
+
+// RemoveUserURLsTags detaches the tags from the user's live short URLs among shortURLs
+// and returns those short URLs.
+func (db *PostgresDB) RemoveUserURLsTags(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	tags []string,
+) ([]string, error) {
+	return db.changeUserURLsTags(ctx, userID, shortURLs, func(queries *sqlc.Queries, userID uuid.UUID, shorts []string) error {
+		return queries.RemoveUserURLsTags(ctx, sqlc.RemoveUserURLsTagsParams{
+			UserID:    userID,
+			ShortUrls: shorts,
+			Tags:      tags,
+		})
+	})
+}
+
+// GetUserTags returns the tags of the user with the number of the user's live URLs carrying each, ordered by tag.
+func (db *PostgresDB) GetUserTags(ctx context.Context, userID string) (models.UserTags, error) {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []sqlc.GetUserTagsRow
+	err = db.withReadQueries(ctx, func(queries *sqlc.Queries) error {
+		var err error
+		rows, err = queries.GetUserTags(ctx, userIDAsUUID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := models.UserTags{}
+	for _, row := range rows {
+		result = append(result, models.UserTag{
+			Tag:       row.Tag,
+			URLsCount: row.UrlsCount,
+		})
+	}
+
+	return result, nil
+}
+
+// GetUserUTMDefaults returns the default UTM parameters of the user, all empty if the user has none.
+// They are read from the primary, so that the defaults just set apply to the links shortened next.
+func (db *PostgresDB) GetUserUTMDefaults(ctx context.Context, userID string) (models.UTMParams, error) {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return models.UTMParams{}, err
+	}
+
+	row, err := db.queries.GetUserUTMDefaults(ctx, userIDAsUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UTMParams{}, nil
+	}
+	if err != nil {
+		return models.UTMParams{}, err
+	}
+
+	return models.UTMParams{
+		Source:   row.Source,
+		Medium:   row.Medium,
+		Campaign: row.Campaign,
+		Term:     row.Term,
+		Content:  row.Content,
+	}, nil
+}
+
+// SetUserUTMDefaults replaces the default UTM parameters of the user.
+func (db *PostgresDB) SetUserUTMDefaults(ctx context.Context, userID string, defaults models.UTMParams) error {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+
+	return db.queries.SetUserUTMDefaults(ctx, sqlc.SetUserUTMDefaultsParams{
+		UserID:   userIDAsUUID,
+		Source:   defaults.Source,
+		Medium:   defaults.Medium,
+		Campaign: defaults.Campaign,
+		Term:     defaults.Term,
+		Content:  defaults.Content,
+	})
+}
+
+// ReportURL files an abuse report on the short URL. A repeated report of the same user is ignored
+// while their previous one is pending, and so are the reports on a disabled short URL.
+// Returns models.ErrURLNotFound if the short URL does not exist or is deleted.
+func (db *PostgresDB) ReportURL(ctx context.Context, short, reporterID, reason string) error {
+	reporterIDAsUUID, err := uuid.Parse(reporterID)
+	if err != nil {
+		return err
+	}
+
+	found, err := db.queries.ReportURL(ctx, sqlc.ReportURLParams{
+		Short:      short,
+		ReporterID: reporterIDAsUUID,
+		Reason:     reason,
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return models.ErrURLNotFound
+	}
+
+	return nil
+}
+
+// GetAbuseReportsQueue returns the short URLs with pending abuse reports, the most reported first,
+// formatted using the provided function.
+func (db *PostgresDB) GetAbuseReportsQueue(
+	ctx context.Context,
+	formatter models.URLFormatter,
+) (models.AbuseReportsQueue, error) {
+	rows, err := db.queries.GetAbuseReportsQueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := models.AbuseReportsQueue{}
+	for _, row := range rows {
+		item := models.AbuseReportsQueueItem{
+			ShortURL:        row.Short,
+			OriginalURL:     row.OriginalUrl,
+			ReportsCount:    row.ReportsCount,
+			FirstReportedAt: row.FirstReportedAt,
+			LastReportedAt:  row.LastReportedAt,
+			Reasons:         row.Reasons,
+		}
+		if formatter != nil {
+			item.ShortURL = formatter(item.ShortURL)
+		}
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+// DisableURL disables the short URL for everyone and marks its pending abuse reports as actioned.
+// Disabling a disabled short URL keeps its original disabling time.
+// Returns models.ErrURLNotFound if the short URL does not exist.
+func (db *PostgresDB) DisableURL(ctx context.Context, short string) error {
+	disabled, err := db.queries.DisableURL(ctx, short)
+	if err != nil {
+		return err
+	}
+	if disabled == 0 {
+		return models.ErrURLNotFound
+	}
+
+	return nil
+}
+
+// DismissURLReports dismisses the pending abuse reports on the short URL and returns how many there were.
+func (db *PostgresDB) DismissURLReports(ctx context.Context, short string) (int64, error) {
+	return db.queries.DismissURLReports(ctx, short)
+}
+
+// SearchURLs retrieves the page defined by query of all the short URLs, deleted and disabled ones
+// included, whose original URL or short URL contains the search string. The tag of the query is
+// ignored. Optionally applies a formatter to each short URL before returning.
+// The search is done on the primary, so that the admins see the latest state of the links.
+func (db *PostgresDB) SearchURLs(
+	ctx context.Context,
+	query models.UserURLsQuery,
+	formatter models.URLFormatter,
+) (models.AdminURLs, error) {
+	params := sqlc.SearchURLsParams{
+		Search:     query.Search,
+		SortBy:     query.SortBy,
+		Descending: query.Descending,
+		PageSize:   sql.NullInt32{Int32: int32(query.Limit), Valid: query.Limit > 0},
+	}
+	if query.After != nil {
+		params.AfterShort = query.After.Short
+		params.AfterOriginalUrl = query.After.OriginalURL
+		params.AfterCreatedAt = query.After.CreatedAt
+	}
+
+	rows, err := db.queries.SearchURLs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := models.AdminURLs{}
+	for _, row := range rows {
+		adminURL := models.AdminURL{
+			ShortURL:    row.Short,
+			OriginalURL: row.OriginalUrl,
+			CreatedAt:   row.CreatedAt,
+			IsDeleted:   row.IsDeleted,
+		}
+		if formatter != nil {
+			adminURL.ShortURL = formatter(adminURL.ShortURL)
+		}
+		if row.OwnerID.Valid {
+			adminURL.OwnerID = row.OwnerID.UUID.String()
+		}
+		if row.CreatedBy.Valid {
+			adminURL.CreatedBy = row.CreatedBy.UUID.String()
+		}
+		if row.DisabledAt.Valid {
+			adminURL.DisabledAt = &row.DisabledAt.Time
+		}
+		result = append(result, adminURL)
+	}
+
+	return result, nil
+}
+
+// CreateUser inserts a new user record into the database.
+// Returns the created user ID or an error if insertion fails.
+func (db *PostgresDB) CreateUser(ctx context.Context, usr *user.User, transaction *sql.Tx) (string, error) {
+	var queries *sqlc.Queries
+	if transaction != nil {
+		queries = db.queries.WithTx(transaction)
+	} else {
+		queries = db.queries
+	}
+
+	userID, err := queries.CreateUser(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return userID.String(), nil
+}
+
+// GetUserByID fetches a user by their UUID from the database, together with their role and email.
+// If the user does not exist, it returns a user with an empty ID field.
+// Without a transaction the lookup is served by the read replica when one is configured.
+func (db *PostgresDB) GetUserByID(ctx context.Context, userID string, transaction *sql.Tx) (*user.User, error) {
+	if userID == "" {
+		return &user.User{ID: ""}, nil
+	}
+
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var row sqlc.GetUserByIDRow
+	if transaction != nil {
+		row, err = db.queries.WithTx(transaction).GetUserByID(ctx, userIDAsUUID)
+	} else {
+		err = db.withReadQueries(ctx, func(queries *sqlc.Queries) error {
+			var err error
+			row, err = queries.GetUserByID(ctx, userIDAsUUID)
+			return err
+		})
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &user.User{ID: ""}, nil
+		}
+		return &user.User{ID: ""}, err
+	}
+
+	return &user.User{ID: row.UserID.String(), Role: row.Role, Email: row.Email.String}, nil
+}
+
+// SetUserRole changes the role of the user to one of the user role constants.
+// Returns models.ErrUserNotFound if the user does not exist.
+func (db *PostgresDB) SetUserRole(ctx context.Context, userID, role string) error {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return models.ErrUserNotFound
+	}
+
+	updated, err := db.queries.SetUserRole(ctx, sqlc.SetUserRoleParams{
+		Role:   role,
+		UserID: userIDAsUUID,
+	})
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// RegisterUser gives the anonymous user an account to sign in with the email and the password hash.
+// Returns models.ErrUserNotFound if the user does not exist, models.ErrUserAlreadyRegistered if
+// the user has an account already, or models.ErrEmailTaken if another account has the email.
+func (db *PostgresDB) RegisterUser(ctx context.Context, userID, email, passwordHash string) error {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return models.ErrUserNotFound
+	}
+
+	row, err := db.queries.RegisterUser(ctx, sqlc.RegisterUserParams{
+		Email:        email,
+		PasswordHash: passwordHash,
+		UserID:       userIDAsUUID,
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return models.ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !row.UserExists:
+		return models.ErrUserNotFound
+	case !row.Registered:
+		return models.ErrUserAlreadyRegistered
+	}
+
+	return nil
+}
+
+// GetUserByEmail fetches the registered user by their email, together with their password hash.
+// If there is no such user, it returns a user with an empty ID field. The lookup is done on
+// the primary, so that the accounts can be signed in to right after their registration.
+func (db *PostgresDB) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
+	row, err := db.queries.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &user.User{ID: ""}, nil
+	}
+	if err != nil {
+		return &user.User{ID: ""}, err
+	}
+
+	return &user.User{
+		ID:           row.UserID.String(),
+		Role:         row.Role,
+		Email:        row.Email.String,
+		PasswordHash: row.PasswordHash.String,
+	}, nil
+}
+
+// ClaimUserURLs moves the links of the anonymous user to the registered user and returns how many
+// the registered user did not have yet. The tags and the deletion states of the links go along,
+// and so does the ownership of the owned short URLs, but for the ones whose original URL the
+// registered user owns a short URL for already. Nothing is claimed from registered users.
+func (db *PostgresDB) ClaimUserURLs(ctx context.Context, anonymousUserID, userID string) (int64, error) {
+	anonymousUserIDAsUUID, err := uuid.Parse(anonymousUserID)
+	if err != nil {
+		return 0, err
+	}
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return 0, err
+	}
+	if anonymousUserIDAsUUID == userIDAsUUID {
+		return 0, nil
+	}
+
+	transaction, err := db.database.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	rows, err := db.queries.GetUserUrls(ctx, userIDAsUUID)
+	claimed, err := claimUserURLs(ctx, db.queries.WithTx(transaction), anonymousUserIDAsUUID, userIDAsUUID)
 	if err != nil {
-		return nil, err
+		err2 := transaction.Rollback()
+		if err2 != nil {
+			return 0, err2
+		}
+		return 0, err
 	}
 
-	result := models.UserUrls{}
-	for _, row := range rows {
-		result = append(result, models.UserURL{
-			ShortURL:    formatter(row.Short),
-			OriginalURL: row.OriginalUrl,
-		})
+	return claimed, transaction.Commit()
+}
+
+// SaveRefreshToken stores the hash of a refresh token of the user, valid until expiresAt.
+func (db *PostgresDB) SaveRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return err
 	}
 
-	return result, nil
+	return db.queries.SaveRefreshToken(ctx, sqlc.SaveRefreshTokenParams{
+		TokenHash: tokenHash,
+		UserID:    userIDAsUUID,
+		ExpiresAt: expiresAt,
+	})
 }
 
-// CreateUser inserts a new user record into the database.
-// Returns the created user ID or an error if insertion fails.
-func (db *PostgresDB) CreateUser(ctx context.Context, usr *user.User, transaction *sql.Tx) (string, error) {
-	var queries *sqlc.Queries
-	if transaction != nil {
-		queries = db.queries.WithTx(transaction)
-	} else {
-		queries = db.queries
+// ConsumeRefreshToken deletes the refresh token of the given hash, so that it is used once,
+// and returns the ID of its user. Returns an empty ID if there is no such token or it has expired.
+func (db *PostgresDB) ConsumeRefreshToken(ctx context.Context, tokenHash string) (string, error) {
+	row, err := db.queries.ConsumeRefreshToken(ctx, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
 	}
-
-	userID, err := queries.CreateUser(ctx)
 	if err != nil {
 		return "", err
 	}
+	if row.ExpiresAt.Before(time.Now()) {
+		return "", nil
+	}
 
-	return userID.String(), nil
+	return row.UserID.String(), nil
 }
 
-// GetUserByID fetches a user by their UUID from the database.
-// If the user does not exist, it returns a user with an empty ID field.
-func (db *PostgresDB) GetUserByID(ctx context.Context, userID string, transaction *sql.Tx) (*user.User, error) {
-	if userID == "" {
-		return &user.User{ID: ""}, nil
+// GetRefreshTokenUserID returns the ID of the user of the refresh token of the given hash,
+// leaving the token usable. Returns an empty ID if there is no such token or it has expired.
+// The lookup is done on the primary, so that the tokens work right after they are issued.
+func (db *PostgresDB) GetRefreshTokenUserID(ctx context.Context, tokenHash string) (string, error) {
+	userID, err := db.queries.GetRefreshTokenUserID(ctx, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
 	}
-
-	var queries *sqlc.Queries
-	if transaction != nil {
-		queries = db.queries.WithTx(transaction)
-	} else {
-		queries = db.queries
+	if err != nil {
+		return "", err
 	}
 
-	userIDAsUUID, err := uuid.Parse(userID)
+	return userID.String(), nil
+}
+
+// DeleteRefreshToken deletes the refresh token of the given hash, if any.
+func (db *PostgresDB) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
+	return db.queries.DeleteRefreshToken(ctx, tokenHash)
+}
+
+// RevokeToken puts the ID of an access token on the revocation list until the token expires.
+func (db *PostgresDB) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	tokenIDAsUUID, err := uuid.Parse(tokenID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	userIDFromDB, err := queries.GetUserByID(ctx, userIDAsUUID)
+	return db.queries.RevokeToken(ctx, sqlc.RevokeTokenParams{
+		TokenID:   tokenIDAsUUID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// IsTokenRevoked tells whether the ID of an access token is on the revocation list.
+// The check is done on the primary, so that the revoked tokens are rejected right away.
+func (db *PostgresDB) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	tokenIDAsUUID, err := uuid.Parse(tokenID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return &user.User{ID: ""}, nil
-		}
-		return &user.User{ID: ""}, err
+		return false, err
 	}
 
-	return &user.User{ID: userIDFromDB.String()}, nil
+	return db.queries.IsTokenRevoked(ctx, tokenIDAsUUID)
 }
 
 // CommitTransaction commits the given SQL transaction.
@@ -270,16 +1067,25 @@ func (db *PostgresDB) BeginTransaction() (*sql.Tx, error) {
 
 // SaveNewFullsAndShorts stores a set of full-to-short URL mappings that
 // do not yet exist in the database. It is used to avoid duplicate inserts.
+// The mappings belong to ownerID, or are shared when ownerID is empty,
+// and get the attributes given for their full URLs.
 // This operation is performed within the provided transaction.
 func (db *PostgresDB) SaveNewFullsAndShorts(
 	ctx context.Context,
 	newURLs map[string]string,
+	ownerID string,
+	attributes map[string]models.URLAttributes,
 	transaction *sql.Tx,
 ) error {
 	if len(newURLs) == 0 {
 		return nil
 	}
 
+	owner, err := toNullUUID(ownerID)
+	if err != nil {
+		return err
+	}
+
 	var queries *sqlc.Queries
 	if transaction != nil {
 		queries = db.queries.WithTx(transaction)
@@ -288,9 +1094,25 @@ func (db *PostgresDB) SaveNewFullsAndShorts(
 	}
 
 	for full, short := range newURLs {
-		err := queries.SaveURLMapping(ctx, sqlc.SaveURLMappingParams{
-			Short:       short,
-			OriginalUrl: full,
+		createdBy, err := toNullUUID(attributes[full].CreatedBy)
+		if err != nil {
+			return err
+		}
+		err = queries.SaveURLMapping(ctx, sqlc.SaveURLMappingParams{
+			Short:           short,
+			OriginalUrl:     full,
+			OriginalUrlHash: hashURL(full),
+			OwnerID:         owner,
+			CreatedBy:       createdBy,
+			Title:           attributes[full].Title,
+			Description:     attributes[full].Description,
+			PasswordHash:    attributes[full].PasswordHash,
+			MaxClicks:       int32(attributes[full].MaxClicks),
+			ActiveFrom:      toNullTime(attributes[full].ActiveFrom),
+			RedirectCode:    int16(attributes[full].RedirectCode),
+			Passthrough:     toPassthrough(attributes[full].Passthrough),
+			Interstitial:    attributes[full].Interstitial,
+			Dedicated:       attributes[full].IsDedicated(),
 		})
 		if err != nil {
 			return err
@@ -302,15 +1124,23 @@ func (db *PostgresDB) SaveNewFullsAndShorts(
 
 // FindShortsByFulls returns a mapping from full URLs to their corresponding
 // short URLs for the given input list. If a URL does not exist, it will be omitted.
+// With an empty ownerID only shared links are considered, otherwise the owner's
+// links and the shared links the owner is linked to.
 func (db *PostgresDB) FindShortsByFulls(
 	ctx context.Context,
 	urls []string,
+	ownerID string,
 	transaction *sql.Tx,
 ) (map[string]string, error) {
 	if len(urls) == 0 {
 		return map[string]string{}, nil
 	}
 
+	owner, err := toNullUUID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
 	var queries *sqlc.Queries
 	if transaction != nil {
 		queries = db.queries.WithTx(transaction)
@@ -318,7 +1148,15 @@ func (db *PostgresDB) FindShortsByFulls(
 		queries = db.queries
 	}
 
-	rows, err := queries.FindShortsByFulls(ctx, urls)
+	hashes := make([]string, 0, len(urls))
+	for _, url := range urls {
+		hashes = append(hashes, hashURL(url))
+	}
+
+	rows, err := queries.FindShortsByFulls(ctx, sqlc.FindShortsByFullsParams{
+		OriginalUrlHashes: hashes,
+		OwnerID:           owner,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -331,11 +1169,14 @@ func (db *PostgresDB) FindShortsByFulls(
 	return result, nil
 }
 
-// InsertURLMapping creates a new short-to-full URL mapping in the database.
+// InsertURLMapping creates a new short-to-full URL mapping with its attributes in the database.
+// The mapping belongs to ownerID, or is shared when ownerID is empty.
 func (db *PostgresDB) InsertURLMapping(
 	ctx context.Context,
 	short,
 	full string,
+	ownerID string,
+	attributes models.URLAttributes,
 	transaction *sql.Tx,
 ) error {
 	var queries *sqlc.Queries
@@ -345,9 +1186,31 @@ func (db *PostgresDB) InsertURLMapping(
 		queries = db.queries
 	}
 
-	err := queries.InsertURLMapping(ctx, sqlc.InsertURLMappingParams{
-		Short:       short,
-		OriginalUrl: full,
+	owner, err := toNullUUID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	createdBy, err := toNullUUID(attributes.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	err = queries.InsertURLMapping(ctx, sqlc.InsertURLMappingParams{
+		Short:           short,
+		OriginalUrl:     full,
+		OriginalUrlHash: hashURL(full),
+		OwnerID:         owner,
+		CreatedBy:       createdBy,
+		Title:           attributes.Title,
+		Description:     attributes.Description,
+		PasswordHash:    attributes.PasswordHash,
+		MaxClicks:       int32(attributes.MaxClicks),
+		ActiveFrom:      toNullTime(attributes.ActiveFrom),
+		RedirectCode:    int16(attributes.RedirectCode),
+		Passthrough:     toPassthrough(attributes.Passthrough),
+		Interstitial:    attributes.Interstitial,
+		Dedicated:       attributes.IsDedicated(),
 	})
 
 	return err
@@ -355,8 +1218,14 @@ func (db *PostgresDB) InsertURLMapping(
 
 // FindFullByShort retrieves the full URL associated with the given short URL.
 // If the short URL is marked as deleted, it returns true and an error.
+// The lookup is served by the read replica when one is configured.
 func (db *PostgresDB) FindFullByShort(ctx context.Context, short string) (string, bool, error) {
-	row, err := db.queries.FindFullByShort(ctx, short)
+	var row sqlc.FindFullByShortRow
+	err := db.withReadQueries(ctx, func(queries *sqlc.Queries) error {
+		var err error
+		row, err = queries.FindFullByShort(ctx, short)
+		return err
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", false, nil
@@ -371,11 +1240,94 @@ func (db *PostgresDB) FindFullByShort(ctx context.Context, short string) (string
 	return row.OriginalUrl, true, nil
 }
 
+// FindRedirectByShort retrieves the original URL and the link attributes of the given short URL.
+// If the short URL is disabled by the moderators, it returns true and models.ErrURLDisabled;
+// if it is marked as deleted, true and models.ErrURLMarkedAsDeleted;
+// if it has no redirects left, true and models.ErrURLClicksExhausted.
+// The lookup is served by the read replica when one is configured.
+func (db *PostgresDB) FindRedirectByShort(ctx context.Context, short string) (models.URLRedirect, bool, error) {
+	var row sqlc.FindRedirectByShortRow
+	err := db.withReadQueries(ctx, func(queries *sqlc.Queries) error {
+		var err error
+		row, err = queries.FindRedirectByShort(ctx, short)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.URLRedirect{}, false, nil
+		}
+		return models.URLRedirect{}, false, err
+	}
+
+	rules, err := toRedirectRules(row.RedirectRules)
+	if err != nil {
+		return models.URLRedirect{}, false, err
+	}
+
+	destinations, err := toDestinations(row.Destinations)
+	if err != nil {
+		return models.URLRedirect{}, false, err
+	}
+
+	redirect := models.URLRedirect{
+		OriginalURL: row.OriginalUrl,
+		Attributes: models.URLAttributes{
+			Title:        row.Title,
+			Description:  row.Description,
+			PasswordHash: row.PasswordHash,
+			MaxClicks:    int(row.MaxClicks),
+			ActiveFrom:   row.ActiveFrom.Time,
+			RedirectCode: int(row.RedirectCode),
+			Passthrough:  row.Passthrough,
+
+			RedirectRules: rules,
+			Destinations:  destinations,
+			Interstitial:  row.Interstitial,
+		},
+		CreatedAt: row.CreatedAt,
+	}
+	if row.CreatedBy.Valid {
+		redirect.Attributes.CreatedBy = row.CreatedBy.UUID.String()
+	}
+
+	if row.DisabledAt.Valid {
+		return redirect, true, models.ErrURLDisabled
+	}
+
+	if row.IsDeleted {
+		return redirect, true, models.ErrURLMarkedAsDeleted
+	}
+
+	if row.MaxClicks > 0 && row.ClicksLeft == 0 {
+		return redirect, true, models.ErrURLClicksExhausted
+	}
+
+	return redirect, true, nil
+}
+
+// ConsumeURLClick atomically takes one redirect from a short URL limited to a number of redirects
+// and returns how many are left. Returns models.ErrURLClicksExhausted if none were left.
+// It always runs on the primary.
+func (db *PostgresDB) ConsumeURLClick(ctx context.Context, short string) (int, error) {
+	clicksLeft, err := db.queries.ConsumeURLClick(ctx, short)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, models.ErrURLClicksExhausted
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return int(clicksLeft), nil
+}
+
 // FindShortByFull retrieves the short URL corresponding to the given full URL.
 // Returns a boolean indicating presence and an error if applicable.
+// With an empty ownerID only shared links are considered, otherwise the owner's
+// link or a shared link the owner is linked to.
 func (db *PostgresDB) FindShortByFull(
 	ctx context.Context,
 	full string,
+	ownerID string,
 	transaction *sql.Tx,
 ) (string, bool, error) {
 	var queries *sqlc.Queries
@@ -385,7 +1337,15 @@ func (db *PostgresDB) FindShortByFull(
 		queries = db.queries
 	}
 
-	short, err := queries.FindShortByFull(ctx, full)
+	owner, err := toNullUUID(ownerID)
+	if err != nil {
+		return "", false, err
+	}
+
+	short, err := queries.FindShortByFull(ctx, sqlc.FindShortByFullParams{
+		OriginalUrlHash: hashURL(full),
+		OwnerID:         owner,
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", false, nil
@@ -412,7 +1372,31 @@ func WithDBPreReset(value bool) InitOption {
 	}
 }
 
+// WithReplicaDSN sets the DSN of a read replica. An empty DSN disables replica routing.
+func WithReplicaDSN(value string) InitOption {
+	return func(options *initOptions) {
+		options.ReplicaDSN = value
+	}
+}
+
+// WithURLOwnershipMode sets the URL ownership mode (models.URLOwnershipModeShared
+// or models.URLOwnershipModePerUser), which defines how URL removal affects other users.
+func WithURLOwnershipMode(value string) InitOption {
+	return func(options *initOptions) {
+		options.URLOwnershipMode = value
+	}
+}
+
+// WithReplicaRetryInterval sets how long reads stay on the primary
+// after the replica has failed before the replica is tried again.
+func WithReplicaRetryInterval(value time.Duration) InitOption {
+	return func(options *initOptions) {
+		options.ReplicaRetryInterval = value
+	}
+}
+
 // Ping verifies connectivity with the PostgreSQL database within the configured timeout.
+// Only the primary is checked: reads fall back to it while the replica is unavailable.
 func (db *PostgresDB) Ping(ctx context.Context) error {
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, db.connectionTimeout)
 	defer cancel()
@@ -420,8 +1404,14 @@ func (db *PostgresDB) Ping(ctx context.Context) error {
 	return db.database.PingContext(ctxWithTimeout)
 }
 
-// Close closes the database connection and releases any associated resources.
+// Close closes the database connections and releases any associated resources.
 func (db *PostgresDB) Close() error {
+	if db.replica != nil {
+		if err := db.replica.Close(); err != nil {
+			return err
+		}
+	}
+
 	err := db.database.Close()
 	if err != nil {
 		return err
@@ -430,6 +1420,260 @@ func (db *PostgresDB) Close() error {
 	return nil
 }
 
+// withReadQueries runs a read-only operation against the replica when it is configured and
+// considered healthy, retrying it on the primary if the replica fails. sql.ErrNoRows is a
+// regular result and does not trigger the fallback.
+func (db *PostgresDB) withReadQueries(ctx context.Context, read func(queries *sqlc.Queries) error) error {
+	if db.replicaQueries == nil || time.Now().UnixNano() < db.replicaDownUntil.Load() {
+		return read(db.queries)
+	}
+
+	err := read(db.replicaQueries)
+	if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+		return err
+	}
+
+	logger.Log.Debugln("The read replica failed, falling back to the primary: ", zap.Error(err))
+	db.replicaDownUntil.Store(time.Now().Add(db.replicaRetryInterval).UnixNano())
+
+	return read(db.queries)
+}
+
+// changeUserURLsTags applies the tags change to the user's live short URLs among shortURLs
+// within a transaction and returns those short URLs.
+func (db *PostgresDB) changeUserURLsTags(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	change func(queries *sqlc.Queries, userID uuid.UUID, shorts []string) error,
+) ([]string, error) {
+	userIDAsUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := db.database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	qtx := db.queries.WithTx(transaction)
+
+	shorts, err := qtx.FilterUserLiveShorts(ctx, sqlc.FilterUserLiveShortsParams{
+		UserID:    userIDAsUUID,
+		ShortUrls: shortURLs,
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return nil, err
+	}
+
+	err = change(qtx, userIDAsUUID, shorts)
+	if err != nil {
+		_ = transaction.Rollback()
+		return nil, err
+	}
+
+	return shorts, transaction.Commit()
+}
+
+// removeUserLink deletes the user's own link to the short URL and, if nobody else links to it
+// anymore, the short URL itself.
+func removeUserLink(ctx context.Context, queries *sqlc.Queries, userID uuid.UUID, short string) error {
+	removed, err := queries.RemoveUserLink(ctx, sqlc.RemoveUserLinkParams{
+		UserID:   userID,
+		ShortUrl: short,
+	})
+	if err != nil || removed == 0 {
+		return err
+	}
+
+	return queries.RemoveUnlinkedURL(ctx, short)
+}
+
+// restoreUserLink restores the user's own link to the short URL and the short URL itself.
+func restoreUserLink(
+	ctx context.Context,
+	queries *sqlc.Queries,
+	userID uuid.UUID,
+	short string,
+	deletedAfter time.Time,
+) (int64, error) {
+	restored, err := queries.RestoreUserLink(ctx, sqlc.RestoreUserLinkParams{
+		UserID:       userID,
+		ShortUrl:     short,
+		DeletedAfter: sql.NullTime{Time: deletedAfter, Valid: true},
+	})
+	if err != nil || restored == 0 {
+		return restored, err
+	}
+
+	return restored, queries.RestoreLinkedURL(ctx, short)
+}
+
+// claimUserURLs performs ClaimUserURLs within the queries' transaction. The anonymous user is locked
+// so that they do not get an account in the meantime.
+func claimUserURLs(ctx context.Context, queries *sqlc.Queries, anonymousUserID, userID uuid.UUID) (int64, error) {
+	_, err := queries.LockAnonymousUser(ctx, anonymousUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	params := sqlc.ClaimUserLinksParams{UserID: userID, AnonymousUserID: anonymousUserID}
+	claimed, err := queries.ClaimUserLinks(ctx, params)
+	if err != nil {
+		return 0, err
+	}
+
+	err = queries.ClaimUserURLsTags(ctx, sqlc.ClaimUserURLsTagsParams(params))
+	if err != nil {
+		return 0, err
+	}
+
+	err = queries.ClaimOwnedURLs(ctx, sqlc.ClaimOwnedURLsParams(params))
+	if err != nil {
+		return 0, err
+	}
+
+	return claimed, queries.RemoveAllUserLinks(ctx, anonymousUserID)
+}
+
+// retargetUserURL performs RetargetUserURL within the queries' transaction.
+func retargetUserURL(ctx context.Context, queries *sqlc.Queries, userID uuid.UUID, short, full string) error {
+	link, err := lockOwnedUserLink(ctx, queries, userID, short)
+	if err != nil {
+		return err
+	}
+
+	if link.OriginalUrl == full {
+		return nil
+	}
+
+	_, err = queries.FindShortByFull(ctx, sqlc.FindShortByFullParams{
+		OriginalUrlHash: hashURL(full),
+		OwnerID:         link.OwnerID,
+	})
+	if err == nil {
+		return models.ErrURLAlreadyShortened
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = queries.SaveURLRedirectHistory(ctx, sqlc.SaveURLRedirectHistoryParams{
+		Short:       short,
+		OriginalUrl: link.OriginalUrl,
+		ChangedBy:   uuid.NullUUID{UUID: userID, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	return queries.RetargetURL(ctx, sqlc.RetargetURLParams{
+		OriginalUrl:     full,
+		OriginalUrlHash: hashURL(full),
+		Short:           short,
+	})
+}
+
+// lockOwnedUserLink locks the user's live short URL for update within the queries' transaction.
+// Returns models.ErrURLNotFound if the user has no such link, or models.ErrURLNotOwned if the link
+// is owned by, or shared with, other users.
+func lockOwnedUserLink(
+	ctx context.Context,
+	queries *sqlc.Queries,
+	userID uuid.UUID,
+	short string,
+) (sqlc.GetUserLinkForUpdateRow, error) {
+	link, err := queries.GetUserLinkForUpdate(ctx, sqlc.GetUserLinkForUpdateParams{
+		UserID: userID,
+		Short:  short,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return link, models.ErrURLNotFound
+		}
+		return link, err
+	}
+
+	if link.OwnerID.Valid && link.OwnerID.UUID != userID ||
+		!link.OwnerID.Valid && link.OtherUsersCount > 0 {
+		return link, models.ErrURLNotOwned
+	}
+
+	return link, nil
+}
+
+// toNullTime converts an optional time into a nullable one; a zero time yields NULL.
+func toNullTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value, Valid: !value.IsZero()}
+}
+
+// toPassthrough converts an optional passthrough policy into the stored one; an empty policy yields "off".
+func toPassthrough(value string) string {
+	if value == "" {
+		return models.PassthroughOff
+	}
+
+	return value
+}
+
+// toRedirectRules decodes the stored redirect rules.
+func toRedirectRules(rulesJSON json.RawMessage) (models.RedirectRules, error) {
+	rules := models.RedirectRules{}
+	if len(rulesJSON) == 0 {
+		return rules, nil
+	}
+
+	err := json.Unmarshal(rulesJSON, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// toDestinations decodes the stored A/B destinations.
+func toDestinations(destinationsJSON json.RawMessage) (models.Destinations, error) {
+	destinations := models.Destinations{}
+	if len(destinationsJSON) == 0 {
+		return destinations, nil
+	}
+
+	err := json.Unmarshal(destinationsJSON, &destinations)
+	if err != nil {
+		return nil, err
+	}
+
+	return destinations, nil
+}
+
+// toNullUUID converts an optional owner ID into a nullable UUID; an empty ID yields NULL.
+func toNullUUID(ownerID string) (uuid.NullUUID, error) {
+	if ownerID == "" {
+		return uuid.NullUUID{}, nil
+	}
+
+	ownerIDAsUUID, err := uuid.Parse(ownerID)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+
+	return uuid.NullUUID{UUID: ownerIDAsUUID, Valid: true}, nil
+}
+
+// hashURL returns the hex-encoded SHA-256 of the URL. Original URLs have no length limit,
+// so the hash is what keys them for uniqueness and foreign keys.
+// It must stay in sync with the hashing done by the migrations.
+func hashURL(url string) string {
+	sum := sha256.Sum256([]byte(url))
+
+	return hex.EncodeToString(sum[:])
+}
+
 func (db *PostgresDB) resetDB(ctx context.Context) error {
 	err := db.queries.ResetDB(ctx)
 	if err != nil {
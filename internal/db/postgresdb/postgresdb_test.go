@@ -0,0 +1,213 @@
+package postgresdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/patric-chuzhbe/urlshrt/internal/db/postgresdb/sqlc"
+	"github.com/patric-chuzhbe/urlshrt/internal/logger"
+	"github.com/patric-chuzhbe/urlshrt/internal/models"
+)
+
+const fakeDriverName = "postgresdb-fake"
+
+var errFakeConnectionRefused = errors.New("connection refused")
+
+// fakeServer is an in-process stand-in for a Postgres server. It counts the statements
+// it receives and fails all of them while it is down. Queries return no rows.
+type fakeServer struct {
+	mu         sync.Mutex
+	down       bool
+	statements int
+}
+
+func (s *fakeServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *fakeServer) statementsCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statements
+}
+
+func (s *fakeServer) handle() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statements++
+	if s.down {
+		return errFakeConnectionRefused
+	}
+	return nil
+}
+
+type fakeDriver struct {
+	mu      sync.Mutex
+	servers map[string]*fakeServer
+}
+
+var theFakeDriver = &fakeDriver{servers: map[string]*fakeServer{}}
+
+func init() {
+	sql.Register(fakeDriverName, theFakeDriver)
+}
+
+func (d *fakeDriver) server(name string) *fakeServer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.servers[name]; !ok {
+		d.servers[name] = &fakeServer{}
+	}
+	return d.servers[name]
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{server: d.server(name)}, nil
+}
+
+type fakeConn struct {
+	server *fakeServer
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *fakeConn) Commit() error { return nil }
+
+func (c *fakeConn) Rollback() error { return nil }
+
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if err := c.server.handle(); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	if err := c.server.handle(); err != nil {
+		return nil, err
+	}
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string { return nil }
+
+func (fakeRows) Close() error { return nil }
+
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+func newTestPostgresDB(t *testing.T, name string) (*PostgresDB, *fakeServer, *fakeServer) {
+	require.NoError(t, logger.Init("debug"))
+
+	primary, err := sql.Open(fakeDriverName, name+"-primary")
+	require.NoError(t, err)
+	replica, err := sql.Open(fakeDriverName, name+"-replica")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, primary.Close())
+		assert.NoError(t, replica.Close())
+	})
+
+	db := &PostgresDB{
+		database:             primary,
+		queries:              sqlc.New(primary),
+		replica:              replica,
+		replicaQueries:       sqlc.New(replica),
+		replicaRetryInterval: time.Minute,
+		urlOwnershipMode:     models.URLOwnershipModeShared,
+	}
+
+	return db, theFakeDriver.server(name + "-primary"), theFakeDriver.server(name + "-replica")
+}
+
+func TestReadReplicaRouting(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Reads outside of a transaction are served by the replica", func(t *testing.T) {
+		db, primary, replica := newTestPostgresDB(t, "healthy")
+
+		_, found, err := db.FindFullByShort(ctx, "someShort")
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		assert.Equal(t, 1, replica.statementsCount())
+		assert.Equal(t, 0, primary.statementsCount())
+	})
+
+	t.Run("An unavailable replica falls back to the primary", func(t *testing.T) {
+		db, primary, replica := newTestPostgresDB(t, "unavailable")
+		replica.setDown(true)
+
+		_, found, err := db.FindFullByShort(ctx, "someShort")
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.Equal(t, 1, replica.statementsCount())
+		assert.Equal(t, 1, primary.statementsCount())
+
+		usr, err := db.GetUserByID(ctx, uuid.NewString(), nil)
+		require.NoError(t, err)
+		assert.Empty(t, usr.ID)
+		assert.Equal(t, 1, replica.statementsCount(), "The replica should not be retried within the retry interval")
+		assert.Equal(t, 2, primary.statementsCount())
+
+		replica.setDown(false)
+		db.replicaDownUntil.Store(time.Now().Add(-time.Second).UnixNano())
+
+		_, _, err = db.FindFullByShort(ctx, "someShort")
+		require.NoError(t, err)
+		assert.Equal(t, 2, replica.statementsCount(), "The replica should be retried after the retry interval")
+		assert.Equal(t, 2, primary.statementsCount())
+	})
+
+	t.Run("A failure of both the replica and the primary is returned", func(t *testing.T) {
+		db, primary, replica := newTestPostgresDB(t, "both-down")
+		replica.setDown(true)
+		primary.setDown(true)
+
+		_, _, err := db.FindFullByShort(ctx, "someShort")
+		assert.ErrorIs(t, err, errFakeConnectionRefused)
+	})
+
+	t.Run("Reads after writes inside a transaction stay on the primary", func(t *testing.T) {
+		db, primary, replica := newTestPostgresDB(t, "transaction")
+
+		transaction, err := db.BeginTransaction()
+		require.NoError(t, err)
+
+		err = db.InsertURLMapping(ctx, "someShort", "https://example.com", "", models.URLAttributes{}, transaction)
+		require.NoError(t, err)
+
+		_, found, err := db.FindShortByFull(ctx, "https://example.com", "", transaction)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		_, err = db.GetUserByID(ctx, uuid.NewString(), transaction)
+		require.NoError(t, err)
+
+		require.NoError(t, db.CommitTransaction(transaction))
+
+		assert.Equal(t, 3, primary.statementsCount())
+		assert.Equal(t, 0, replica.statementsCount())
+	})
+}
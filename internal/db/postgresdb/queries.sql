@@ -1,71 +1,638 @@
--- name: RemoveUsersUrls :exec
-UPDATE url_redirects
-    SET is_deleted = true
-    FROM users_urls
-    WHERE url_redirects.original_url = users_urls.url
-        AND users_urls.user_id = sqlc.arg(user_id)
-        AND url_redirects.short = sqlc.arg(short_url);
-
--- name: SaveUserUrl :exec
-INSERT INTO users_urls (user_id, url)
-    VALUES (sqlc.arg(user_id), sqlc.arg(url))
-    ON CONFLICT (user_id, url) DO UPDATE
-        SET
-            user_id = EXCLUDED.user_id,
-            url = EXCLUDED.url;
-
--- name: GetUserUrls :many
-SELECT url_redirects.original_url, url_redirects.short
-    FROM url_redirects
-        JOIN users_urls ON
-            users_urls.url = url_redirects.original_url
-                AND users_urls.user_id = sqlc.arg(user_id)
-                AND NOT url_redirects.is_deleted;
-
--- name: CreateUser :one
-INSERT INTO users DEFAULT VALUES
-    RETURNING user_id;
-
--- name: GetUserByID :one
-SELECT user_id
-    FROM users
-    WHERE user_id = sqlc.arg(user_id);
-
--- name: SaveURLMapping :exec
-INSERT INTO url_redirects (short, original_url)
-    VALUES (sqlc.arg(short), sqlc.arg(original_url))
-    ON CONFLICT DO NOTHING;
-
--- name: FindShortsByFulls :many
-SELECT short, original_url
-    FROM url_redirects
-    WHERE original_url = ANY(sqlc.arg(original_urls)::text[]);
-
--- name: InsertURLMapping :exec
-INSERT INTO url_redirects (short, original_url)
-    VALUES (sqlc.arg(short), sqlc.arg(original_url));
-
--- name: FindFullByShort :one
-SELECT original_url, is_deleted
-    FROM url_redirects
-    WHERE short = sqlc.arg(short);
-
--- name: FindShortByFull :one
-SELECT short
-    FROM url_redirects
-    WHERE original_url = sqlc.arg(original_url);
-
--- name: IsShortExists :one
-SELECT EXISTS (
-    SELECT 1 FROM url_redirects WHERE short = sqlc.arg(short)
-);
-
--- name: ResetDB :exec
-DO $$
-DECLARE
-    r RECORD;
-BEGIN
-    FOR r IN (SELECT tablename FROM pg_tables WHERE schemaname = 'public') LOOP
-        EXECUTE 'DROP TABLE IF EXISTS ' || quote_ident(r.tablename) || ' CASCADE';
-    END LOOP;
-END $$;
+-- name: RemoveUsersUrls :exec
+UPDATE url_redirects
+    SET
+        is_deleted = true,
+        deleted_at = now()
+    FROM users_urls
+    WHERE url_redirects.short = users_urls.short
+        AND users_urls.user_id = sqlc.arg(user_id)
+        AND url_redirects.short = sqlc.arg(short_url)
+        AND NOT url_redirects.is_deleted;
+
+-- name: RemoveUserLink :execrows
+UPDATE users_urls
+    SET
+        is_deleted = true,
+        deleted_at = now()
+    WHERE user_id = sqlc.arg(user_id)
+        AND short = sqlc.arg(short_url)
+        AND NOT is_deleted;
+
+-- name: RemoveUnlinkedURL :exec
+UPDATE url_redirects
+    SET
+        is_deleted = true,
+        deleted_at = now()
+    WHERE short = sqlc.arg(short_url)
+        AND NOT url_redirects.is_deleted
+        AND NOT EXISTS (
+            SELECT 1
+                FROM users_urls
+                WHERE users_urls.short = url_redirects.short
+                    AND NOT users_urls.is_deleted
+        );
+
+-- name: RestoreUsersUrl :execrows
+UPDATE url_redirects
+    SET
+        is_deleted = false,
+        deleted_at = NULL
+    FROM users_urls
+    WHERE url_redirects.short = users_urls.short
+        AND users_urls.user_id = sqlc.arg(user_id)
+        AND url_redirects.short = sqlc.arg(short_url)
+        AND url_redirects.is_deleted
+        AND url_redirects.deleted_at >= sqlc.arg(deleted_after);
+
+-- name: RestoreUserLink :execrows
+UPDATE users_urls
+    SET
+        is_deleted = false,
+        deleted_at = NULL
+    WHERE user_id = sqlc.arg(user_id)
+        AND short = sqlc.arg(short_url)
+        AND is_deleted
+        AND deleted_at >= sqlc.arg(deleted_after);
+
+-- name: RestoreLinkedURL :exec
+UPDATE url_redirects
+    SET
+        is_deleted = false,
+        deleted_at = NULL
+    WHERE short = sqlc.arg(short_url)
+        AND is_deleted;
+
+-- name: PurgeDeletedURLs :execrows
+DELETE FROM url_redirects
+    WHERE is_deleted
+        AND deleted_at < sqlc.arg(deleted_before);
+
+-- name: PurgeDeletedUserLinks :execrows
+DELETE FROM users_urls
+    WHERE is_deleted
+        AND deleted_at < sqlc.arg(deleted_before);
+
+-- name: SaveUserUrl :exec
+INSERT INTO users_urls (user_id, short)
+    VALUES (sqlc.arg(user_id), sqlc.arg(short))
+    ON CONFLICT (user_id, short) DO UPDATE
+        SET
+            is_deleted = false,
+            deleted_at = NULL;
+
+-- name: GetUserUrls :many
+SELECT
+    url_redirects.original_url,
+    url_redirects.short,
+    url_redirects.created_at,
+    url_redirects.updated_at,
+    url_redirects.title,
+    url_redirects.description,
+    url_redirects.active_from,
+    ARRAY(
+        SELECT users_urls_tags.tag
+            FROM users_urls_tags
+            WHERE users_urls_tags.user_id = users_urls.user_id
+                AND users_urls_tags.short = users_urls.short
+            ORDER BY users_urls_tags.tag
+    )::text[] AS tags
+    FROM url_redirects
+        JOIN users_urls ON
+            users_urls.short = url_redirects.short
+                AND users_urls.user_id = sqlc.arg(user_id)
+                AND NOT users_urls.is_deleted
+                AND NOT url_redirects.is_deleted
+    WHERE strpos(lower(url_redirects.original_url), lower(sqlc.arg(search)::text)) > 0
+        AND (
+            sqlc.arg(tag)::text = ''
+            OR EXISTS (
+                SELECT 1
+                    FROM users_urls_tags
+                    WHERE users_urls_tags.user_id = users_urls.user_id
+                        AND users_urls_tags.short = users_urls.short
+                        AND users_urls_tags.tag = sqlc.arg(tag)::text
+            )
+        )
+        AND (
+            sqlc.arg(after_short)::text = ''
+            OR CASE
+                WHEN sqlc.arg(sort_by)::text = 'original_url' AND sqlc.arg(descending)::bool THEN
+                    (url_redirects.original_url, url_redirects.short)
+                        < (sqlc.arg(after_original_url)::text, sqlc.arg(after_short)::text)
+                WHEN sqlc.arg(sort_by)::text = 'original_url' THEN
+                    (url_redirects.original_url, url_redirects.short)
+                        > (sqlc.arg(after_original_url)::text, sqlc.arg(after_short)::text)
+                WHEN sqlc.arg(descending)::bool THEN
+                    (url_redirects.created_at, url_redirects.short)
+                        < (sqlc.arg(after_created_at)::timestamptz, sqlc.arg(after_short)::text)
+                ELSE
+                    (url_redirects.created_at, url_redirects.short)
+                        > (sqlc.arg(after_created_at)::timestamptz, sqlc.arg(after_short)::text)
+            END
+        )
+    ORDER BY
+        CASE WHEN sqlc.arg(sort_by)::text = 'original_url' AND NOT sqlc.arg(descending)::bool
+            THEN url_redirects.original_url END,
+        CASE WHEN sqlc.arg(sort_by)::text = 'original_url' AND sqlc.arg(descending)::bool
+            THEN url_redirects.original_url END DESC,
+        CASE WHEN sqlc.arg(sort_by)::text <> 'original_url' AND NOT sqlc.arg(descending)::bool
+            THEN url_redirects.created_at END,
+        CASE WHEN sqlc.arg(sort_by)::text <> 'original_url' AND sqlc.arg(descending)::bool
+            THEN url_redirects.created_at END DESC,
+        CASE WHEN NOT sqlc.arg(descending)::bool THEN url_redirects.short END,
+        CASE WHEN sqlc.arg(descending)::bool THEN url_redirects.short END DESC
+    LIMIT sqlc.narg(page_size)::int;
+
+-- name: CreateUser :one
+INSERT INTO users DEFAULT VALUES
+    RETURNING user_id;
+
+-- name: GetUserByID :one
+SELECT user_id, role, email
+    FROM users
+    WHERE user_id = sqlc.arg(user_id);
+
+-- name: GetUserByEmail :one
+SELECT user_id, role, email, password_hash
+    FROM users
+    WHERE email = sqlc.arg(email)::text;
+
+-- name: RegisterUser :one
+WITH registered AS (
+    UPDATE users
+        SET email = sqlc.arg(email)::text,
+            password_hash = sqlc.arg(password_hash)::text
+        WHERE user_id = sqlc.arg(user_id)
+            AND email IS NULL
+        RETURNING user_id
+)
+SELECT
+    EXISTS (SELECT 1 FROM registered)::bool AS registered,
+    EXISTS (SELECT 1 FROM users WHERE user_id = sqlc.arg(user_id))::bool AS user_exists;
+
+-- name: LockAnonymousUser :one
+SELECT user_id
+    FROM users
+    WHERE user_id = sqlc.arg(user_id)
+        AND email IS NULL
+    FOR UPDATE;
+
+-- name: ClaimUserLinks :execrows
+INSERT INTO users_urls (user_id, short, is_deleted, deleted_at)
+    SELECT sqlc.arg(user_id)::uuid, users_urls.short, users_urls.is_deleted, users_urls.deleted_at
+        FROM users_urls
+        WHERE users_urls.user_id = sqlc.arg(anonymous_user_id)
+    ON CONFLICT (user_id, short) DO NOTHING;
+
+-- name: ClaimUserURLsTags :exec
+INSERT INTO users_urls_tags (user_id, short, tag)
+    SELECT sqlc.arg(user_id)::uuid, users_urls_tags.short, users_urls_tags.tag
+        FROM users_urls_tags
+        WHERE users_urls_tags.user_id = sqlc.arg(anonymous_user_id)
+    ON CONFLICT DO NOTHING;
+
+-- name: ClaimOwnedURLs :exec
+UPDATE url_redirects
+    SET owner_id = sqlc.arg(user_id)::uuid
+    WHERE owner_id = sqlc.arg(anonymous_user_id)
+        AND (
+            url_redirects.dedicated
+            OR NOT EXISTS (
+                SELECT 1
+                    FROM url_redirects AS owned
+                    WHERE owned.owner_id = sqlc.arg(user_id)::uuid
+                        AND NOT owned.dedicated
+                        AND owned.original_url_hash = url_redirects.original_url_hash
+            )
+        );
+
+-- name: RemoveAllUserLinks :exec
+DELETE FROM users_urls
+    WHERE user_id = sqlc.arg(user_id);
+
+-- name: SetUserRole :execrows
+UPDATE users
+    SET role = sqlc.arg(role)
+    WHERE user_id = sqlc.arg(user_id);
+
+-- name: SaveURLMapping :exec
+INSERT INTO url_redirects (
+    short,
+    original_url,
+    original_url_hash,
+    owner_id,
+    created_by,
+    title,
+    description,
+    password_hash,
+    max_clicks,
+    clicks_left,
+    active_from,
+    redirect_code,
+    passthrough,
+    interstitial,
+    dedicated
+)
+    VALUES (
+        sqlc.arg(short),
+        sqlc.arg(original_url),
+        sqlc.arg(original_url_hash),
+        sqlc.narg(owner_id),
+        sqlc.narg(created_by),
+        sqlc.arg(title),
+        sqlc.arg(description),
+        sqlc.arg(password_hash),
+        sqlc.arg(max_clicks),
+        sqlc.arg(max_clicks),
+        sqlc.narg(active_from),
+        sqlc.arg(redirect_code),
+        sqlc.arg(passthrough),
+        sqlc.arg(interstitial),
+        sqlc.arg(dedicated)
+    )
+    ON CONFLICT DO NOTHING;
+
+-- name: FindShortsByFulls :many
+SELECT DISTINCT ON (url_redirects.original_url_hash) url_redirects.short, url_redirects.original_url
+    FROM url_redirects
+    WHERE NOT url_redirects.dedicated
+        AND url_redirects.original_url_hash = ANY(sqlc.arg(original_url_hashes)::text[])
+        AND CASE
+            WHEN sqlc.narg(owner_id)::uuid IS NULL THEN url_redirects.owner_id IS NULL
+            ELSE url_redirects.owner_id = sqlc.narg(owner_id)::uuid
+                OR (
+                    url_redirects.owner_id IS NULL
+                    AND EXISTS (
+                        SELECT 1
+                            FROM users_urls
+                            WHERE users_urls.short = url_redirects.short
+                                AND users_urls.user_id = sqlc.narg(owner_id)::uuid
+                    )
+                )
+        END
+    ORDER BY url_redirects.original_url_hash, url_redirects.owner_id NULLS LAST;
+
+-- name: InsertURLMapping :exec
+INSERT INTO url_redirects (
+    short,
+    original_url,
+    original_url_hash,
+    owner_id,
+    created_by,
+    title,
+    description,
+    password_hash,
+    max_clicks,
+    clicks_left,
+    active_from,
+    redirect_code,
+    passthrough,
+    interstitial,
+    dedicated
+)
+    VALUES (
+        sqlc.arg(short),
+        sqlc.arg(original_url),
+        sqlc.arg(original_url_hash),
+        sqlc.narg(owner_id),
+        sqlc.narg(created_by),
+        sqlc.arg(title),
+        sqlc.arg(description),
+        sqlc.arg(password_hash),
+        sqlc.arg(max_clicks),
+        sqlc.arg(max_clicks),
+        sqlc.narg(active_from),
+        sqlc.arg(redirect_code),
+        sqlc.arg(passthrough),
+        sqlc.arg(interstitial),
+        sqlc.arg(dedicated)
+    );
+
+-- name: FindFullByShort :one
+SELECT original_url, is_deleted
+    FROM url_redirects
+    WHERE short = sqlc.arg(short);
+
+-- name: FindRedirectByShort :one
+SELECT
+    original_url,
+    is_deleted,
+    created_by,
+    title,
+    description,
+    password_hash,
+    max_clicks,
+    clicks_left,
+    active_from,
+    redirect_code,
+    passthrough,
+    redirect_rules,
+    destinations,
+    interstitial,
+    disabled_at,
+    created_at
+    FROM url_redirects
+    WHERE short = sqlc.arg(short);
+
+-- name: ConsumeURLClick :one
+UPDATE url_redirects
+    SET clicks_left = clicks_left - 1
+    WHERE short = sqlc.arg(short)
+        AND max_clicks > 0
+        AND clicks_left > 0
+        AND NOT is_deleted
+    RETURNING clicks_left;
+
+-- name: FindShortByFull :one
+SELECT url_redirects.short
+    FROM url_redirects
+    WHERE NOT url_redirects.dedicated
+        AND url_redirects.original_url_hash = sqlc.arg(original_url_hash)
+        AND CASE
+            WHEN sqlc.narg(owner_id)::uuid IS NULL THEN url_redirects.owner_id IS NULL
+            ELSE url_redirects.owner_id = sqlc.narg(owner_id)::uuid
+                OR (
+                    url_redirects.owner_id IS NULL
+                    AND EXISTS (
+                        SELECT 1
+                            FROM users_urls
+                            WHERE users_urls.short = url_redirects.short
+                                AND users_urls.user_id = sqlc.narg(owner_id)::uuid
+                    )
+                )
+        END
+    ORDER BY url_redirects.owner_id NULLS LAST
+    LIMIT 1;
+
+-- name: IsShortExists :one
+SELECT EXISTS (
+    SELECT 1 FROM url_redirects WHERE short = sqlc.arg(short)
+);
+
+-- name: ResetDB :exec
+DO $$
+DECLARE
+    r RECORD;
+BEGIN
+    FOR r IN (SELECT tablename FROM pg_tables WHERE schemaname = 'public') LOOP
+        EXECUTE 'DROP TABLE IF EXISTS ' || quote_ident(r.tablename) || ' CASCADE';
+    END LOOP;
+END $$;
+
+-- name: GetUserLinkForUpdate :one
+SELECT
+    url_redirects.original_url,
+    url_redirects.owner_id,
+    (
+        SELECT COUNT(*)
+            FROM users_urls AS others
+            WHERE others.short = url_redirects.short
+                AND others.user_id <> sqlc.arg(user_id)
+                AND NOT others.is_deleted
+    ) AS other_users_count
+    FROM url_redirects
+        JOIN users_urls ON
+            users_urls.short = url_redirects.short
+                AND users_urls.user_id = sqlc.arg(user_id)
+                AND NOT users_urls.is_deleted
+    WHERE url_redirects.short = sqlc.arg(short)
+        AND NOT url_redirects.is_deleted
+    FOR UPDATE OF url_redirects;
+
+-- name: RetargetURL :exec
+UPDATE url_redirects
+    SET
+        original_url = sqlc.arg(original_url),
+        original_url_hash = sqlc.arg(original_url_hash),
+        updated_at = now()
+    WHERE short = sqlc.arg(short);
+
+-- name: SetURLActiveFrom :exec
+UPDATE url_redirects
+    SET active_from = sqlc.narg(active_from),
+        dedicated = url_redirects.dedicated OR sqlc.arg(dedicated)
+    WHERE short = sqlc.arg(short);
+
+-- name: SetURLRedirectRules :exec
+UPDATE url_redirects
+    SET redirect_rules = sqlc.arg(redirect_rules),
+        dedicated = url_redirects.dedicated OR sqlc.arg(dedicated)
+    WHERE short = sqlc.arg(short);
+
+-- name: GetUserURLRedirectRules :one
+SELECT url_redirects.redirect_rules
+    FROM users_urls
+        JOIN url_redirects ON
+            url_redirects.short = users_urls.short
+                AND NOT url_redirects.is_deleted
+    WHERE users_urls.user_id = sqlc.arg(user_id)
+        AND users_urls.short = sqlc.arg(short)
+        AND NOT users_urls.is_deleted;
+
+-- name: SetURLDestinations :exec
+UPDATE url_redirects
+    SET destinations = sqlc.arg(destinations),
+        dedicated = url_redirects.dedicated OR sqlc.arg(dedicated)
+    WHERE short = sqlc.arg(short);
+
+-- name: GetUserURLDestinations :one
+SELECT url_redirects.destinations
+    FROM users_urls
+        JOIN url_redirects ON
+            url_redirects.short = users_urls.short
+                AND NOT url_redirects.is_deleted
+    WHERE users_urls.user_id = sqlc.arg(user_id)
+        AND users_urls.short = sqlc.arg(short)
+        AND NOT users_urls.is_deleted;
+
+-- name: SaveURLRedirectHistory :exec
+INSERT INTO url_redirects_history (short, original_url, changed_by)
+    VALUES (sqlc.arg(short), sqlc.arg(original_url), sqlc.arg(changed_by));
+
+-- name: FilterUserLiveShorts :many
+SELECT users_urls.short
+    FROM users_urls
+        JOIN url_redirects ON
+            url_redirects.short = users_urls.short
+                AND NOT url_redirects.is_deleted
+    WHERE users_urls.user_id = sqlc.arg(user_id)
+        AND users_urls.short = ANY(sqlc.arg(short_urls)::text[])
+        AND NOT users_urls.is_deleted;
+
+-- name: AddUserURLsTags :exec
+INSERT INTO users_urls_tags (user_id, short, tag)
+    SELECT sqlc.arg(user_id)::uuid, shorts.short, tags.tag
+        FROM unnest(sqlc.arg(short_urls)::text[]) AS shorts (short)
+            CROSS JOIN unnest(sqlc.arg(tags)::text[]) AS tags (tag)
+    ON CONFLICT DO NOTHING;
+
+-- name: RemoveUserURLsTags :exec
+DELETE FROM users_urls_tags
+    WHERE user_id = sqlc.arg(user_id)
+        AND short = ANY(sqlc.arg(short_urls)::text[])
+        AND tag = ANY(sqlc.arg(tags)::text[]);
+
+-- name: GetUserTags :many
+SELECT users_urls_tags.tag, COUNT(*) AS urls_count
+    FROM users_urls_tags
+        JOIN users_urls ON
+            users_urls.user_id = users_urls_tags.user_id
+                AND users_urls.short = users_urls_tags.short
+                AND NOT users_urls.is_deleted
+        JOIN url_redirects ON
+            url_redirects.short = users_urls_tags.short
+                AND NOT url_redirects.is_deleted
+    WHERE users_urls_tags.user_id = sqlc.arg(user_id)
+    GROUP BY users_urls_tags.tag
+    ORDER BY users_urls_tags.tag;
+
+-- name: GetUserUTMDefaults :one
+SELECT source, medium, campaign, term, content
+    FROM users_utm_defaults
+    WHERE user_id = sqlc.arg(user_id);
+
+-- name: SetUserUTMDefaults :exec
+INSERT INTO users_utm_defaults (user_id, source, medium, campaign, term, content)
+    VALUES (
+        sqlc.arg(user_id),
+        sqlc.arg(source),
+        sqlc.arg(medium),
+        sqlc.arg(campaign),
+        sqlc.arg(term),
+        sqlc.arg(content)
+    )
+    ON CONFLICT (user_id) DO UPDATE
+        SET source = EXCLUDED.source,
+            medium = EXCLUDED.medium,
+            campaign = EXCLUDED.campaign,
+            term = EXCLUDED.term,
+            content = EXCLUDED.content;
+
+-- name: ReportURL :one
+WITH reported AS (
+    SELECT url_redirects.short, url_redirects.disabled_at
+        FROM url_redirects
+        WHERE url_redirects.short = sqlc.arg(short)
+            AND NOT url_redirects.is_deleted
+), inserted AS (
+    INSERT INTO abuse_reports (short, reporter_id, reason)
+        SELECT reported.short, sqlc.arg(reporter_id)::uuid, sqlc.arg(reason)::text
+            FROM reported
+            WHERE reported.disabled_at IS NULL
+        ON CONFLICT (short, reporter_id) WHERE status = 'pending' DO NOTHING
+)
+SELECT EXISTS (SELECT 1 FROM reported);
+
+-- name: GetAbuseReportsQueue :many
+SELECT
+    abuse_reports.short,
+    url_redirects.original_url,
+    COUNT(*) AS reports_count,
+    MIN(abuse_reports.created_at)::timestamptz AS first_reported_at,
+    MAX(abuse_reports.created_at)::timestamptz AS last_reported_at,
+    ARRAY_REMOVE(ARRAY_AGG(DISTINCT abuse_reports.reason), '')::text[] AS reasons
+    FROM abuse_reports
+        JOIN url_redirects ON url_redirects.short = abuse_reports.short
+    WHERE abuse_reports.status = 'pending'
+    GROUP BY abuse_reports.short, url_redirects.original_url
+    ORDER BY reports_count DESC, first_reported_at, abuse_reports.short;
+
+-- name: DisableURL :execrows
+WITH actioned AS (
+    UPDATE abuse_reports
+        SET status = 'actioned'
+        WHERE abuse_reports.short = sqlc.arg(short)
+            AND abuse_reports.status = 'pending'
+)
+UPDATE url_redirects
+    SET disabled_at = COALESCE(url_redirects.disabled_at, now())
+    WHERE url_redirects.short = sqlc.arg(short);
+
+-- name: DismissURLReports :execrows
+UPDATE abuse_reports
+    SET status = 'dismissed'
+    WHERE short = sqlc.arg(short)
+        AND status = 'pending';
+
+-- name: SearchURLs :many
+SELECT
+    url_redirects.short,
+    url_redirects.original_url,
+    url_redirects.owner_id,
+    url_redirects.created_by,
+    url_redirects.created_at,
+    url_redirects.is_deleted,
+    url_redirects.disabled_at
+    FROM url_redirects
+    WHERE (
+            strpos(lower(url_redirects.original_url), lower(sqlc.arg(search)::text)) > 0
+            OR strpos(lower(url_redirects.short), lower(sqlc.arg(search)::text)) > 0
+        )
+        AND (
+            sqlc.arg(after_short)::text = ''
+            OR CASE
+                WHEN sqlc.arg(sort_by)::text = 'original_url' AND sqlc.arg(descending)::bool THEN
+                    (url_redirects.original_url, url_redirects.short)
+                        < (sqlc.arg(after_original_url)::text, sqlc.arg(after_short)::text)
+                WHEN sqlc.arg(sort_by)::text = 'original_url' THEN
+                    (url_redirects.original_url, url_redirects.short)
+                        > (sqlc.arg(after_original_url)::text, sqlc.arg(after_short)::text)
+                WHEN sqlc.arg(descending)::bool THEN
+                    (url_redirects.created_at, url_redirects.short)
+                        < (sqlc.arg(after_created_at)::timestamptz, sqlc.arg(after_short)::text)
+                ELSE
+                    (url_redirects.created_at, url_redirects.short)
+                        > (sqlc.arg(after_created_at)::timestamptz, sqlc.arg(after_short)::text)
+            END
+        )
+    ORDER BY
+        CASE WHEN sqlc.arg(sort_by)::text = 'original_url' AND NOT sqlc.arg(descending)::bool
+            THEN url_redirects.original_url END,
+        CASE WHEN sqlc.arg(sort_by)::text = 'original_url' AND sqlc.arg(descending)::bool
+            THEN url_redirects.original_url END DESC,
+        CASE WHEN sqlc.arg(sort_by)::text <> 'original_url' AND NOT sqlc.arg(descending)::bool
+            THEN url_redirects.created_at END,
+        CASE WHEN sqlc.arg(sort_by)::text <> 'original_url' AND sqlc.arg(descending)::bool
+            THEN url_redirects.created_at END DESC,
+        CASE WHEN NOT sqlc.arg(descending)::bool THEN url_redirects.short END,
+        CASE WHEN sqlc.arg(descending)::bool THEN url_redirects.short END DESC
+    LIMIT sqlc.narg(page_size)::int;
+
+-- name: SaveRefreshToken :exec
+INSERT INTO refresh_tokens (token_hash, user_id, expires_at)
+    VALUES (sqlc.arg(token_hash), sqlc.arg(user_id), sqlc.arg(expires_at));
+
+-- name: ConsumeRefreshToken :one
+DELETE FROM refresh_tokens
+    WHERE token_hash = sqlc.arg(token_hash)
+    RETURNING user_id, expires_at;
+
+-- name: GetRefreshTokenUserID :one
+SELECT user_id
+    FROM refresh_tokens
+    WHERE token_hash = sqlc.arg(token_hash)
+        AND expires_at > now();
+
+-- name: DeleteRefreshToken :exec
+DELETE FROM refresh_tokens
+    WHERE token_hash = sqlc.arg(token_hash);
+
+-- name: RevokeToken :exec
+INSERT INTO revoked_tokens (token_id, expires_at)
+    VALUES (sqlc.arg(token_id), sqlc.arg(expires_at))
+    ON CONFLICT (token_id) DO NOTHING;
+
+-- name: IsTokenRevoked :one
+SELECT EXISTS (
+    SELECT 1
+        FROM revoked_tokens
+        WHERE token_id = sqlc.arg(token_id)
+);
+
+-- name: PurgeExpiredRefreshTokens :execrows
+DELETE FROM refresh_tokens
+    WHERE expires_at < sqlc.arg(expired_before);
+
+-- name: PurgeExpiredRevokedTokens :execrows
+DELETE FROM revoked_tokens
+    WHERE expires_at < sqlc.arg(expired_before);
@@ -5,20 +5,92 @@
 package sqlc
 
 import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
 	"github.com/google/uuid"
 )
 
+type AbuseReport struct {
+	ID         int64         `json:"id"`
+	Short      string        `json:"short"`
+	ReporterID uuid.NullUUID `json:"reporter_id"`
+	Reason     string        `json:"reason"`
+	Status     string        `json:"status"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+type RefreshToken struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RevokedToken struct {
+	TokenID   uuid.UUID `json:"token_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 type UrlRedirect struct {
-	OriginalUrl string `json:"original_url"`
-	Short       string `json:"short"`
-	IsDeleted   bool   `json:"is_deleted"`
+	OriginalUrl     string          `json:"original_url"`
+	Short           string          `json:"short"`
+	IsDeleted       bool            `json:"is_deleted"`
+	OriginalUrlHash string          `json:"original_url_hash"`
+	OwnerID         uuid.NullUUID   `json:"owner_id"`
+	DeletedAt       sql.NullTime    `json:"deleted_at"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	CreatedBy       uuid.NullUUID   `json:"created_by"`
+	Title           string          `json:"title"`
+	Description     string          `json:"description"`
+	PasswordHash    string          `json:"password_hash"`
+	MaxClicks       int32           `json:"max_clicks"`
+	ClicksLeft      int32           `json:"clicks_left"`
+	ActiveFrom      sql.NullTime    `json:"active_from"`
+	RedirectCode    int16           `json:"redirect_code"`
+	Passthrough     string          `json:"passthrough"`
+	RedirectRules   json.RawMessage `json:"redirect_rules"`
+	Destinations    json.RawMessage `json:"destinations"`
+	Interstitial    bool            `json:"interstitial"`
+	DisabledAt      sql.NullTime    `json:"disabled_at"`
+	Dedicated       bool            `json:"dedicated"`
+}
+
+type UrlRedirectsHistory struct {
+	ID          int64         `json:"id"`
+	Short       string        `json:"short"`
+	OriginalUrl string        `json:"original_url"`
+	ChangedBy   uuid.NullUUID `json:"changed_by"`
+	ChangedAt   time.Time     `json:"changed_at"`
 }
 
 type User struct {
-	UserID uuid.UUID `json:"user_id"`
+	UserID       uuid.UUID      `json:"user_id"`
+	Role         string         `json:"role"`
+	Email        sql.NullString `json:"email"`
+	PasswordHash sql.NullString `json:"password_hash"`
 }
 
 type UsersUrl struct {
+	UserID    uuid.UUID    `json:"user_id"`
+	Short     string       `json:"short"`
+	IsDeleted bool         `json:"is_deleted"`
+	DeletedAt sql.NullTime `json:"deleted_at"`
+}
+
+type UsersUrlsTag struct {
 	UserID uuid.UUID `json:"user_id"`
-	Url    string    `json:"url"`
+	Short  string    `json:"short"`
+	Tag    string    `json:"tag"`
+}
+
+type UsersUtmDefault struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Source   string    `json:"source"`
+	Medium   string    `json:"medium"`
+	Campaign string    `json:"campaign"`
+	Term     string    `json:"term"`
+	Content  string    `json:"content"`
 }
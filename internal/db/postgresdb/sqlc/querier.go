@@ -6,23 +6,70 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Querier interface {
+	AddUserURLsTags(ctx context.Context, arg AddUserURLsTagsParams) error
+	ClaimOwnedURLs(ctx context.Context, arg ClaimOwnedURLsParams) error
+	ClaimUserLinks(ctx context.Context, arg ClaimUserLinksParams) (int64, error)
+	ClaimUserURLsTags(ctx context.Context, arg ClaimUserURLsTagsParams) error
+	ConsumeRefreshToken(ctx context.Context, tokenHash string) (ConsumeRefreshTokenRow, error)
+	ConsumeURLClick(ctx context.Context, short string) (int32, error)
 	CreateUser(ctx context.Context) (uuid.UUID, error)
+	DeleteRefreshToken(ctx context.Context, tokenHash string) error
+	DisableURL(ctx context.Context, short string) (int64, error)
+	DismissURLReports(ctx context.Context, short string) (int64, error)
+	FilterUserLiveShorts(ctx context.Context, arg FilterUserLiveShortsParams) ([]string, error)
 	FindFullByShort(ctx context.Context, short string) (FindFullByShortRow, error)
-	FindShortByFull(ctx context.Context, originalUrl string) (string, error)
-	FindShortsByFulls(ctx context.Context, originalUrls []string) ([]FindShortsByFullsRow, error)
-	GetUserByID(ctx context.Context, userID uuid.UUID) (uuid.UUID, error)
-	GetUserUrls(ctx context.Context, userID uuid.UUID) ([]GetUserUrlsRow, error)
+	FindRedirectByShort(ctx context.Context, short string) (FindRedirectByShortRow, error)
+	FindShortByFull(ctx context.Context, arg FindShortByFullParams) (string, error)
+	FindShortsByFulls(ctx context.Context, arg FindShortsByFullsParams) ([]FindShortsByFullsRow, error)
+	GetAbuseReportsQueue(ctx context.Context) ([]GetAbuseReportsQueueRow, error)
+	GetRefreshTokenUserID(ctx context.Context, tokenHash string) (uuid.UUID, error)
+	GetUserByEmail(ctx context.Context, email string) (GetUserByEmailRow, error)
+	GetUserByID(ctx context.Context, userID uuid.UUID) (GetUserByIDRow, error)
+	GetUserLinkForUpdate(ctx context.Context, arg GetUserLinkForUpdateParams) (GetUserLinkForUpdateRow, error)
+	GetUserTags(ctx context.Context, userID uuid.UUID) ([]GetUserTagsRow, error)
+	GetUserURLDestinations(ctx context.Context, arg GetUserURLDestinationsParams) (json.RawMessage, error)
+	GetUserURLRedirectRules(ctx context.Context, arg GetUserURLRedirectRulesParams) (json.RawMessage, error)
+	GetUserUrls(ctx context.Context, arg GetUserUrlsParams) ([]GetUserUrlsRow, error)
+	GetUserUTMDefaults(ctx context.Context, userID uuid.UUID) (GetUserUTMDefaultsRow, error)
 	InsertURLMapping(ctx context.Context, arg InsertURLMappingParams) error
 	IsShortExists(ctx context.Context, short string) (bool, error)
+	IsTokenRevoked(ctx context.Context, tokenID uuid.UUID) (bool, error)
+	LockAnonymousUser(ctx context.Context, userID uuid.UUID) (uuid.UUID, error)
+	PurgeDeletedURLs(ctx context.Context, deletedBefore sql.NullTime) (int64, error)
+	PurgeDeletedUserLinks(ctx context.Context, deletedBefore sql.NullTime) (int64, error)
+	PurgeExpiredRefreshTokens(ctx context.Context, expiredBefore time.Time) (int64, error)
+	PurgeExpiredRevokedTokens(ctx context.Context, expiredBefore time.Time) (int64, error)
+	RegisterUser(ctx context.Context, arg RegisterUserParams) (RegisterUserRow, error)
+	RemoveAllUserLinks(ctx context.Context, userID uuid.UUID) error
+	RemoveUnlinkedURL(ctx context.Context, shortUrl string) error
+	RemoveUserLink(ctx context.Context, arg RemoveUserLinkParams) (int64, error)
 	RemoveUsersUrls(ctx context.Context, arg RemoveUsersUrlsParams) error
+	RemoveUserURLsTags(ctx context.Context, arg RemoveUserURLsTagsParams) error
+	ReportURL(ctx context.Context, arg ReportURLParams) (bool, error)
 	ResetDB(ctx context.Context) error
+	RestoreLinkedURL(ctx context.Context, shortUrl string) error
+	RestoreUserLink(ctx context.Context, arg RestoreUserLinkParams) (int64, error)
+	RestoreUsersUrl(ctx context.Context, arg RestoreUsersUrlParams) (int64, error)
+	RetargetURL(ctx context.Context, arg RetargetURLParams) error
+	RevokeToken(ctx context.Context, arg RevokeTokenParams) error
+	SaveRefreshToken(ctx context.Context, arg SaveRefreshTokenParams) error
 	SaveURLMapping(ctx context.Context, arg SaveURLMappingParams) error
+	SaveURLRedirectHistory(ctx context.Context, arg SaveURLRedirectHistoryParams) error
 	SaveUserUrl(ctx context.Context, arg SaveUserUrlParams) error
+	SearchURLs(ctx context.Context, arg SearchURLsParams) ([]SearchURLsRow, error)
+	SetURLActiveFrom(ctx context.Context, arg SetURLActiveFromParams) error
+	SetURLDestinations(ctx context.Context, arg SetURLDestinationsParams) error
+	SetURLRedirectRules(ctx context.Context, arg SetURLRedirectRulesParams) error
+	SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error)
+	SetUserUTMDefaults(ctx context.Context, arg SetUserUTMDefaultsParams) error
 }
 
 var _ Querier = (*Queries)(nil)
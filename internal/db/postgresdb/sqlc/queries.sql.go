@@ -7,11 +7,133 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 )
 
+const addUserURLsTags = `-- name: AddUserURLsTags :exec
+INSERT INTO users_urls_tags (user_id, short, tag)
+    SELECT $1::uuid, shorts.short, tags.tag
+        FROM unnest($2::text[]) AS shorts (short)
+            CROSS JOIN unnest($3::text[]) AS tags (tag)
+    ON CONFLICT DO NOTHING
+`
+
+type AddUserURLsTagsParams struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ShortUrls []string  `json:"short_urls"`
+	Tags      []string  `json:"tags"`
+}
+
+func (q *Queries) AddUserURLsTags(ctx context.Context, arg AddUserURLsTagsParams) error {
+	_, err := q.db.ExecContext(ctx, addUserURLsTags, arg.UserID, pq.Array(arg.ShortUrls), pq.Array(arg.Tags))
+	return err
+}
+
+const claimOwnedURLs = `-- name: ClaimOwnedURLs :exec
+UPDATE url_redirects
+    SET owner_id = $1::uuid
+    WHERE owner_id = $2
+        AND (
+            url_redirects.dedicated
+            OR NOT EXISTS (
+                SELECT 1
+                    FROM url_redirects AS owned
+                    WHERE owned.owner_id = $1::uuid
+                        AND NOT owned.dedicated
+                        AND owned.original_url_hash = url_redirects.original_url_hash
+            )
+        )
+`
+
+type ClaimOwnedURLsParams struct {
+	UserID          uuid.UUID `json:"user_id"`
+	AnonymousUserID uuid.UUID `json:"anonymous_user_id"`
+}
+
+func (q *Queries) ClaimOwnedURLs(ctx context.Context, arg ClaimOwnedURLsParams) error {
+	_, err := q.db.ExecContext(ctx, claimOwnedURLs, arg.UserID, arg.AnonymousUserID)
+	return err
+}
+
+const claimUserLinks = `-- name: ClaimUserLinks :execrows
+INSERT INTO users_urls (user_id, short, is_deleted, deleted_at)
+    SELECT $1::uuid, users_urls.short, users_urls.is_deleted, users_urls.deleted_at
+        FROM users_urls
+        WHERE users_urls.user_id = $2
+    ON CONFLICT (user_id, short) DO NOTHING
+`
+
+type ClaimUserLinksParams struct {
+	UserID          uuid.UUID `json:"user_id"`
+	AnonymousUserID uuid.UUID `json:"anonymous_user_id"`
+}
+
+func (q *Queries) ClaimUserLinks(ctx context.Context, arg ClaimUserLinksParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimUserLinks, arg.UserID, arg.AnonymousUserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimUserURLsTags = `-- name: ClaimUserURLsTags :exec
+INSERT INTO users_urls_tags (user_id, short, tag)
+    SELECT $1::uuid, users_urls_tags.short, users_urls_tags.tag
+        FROM users_urls_tags
+        WHERE users_urls_tags.user_id = $2
+    ON CONFLICT DO NOTHING
+`
+
+type ClaimUserURLsTagsParams struct {
+	UserID          uuid.UUID `json:"user_id"`
+	AnonymousUserID uuid.UUID `json:"anonymous_user_id"`
+}
+
+func (q *Queries) ClaimUserURLsTags(ctx context.Context, arg ClaimUserURLsTagsParams) error {
+	_, err := q.db.ExecContext(ctx, claimUserURLsTags, arg.UserID, arg.AnonymousUserID)
+	return err
+}
+
+const consumeRefreshToken = `-- name: ConsumeRefreshToken :one
+DELETE FROM refresh_tokens
+    WHERE token_hash = $1
+    RETURNING user_id, expires_at
+`
+
+type ConsumeRefreshTokenRow struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) ConsumeRefreshToken(ctx context.Context, tokenHash string) (ConsumeRefreshTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, consumeRefreshToken, tokenHash)
+	var i ConsumeRefreshTokenRow
+	err := row.Scan(&i.UserID, &i.ExpiresAt)
+	return i, err
+}
+
+const consumeURLClick = `-- name: ConsumeURLClick :one
+UPDATE url_redirects
+    SET clicks_left = clicks_left - 1
+    WHERE short = $1
+        AND max_clicks > 0
+        AND clicks_left > 0
+        AND NOT is_deleted
+    RETURNING clicks_left
+`
+
+func (q *Queries) ConsumeURLClick(ctx context.Context, short string) (int32, error) {
+	row := q.db.QueryRowContext(ctx, consumeURLClick, short)
+	var clicks_left int32
+	err := row.Scan(&clicks_left)
+	return clicks_left, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users DEFAULT VALUES
     RETURNING user_id
@@ -24,6 +146,90 @@ func (q *Queries) CreateUser(ctx context.Context) (uuid.UUID, error) {
 	return user_id, err
 }
 
+const deleteRefreshToken = `-- name: DeleteRefreshToken :exec
+DELETE FROM refresh_tokens
+    WHERE token_hash = $1
+`
+
+func (q *Queries) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, deleteRefreshToken, tokenHash)
+	return err
+}
+
+const disableURL = `-- name: DisableURL :execrows
+WITH actioned AS (
+    UPDATE abuse_reports
+        SET status = 'actioned'
+        WHERE abuse_reports.short = $1
+            AND abuse_reports.status = 'pending'
+)
+UPDATE url_redirects
+    SET disabled_at = COALESCE(url_redirects.disabled_at, now())
+    WHERE url_redirects.short = $1
+`
+
+func (q *Queries) DisableURL(ctx context.Context, short string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, disableURL, short)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const dismissURLReports = `-- name: DismissURLReports :execrows
+UPDATE abuse_reports
+    SET status = 'dismissed'
+    WHERE short = $1
+        AND status = 'pending'
+`
+
+func (q *Queries) DismissURLReports(ctx context.Context, short string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, dismissURLReports, short)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const filterUserLiveShorts = `-- name: FilterUserLiveShorts :many
+SELECT users_urls.short
+    FROM users_urls
+        JOIN url_redirects ON
+            url_redirects.short = users_urls.short
+                AND NOT url_redirects.is_deleted
+    WHERE users_urls.user_id = $1
+        AND users_urls.short = ANY($2::text[])
+        AND NOT users_urls.is_deleted
+`
+
+type FilterUserLiveShortsParams struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ShortUrls []string  `json:"short_urls"`
+}
+
+func (q *Queries) FilterUserLiveShorts(ctx context.Context, arg FilterUserLiveShortsParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, filterUserLiveShorts, arg.UserID, pq.Array(arg.ShortUrls))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var short string
+		if err := rows.Scan(&short); err != nil {
+			return nil, err
+		}
+		items = append(items, short)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const findFullByShort = `-- name: FindFullByShort :one
 SELECT original_url, is_deleted
     FROM url_redirects
@@ -42,32 +248,138 @@ func (q *Queries) FindFullByShort(ctx context.Context, short string) (FindFullBy
 	return i, err
 }
 
+const findRedirectByShort = `-- name: FindRedirectByShort :one
+SELECT
+    original_url,
+    is_deleted,
+    created_by,
+    title,
+    description,
+    password_hash,
+    max_clicks,
+    clicks_left,
+    active_from,
+    redirect_code,
+    passthrough,
+    redirect_rules,
+    destinations,
+    interstitial,
+    disabled_at,
+    created_at
+    FROM url_redirects
+    WHERE short = $1
+`
+
+type FindRedirectByShortRow struct {
+	OriginalUrl   string          `json:"original_url"`
+	IsDeleted     bool            `json:"is_deleted"`
+	CreatedBy     uuid.NullUUID   `json:"created_by"`
+	Title         string          `json:"title"`
+	Description   string          `json:"description"`
+	PasswordHash  string          `json:"password_hash"`
+	MaxClicks     int32           `json:"max_clicks"`
+	ClicksLeft    int32           `json:"clicks_left"`
+	ActiveFrom    sql.NullTime    `json:"active_from"`
+	RedirectCode  int16           `json:"redirect_code"`
+	Passthrough   string          `json:"passthrough"`
+	RedirectRules json.RawMessage `json:"redirect_rules"`
+	Destinations  json.RawMessage `json:"destinations"`
+	Interstitial  bool            `json:"interstitial"`
+	DisabledAt    sql.NullTime    `json:"disabled_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+func (q *Queries) FindRedirectByShort(ctx context.Context, short string) (FindRedirectByShortRow, error) {
+	row := q.db.QueryRowContext(ctx, findRedirectByShort, short)
+	var i FindRedirectByShortRow
+	err := row.Scan(
+		&i.OriginalUrl,
+		&i.IsDeleted,
+		&i.CreatedBy,
+		&i.Title,
+		&i.Description,
+		&i.PasswordHash,
+		&i.MaxClicks,
+		&i.ClicksLeft,
+		&i.ActiveFrom,
+		&i.RedirectCode,
+		&i.Passthrough,
+		&i.RedirectRules,
+		&i.Destinations,
+		&i.Interstitial,
+		&i.DisabledAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const findShortByFull = `-- name: FindShortByFull :one
-SELECT short
+SELECT url_redirects.short
     FROM url_redirects
-    WHERE original_url = $1
+    WHERE NOT url_redirects.dedicated
+        AND url_redirects.original_url_hash = $1
+        AND CASE
+            WHEN $2::uuid IS NULL THEN url_redirects.owner_id IS NULL
+            ELSE url_redirects.owner_id = $2::uuid
+                OR (
+                    url_redirects.owner_id IS NULL
+                    AND EXISTS (
+                        SELECT 1
+                            FROM users_urls
+                            WHERE users_urls.short = url_redirects.short
+                                AND users_urls.user_id = $2::uuid
+                    )
+                )
+        END
+    ORDER BY url_redirects.owner_id NULLS LAST
+    LIMIT 1
 `
 
-func (q *Queries) FindShortByFull(ctx context.Context, originalUrl string) (string, error) {
-	row := q.db.QueryRowContext(ctx, findShortByFull, originalUrl)
+type FindShortByFullParams struct {
+	OriginalUrlHash string        `json:"original_url_hash"`
+	OwnerID         uuid.NullUUID `json:"owner_id"`
+}
+
+func (q *Queries) FindShortByFull(ctx context.Context, arg FindShortByFullParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, findShortByFull, arg.OriginalUrlHash, arg.OwnerID)
 	var short string
 	err := row.Scan(&short)
 	return short, err
 }
 
 const findShortsByFulls = `-- name: FindShortsByFulls :many
-SELECT short, original_url
+SELECT DISTINCT ON (url_redirects.original_url_hash) url_redirects.short, url_redirects.original_url
     FROM url_redirects
-    WHERE original_url = ANY($1::text[])
+    WHERE NOT url_redirects.dedicated
+        AND url_redirects.original_url_hash = ANY($1::text[])
+        AND CASE
+            WHEN $2::uuid IS NULL THEN url_redirects.owner_id IS NULL
+            ELSE url_redirects.owner_id = $2::uuid
+                OR (
+                    url_redirects.owner_id IS NULL
+                    AND EXISTS (
+                        SELECT 1
+                            FROM users_urls
+                            WHERE users_urls.short = url_redirects.short
+                                AND users_urls.user_id = $2::uuid
+                    )
+                )
+        END
+    ORDER BY url_redirects.original_url_hash, url_redirects.owner_id NULLS LAST
 `
 
+type FindShortsByFullsParams struct {
+	OriginalUrlHashes []string      `json:"original_url_hashes"`
+	OwnerID           uuid.NullUUID `json:"owner_id"`
+}
+
 type FindShortsByFullsRow struct {
 	Short       string `json:"short"`
 	OriginalUrl string `json:"original_url"`
 }
 
-func (q *Queries) FindShortsByFulls(ctx context.Context, originalUrls []string) ([]FindShortsByFullsRow, error) {
-	rows, err := q.db.QueryContext(ctx, findShortsByFulls, pq.Array(originalUrls))
+func (q *Queries) FindShortsByFulls(ctx context.Context, arg FindShortsByFullsParams) ([]FindShortsByFullsRow, error) {
+	rows, err := q.db.QueryContext(ctx, findShortsByFulls, pq.Array(arg.OriginalUrlHashes), arg.OwnerID)
 	if err != nil {
 		return nil, err
 	}
@@ -89,35 +401,345 @@ func (q *Queries) FindShortsByFulls(ctx context.Context, originalUrls []string)
 	return items, nil
 }
 
-const getUserByID = `-- name: GetUserByID :one
+const getAbuseReportsQueue = `-- name: GetAbuseReportsQueue :many
+SELECT
+    abuse_reports.short,
+    url_redirects.original_url,
+    COUNT(*) AS reports_count,
+    MIN(abuse_reports.created_at)::timestamptz AS first_reported_at,
+    MAX(abuse_reports.created_at)::timestamptz AS last_reported_at,
+    ARRAY_REMOVE(ARRAY_AGG(DISTINCT abuse_reports.reason), '')::text[] AS reasons
+    FROM abuse_reports
+        JOIN url_redirects ON url_redirects.short = abuse_reports.short
+    WHERE abuse_reports.status = 'pending'
+    GROUP BY abuse_reports.short, url_redirects.original_url
+    ORDER BY reports_count DESC, first_reported_at, abuse_reports.short
+`
+
+type GetAbuseReportsQueueRow struct {
+	Short           string    `json:"short"`
+	OriginalUrl     string    `json:"original_url"`
+	ReportsCount    int64     `json:"reports_count"`
+	FirstReportedAt time.Time `json:"first_reported_at"`
+	LastReportedAt  time.Time `json:"last_reported_at"`
+	Reasons         []string  `json:"reasons"`
+}
+
+func (q *Queries) GetAbuseReportsQueue(ctx context.Context) ([]GetAbuseReportsQueueRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAbuseReportsQueue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetAbuseReportsQueueRow{}
+	for rows.Next() {
+		var i GetAbuseReportsQueueRow
+		if err := rows.Scan(
+			&i.Short,
+			&i.OriginalUrl,
+			&i.ReportsCount,
+			&i.FirstReportedAt,
+			&i.LastReportedAt,
+			pq.Array(&i.Reasons),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRefreshTokenUserID = `-- name: GetRefreshTokenUserID :one
 SELECT user_id
-    FROM users
-    WHERE user_id = $1
+    FROM refresh_tokens
+    WHERE token_hash = $1
+        AND expires_at > now()
 `
 
-func (q *Queries) GetUserByID(ctx context.Context, userID uuid.UUID) (uuid.UUID, error) {
-	row := q.db.QueryRowContext(ctx, getUserByID, userID)
+func (q *Queries) GetRefreshTokenUserID(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenUserID, tokenHash)
 	var user_id uuid.UUID
 	err := row.Scan(&user_id)
 	return user_id, err
 }
 
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT user_id, role, email, password_hash
+    FROM users
+    WHERE email = $1::text
+`
+
+type GetUserByEmailRow struct {
+	UserID       uuid.UUID      `json:"user_id"`
+	Role         string         `json:"role"`
+	Email        sql.NullString `json:"email"`
+	PasswordHash sql.NullString `json:"password_hash"`
+}
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (GetUserByEmailRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i GetUserByEmailRow
+	err := row.Scan(
+		&i.UserID,
+		&i.Role,
+		&i.Email,
+		&i.PasswordHash,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT user_id, role, email
+    FROM users
+    WHERE user_id = $1
+`
+
+type GetUserByIDRow struct {
+	UserID uuid.UUID      `json:"user_id"`
+	Role   string         `json:"role"`
+	Email  sql.NullString `json:"email"`
+}
+
+func (q *Queries) GetUserByID(ctx context.Context, userID uuid.UUID) (GetUserByIDRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, userID)
+	var i GetUserByIDRow
+	err := row.Scan(&i.UserID, &i.Role, &i.Email)
+	return i, err
+}
+
+const getUserLinkForUpdate = `-- name: GetUserLinkForUpdate :one
+SELECT
+    url_redirects.original_url,
+    url_redirects.owner_id,
+    (
+        SELECT COUNT(*)
+            FROM users_urls AS others
+            WHERE others.short = url_redirects.short
+                AND others.user_id <> $1
+                AND NOT others.is_deleted
+    ) AS other_users_count
+    FROM url_redirects
+        JOIN users_urls ON
+            users_urls.short = url_redirects.short
+                AND users_urls.user_id = $1
+                AND NOT users_urls.is_deleted
+    WHERE url_redirects.short = $2
+        AND NOT url_redirects.is_deleted
+    FOR UPDATE OF url_redirects
+`
+
+type GetUserLinkForUpdateParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Short  string    `json:"short"`
+}
+
+type GetUserLinkForUpdateRow struct {
+	OriginalUrl     string        `json:"original_url"`
+	OwnerID         uuid.NullUUID `json:"owner_id"`
+	OtherUsersCount int64         `json:"other_users_count"`
+}
+
+func (q *Queries) GetUserLinkForUpdate(ctx context.Context, arg GetUserLinkForUpdateParams) (GetUserLinkForUpdateRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserLinkForUpdate, arg.UserID, arg.Short)
+	var i GetUserLinkForUpdateRow
+	err := row.Scan(&i.OriginalUrl, &i.OwnerID, &i.OtherUsersCount)
+	return i, err
+}
+
+const getUserTags = `-- name: GetUserTags :many
+SELECT users_urls_tags.tag, COUNT(*) AS urls_count
+    FROM users_urls_tags
+        JOIN users_urls ON
+            users_urls.user_id = users_urls_tags.user_id
+                AND users_urls.short = users_urls_tags.short
+                AND NOT users_urls.is_deleted
+        JOIN url_redirects ON
+            url_redirects.short = users_urls_tags.short
+                AND NOT url_redirects.is_deleted
+    WHERE users_urls_tags.user_id = $1
+    GROUP BY users_urls_tags.tag
+    ORDER BY users_urls_tags.tag
+`
+
+type GetUserTagsRow struct {
+	Tag       string `json:"tag"`
+	UrlsCount int64  `json:"urls_count"`
+}
+
+func (q *Queries) GetUserTags(ctx context.Context, userID uuid.UUID) ([]GetUserTagsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUserTags, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetUserTagsRow{}
+	for rows.Next() {
+		var i GetUserTagsRow
+		if err := rows.Scan(&i.Tag, &i.UrlsCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserURLDestinations = `-- name: GetUserURLDestinations :one
+SELECT url_redirects.destinations
+    FROM users_urls
+        JOIN url_redirects ON
+            url_redirects.short = users_urls.short
+                AND NOT url_redirects.is_deleted
+    WHERE users_urls.user_id = $1
+        AND users_urls.short = $2
+        AND NOT users_urls.is_deleted
+`
+
+type GetUserURLDestinationsParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Short  string    `json:"short"`
+}
+
+func (q *Queries) GetUserURLDestinations(ctx context.Context, arg GetUserURLDestinationsParams) (json.RawMessage, error) {
+	row := q.db.QueryRowContext(ctx, getUserURLDestinations, arg.UserID, arg.Short)
+	var destinations json.RawMessage
+	err := row.Scan(&destinations)
+	return destinations, err
+}
+
+const getUserURLRedirectRules = `-- name: GetUserURLRedirectRules :one
+SELECT url_redirects.redirect_rules
+    FROM users_urls
+        JOIN url_redirects ON
+            url_redirects.short = users_urls.short
+                AND NOT url_redirects.is_deleted
+    WHERE users_urls.user_id = $1
+        AND users_urls.short = $2
+        AND NOT users_urls.is_deleted
+`
+
+type GetUserURLRedirectRulesParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Short  string    `json:"short"`
+}
+
+func (q *Queries) GetUserURLRedirectRules(ctx context.Context, arg GetUserURLRedirectRulesParams) (json.RawMessage, error) {
+	row := q.db.QueryRowContext(ctx, getUserURLRedirectRules, arg.UserID, arg.Short)
+	var redirect_rules json.RawMessage
+	err := row.Scan(&redirect_rules)
+	return redirect_rules, err
+}
+
 const getUserUrls = `-- name: GetUserUrls :many
-SELECT url_redirects.original_url, url_redirects.short
+SELECT
+    url_redirects.original_url,
+    url_redirects.short,
+    url_redirects.created_at,
+    url_redirects.updated_at,
+    url_redirects.title,
+    url_redirects.description,
+    url_redirects.active_from,
+    ARRAY(
+        SELECT users_urls_tags.tag
+            FROM users_urls_tags
+            WHERE users_urls_tags.user_id = users_urls.user_id
+                AND users_urls_tags.short = users_urls.short
+            ORDER BY users_urls_tags.tag
+    )::text[] AS tags
     FROM url_redirects
         JOIN users_urls ON
-            users_urls.url = url_redirects.original_url
+            users_urls.short = url_redirects.short
                 AND users_urls.user_id = $1
+                AND NOT users_urls.is_deleted
                 AND NOT url_redirects.is_deleted
+    WHERE strpos(lower(url_redirects.original_url), lower($2::text)) > 0
+        AND (
+            $3::text = ''
+            OR EXISTS (
+                SELECT 1
+                    FROM users_urls_tags
+                    WHERE users_urls_tags.user_id = users_urls.user_id
+                        AND users_urls_tags.short = users_urls.short
+                        AND users_urls_tags.tag = $3::text
+            )
+        )
+        AND (
+            $4::text = ''
+            OR CASE
+                WHEN $5::text = 'original_url' AND $6::bool THEN
+                    (url_redirects.original_url, url_redirects.short)
+                        < ($7::text, $4::text)
+                WHEN $5::text = 'original_url' THEN
+                    (url_redirects.original_url, url_redirects.short)
+                        > ($7::text, $4::text)
+                WHEN $6::bool THEN
+                    (url_redirects.created_at, url_redirects.short)
+                        < ($8::timestamptz, $4::text)
+                ELSE
+                    (url_redirects.created_at, url_redirects.short)
+                        > ($8::timestamptz, $4::text)
+            END
+        )
+    ORDER BY
+        CASE WHEN $5::text = 'original_url' AND NOT $6::bool
+            THEN url_redirects.original_url END,
+        CASE WHEN $5::text = 'original_url' AND $6::bool
+            THEN url_redirects.original_url END DESC,
+        CASE WHEN $5::text <> 'original_url' AND NOT $6::bool
+            THEN url_redirects.created_at END,
+        CASE WHEN $5::text <> 'original_url' AND $6::bool
+            THEN url_redirects.created_at END DESC,
+        CASE WHEN NOT $6::bool THEN url_redirects.short END,
+        CASE WHEN $6::bool THEN url_redirects.short END DESC
+    LIMIT $9::int
 `
 
+type GetUserUrlsParams struct {
+	UserID           uuid.UUID     `json:"user_id"`
+	Search           string        `json:"search"`
+	Tag              string        `json:"tag"`
+	AfterShort       string        `json:"after_short"`
+	SortBy           string        `json:"sort_by"`
+	Descending       bool          `json:"descending"`
+	AfterOriginalUrl string        `json:"after_original_url"`
+	AfterCreatedAt   time.Time     `json:"after_created_at"`
+	PageSize         sql.NullInt32 `json:"page_size"`
+}
+
 type GetUserUrlsRow struct {
-	OriginalUrl string `json:"original_url"`
-	Short       string `json:"short"`
+	OriginalUrl string       `json:"original_url"`
+	Short       string       `json:"short"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	ActiveFrom  sql.NullTime `json:"active_from"`
+	Tags        []string     `json:"tags"`
 }
 
-func (q *Queries) GetUserUrls(ctx context.Context, userID uuid.UUID) ([]GetUserUrlsRow, error) {
-	rows, err := q.db.QueryContext(ctx, getUserUrls, userID)
+func (q *Queries) GetUserUrls(ctx context.Context, arg GetUserUrlsParams) ([]GetUserUrlsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUserUrls,
+		arg.UserID,
+		arg.Search,
+		arg.Tag,
+		arg.AfterShort,
+		arg.SortBy,
+		arg.Descending,
+		arg.AfterOriginalUrl,
+		arg.AfterCreatedAt,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -125,7 +747,16 @@ func (q *Queries) GetUserUrls(ctx context.Context, userID uuid.UUID) ([]GetUserU
 	items := []GetUserUrlsRow{}
 	for rows.Next() {
 		var i GetUserUrlsRow
-		if err := rows.Scan(&i.OriginalUrl, &i.Short); err != nil {
+		if err := rows.Scan(
+			&i.OriginalUrl,
+			&i.Short,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Title,
+			&i.Description,
+			&i.ActiveFrom,
+			pq.Array(&i.Tags),
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -139,18 +770,105 @@ func (q *Queries) GetUserUrls(ctx context.Context, userID uuid.UUID) ([]GetUserU
 	return items, nil
 }
 
+const getUserUTMDefaults = `-- name: GetUserUTMDefaults :one
+SELECT source, medium, campaign, term, content
+    FROM users_utm_defaults
+    WHERE user_id = $1
+`
+
+type GetUserUTMDefaultsRow struct {
+	Source   string `json:"source"`
+	Medium   string `json:"medium"`
+	Campaign string `json:"campaign"`
+	Term     string `json:"term"`
+	Content  string `json:"content"`
+}
+
+func (q *Queries) GetUserUTMDefaults(ctx context.Context, userID uuid.UUID) (GetUserUTMDefaultsRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserUTMDefaults, userID)
+	var i GetUserUTMDefaultsRow
+	err := row.Scan(
+		&i.Source,
+		&i.Medium,
+		&i.Campaign,
+		&i.Term,
+		&i.Content,
+	)
+	return i, err
+}
+
 const insertURLMapping = `-- name: InsertURLMapping :exec
-INSERT INTO url_redirects (short, original_url)
-    VALUES ($1, $2)
+INSERT INTO url_redirects (
+    short,
+    original_url,
+    original_url_hash,
+    owner_id,
+    created_by,
+    title,
+    description,
+    password_hash,
+    max_clicks,
+    clicks_left,
+    active_from,
+    redirect_code,
+    passthrough,
+    interstitial,
+    dedicated
+)
+    VALUES (
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7,
+        $8,
+        $9,
+        $9,
+        $10,
+        $11,
+        $12,
+        $13,
+        $14
+    )
 `
 
 type InsertURLMappingParams struct {
-	Short       string `json:"short"`
-	OriginalUrl string `json:"original_url"`
+	Short           string        `json:"short"`
+	OriginalUrl     string        `json:"original_url"`
+	OriginalUrlHash string        `json:"original_url_hash"`
+	OwnerID         uuid.NullUUID `json:"owner_id"`
+	CreatedBy       uuid.NullUUID `json:"created_by"`
+	Title           string        `json:"title"`
+	Description     string        `json:"description"`
+	PasswordHash    string        `json:"password_hash"`
+	MaxClicks       int32         `json:"max_clicks"`
+	ActiveFrom      sql.NullTime  `json:"active_from"`
+	RedirectCode    int16         `json:"redirect_code"`
+	Passthrough     string        `json:"passthrough"`
+	Interstitial    bool          `json:"interstitial"`
+	Dedicated       bool          `json:"dedicated"`
 }
 
 func (q *Queries) InsertURLMapping(ctx context.Context, arg InsertURLMappingParams) error {
-	_, err := q.db.ExecContext(ctx, insertURLMapping, arg.Short, arg.OriginalUrl)
+	_, err := q.db.ExecContext(ctx,
+		insertURLMapping,
+		arg.Short,
+		arg.OriginalUrl,
+		arg.OriginalUrlHash,
+		arg.OwnerID,
+		arg.CreatedBy,
+		arg.Title,
+		arg.Description,
+		arg.PasswordHash,
+		arg.MaxClicks,
+		arg.ActiveFrom,
+		arg.RedirectCode,
+		arg.Passthrough,
+		arg.Interstitial,
+		arg.Dedicated,
+	)
 	return err
 }
 
@@ -167,13 +885,185 @@ func (q *Queries) IsShortExists(ctx context.Context, short string) (bool, error)
 	return exists, err
 }
 
+const isTokenRevoked = `-- name: IsTokenRevoked :one
+SELECT EXISTS (
+    SELECT 1
+        FROM revoked_tokens
+        WHERE token_id = $1
+)
+`
+
+func (q *Queries) IsTokenRevoked(ctx context.Context, tokenID uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isTokenRevoked, tokenID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const lockAnonymousUser = `-- name: LockAnonymousUser :one
+SELECT user_id
+    FROM users
+    WHERE user_id = $1
+        AND email IS NULL
+    FOR UPDATE
+`
+
+func (q *Queries) LockAnonymousUser(ctx context.Context, userID uuid.UUID) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, lockAnonymousUser, userID)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const purgeDeletedURLs = `-- name: PurgeDeletedURLs :execrows
+DELETE FROM url_redirects
+    WHERE is_deleted
+        AND deleted_at < $1
+`
+
+func (q *Queries) PurgeDeletedURLs(ctx context.Context, deletedBefore sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedURLs, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedUserLinks = `-- name: PurgeDeletedUserLinks :execrows
+DELETE FROM users_urls
+    WHERE is_deleted
+        AND deleted_at < $1
+`
+
+func (q *Queries) PurgeDeletedUserLinks(ctx context.Context, deletedBefore sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedUserLinks, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeExpiredRefreshTokens = `-- name: PurgeExpiredRefreshTokens :execrows
+DELETE FROM refresh_tokens
+    WHERE expires_at < $1
+`
+
+func (q *Queries) PurgeExpiredRefreshTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeExpiredRefreshTokens, expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeExpiredRevokedTokens = `-- name: PurgeExpiredRevokedTokens :execrows
+DELETE FROM revoked_tokens
+    WHERE expires_at < $1
+`
+
+func (q *Queries) PurgeExpiredRevokedTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeExpiredRevokedTokens, expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const registerUser = `-- name: RegisterUser :one
+WITH registered AS (
+    UPDATE users
+        SET email = $1::text,
+            password_hash = $2::text
+        WHERE user_id = $3
+            AND email IS NULL
+        RETURNING user_id
+)
+SELECT
+    EXISTS (SELECT 1 FROM registered)::bool AS registered,
+    EXISTS (SELECT 1 FROM users WHERE user_id = $3)::bool AS user_exists
+`
+
+type RegisterUserParams struct {
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"password_hash"`
+	UserID       uuid.UUID `json:"user_id"`
+}
+
+type RegisterUserRow struct {
+	Registered bool `json:"registered"`
+	UserExists bool `json:"user_exists"`
+}
+
+func (q *Queries) RegisterUser(ctx context.Context, arg RegisterUserParams) (RegisterUserRow, error) {
+	row := q.db.QueryRowContext(ctx, registerUser, arg.Email, arg.PasswordHash, arg.UserID)
+	var i RegisterUserRow
+	err := row.Scan(&i.Registered, &i.UserExists)
+	return i, err
+}
+
+const removeAllUserLinks = `-- name: RemoveAllUserLinks :exec
+DELETE FROM users_urls
+    WHERE user_id = $1
+`
+
+func (q *Queries) RemoveAllUserLinks(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, removeAllUserLinks, userID)
+	return err
+}
+
+const removeUnlinkedURL = `-- name: RemoveUnlinkedURL :exec
+UPDATE url_redirects
+    SET
+        is_deleted = true,
+        deleted_at = now()
+    WHERE short = $1
+        AND NOT url_redirects.is_deleted
+        AND NOT EXISTS (
+            SELECT 1
+                FROM users_urls
+                WHERE users_urls.short = url_redirects.short
+                    AND NOT users_urls.is_deleted
+        )
+`
+
+func (q *Queries) RemoveUnlinkedURL(ctx context.Context, shortUrl string) error {
+	_, err := q.db.ExecContext(ctx, removeUnlinkedURL, shortUrl)
+	return err
+}
+
+const removeUserLink = `-- name: RemoveUserLink :execrows
+UPDATE users_urls
+    SET
+        is_deleted = true,
+        deleted_at = now()
+    WHERE user_id = $1
+        AND short = $2
+        AND NOT is_deleted
+`
+
+type RemoveUserLinkParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	ShortUrl string    `json:"short_url"`
+}
+
+func (q *Queries) RemoveUserLink(ctx context.Context, arg RemoveUserLinkParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removeUserLink, arg.UserID, arg.ShortUrl)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const removeUsersUrls = `-- name: RemoveUsersUrls :exec
 UPDATE url_redirects
-    SET is_deleted = true
+    SET
+        is_deleted = true,
+        deleted_at = now()
     FROM users_urls
-    WHERE url_redirects.original_url = users_urls.url
+    WHERE url_redirects.short = users_urls.short
         AND users_urls.user_id = $1
         AND url_redirects.short = $2
+        AND NOT url_redirects.is_deleted
 `
 
 type RemoveUsersUrlsParams struct {
@@ -186,6 +1076,53 @@ func (q *Queries) RemoveUsersUrls(ctx context.Context, arg RemoveUsersUrlsParams
 	return err
 }
 
+const removeUserURLsTags = `-- name: RemoveUserURLsTags :exec
+DELETE FROM users_urls_tags
+    WHERE user_id = $1
+        AND short = ANY($2::text[])
+        AND tag = ANY($3::text[])
+`
+
+type RemoveUserURLsTagsParams struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ShortUrls []string  `json:"short_urls"`
+	Tags      []string  `json:"tags"`
+}
+
+func (q *Queries) RemoveUserURLsTags(ctx context.Context, arg RemoveUserURLsTagsParams) error {
+	_, err := q.db.ExecContext(ctx, removeUserURLsTags, arg.UserID, pq.Array(arg.ShortUrls), pq.Array(arg.Tags))
+	return err
+}
+
+const reportURL = `-- name: ReportURL :one
+WITH reported AS (
+    SELECT url_redirects.short, url_redirects.disabled_at
+        FROM url_redirects
+        WHERE url_redirects.short = $1
+            AND NOT url_redirects.is_deleted
+), inserted AS (
+    INSERT INTO abuse_reports (short, reporter_id, reason)
+        SELECT reported.short, $2::uuid, $3::text
+            FROM reported
+            WHERE reported.disabled_at IS NULL
+        ON CONFLICT (short, reporter_id) WHERE status = 'pending' DO NOTHING
+)
+SELECT EXISTS (SELECT 1 FROM reported)
+`
+
+type ReportURLParams struct {
+	Short      string    `json:"short"`
+	ReporterID uuid.UUID `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+}
+
+func (q *Queries) ReportURL(ctx context.Context, arg ReportURLParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, reportURL, arg.Short, arg.ReporterID, arg.Reason)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const resetDB = `-- name: ResetDB :exec
 DO $$
 DECLARE
@@ -202,37 +1139,447 @@ func (q *Queries) ResetDB(ctx context.Context) error {
 	return err
 }
 
-const saveURLMapping = `-- name: SaveURLMapping :exec
-INSERT INTO url_redirects (short, original_url)
+const restoreLinkedURL = `-- name: RestoreLinkedURL :exec
+UPDATE url_redirects
+    SET
+        is_deleted = false,
+        deleted_at = NULL
+    WHERE short = $1
+        AND is_deleted
+`
+
+func (q *Queries) RestoreLinkedURL(ctx context.Context, shortUrl string) error {
+	_, err := q.db.ExecContext(ctx, restoreLinkedURL, shortUrl)
+	return err
+}
+
+const restoreUserLink = `-- name: RestoreUserLink :execrows
+UPDATE users_urls
+    SET
+        is_deleted = false,
+        deleted_at = NULL
+    WHERE user_id = $1
+        AND short = $2
+        AND is_deleted
+        AND deleted_at >= $3
+`
+
+type RestoreUserLinkParams struct {
+	UserID       uuid.UUID    `json:"user_id"`
+	ShortUrl     string       `json:"short_url"`
+	DeletedAfter sql.NullTime `json:"deleted_after"`
+}
+
+func (q *Queries) RestoreUserLink(ctx context.Context, arg RestoreUserLinkParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreUserLink, arg.UserID, arg.ShortUrl, arg.DeletedAfter)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreUsersUrl = `-- name: RestoreUsersUrl :execrows
+UPDATE url_redirects
+    SET
+        is_deleted = false,
+        deleted_at = NULL
+    FROM users_urls
+    WHERE url_redirects.short = users_urls.short
+        AND users_urls.user_id = $1
+        AND url_redirects.short = $2
+        AND url_redirects.is_deleted
+        AND url_redirects.deleted_at >= $3
+`
+
+type RestoreUsersUrlParams struct {
+	UserID       uuid.UUID    `json:"user_id"`
+	ShortUrl     string       `json:"short_url"`
+	DeletedAfter sql.NullTime `json:"deleted_after"`
+}
+
+func (q *Queries) RestoreUsersUrl(ctx context.Context, arg RestoreUsersUrlParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreUsersUrl, arg.UserID, arg.ShortUrl, arg.DeletedAfter)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const retargetURL = `-- name: RetargetURL :exec
+UPDATE url_redirects
+    SET
+        original_url = $1,
+        original_url_hash = $2,
+        updated_at = now()
+    WHERE short = $3
+`
+
+type RetargetURLParams struct {
+	OriginalUrl     string `json:"original_url"`
+	OriginalUrlHash string `json:"original_url_hash"`
+	Short           string `json:"short"`
+}
+
+func (q *Queries) RetargetURL(ctx context.Context, arg RetargetURLParams) error {
+	_, err := q.db.ExecContext(ctx, retargetURL, arg.OriginalUrl, arg.OriginalUrlHash, arg.Short)
+	return err
+}
+
+const revokeToken = `-- name: RevokeToken :exec
+INSERT INTO revoked_tokens (token_id, expires_at)
     VALUES ($1, $2)
+    ON CONFLICT (token_id) DO NOTHING
+`
+
+type RevokeTokenParams struct {
+	TokenID   uuid.UUID `json:"token_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) RevokeToken(ctx context.Context, arg RevokeTokenParams) error {
+	_, err := q.db.ExecContext(ctx, revokeToken, arg.TokenID, arg.ExpiresAt)
+	return err
+}
+
+const saveRefreshToken = `-- name: SaveRefreshToken :exec
+INSERT INTO refresh_tokens (token_hash, user_id, expires_at)
+    VALUES ($1, $2, $3)
+`
+
+type SaveRefreshTokenParams struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) SaveRefreshToken(ctx context.Context, arg SaveRefreshTokenParams) error {
+	_, err := q.db.ExecContext(ctx, saveRefreshToken, arg.TokenHash, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const saveURLMapping = `-- name: SaveURLMapping :exec
+INSERT INTO url_redirects (
+    short,
+    original_url,
+    original_url_hash,
+    owner_id,
+    created_by,
+    title,
+    description,
+    password_hash,
+    max_clicks,
+    clicks_left,
+    active_from,
+    redirect_code,
+    passthrough,
+    interstitial,
+    dedicated
+)
+    VALUES (
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7,
+        $8,
+        $9,
+        $9,
+        $10,
+        $11,
+        $12,
+        $13,
+        $14
+    )
     ON CONFLICT DO NOTHING
 `
 
 type SaveURLMappingParams struct {
-	Short       string `json:"short"`
-	OriginalUrl string `json:"original_url"`
+	Short           string        `json:"short"`
+	OriginalUrl     string        `json:"original_url"`
+	OriginalUrlHash string        `json:"original_url_hash"`
+	OwnerID         uuid.NullUUID `json:"owner_id"`
+	CreatedBy       uuid.NullUUID `json:"created_by"`
+	Title           string        `json:"title"`
+	Description     string        `json:"description"`
+	PasswordHash    string        `json:"password_hash"`
+	MaxClicks       int32         `json:"max_clicks"`
+	ActiveFrom      sql.NullTime  `json:"active_from"`
+	RedirectCode    int16         `json:"redirect_code"`
+	Passthrough     string        `json:"passthrough"`
+	Interstitial    bool          `json:"interstitial"`
+	Dedicated       bool          `json:"dedicated"`
 }
 
 func (q *Queries) SaveURLMapping(ctx context.Context, arg SaveURLMappingParams) error {
-	_, err := q.db.ExecContext(ctx, saveURLMapping, arg.Short, arg.OriginalUrl)
+	_, err := q.db.ExecContext(ctx,
+		saveURLMapping,
+		arg.Short,
+		arg.OriginalUrl,
+		arg.OriginalUrlHash,
+		arg.OwnerID,
+		arg.CreatedBy,
+		arg.Title,
+		arg.Description,
+		arg.PasswordHash,
+		arg.MaxClicks,
+		arg.ActiveFrom,
+		arg.RedirectCode,
+		arg.Passthrough,
+		arg.Interstitial,
+		arg.Dedicated,
+	)
+	return err
+}
+
+const saveURLRedirectHistory = `-- name: SaveURLRedirectHistory :exec
+INSERT INTO url_redirects_history (short, original_url, changed_by)
+    VALUES ($1, $2, $3)
+`
+
+type SaveURLRedirectHistoryParams struct {
+	Short       string        `json:"short"`
+	OriginalUrl string        `json:"original_url"`
+	ChangedBy   uuid.NullUUID `json:"changed_by"`
+}
+
+func (q *Queries) SaveURLRedirectHistory(ctx context.Context, arg SaveURLRedirectHistoryParams) error {
+	_, err := q.db.ExecContext(ctx, saveURLRedirectHistory, arg.Short, arg.OriginalUrl, arg.ChangedBy)
 	return err
 }
 
 const saveUserUrl = `-- name: SaveUserUrl :exec
-INSERT INTO users_urls (user_id, url)
+INSERT INTO users_urls (user_id, short)
     VALUES ($1, $2)
-    ON CONFLICT (user_id, url) DO UPDATE
+    ON CONFLICT (user_id, short) DO UPDATE
         SET
-            user_id = EXCLUDED.user_id,
-            url = EXCLUDED.url
+            is_deleted = false,
+            deleted_at = NULL
 `
 
 type SaveUserUrlParams struct {
 	UserID uuid.UUID `json:"user_id"`
-	Url    string    `json:"url"`
+	Short  string    `json:"short"`
 }
 
 func (q *Queries) SaveUserUrl(ctx context.Context, arg SaveUserUrlParams) error {
-	_, err := q.db.ExecContext(ctx, saveUserUrl, arg.UserID, arg.Url)
+	_, err := q.db.ExecContext(ctx, saveUserUrl, arg.UserID, arg.Short)
+	return err
+}
+
+const searchURLs = `-- name: SearchURLs :many
+SELECT
+    url_redirects.short,
+    url_redirects.original_url,
+    url_redirects.owner_id,
+    url_redirects.created_by,
+    url_redirects.created_at,
+    url_redirects.is_deleted,
+    url_redirects.disabled_at
+    FROM url_redirects
+    WHERE (
+            strpos(lower(url_redirects.original_url), lower($1::text)) > 0
+            OR strpos(lower(url_redirects.short), lower($1::text)) > 0
+        )
+        AND (
+            $2::text = ''
+            OR CASE
+                WHEN $3::text = 'original_url' AND $4::bool THEN
+                    (url_redirects.original_url, url_redirects.short)
+                        < ($5::text, $2::text)
+                WHEN $3::text = 'original_url' THEN
+                    (url_redirects.original_url, url_redirects.short)
+                        > ($5::text, $2::text)
+                WHEN $4::bool THEN
+                    (url_redirects.created_at, url_redirects.short)
+                        < ($6::timestamptz, $2::text)
+                ELSE
+                    (url_redirects.created_at, url_redirects.short)
+                        > ($6::timestamptz, $2::text)
+            END
+        )
+    ORDER BY
+        CASE WHEN $3::text = 'original_url' AND NOT $4::bool
+            THEN url_redirects.original_url END,
+        CASE WHEN $3::text = 'original_url' AND $4::bool
+            THEN url_redirects.original_url END DESC,
+        CASE WHEN $3::text <> 'original_url' AND NOT $4::bool
+            THEN url_redirects.created_at END,
+        CASE WHEN $3::text <> 'original_url' AND $4::bool
+            THEN url_redirects.created_at END DESC,
+        CASE WHEN NOT $4::bool THEN url_redirects.short END,
+        CASE WHEN $4::bool THEN url_redirects.short END DESC
+    LIMIT $7::int
+`
+
+type SearchURLsParams struct {
+	Search           string        `json:"search"`
+	AfterShort       string        `json:"after_short"`
+	SortBy           string        `json:"sort_by"`
+	Descending       bool          `json:"descending"`
+	AfterOriginalUrl string        `json:"after_original_url"`
+	AfterCreatedAt   time.Time     `json:"after_created_at"`
+	PageSize         sql.NullInt32 `json:"page_size"`
+}
+
+type SearchURLsRow struct {
+	Short       string        `json:"short"`
+	OriginalUrl string        `json:"original_url"`
+	OwnerID     uuid.NullUUID `json:"owner_id"`
+	CreatedBy   uuid.NullUUID `json:"created_by"`
+	CreatedAt   time.Time     `json:"created_at"`
+	IsDeleted   bool          `json:"is_deleted"`
+	DisabledAt  sql.NullTime  `json:"disabled_at"`
+}
+
+func (q *Queries) SearchURLs(ctx context.Context, arg SearchURLsParams) ([]SearchURLsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchURLs,
+		arg.Search,
+		arg.AfterShort,
+		arg.SortBy,
+		arg.Descending,
+		arg.AfterOriginalUrl,
+		arg.AfterCreatedAt,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []SearchURLsRow{}
+	for rows.Next() {
+		var i SearchURLsRow
+		if err := rows.Scan(
+			&i.Short,
+			&i.OriginalUrl,
+			&i.OwnerID,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.IsDeleted,
+			&i.DisabledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setURLActiveFrom = `-- name: SetURLActiveFrom :exec
+UPDATE url_redirects
+    SET active_from = $1,
+        dedicated = url_redirects.dedicated OR $2
+    WHERE short = $3
+`
+
+type SetURLActiveFromParams struct {
+	ActiveFrom sql.NullTime `json:"active_from"`
+	Dedicated  bool         `json:"dedicated"`
+	Short      string       `json:"short"`
+}
+
+func (q *Queries) SetURLActiveFrom(ctx context.Context, arg SetURLActiveFromParams) error {
+	_, err := q.db.ExecContext(ctx, setURLActiveFrom, arg.ActiveFrom, arg.Dedicated, arg.Short)
+	return err
+}
+
+const setURLDestinations = `-- name: SetURLDestinations :exec
+UPDATE url_redirects
+    SET destinations = $1,
+        dedicated = url_redirects.dedicated OR $2
+    WHERE short = $3
+`
+
+type SetURLDestinationsParams struct {
+	Destinations json.RawMessage `json:"destinations"`
+	Dedicated    bool            `json:"dedicated"`
+	Short        string          `json:"short"`
+}
+
+func (q *Queries) SetURLDestinations(ctx context.Context, arg SetURLDestinationsParams) error {
+	_, err := q.db.ExecContext(ctx, setURLDestinations, arg.Destinations, arg.Dedicated, arg.Short)
+	return err
+}
+
+const setURLRedirectRules = `-- name: SetURLRedirectRules :exec
+UPDATE url_redirects
+    SET redirect_rules = $1,
+        dedicated = url_redirects.dedicated OR $2
+    WHERE short = $3
+`
+
+type SetURLRedirectRulesParams struct {
+	RedirectRules json.RawMessage `json:"redirect_rules"`
+	Dedicated     bool            `json:"dedicated"`
+	Short         string          `json:"short"`
+}
+
+func (q *Queries) SetURLRedirectRules(ctx context.Context, arg SetURLRedirectRulesParams) error {
+	_, err := q.db.ExecContext(ctx, setURLRedirectRules, arg.RedirectRules, arg.Dedicated, arg.Short)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :execrows
+UPDATE users
+    SET role = $1
+    WHERE user_id = $2
+`
+
+type SetUserRoleParams struct {
+	Role   string    `json:"role"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRole, arg.Role, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserUTMDefaults = `-- name: SetUserUTMDefaults :exec
+INSERT INTO users_utm_defaults (user_id, source, medium, campaign, term, content)
+    VALUES (
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6
+    )
+    ON CONFLICT (user_id) DO UPDATE
+        SET source = EXCLUDED.source,
+            medium = EXCLUDED.medium,
+            campaign = EXCLUDED.campaign,
+            term = EXCLUDED.term,
+            content = EXCLUDED.content
+`
+
+type SetUserUTMDefaultsParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Source   string    `json:"source"`
+	Medium   string    `json:"medium"`
+	Campaign string    `json:"campaign"`
+	Term     string    `json:"term"`
+	Content  string    `json:"content"`
+}
+
+func (q *Queries) SetUserUTMDefaults(ctx context.Context, arg SetUserUTMDefaultsParams) error {
+	_, err := q.db.ExecContext(ctx, setUserUTMDefaults,
+		arg.UserID,
+		arg.Source,
+		arg.Medium,
+		arg.Campaign,
+		arg.Term,
+		arg.Content,
+	)
 	return err
 }
@@ -0,0 +1,261 @@
+// Package domainpolicy decides which destination hosts may be shortened, according to
+// an allow-list and a deny-list loaded from a JSON file and reloaded whenever the file changes.
+//
+// The file holds both lists:
+//
+//	{
+//		"allow": ["example.com", "*.example.com", "10.0.0.0/8"],
+//		"deny": ["phishing.example.com", "*.malware.example", "192.0.2.1"]
+//	}
+//
+// Each entry is either an exact host, a wildcard "*.domain" matching the subdomains
+// of the domain (but not the domain itself), an IP address or a CIDR matching the IP
+// literals within it. Hosts are compared case-insensitively.
+package domainpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patric-chuzhbe/urlshrt/internal/logger"
+)
+
+// ErrDomainDenied is returned for the URLs leading to a host on the deny-list.
+var ErrDomainDenied = errors.New("the destination domain is denied")
+
+// ErrDomainNotAllowed is returned for the URLs leading to a host missing from a non-empty allow-list.
+var ErrDomainNotAllowed = errors.New("the destination domain is not allowed")
+
+// Policy checks the destination hosts of URLs against the allow-list and the deny-list.
+// It is safe for concurrent use.
+type Policy struct {
+	mutex          sync.RWMutex
+	path           string
+	reloadInterval time.Duration
+	modTime        time.Time
+	size           int64
+	allow          hostList
+	deny           hostList
+	errorChannel   chan error
+}
+
+type listsFile struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+type hostList struct {
+	hosts     map[string]struct{}
+	wildcards []string
+	networks  []*net.IPNet
+}
+
+// New initializes and returns a new instance of Policy loaded from the file at path,
+// which is checked for changes every reloadInterval once Run is called.
+func New(path string, reloadInterval time.Duration) (*Policy, error) {
+	policy := &Policy{
+		path:           path,
+		reloadInterval: reloadInterval,
+		errorChannel:   make(chan error, 1),
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("in internal/domainpolicy/domainpolicy.go/New(): error while `os.Stat()` calling: %w", err)
+	}
+
+	err = policy.load(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Check tells whether the URL may be shortened: it returns ErrDomainDenied if its host
+// is on the deny-list, ErrDomainNotAllowed if the allow-list is not empty and its host
+// is not on it, and nil otherwise.
+func (p *Policy) Check(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("in internal/domainpolicy/domainpolicy.go/Check(): error while `url.Parse()` calling: %w", err)
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsedURL.Hostname()), ".")
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.deny.matches(host) {
+		return ErrDomainDenied
+	}
+
+	if !p.allow.isEmpty() && !p.allow.matches(host) {
+		return ErrDomainNotAllowed
+	}
+
+	return nil
+}
+
+// ListenErrors starts a goroutine that listens for errors from the internal
+// error channel and passes them to the provided callback function.
+//
+// The callback is invoked for each error as it arrives. This method returns immediately,
+// and the listening continues in the background.
+func (p *Policy) ListenErrors(callback func(error)) {
+	go func() {
+		for err := range p.errorChannel {
+			callback(err)
+		}
+	}()
+}
+
+// Run starts a background goroutine that periodically reloads the lists if their file
+// has changed. A file that cannot be read or parsed leaves the lists loaded before in effect.
+// The method returns immediately and continues processing in the background until the provided
+// context is canceled.
+func (p *Policy) Run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(p.reloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Log.Infoln("Policy.Run() stopped")
+				return
+			case <-ticker.C:
+				err := p.reloadIfChanged()
+				if err != nil {
+					p.errorChannel <- err
+				}
+			}
+		}
+	}()
+}
+
+func (p *Policy) reloadIfChanged() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("in internal/domainpolicy/domainpolicy.go/reloadIfChanged(): error while `os.Stat()` calling: %w", err)
+	}
+
+	p.mutex.RLock()
+	changed := !info.ModTime().Equal(p.modTime) || info.Size() != p.size
+	p.mutex.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	err = p.load(info)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infoln("domain lists reloaded from", p.path)
+
+	return nil
+}
+
+func (p *Policy) load(info os.FileInfo) error {
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("in internal/domainpolicy/domainpolicy.go/load(): error while `os.ReadFile()` calling: %w", err)
+	}
+
+	var lists listsFile
+	err = json.Unmarshal(content, &lists)
+	if err != nil {
+		return fmt.Errorf("in internal/domainpolicy/domainpolicy.go/load(): error while `json.Unmarshal()` calling: %w", err)
+	}
+
+	allow, err := newHostList(lists.Allow)
+	if err != nil {
+		return fmt.Errorf("in internal/domainpolicy/domainpolicy.go/load(): invalid allow-list: %w", err)
+	}
+
+	deny, err := newHostList(lists.Deny)
+	if err != nil {
+		return fmt.Errorf("in internal/domainpolicy/domainpolicy.go/load(): invalid deny-list: %w", err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.allow = allow
+	p.deny = deny
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+
+	return nil
+}
+
+func newHostList(entries []string) (hostList, error) {
+	list := hostList{hosts: map[string]struct{}{}}
+
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+
+		switch {
+		case entry == "":
+			return hostList{}, errors.New("empty entry")
+
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return hostList{}, err
+			}
+			list.networks = append(list.networks, network)
+
+		case strings.HasPrefix(entry, "*."):
+			list.wildcards = append(list.wildcards, entry[1:])
+
+		case strings.Contains(entry, "*"):
+			return hostList{}, fmt.Errorf("unsupported wildcard in %q", entry)
+
+		default:
+			if ip := net.ParseIP(entry); ip != nil {
+				entry = ip.String()
+			}
+			list.hosts[entry] = struct{}{}
+		}
+	}
+
+	return list, nil
+}
+
+func (l hostList) isEmpty() bool {
+	return len(l.hosts) == 0 && len(l.wildcards) == 0 && len(l.networks) == 0
+}
+
+func (l hostList) matches(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		if _, found := l.hosts[ip.String()]; found {
+			return true
+		}
+		for _, network := range l.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if _, found := l.hosts[host]; found {
+		return true
+	}
+	for _, suffix := range l.wildcards {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package domainpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/patric-chuzhbe/urlshrt/internal/logger"
+)
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.json")
+	err := os.WriteFile(path, []byte(`{
+		"allow": ["corp.example", "*.corp.example", "10.0.0.0/8", "2001:db8::1"],
+		"deny": ["phishing.corp.example", "*.evil.corp.example", "10.6.6.0/24"]
+	}`), 0o600)
+	require.NoError(t, err)
+
+	policy, err := New(path, time.Minute)
+	require.NoError(t, err)
+
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{url: "https://corp.example/page", want: nil},
+		{url: "https://WWW.Corp.Example./page", want: nil},
+		{url: "https://phishing.corp.example/login", want: ErrDomainDenied},
+		{url: "https://a.evil.corp.example", want: ErrDomainDenied},
+		{url: "https://notcorp.example", want: ErrDomainNotAllowed},
+		{url: "http://10.1.2.3:8080/", want: nil},
+		{url: "http://10.6.6.6/", want: ErrDomainDenied},
+		{url: "http://11.0.0.1/", want: ErrDomainNotAllowed},
+		{url: "http://[2001:db8:0::1]/", want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			assert.ErrorIs(t, policy.Check(test.url), test.want)
+		})
+	}
+}
+
+func TestReloadIfChanged(t *testing.T) {
+	require.NoError(t, logger.Init("debug"))
+
+	path := filepath.Join(t.TempDir(), "domains.json")
+	err := os.WriteFile(path, []byte(`{"deny": ["phishing.example"]}`), 0o600)
+	require.NoError(t, err)
+
+	policy, err := New(path, time.Minute)
+	require.NoError(t, err)
+	assert.NoError(t, policy.Check("https://allowed.example"))
+	assert.ErrorIs(t, policy.Check("https://phishing.example"), ErrDomainDenied)
+
+	err = os.WriteFile(path, []byte(`{"deny": ["phishing.example", "allowed.example"]}`), 0o600)
+	require.NoError(t, err)
+	require.NoError(t, policy.reloadIfChanged())
+	assert.ErrorIs(t, policy.Check("https://allowed.example"), ErrDomainDenied)
+
+	err = os.WriteFile(path, []byte(`{"deny": ["bad*.example"]}`), 0o600)
+	require.NoError(t, err)
+	assert.Error(t, policy.reloadIfChanged())
+	assert.ErrorIs(t, policy.Check("https://allowed.example"), ErrDomainDenied)
+}
@@ -13,6 +13,7 @@ import (
 	"regexp"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
@@ -30,19 +31,24 @@ import (
 type authenticator interface {
 	AuthenticateUser(h http.Handler) http.Handler
 	RegisterNewUser(h http.Handler) http.Handler
+	IssueImpersonationToken(ctx context.Context, adminID, userID string) (string, time.Time, error)
+	IssueUserTokens(ctx context.Context, response http.ResponseWriter, userID string) (models.AuthTokens, error)
+	RefreshTokens(response http.ResponseWriter, request *http.Request, refreshToken string) (models.AuthTokens, error)
+	Logout(response http.ResponseWriter, request *http.Request, refreshToken string) error
 }
 
 type userUrlsKeeper interface {
 	GetUserUrls(
 		ctx context.Context,
 		userID string,
+		query models.UserURLsQuery,
 		shortURLFormatter models.URLFormatter,
 	) (models.UserUrls, error)
 
 	SaveUserUrls(
 		ctx context.Context,
 		userID string,
-		urls []string,
+		shortURLs []string,
 		transaction *sql.Tx,
 	) error
 }
@@ -59,12 +65,15 @@ type urlsMapper interface {
 	FindShortsByFulls(
 		ctx context.Context,
 		originalUrls []string,
+		ownerID string,
 		transaction *sql.Tx,
 	) (map[string]string, error)
 
 	SaveNewFullsAndShorts(
 		ctx context.Context,
 		unexistentFullsToShortsMap map[string]string,
+		ownerID string,
+		attributes map[string]models.URLAttributes,
 		transaction *sql.Tx,
 	) error
 
@@ -73,6 +82,7 @@ type urlsMapper interface {
 	FindShortByFull(
 		ctx context.Context,
 		full string,
+		ownerID string,
 		transaction *sql.Tx,
 	) (string, bool, error)
 
@@ -80,6 +90,8 @@ type urlsMapper interface {
 		ctx context.Context,
 		short,
 		full string,
+		ownerID string,
+		attributes models.URLAttributes,
 		transaction *sql.Tx,
 	) error
 }
@@ -95,6 +107,12 @@ type testStorage interface {
 	pinger
 	CreateUser(ctx context.Context, usr *user.User, transaction *sql.Tx) (string, error)
 	GetUserByID(ctx context.Context, userID string, transaction *sql.Tx) (*user.User, error)
+	SaveRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	ConsumeRefreshToken(ctx context.Context, tokenHash string) (string, error)
+	GetRefreshTokenUserID(ctx context.Context, tokenHash string) (string, error)
+	DeleteRefreshToken(ctx context.Context, tokenHash string) error
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
 	Close() error
 }
 
@@ -182,6 +200,30 @@ func (m *mockAuth) RegisterNewUser(h http.Handler) http.Handler {
 	return h
 }
 
+func (m *mockAuth) IssueImpersonationToken(ctx context.Context, adminID, userID string) (string, time.Time, error) {
+	return adminID + ":" + userID, time.Now().Add(time.Hour), nil
+}
+
+func (m *mockAuth) IssueUserTokens(
+	ctx context.Context,
+	response http.ResponseWriter,
+	userID string,
+) (models.AuthTokens, error) {
+	return models.AuthTokens{Token: userID, ExpiresAt: time.Now().Add(time.Hour), RefreshToken: userID}, nil
+}
+
+func (m *mockAuth) RefreshTokens(
+	response http.ResponseWriter,
+	request *http.Request,
+	refreshToken string,
+) (models.AuthTokens, error) {
+	return models.AuthTokens{Token: refreshToken, ExpiresAt: time.Now().Add(time.Hour), RefreshToken: refreshToken}, nil
+}
+
+func (m *mockAuth) Logout(response http.ResponseWriter, request *http.Request, refreshToken string) error {
+	return nil
+}
+
 func ExampleRouter_GetPing() {
 	server, _, _ := setupTestRouter(nil)
 	defer server.Close()
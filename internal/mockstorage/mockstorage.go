@@ -6,6 +6,7 @@ package mockstorage
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
@@ -50,20 +51,116 @@ func (m *StorageMock) RollbackTransaction(tx *sql.Tx) error {
 func (m *StorageMock) GetUserUrls(
 	ctx context.Context,
 	userID string,
+	query models.UserURLsQuery,
 	shortURLFormatter models.URLFormatter,
 ) (models.UserUrls, error) {
-	args := m.Called(ctx, userID, shortURLFormatter)
+	args := m.Called(ctx, userID, query, shortURLFormatter)
 	return args.Get(0).(models.UserUrls), args.Error(1)
 }
 
-// SaveUserUrls mocks storing a set of URLs for a user.
+// SaveUserUrls mocks storing a set of short URLs for a user.
 func (m *StorageMock) SaveUserUrls(
 	ctx context.Context,
 	userID string,
-	urls []string,
+	shortURLs []string,
 	tx *sql.Tx,
 ) error {
-	args := m.Called(ctx, userID, urls, tx)
+	args := m.Called(ctx, userID, shortURLs, tx)
+	return args.Error(0)
+}
+
+// RetargetUserURL mocks changing the original URL of a user's short URL.
+func (m *StorageMock) RetargetUserURL(ctx context.Context, userID, short, full string) error {
+	args := m.Called(ctx, userID, short, full)
+	return args.Error(0)
+}
+
+// SetUserURLActiveFrom mocks changing the activation time of a user's short URL.
+func (m *StorageMock) SetUserURLActiveFrom(ctx context.Context, userID, short string, activeFrom time.Time) error {
+	args := m.Called(ctx, userID, short, activeFrom)
+	return args.Error(0)
+}
+
+// SetUserURLRedirectRules mocks replacing the redirect rules of a user's short URL.
+func (m *StorageMock) SetUserURLRedirectRules(
+	ctx context.Context,
+	userID, short string,
+	rules models.RedirectRules,
+) error {
+	args := m.Called(ctx, userID, short, rules)
+	return args.Error(0)
+}
+
+// GetUserURLRedirectRules mocks retrieving the redirect rules of a user's short URL.
+func (m *StorageMock) GetUserURLRedirectRules(ctx context.Context, userID, short string) (models.RedirectRules, error) {
+	args := m.Called(ctx, userID, short)
+	return args.Get(0).(models.RedirectRules), args.Error(1)
+}
+
+// SetUserURLDestinations mocks replacing the A/B destinations of a user's short URL.
+func (m *StorageMock) SetUserURLDestinations(
+	ctx context.Context,
+	userID, short string,
+	destinations models.Destinations,
+) error {
+	args := m.Called(ctx, userID, short, destinations)
+	return args.Error(0)
+}
+
+// GetUserURLDestinations mocks retrieving the A/B destinations of a user's short URL.
+func (m *StorageMock) GetUserURLDestinations(ctx context.Context, userID, short string) (models.Destinations, error) {
+	args := m.Called(ctx, userID, short)
+	return args.Get(0).(models.Destinations), args.Error(1)
+}
+
+// RestoreUsersUrls mocks restoring deleted URLs of a user.
+func (m *StorageMock) RestoreUsersUrls(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	deletedAfter time.Time,
+) ([]string, error) {
+	args := m.Called(ctx, userID, shortURLs, deletedAfter)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// AddUserURLsTags mocks attaching tags to URLs of a user.
+func (m *StorageMock) AddUserURLsTags(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	tags []string,
+) ([]string, error) {
+	args := m.Called(ctx, userID, shortURLs, tags)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// RemoveUserURLsTags mocks detaching tags from URLs of a user.
+func (m *StorageMock) RemoveUserURLsTags(
+	ctx context.Context,
+	userID string,
+	shortURLs []string,
+	tags []string,
+) ([]string, error) {
+	args := m.Called(ctx, userID, shortURLs, tags)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// GetUserTags mocks retrieving the tags of a user.
+func (m *StorageMock) GetUserTags(ctx context.Context, userID string) (models.UserTags, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(models.UserTags), args.Error(1)
+}
+
+// GetUserUTMDefaults mocks retrieving the default UTM parameters of a user.
+func (m *StorageMock) GetUserUTMDefaults(ctx context.Context, userID string) (models.UTMParams, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(models.UTMParams), args.Error(1)
+}
+
+// SetUserUTMDefaults mocks replacing the default UTM parameters of a user.
+func (m *StorageMock) SetUserUTMDefaults(ctx context.Context, userID string, defaults models.UTMParams) error {
+	args := m.Called(ctx, userID, defaults)
 	return args.Error(0)
 }
 
@@ -71,9 +168,10 @@ func (m *StorageMock) SaveUserUrls(
 func (m *StorageMock) FindShortsByFulls(
 	ctx context.Context,
 	originalUrls []string,
+	ownerID string,
 	tx *sql.Tx,
 ) (map[string]string, error) {
-	args := m.Called(ctx, originalUrls, tx)
+	args := m.Called(ctx, originalUrls, ownerID, tx)
 	return args.Get(0).(map[string]string), args.Error(1)
 }
 
@@ -81,9 +179,11 @@ func (m *StorageMock) FindShortsByFulls(
 func (m *StorageMock) SaveNewFullsAndShorts(
 	ctx context.Context,
 	unexistentFullsToShortsMap map[string]string,
+	ownerID string,
+	attributes map[string]models.URLAttributes,
 	tx *sql.Tx,
 ) error {
-	args := m.Called(ctx, unexistentFullsToShortsMap, tx)
+	args := m.Called(ctx, unexistentFullsToShortsMap, ownerID, attributes, tx)
 	return args.Error(0)
 }
 
@@ -93,15 +193,76 @@ func (m *StorageMock) FindFullByShort(ctx context.Context, short string) (string
 	return args.String(0), args.Bool(1), args.Error(2)
 }
 
+// FindRedirectByShort mocks finding the original URL and the link attributes for a given short code.
+func (m *StorageMock) FindRedirectByShort(ctx context.Context, short string) (models.URLRedirect, bool, error) {
+	args := m.Called(ctx, short)
+	return args.Get(0).(models.URLRedirect), args.Bool(1), args.Error(2)
+}
+
+// ConsumeURLClick mocks taking one redirect from a link limited to a number of redirects.
+func (m *StorageMock) ConsumeURLClick(ctx context.Context, short string) (int, error) {
+	args := m.Called(ctx, short)
+	return args.Int(0), args.Error(1)
+}
+
 // FindShortByFull mocks finding the short code for a full URL.
-func (m *StorageMock) FindShortByFull(ctx context.Context, full string, tx *sql.Tx) (string, bool, error) {
-	args := m.Called(ctx, full, tx)
+func (m *StorageMock) FindShortByFull(ctx context.Context, full string, ownerID string, tx *sql.Tx) (string, bool, error) {
+	args := m.Called(ctx, full, ownerID, tx)
 	return args.String(0), args.Bool(1), args.Error(2)
 }
 
 // InsertURLMapping mocks inserting a new short-full mapping.
-func (m *StorageMock) InsertURLMapping(ctx context.Context, short, full string, tx *sql.Tx) error {
-	args := m.Called(ctx, short, full, tx)
+func (m *StorageMock) InsertURLMapping(
+	ctx context.Context,
+	short, full string,
+	ownerID string,
+	attributes models.URLAttributes,
+	tx *sql.Tx,
+) error {
+	args := m.Called(ctx, short, full, ownerID, attributes, tx)
+	return args.Error(0)
+}
+
+// ReportURL mocks filing an abuse report on a short URL.
+func (m *StorageMock) ReportURL(ctx context.Context, short, reporterID, reason string) error {
+	args := m.Called(ctx, short, reporterID, reason)
+	return args.Error(0)
+}
+
+// GetAbuseReportsQueue mocks retrieving the moderation queue of the reported short URLs.
+func (m *StorageMock) GetAbuseReportsQueue(
+	ctx context.Context,
+	formatter models.URLFormatter,
+) (models.AbuseReportsQueue, error) {
+	args := m.Called(ctx, formatter)
+	return args.Get(0).(models.AbuseReportsQueue), args.Error(1)
+}
+
+// DisableURL mocks disabling a short URL by the moderators.
+func (m *StorageMock) DisableURL(ctx context.Context, short string) error {
+	args := m.Called(ctx, short)
+	return args.Error(0)
+}
+
+// DismissURLReports mocks dismissing the pending abuse reports on a short URL.
+func (m *StorageMock) DismissURLReports(ctx context.Context, short string) (int64, error) {
+	args := m.Called(ctx, short)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// SearchURLs mocks searching all the short URLs.
+func (m *StorageMock) SearchURLs(
+	ctx context.Context,
+	query models.UserURLsQuery,
+	formatter models.URLFormatter,
+) (models.AdminURLs, error) {
+	args := m.Called(ctx, query, formatter)
+	return args.Get(0).(models.AdminURLs), args.Error(1)
+}
+
+// SetUserRole mocks changing the role of a user.
+func (m *StorageMock) SetUserRole(ctx context.Context, userID, role string) error {
+	args := m.Called(ctx, userID, role)
 	return args.Error(0)
 }
 
@@ -117,6 +278,60 @@ func (m *StorageMock) GetUserByID(ctx context.Context, userID string, tx *sql.Tx
 	return args.Get(0).(*user.User), args.Error(1)
 }
 
+// RegisterUser mocks giving an anonymous user an account.
+func (m *StorageMock) RegisterUser(ctx context.Context, userID, email, passwordHash string) error {
+	args := m.Called(ctx, userID, email, passwordHash)
+	return args.Error(0)
+}
+
+// GetUserByEmail mocks fetching a registered user by their email.
+func (m *StorageMock) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
+	args := m.Called(ctx, email)
+	return args.Get(0).(*user.User), args.Error(1)
+}
+
+// ClaimUserURLs mocks moving the links of an anonymous user to a registered one.
+func (m *StorageMock) ClaimUserURLs(ctx context.Context, anonymousUserID, userID string) (int64, error) {
+	args := m.Called(ctx, anonymousUserID, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// SaveRefreshToken mocks storing a refresh token.
+func (m *StorageMock) SaveRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error {
+	args := m.Called(ctx, userID, tokenHash, expiresAt)
+	return args.Error(0)
+}
+
+// ConsumeRefreshToken mocks using up a refresh token.
+func (m *StorageMock) ConsumeRefreshToken(ctx context.Context, tokenHash string) (string, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.String(0), args.Error(1)
+}
+
+// GetRefreshTokenUserID mocks looking up the user of a refresh token.
+func (m *StorageMock) GetRefreshTokenUserID(ctx context.Context, tokenHash string) (string, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.String(0), args.Error(1)
+}
+
+// DeleteRefreshToken mocks deleting a refresh token.
+func (m *StorageMock) DeleteRefreshToken(ctx context.Context, tokenHash string) error {
+	args := m.Called(ctx, tokenHash)
+	return args.Error(0)
+}
+
+// RevokeToken mocks putting an access token on the revocation list.
+func (m *StorageMock) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	args := m.Called(ctx, tokenID, expiresAt)
+	return args.Error(0)
+}
+
+// IsTokenRevoked mocks checking the revocation list.
+func (m *StorageMock) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	args := m.Called(ctx, tokenID)
+	return args.Bool(0), args.Error(1)
+}
+
 // Close mocks closing the storage and releasing resources.
 func (m *StorageMock) Close() error {
 	args := m.Called()
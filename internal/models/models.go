@@ -1,10 +1,36 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 // ShortenRequest represents an input URL for the shortening API.
 type ShortenRequest struct {
-	URL string `json:"url" validate:"required,url"` // Original long URL to be shortened
+	URL          string     `json:"url" validate:"required,url"`                                               // Original long URL to be shortened
+	Title        string     `json:"title,omitempty" validate:"max=255"`                                        // Optional title of the link
+	Description  string     `json:"description,omitempty" validate:"max=1024"`                                 // Optional description of the link
+	Password     string     `json:"password,omitempty" validate:"max=72"`                                      // Optional password protecting the link, up to 72 bytes
+	MaxClicks    int        `json:"max_clicks,omitempty" validate:"gte=0,lte=1000000"`                         // Optional number of redirects after which the link expires
+	ActiveFrom   *time.Time `json:"active_from,omitempty"`                                                     // Optional time before which the link does not redirect
+	RedirectCode int        `json:"redirect_code,omitempty" validate:"omitempty,oneof=301 302 307 308"`        // Optional HTTP status code of the redirect
+	Passthrough  string     `json:"passthrough,omitempty" validate:"omitempty,oneof=off query query_and_path"` // Optional passthrough policy, "off" by default
+	Interstitial bool       `json:"interstitial,omitempty"`                                                    // Whether to show the "you are leaving" page before redirecting to an untrusted domain
+	UTM          *UTMParams `json:"utm,omitempty"`                                                             // Optional UTM parameters merged into the URL, completed with the user's defaults
+}
+
+// RetargetURLRequest defines the request payload changing the original URL of a short URL.
+type RetargetURLRequest struct {
+	URL string `json:"url" validate:"required,url"` // New original URL
+}
+
+// UTMParams holds the UTM parameters of a link, each one optional.
+type UTMParams struct {
+	Source   string `json:"source,omitempty" validate:"max=255"`   // utm_source
+	Medium   string `json:"medium,omitempty" validate:"max=255"`   // utm_medium
+	Campaign string `json:"campaign,omitempty" validate:"max=255"` // utm_campaign
+	Term     string `json:"term,omitempty" validate:"max=255"`     // utm_term
+	Content  string `json:"content,omitempty" validate:"max=255"`  // utm_content
 }
 
 // ShortenResponse defines the response payload containing the shortened URL.
@@ -14,8 +40,17 @@ type ShortenResponse struct {
 
 // ShortenRequestItem defines a batch shortening request payload.
 type ShortenRequestItem struct {
-	CorrelationID string `json:"correlation_id" validate:"required"`   // ID to correlate request/response
-	OriginalURL   string `json:"original_url" validate:"required,url"` // Original URL
+	CorrelationID string     `json:"correlation_id" validate:"required"`                                        // ID to correlate request/response
+	OriginalURL   string     `json:"original_url" validate:"required,url"`                                      // Original URL
+	Title         string     `json:"title,omitempty" validate:"max=255"`                                        // Optional title of the link
+	Description   string     `json:"description,omitempty" validate:"max=1024"`                                 // Optional description of the link
+	Password      string     `json:"password,omitempty" validate:"max=72"`                                      // Optional password protecting the link, up to 72 bytes
+	MaxClicks     int        `json:"max_clicks,omitempty" validate:"gte=0,lte=1000000"`                         // Optional number of redirects after which the link expires
+	ActiveFrom    *time.Time `json:"active_from,omitempty"`                                                     // Optional time before which the link does not redirect
+	RedirectCode  int        `json:"redirect_code,omitempty" validate:"omitempty,oneof=301 302 307 308"`        // Optional HTTP status code of the redirect
+	Passthrough   string     `json:"passthrough,omitempty" validate:"omitempty,oneof=off query query_and_path"` // Optional passthrough policy, "off" by default
+	Interstitial  bool       `json:"interstitial,omitempty"`                                                    // Whether to show the "you are leaving" page before redirecting to an untrusted domain
+	UTM           *UTMParams `json:"utm,omitempty"`                                                             // Optional UTM parameters merged into the URL, completed with the user's defaults
 }
 
 // BatchShortenRequest defines a batch shortening request payload.
@@ -30,15 +65,178 @@ type BatchShortenResponseItem struct {
 // BatchShortenResponse defines the response payload for batch shortening.
 type BatchShortenResponse []BatchShortenResponseItem
 
+// BatchShortenErrorItem defines single rejected item for a batch shortening error response payload.
+type BatchShortenErrorItem struct {
+	CorrelationID string `json:"correlation_id"` // Correlation ID matching the request
+	OriginalURL   string `json:"original_url"`   // Rejected URL
+	Error         string `json:"error"`          // Reason of the rejection
+}
+
+// BatchShortenErrorResponse defines the response payload for a rejected batch shortening.
+type BatchShortenErrorResponse []BatchShortenErrorItem
+
+// ErrorResponse defines the response payload describing why a request was rejected.
+type ErrorResponse struct {
+	Error string `json:"error"` // Reason of the rejection
+}
+
 // UserURL represents a mapping between a short and original URL for a user.
 type UserURL struct {
-	ShortURL    string `json:"short_url" validate:"required,url"`
-	OriginalURL string `json:"original_url" validate:"required,url"`
+	ShortURL    string     `json:"short_url" validate:"required,url"`
+	OriginalURL string     `json:"original_url" validate:"required,url"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`            // Creation time of the short URL
+	UpdatedAt   time.Time  `json:"updated_at"`            // Time of the last change of the original URL
+	ActiveFrom  *time.Time `json:"active_from,omitempty"` // Time before which the link does not redirect, if any
+	Tags        []string   `json:"tags,omitempty"`        // Tags the user attached to the link, sorted
 }
 
 // UserUrls is a slice of UserURL, returned for user-specific URL queries.
 type UserUrls []UserURL
 
+// URLAttributes holds the attributes of a new short URL besides the URLs themselves.
+type URLAttributes struct {
+	CreatedBy     string        // ID of the user who first shortened the URL
+	Title         string        // Optional title of the link
+	Description   string        // Optional description of the link
+	PasswordHash  string        // bcrypt hash of the password protecting the link; empty if it is not protected
+	MaxClicks     int           // Number of redirects after which the link expires; zero means no limit
+	ActiveFrom    time.Time     // Time before which the link does not redirect; zero means it is always active
+	RedirectCode  int           // HTTP status code of the redirect; zero means the configured default
+	Passthrough   string        // What of the request is passed on to the original URL, see the Passthrough constants; empty means off
+	RedirectRules RedirectRules // Rules sending matching visitors elsewhere than the original URL, in the order they are evaluated
+	Destinations  Destinations  // A/B variants the visitors matching no rule are split across instead of the original URL
+	Interstitial  bool          // Whether the "you are leaving" page is shown before redirecting to an untrusted domain
+}
+
+// IsDedicated reports whether the attributes make the link one of its own: such a link is
+// created for every shortening asking for it, and never handed out to other shortenings
+// of the same original URL.
+func (attributes URLAttributes) IsDedicated() bool {
+	return attributes.PasswordHash != "" ||
+		attributes.MaxClicks > 0 ||
+		!attributes.ActiveFrom.IsZero() ||
+		attributes.RedirectCode != 0 ||
+		(attributes.Passthrough != "" && attributes.Passthrough != PassthroughOff) ||
+		attributes.Interstitial ||
+		len(attributes.RedirectRules) > 0 ||
+		len(attributes.Destinations) > 0
+}
+
+// URLRedirect is what a short URL redirects to: the original URL and the attributes of the link.
+type URLRedirect struct {
+	OriginalURL string
+	Attributes  URLAttributes
+	CreatedAt   time.Time // Creation time of the short URL
+}
+
+// Sort fields for the user's URLs. See every constant description.
+const (
+	// UserURLsSortByCreatedAt orders the user's URLs by the creation time of the short URL.
+	UserURLsSortByCreatedAt = "created_at"
+
+	// UserURLsSortByOriginalURL orders the user's URLs by the original URL.
+	UserURLsSortByOriginalURL = "original_url"
+)
+
+// UserURLsQuery defines which page of the user's URLs to retrieve and in what order.
+// The zero value retrieves all of them ordered by creation time.
+type UserURLsQuery struct {
+	Limit      int             // Maximum number of URLs to return; zero means no limit
+	SortBy     string          // UserURLsSortByCreatedAt (default) or UserURLsSortByOriginalURL
+	Descending bool            // Whether to sort in descending order
+	Search     string          // Case-insensitive substring of the original URL to filter by
+	Tag        string          // Tag to filter by; empty means any
+	After      *UserURLsCursor // Position after which the page starts; nil for the first page
+}
+
+// UserURLsCursor is the position of a URL in the ordered list of the user's URLs.
+// The short URL breaks ties between equal sort values. The sort field and order the
+// cursor was issued for are kept, as the position means nothing in any other order.
+type UserURLsCursor struct {
+	SortBy      string    `json:"sort_by"`
+	Descending  bool      `json:"desc,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+	OriginalURL string    `json:"original_url,omitempty"`
+	Short       string    `json:"short"`
+}
+
+// RedirectRule sends the visitors of a short URL matching all of its conditions to its own URL
+// instead of the original one, which stays the fallback. A rule has at least one condition.
+type RedirectRule struct {
+	OS         string `json:"os,omitempty" validate:"required_without_all=Browser Language QueryParam,omitempty,oneof=ios android windows macos linux"` // Operating system of the visitor
+	Browser    string `json:"browser,omitempty" validate:"omitempty,oneof=chrome firefox safari edge opera bot"`                                        // User agent family of the visitor; "bot" stands for crawlers
+	Language   string `json:"language,omitempty" validate:"omitempty,bcp47_language_tag"`                                                               // Most preferred language of the visitor, e.g. "en" or "pt-BR"
+	QueryParam string `json:"query_param,omitempty" validate:"max=255"`                                                                                 // Query parameter the request has
+	QueryValue string `json:"query_value,omitempty" validate:"excluded_without=QueryParam,max=255"`                                                     // Value of the query parameter; empty matches any value
+	URL        string `json:"url" validate:"required,url"`                                                                                              // URL to redirect the matching visitors to
+}
+
+// RedirectRules is the ordered list of the redirect rules of a short URL: the first matching rule wins.
+type RedirectRules []RedirectRule
+
+// Destination is an A/B variant of a short URL, chosen for a share of the visitors
+// proportional to its weight.
+type Destination struct {
+	URL    string `json:"url" validate:"required,url"`      // URL of the variant
+	Weight int    `json:"weight" validate:"gte=1,lte=1000"` // Weight of the variant relative to the other ones
+}
+
+// Destinations is the list of the A/B variants of a short URL.
+type Destinations []Destination
+
+// ActiveFromRequest defines the new activation time of a short URL. A null time activates it right away.
+type ActiveFromRequest struct {
+	ActiveFrom *time.Time `json:"active_from"`
+}
+
+// TagURLsRequest defines the short keys of the user's URLs and the tags to add to or remove from them.
+// Used as request body in bulk tagging operations.
+type TagURLsRequest struct {
+	ShortURLs []string `json:"short_urls" validate:"required,min=1,max=1000,dive,required"`
+	Tags      []string `json:"tags" validate:"required,min=1,max=100,dive,required,max=64"`
+}
+
+// TagURLsResponse represents a slice of short keys of the user's URLs the tags were applied to.
+type TagURLsResponse []string
+
+// UserTag represents a tag of the user together with the number of the user's URLs carrying it.
+type UserTag struct {
+	Tag       string `json:"tag"`
+	URLsCount int64  `json:"urls_count"`
+}
+
+// UserTags is a slice of UserTag, returned for user-specific tag queries.
+type UserTags []UserTag
+
+// URLRedirectHistoryRecord is an audit record of a previous original URL of a retargeted short URL.
+type URLRedirectHistoryRecord struct {
+	OriginalURL string    // Original URL before the change
+	ChangedBy   string    // ID of the user who changed it
+	ChangedAt   time.Time // Time of the change
+}
+
+// AbuseReportRecord is an abuse report on a short URL.
+type AbuseReportRecord struct {
+	ReporterID string    // ID of the reporting user
+	Reason     string    // Optional description of the abuse
+	Status     string    // One of the abuse report status constants
+	CreatedAt  time.Time // Time of the report
+}
+
+// Abuse report status constants. See every constant description.
+const (
+	// AbuseReportStatusPending marks a report waiting for moderation.
+	AbuseReportStatusPending = "pending"
+
+	// AbuseReportStatusDismissed marks a report the moderators found unfounded.
+	AbuseReportStatusDismissed = "dismissed"
+
+	// AbuseReportStatusActioned marks a report that got the short URL disabled.
+	AbuseReportStatusActioned = "actioned"
+)
+
 // Storage type constants. See every constant description.
 const (
 	// StorageTypeUnknown represents an unknown storage type. Used when the storage type is undefined or unsupported.
@@ -54,13 +252,167 @@ const (
 	StorageTypeMemory
 )
 
+// URL ownership mode constants. See every constant description.
+const (
+	// URLOwnershipModeShared makes every original URL map to a single short link shared by all users.
+	URLOwnershipModeShared = "shared"
+
+	// URLOwnershipModePerUser gives every user their own short link per original URL,
+	// with a deletion state independent of other users.
+	URLOwnershipModePerUser = "per_user"
+)
+
+// Passthrough policy constants: what of a request to a short URL is passed on to the original URL.
+// See every constant description.
+const (
+	// PassthroughOff redirects to the original URL as is.
+	PassthroughOff = "off"
+
+	// PassthroughQuery merges the query parameters of the request into the original URL.
+	PassthroughQuery = "query"
+
+	// PassthroughQueryAndPath also appends the path following the short URL to the original URL path.
+	PassthroughQueryAndPath = "query_and_path"
+)
+
 // DeleteURLsRequest represents a slice of short keys of URLs to be deleted.
 // Used as request body in batch delete operations.
 type DeleteURLsRequest []string
 
+// RestoreURLsRequest represents a slice of short keys of deleted URLs to be restored.
+// Used as request body in batch restore operations.
+type RestoreURLsRequest []string
+
+// RestoreURLsResponse represents a slice of short keys of the restored URLs.
+type RestoreURLsResponse []string
+
+// AbuseReportRequest defines the request payload of an abuse report on a short URL.
+type AbuseReportRequest struct {
+	Reason string `json:"reason,omitempty" validate:"max=1024"` // Optional description of the abuse
+}
+
+// AbuseReportsQueueItem represents a short URL waiting for moderation together with its pending abuse reports.
+type AbuseReportsQueueItem struct {
+	ShortURL        string    `json:"short_url"`
+	OriginalURL     string    `json:"original_url"`
+	ReportsCount    int64     `json:"reports_count"`
+	FirstReportedAt time.Time `json:"first_reported_at"`
+	LastReportedAt  time.Time `json:"last_reported_at"`
+	Reasons         []string  `json:"reasons"` // Distinct non-empty reasons given by the reporters
+}
+
+// AbuseReportsQueue is the moderation queue: the reported short URLs, most reported first.
+type AbuseReportsQueue []AbuseReportsQueueItem
+
+// DismissAbuseReportsResponse defines the response payload of the dismissal of a short URL's abuse reports.
+type DismissAbuseReportsResponse struct {
+	Dismissed int64 `json:"dismissed"` // Number of the dismissed reports
+}
+
+// AdminURL represents any short URL as seen by the admins.
+type AdminURL struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	OwnerID     string     `json:"owner_id,omitempty"`   // Owner of the link; empty for shared links
+	CreatedBy   string     `json:"created_by,omitempty"` // ID of the user who shortened the URL
+	CreatedAt   time.Time  `json:"created_at"`
+	IsDeleted   bool       `json:"is_deleted"`
+	DisabledAt  *time.Time `json:"disabled_at,omitempty"` // Time the short URL was disabled by the moderators
+}
+
+// AdminURLs is a slice of AdminURL, returned for the searches of the admins over all short URLs.
+type AdminURLs []AdminURL
+
+// SetUserRoleRequest defines the request payload changing the role of a user.
+type SetUserRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=user admin"`
+}
+
+// ImpersonationResponse defines the response payload of a token issued to an admin to act as a user.
+type ImpersonationResponse struct {
+	Token     string    `json:"token"` // JWT to send in the Authorization header
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SignUpRequest defines the request payload registering an account for the current user.
+// Passwords are limited to the 72 bytes bcrypt hashes; the handler checks the byte length.
+type SignUpRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// SignUpResponse defines the response payload of a registered account.
+type SignUpResponse struct {
+	AuthTokens
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// LoginRequest defines the request payload signing in to an account.
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// AuthTokens defines the tokens authenticating a user: a short-lived access token and
+// a refresh token exchanged for new tokens when it expires.
+type AuthTokens struct {
+	Token        string    `json:"token"`         // Access JWT to send in the Authorization header
+	ExpiresAt    time.Time `json:"expires_at"`    // Expiry of the access JWT
+	RefreshToken string    `json:"refresh_token"` // Token to send to POST /api/user/refresh, usable once
+}
+
+// LoginResponse defines the response payload of a successful login.
+type LoginResponse struct {
+	AuthTokens
+	UserID      string `json:"user_id"`
+	ClaimedURLs int64  `json:"claimed_urls"` // Number of the anonymous user's URLs moved to the account
+}
+
+// RefreshTokenRequest defines the request payload of the refresh and logout endpoints. The refresh
+// token may be omitted when it is sent in the refresh cookie.
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshTokenRecord is a stored refresh token.
+type RefreshTokenRecord struct {
+	UserID    string    // ID of the user the token was issued to
+	ExpiresAt time.Time // Time after which the token is not accepted
+}
+
 // ErrURLMarkedAsDeleted is returned when an attempt is made to access or modify a URL that is marked as deleted.
 var ErrURLMarkedAsDeleted = errors.New("the URL marked as deleted")
 
+// ErrURLClicksExhausted is returned when a short URL limited to a number of redirects has none left.
+var ErrURLClicksExhausted = errors.New("the URL has no clicks left")
+
+// ErrURLDisabled is returned when a short URL has been disabled by the moderators.
+var ErrURLDisabled = errors.New("the URL is disabled")
+
+// ErrURLNotFound is returned when a short URL does not exist, is deleted, or is not linked to the user.
+var ErrURLNotFound = errors.New("the URL not found")
+
+// ErrURLNotOwned is returned when a user tries to modify a short URL owned by, or shared with, other users.
+var ErrURLNotOwned = errors.New("the URL is not owned by the user")
+
+// ErrURLAlreadyShortened is returned when a short URL is retargeted to an original URL
+// that already has a short URL in the same ownership scope.
+var ErrURLAlreadyShortened = errors.New("the URL is already shortened")
+
+// ErrUserNotFound is returned when a user does not exist.
+var ErrUserNotFound = errors.New("the user not found")
+
+// ErrEmailTaken is returned when an account is registered with the email of another one.
+var ErrEmailTaken = errors.New("the email is already registered")
+
+// ErrUserAlreadyRegistered is returned when an account is registered for a user who already has one.
+var ErrUserAlreadyRegistered = errors.New("the user is already registered")
+
+// ErrInvalidCredentials is returned when the email or the password given to sign in is wrong.
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
 // URLDeleteJob defines a deletion task associated with a specific user.
 // Used in background deletion queues.
 type URLDeleteJob struct {
@@ -2,29 +2,58 @@ package router
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"html/template"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/thoas/go-funk"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/language"
 
 	gzippedHttp "github.com/patric-chuzhbe/urlshrt/internal/gzippedhttp"
 
 	"github.com/patric-chuzhbe/urlshrt/internal/auth"
 	"github.com/patric-chuzhbe/urlshrt/internal/logger"
 	"github.com/patric-chuzhbe/urlshrt/internal/models"
+	"github.com/patric-chuzhbe/urlshrt/internal/urlscanner"
+	"github.com/patric-chuzhbe/urlshrt/internal/user"
+	"github.com/patric-chuzhbe/urlshrt/internal/useragent"
 )
 
+type tokenIssuer interface {
+	IssueImpersonationToken(ctx context.Context, adminID, userID string) (string, time.Time, error)
+
+	IssueUserTokens(ctx context.Context, response http.ResponseWriter, userID string) (models.AuthTokens, error)
+
+	RefreshTokens(response http.ResponseWriter, request *http.Request, refreshToken string) (models.AuthTokens, error)
+
+	Logout(response http.ResponseWriter, request *http.Request, refreshToken string) error
+}
+
 type authenticator interface {
 	AuthenticateUser(h http.Handler) http.Handler
 	RegisterNewUser(h http.Handler) http.Handler
+	tokenIssuer
 }
 
 type urlsRemover interface {
@@ -35,15 +64,39 @@ type userUrlsKeeper interface {
 	GetUserUrls(
 		ctx context.Context,
 		userID string,
+		query models.UserURLsQuery,
 		shortURLFormatter models.URLFormatter,
 	) (models.UserUrls, error)
 
 	SaveUserUrls(
 		ctx context.Context,
 		userID string,
-		urls []string,
+		shortURLs []string,
 		transaction *sql.Tx,
 	) error
+
+	RetargetUserURL(ctx context.Context, userID, short, full string) error
+
+	SetUserURLActiveFrom(ctx context.Context, userID, short string, activeFrom time.Time) error
+	SetUserURLRedirectRules(ctx context.Context, userID, short string, rules models.RedirectRules) error
+	GetUserURLRedirectRules(ctx context.Context, userID, short string) (models.RedirectRules, error)
+	SetUserURLDestinations(ctx context.Context, userID, short string, destinations models.Destinations) error
+	GetUserURLDestinations(ctx context.Context, userID, short string) (models.Destinations, error)
+
+	RestoreUsersUrls(
+		ctx context.Context,
+		userID string,
+		shortURLs []string,
+		deletedAfter time.Time,
+	) ([]string, error)
+
+	AddUserURLsTags(ctx context.Context, userID string, shortURLs []string, tags []string) ([]string, error)
+
+	RemoveUserURLsTags(ctx context.Context, userID string, shortURLs []string, tags []string) ([]string, error)
+
+	GetUserTags(ctx context.Context, userID string) (models.UserTags, error)
+	GetUserUTMDefaults(ctx context.Context, userID string) (models.UTMParams, error)
+	SetUserUTMDefaults(ctx context.Context, userID string, defaults models.UTMParams) error
 }
 
 type transactioner interface {
@@ -58,20 +111,26 @@ type urlsMapper interface {
 	FindShortsByFulls(
 		ctx context.Context,
 		originalUrls []string,
+		ownerID string,
 		transaction *sql.Tx,
 	) (map[string]string, error)
 
 	SaveNewFullsAndShorts(
 		ctx context.Context,
 		unexistentFullsToShortsMap map[string]string,
+		ownerID string,
+		attributes map[string]models.URLAttributes,
 		transaction *sql.Tx,
 	) error
 
-	FindFullByShort(ctx context.Context, short string) (string, bool, error)
+	FindRedirectByShort(ctx context.Context, short string) (models.URLRedirect, bool, error)
+
+	ConsumeURLClick(ctx context.Context, short string) (int, error)
 
 	FindShortByFull(
 		ctx context.Context,
 		full string,
+		ownerID string,
 		transaction *sql.Tx,
 	) (string, bool, error)
 
@@ -79,18 +138,72 @@ type urlsMapper interface {
 		ctx context.Context,
 		short,
 		full string,
+		ownerID string,
+		attributes models.URLAttributes,
 		transaction *sql.Tx,
 	) error
 }
 
+type abuseReportsKeeper interface {
+	ReportURL(ctx context.Context, short, reporterID, reason string) error
+
+	GetAbuseReportsQueue(ctx context.Context, formatter models.URLFormatter) (models.AbuseReportsQueue, error)
+
+	DisableURL(ctx context.Context, short string) error
+
+	DismissURLReports(ctx context.Context, short string) (int64, error)
+}
+
+type urlsSearcher interface {
+	SearchURLs(ctx context.Context, query models.UserURLsQuery, formatter models.URLFormatter) (models.AdminURLs, error)
+}
+
+type userRoleSetter interface {
+	SetUserRole(ctx context.Context, userID, role string) error
+}
+
+type accountsKeeper interface {
+	RegisterUser(ctx context.Context, userID, email, passwordHash string) error
+
+	GetUserByEmail(ctx context.Context, email string) (*user.User, error)
+
+	ClaimUserURLs(ctx context.Context, anonymousUserID, userID string) (int64, error)
+}
+
+type attemptsLimiter interface {
+	Allow(key string) (bool, time.Duration)
+
+	Fail(key string)
+}
+
 type pinger interface {
 	Ping(ctx context.Context) error
 }
 
+type destinationPolicy interface {
+	Check(rawURL string) error
+}
+
+type urlSafetyPolicy interface {
+	Check(ctx context.Context, rawURL string) error
+}
+
+type urlCanonicalizer interface {
+	Canonicalize(rawURL string) (string, error)
+}
+
+type urlScanner interface {
+	Scan(ctx context.Context, rawURL string) error
+}
+
 type storage interface {
 	userUrlsKeeper
 	transactioner
 	urlsMapper
+	abuseReportsKeeper
+	urlsSearcher
+	userRoleSetter
+	accountsKeeper
 	pinger
 }
 
@@ -101,29 +214,135 @@ type storage interface {
 // It provides handlers for shortening URLs, retrieving user-specific URLs,
 // deleting URLs, and redirecting short URLs to their full versions.
 type Router struct {
-	db           storage
-	shortURLBase string
-	urlsRemover  urlsRemover
-	validator    *validator.Validate
+	db                    storage
+	tokenIssuer           tokenIssuer
+	shortURLBase          string
+	urlsRemover           urlsRemover
+	validator             *validator.Validate
+	maxURLLength          int
+	urlOwnershipMode      string
+	urlRestoreGracePeriod time.Duration
+
+	linkPasswordAttemptsLimiter attemptsLimiter
+	loginAttemptsLimiter        attemptsLimiter
+	destinationPolicy           destinationPolicy
+	urlSafetyPolicy             urlSafetyPolicy
+	urlCanonicalizer            urlCanonicalizer
+	urlScanner                  urlScanner
+	urlScanTimeout              time.Duration
+	urlScanFailOpen             bool
+	redirectStatusCode          int
+	permanentRedirectMaxAge     time.Duration
+
+	interstitialForUntrustedDomains bool
+	trustedDomains                  []string
+
+	disabledLinkStatusCode int
 }
 
+// InitOption defines a functional option for configuring the Router.
+type InitOption func(*Router)
+
 var urlPattern = regexp.MustCompile(`\bhttps?://\S+\b`)
 
+const (
+	defaultUserURLsPageSize = 100
+	maxUserURLsPageSize     = 1000
+	maxRedirectRules        = 50
+	maxDestinations         = 20
+	maxPasswordBytes        = 72 // bcrypt hashes no more; the validator counts runes rather than bytes
+)
+
+// dummyPasswordHash is compared against on sign-in with an unknown email so that the response
+// takes as long as with a registered one and does not reveal which emails are registered.
+const dummyPasswordHash = "$2a$10$C5TruUd2xyJrQKTptL6/VeaJOD8Sbn24fp5QxJ9gH5s4Z1k/kB4PC"
+
+// abVariantCookiePrefix prefixes the short URL in the name of the cookie remembering
+// the A/B variant the visitor was sent to.
+const abVariantCookiePrefix = "ab_"
+
+// abVariantCookieMaxAge is how long a visitor keeps being sent to the same A/B variant.
+const abVariantCookieMaxAge = 30 * 24 * time.Hour
+
+// maxConcurrentURLScans is how many new URLs of a batch are scanned at the same time.
+const maxConcurrentURLScans = 8
+
 // ErrConflict is returned when a short URL already exists for the provided original URL.
 var ErrConflict = errors.New("data conflict")
 
+// ErrURLScanUnavailable is returned when a new URL could not be scanned and the scan does not fail open.
+var ErrURLScanUnavailable = errors.New("the URL could not be checked, try again later")
+
+// linkPasswordHeader is the request header carrying the password of a password-protected link.
+const linkPasswordHeader = "X-Link-Password"
+
+var linkPasswordFormTemplate = template.Must(template.New("linkPasswordForm").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Password required</title>
+</head>
+<body>
+<form method="post">
+{{if .WrongPassword}}<p>Wrong password.</p>{{end}}
+<label>This link is password-protected: <input type="password" name="password" autofocus></label>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+var linkPreviewTemplate = template.Must(template.New("linkPreview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Link preview</title>
+</head>
+<body>
+{{if .Title}}<h1>{{.Title}}</h1>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .PasswordProtected}}<p>This link is password-protected: its destination is not shown.</p>
+{{else}}<p>This link leads to <a href="{{.Destination}}" rel="noopener noreferrer nofollow">{{.Destination}}</a></p>
+{{if .MayVary}}<p>Depending on your device, language or a running experiment, you may be sent to another page.</p>{{end}}
+{{end}}<p>Created on {{.CreatedAt.Format "2006-01-02"}}</p>
+</body>
+</html>
+`))
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>You are leaving</title>
+</head>
+<body>
+<p>You are leaving for {{.Host}}, a site that is not on our list of trusted ones.</p>
+{{if .Title}}<p>The link is titled: {{.Title}}</p>{{end}}
+<p><a href="{{.Destination}}" rel="noopener noreferrer nofollow">Continue to {{.Destination}}</a></p>
+</body>
+</html>
+`))
+
+// ErrURLTooLong is returned when the URL to shorten exceeds the configured maximum length.
+var ErrURLTooLong = errors.New("the URL is too long")
+
 // New initializes and returns a new HTTP Router with middleware and handlers.
 func New(
 	database storage,
 	shortURLBase string,
 	auth authenticator,
 	urlsRemover urlsRemover,
+	optionsProto ...InitOption,
 ) *chi.Mux {
 	myRouter := Router{
 		db:           database,
+		tokenIssuer:  auth,
 		shortURLBase: shortURLBase,
 		urlsRemover:  urlsRemover,
 	}
+	for _, protoOption := range optionsProto {
+		protoOption(&myRouter)
+	}
 	router := chi.NewRouter()
 
 	router.Use(
@@ -139,32 +358,260 @@ func New(
 
 	router.Get(`/{short}`, myRouter.GetRedirecttofullurl)
 
-	router.With(
-		gzippedHttp.GzipResponse,
-		auth.AuthenticateUser,
-		auth.RegisterNewUser,
-	).Post(`/api/shorten`, myRouter.PostApishorten)
+	router.Post(`/{short}`, myRouter.GetRedirecttofullurl)
+
+	router.Get(`/{short}/*`, myRouter.GetRedirecttofullurl)
+
+	router.Post(`/{short}/*`, myRouter.GetRedirecttofullurl)
 
 	router.Get(`/ping`, myRouter.GetPing)
 
-	router.With(
-		gzippedHttp.GzipResponse,
-		auth.AuthenticateUser,
-		auth.RegisterNewUser,
-	).Post(`/api/shorten/batch`, myRouter.PostApishortenbatch)
+	// The API is routed apart, so that its paths are never taken for short URLs followed by a passthrough path.
+	router.Route(`/api`, func(apiRouter chi.Router) {
+		apiRouter.With(
+			gzippedHttp.GzipResponse,
+			auth.AuthenticateUser,
+			auth.RegisterNewUser,
+		).Post(`/shorten`, myRouter.PostApishorten)
 
-	router.With(
-		auth.AuthenticateUser,
-		auth.RegisterNewUser,
-	).Get(`/api/user/urls`, myRouter.GetApiuserurls)
+		apiRouter.With(
+			gzippedHttp.GzipResponse,
+			auth.AuthenticateUser,
+			auth.RegisterNewUser,
+		).Post(`/shorten/batch`, myRouter.PostApishortenbatch)
 
-	router.With(
-		auth.AuthenticateUser,
-	).Delete(`/api/user/urls`, myRouter.DeleteApiuserurls)
+		apiRouter.With(
+			auth.AuthenticateUser,
+			auth.RegisterNewUser,
+		).Get(`/user/urls`, myRouter.GetApiuserurls)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Delete(`/user/urls`, myRouter.DeleteApiuserurls)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Patch(`/user/urls/{short}`, myRouter.PatchApiuserurl)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Put(`/user/urls/{short}/active_from`, myRouter.PutApiuserurlactivefrom)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Get(`/user/urls/{short}/rules`, myRouter.GetApiuserurlrules)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Put(`/user/urls/{short}/rules`, myRouter.PutApiuserurlrules)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Get(`/user/urls/{short}/destinations`, myRouter.GetApiuserurldestinations)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Put(`/user/urls/{short}/destinations`, myRouter.PutApiuserurldestinations)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Post(`/user/urls/{short}/restore`, myRouter.PostApiuserurlrestore)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Post(`/user/urls/restore`, myRouter.PostApiuserurlsrestore)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Post(`/user/urls/tags`, myRouter.PostApiuserurlstags)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Delete(`/user/urls/tags`, myRouter.DeleteApiuserurlstags)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Get(`/user/tags`, myRouter.GetApiusertags)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Get(`/user/utm`, myRouter.GetApiuserutm)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Put(`/user/utm`, myRouter.PutApiuserutm)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+			auth.RegisterNewUser,
+		).Post(`/user/register`, myRouter.PostApiuserregister)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+		).Post(`/user/login`, myRouter.PostApiuserlogin)
+
+		apiRouter.Post(`/user/refresh`, myRouter.PostApiuserrefresh)
+
+		apiRouter.Post(`/user/logout`, myRouter.PostApiuserlogout)
+
+		apiRouter.With(
+			auth.AuthenticateUser,
+			auth.RegisterNewUser,
+		).Post(`/report/{short}`, myRouter.PostApireport)
+
+		// Every request to the admin API is audit-logged, the rejected ones included.
+		apiRouter.Route(`/admin`, func(adminRouter chi.Router) {
+			adminRouter.Use(auth.AuthenticateUser, auditAdminRequest, requireAdmin)
+
+			adminRouter.Get(`/reports`, myRouter.GetApiadminreports)
+
+			adminRouter.Post(`/reports/{short}/disable`, myRouter.PostApiadminurldisable)
+
+			adminRouter.Post(`/reports/{short}/dismiss`, myRouter.PostApiadminreportdismiss)
+
+			adminRouter.Get(`/urls`, myRouter.GetApiadminurls)
+
+			adminRouter.Post(`/urls/{short}/disable`, myRouter.PostApiadminurldisable)
+
+			adminRouter.Get(`/users/{userID}/urls`, myRouter.GetApiadminuserurls)
+
+			adminRouter.Put(`/users/{userID}/role`, myRouter.PutApiadminuserrole)
+
+			adminRouter.Post(`/users/{userID}/impersonate`, myRouter.PostApiadminuserimpersonate)
+		})
+	})
 
 	return router
 }
 
+// WithMaxURLLength limits the length of URLs accepted for shortening. Zero disables the limit.
+func WithMaxURLLength(value int) InitOption {
+	return func(theRouter *Router) {
+		theRouter.maxURLLength = value
+	}
+}
+
+// WithURLOwnershipMode sets the URL ownership mode (models.URLOwnershipModeShared
+// or models.URLOwnershipModePerUser) applied to newly shortened URLs.
+func WithURLOwnershipMode(value string) InitOption {
+	return func(theRouter *Router) {
+		theRouter.urlOwnershipMode = value
+	}
+}
+
+// WithLinkPasswordAttemptsLimiter sets the limiter of wrong passwords entered for
+// password-protected links, keyed by the short URL. Without it the attempts are not limited.
+func WithLinkPasswordAttemptsLimiter(value attemptsLimiter) InitOption {
+	return func(theRouter *Router) {
+		theRouter.linkPasswordAttemptsLimiter = value
+	}
+}
+
+// WithLoginAttemptsLimiter sets the limiter of wrong passwords entered to sign in to accounts,
+// keyed by the email. Without it the attempts are not limited.
+func WithLoginAttemptsLimiter(value attemptsLimiter) InitOption {
+	return func(theRouter *Router) {
+		theRouter.loginAttemptsLimiter = value
+	}
+}
+
+// WithDestinationPolicy sets the policy deciding which destination domains may be shortened.
+// Without it any destination may be.
+func WithDestinationPolicy(value destinationPolicy) InitOption {
+	return func(theRouter *Router) {
+		theRouter.destinationPolicy = value
+	}
+}
+
+// WithURLSafetyPolicy sets the policy keeping URLs leading to internal addresses or using
+// unexpected schemes from being shortened. Without it only the URL syntax is checked.
+func WithURLSafetyPolicy(value urlSafetyPolicy) InitOption {
+	return func(theRouter *Router) {
+		theRouter.urlSafetyPolicy = value
+	}
+}
+
+// WithURLCanonicalizer sets the canonicalizer the URLs are brought to their canonical form with
+// before being looked up and stored, so that the URLs written differently are shortened once.
+// Without it the URLs are stored as given.
+func WithURLCanonicalizer(value urlCanonicalizer) InitOption {
+	return func(theRouter *Router) {
+		theRouter.urlCanonicalizer = value
+	}
+}
+
+// WithURLScanner sets the scanner every new URL is checked with before it is stored.
+// The URLs reported as malicious are rejected. Without it the URLs are not scanned.
+func WithURLScanner(value urlScanner) InitOption {
+	return func(theRouter *Router) {
+		theRouter.urlScanner = value
+	}
+}
+
+// WithURLScanTimeout sets how long the scan of a URL, or of all the new URLs of a batch,
+// may take. Without it the scans are only limited by the request.
+func WithURLScanTimeout(value time.Duration) InitOption {
+	return func(theRouter *Router) {
+		theRouter.urlScanTimeout = value
+	}
+}
+
+// WithURLScanFailOpen sets whether the URLs that could not be scanned, because the scanner
+// failed or timed out, are accepted. By default they are rejected with 503 Service Unavailable.
+func WithURLScanFailOpen(value bool) InitOption {
+	return func(theRouter *Router) {
+		theRouter.urlScanFailOpen = value
+	}
+}
+
+// WithRedirectStatusCode sets the status code of redirects from short URLs
+// that were not given their own one. Defaults to 307 Temporary Redirect.
+func WithRedirectStatusCode(value int) InitOption {
+	return func(theRouter *Router) {
+		theRouter.redirectStatusCode = value
+	}
+}
+
+// WithPermanentRedirectMaxAge sets how long clients may cache permanent (301, 308) redirects.
+// Without it permanent redirects are not cached either.
+func WithPermanentRedirectMaxAge(value time.Duration) InitOption {
+	return func(theRouter *Router) {
+		theRouter.permanentRedirectMaxAge = value
+	}
+}
+
+// WithInterstitialForUntrustedDomains makes every short URL show the "you are leaving" page
+// instead of redirecting to a domain that is not trusted, as if every link asked for it.
+func WithInterstitialForUntrustedDomains(value bool) InitOption {
+	return func(theRouter *Router) {
+		theRouter.interstitialForUntrustedDomains = value
+	}
+}
+
+// WithTrustedDomains sets the domains, subdomains included, redirected to without
+// the "you are leaving" page.
+func WithTrustedDomains(value []string) InitOption {
+	return func(theRouter *Router) {
+		theRouter.trustedDomains = value
+	}
+}
+
+// WithDisabledLinkStatusCode sets the status code answering the short URLs disabled by the moderators:
+// 410 Gone or 451 Unavailable For Legal Reasons. Defaults to 410 Gone.
+func WithDisabledLinkStatusCode(value int) InitOption {
+	return func(theRouter *Router) {
+		theRouter.disabledLinkStatusCode = value
+	}
+}
+
+// WithURLRestoreGracePeriod sets the period during which deleted URLs can be restored.
+// Zero allows restoring them at any time.
+func WithURLRestoreGracePeriod(value time.Duration) InitOption {
+	return func(theRouter *Router) {
+		theRouter.urlRestoreGracePeriod = value
+	}
+}
+
 // DeleteApiuserurls asynchronously enqueues a job to delete user-owned URLs.
 // Responds with 202 if accepted or 401/422/500 on error.
 func (theRouter Router) DeleteApiuserurls(response http.ResponseWriter, request *http.Request) {
@@ -197,173 +644,164 @@ func (theRouter Router) DeleteApiuserurls(response http.ResponseWriter, request
 	response.WriteHeader(http.StatusAccepted)
 }
 
-// GetApiuserurls returns all user-specific shortened URLs in JSON format.
-// Responds with 200 and the list or 204 if no URLs exist.
-func (theRouter Router) GetApiuserurls(response http.ResponseWriter, request *http.Request) {
+// PostApiuserurlrestore restores a deleted short URL of the user within the restore grace period.
+// Responds with 204 if restored, 401 if unauthenticated, 404 if there is nothing to restore, or 500 on error.
+func (theRouter Router) PostApiuserurlrestore(response http.ResponseWriter, request *http.Request) {
 	userID, ok := request.Context().Value(auth.UserIDKey).(string)
 	if !ok || userID == "" {
 		response.WriteHeader(http.StatusUnauthorized)
 
 		return
 	}
-	responseDTO, err := theRouter.db.GetUserUrls(request.Context(), userID, theRouter.getShortURL)
+
+	restored, err := theRouter.db.RestoreUsersUrls(
+		request.Context(),
+		userID,
+		[]string{chi.URLParam(request, "short")},
+		theRouter.getRestoreDeadline(),
+	)
 	if err != nil {
-		logger.Log.Debugln("Error calling the `theRouter.db.GetUserUrls()`: ", zap.Error(err))
+		logger.Log.Debugln("Error calling the `theRouter.db.RestoreUsersUrls()`: ", zap.Error(err))
 		response.WriteHeader(http.StatusInternalServerError)
 
 		return
 	}
 
-	if len(responseDTO) == 0 {
-		response.WriteHeader(http.StatusNoContent)
+	if len(restored) == 0 {
+		response.WriteHeader(http.StatusNotFound)
 
 		return
 	}
 
-	response.Header().Set("Content-Type", "application/json")
-
-	err = json.NewEncoder(response).Encode(responseDTO)
-	if err != nil {
-		logger.Log.Debug("error encoding response", zap.Error(err))
-
-		return
-	}
+	response.WriteHeader(http.StatusNoContent)
 }
 
-// PostApishortenbatch handles batch URL shortening via API.
-// Accepts a list of URLs and returns their short mappings.
-func (theRouter Router) PostApishortenbatch(response http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodPost {
-		logger.Log.Debug("got request with bad method", zap.String("method", request.Method))
-		response.WriteHeader(http.StatusMethodNotAllowed)
+// PostApiuserurlsrestore restores a batch of deleted short URLs of the user within the restore grace period.
+// Responds with 200 and the list of restored short URLs, or 401/422/500 on error.
+func (theRouter Router) PostApiuserurlsrestore(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
 
 		return
 	}
 
-	var requestDTO models.BatchShortenRequest
-	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+	var URLsToRestore models.RestoreURLsRequest
+	if err := json.NewDecoder(request.Body).Decode(&URLsToRestore); err != nil {
 		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
 		response.WriteHeader(http.StatusInternalServerError)
-
 		return
 	}
 
 	validate := validator.New()
-	if err := validate.Var(requestDTO, "dive"); err != nil {
+	if err := validate.Var(URLsToRestore, "dive"); err != nil {
 		logger.Log.Debugln("incorrect request structure", zap.Error(err))
 		response.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
 
-	transaction, err := theRouter.db.BeginTransaction()
+	restored, err := theRouter.db.RestoreUsersUrls(
+		request.Context(),
+		userID,
+		URLsToRestore,
+		theRouter.getRestoreDeadline(),
+	)
 	if err != nil {
-		logger.Log.Debugln("Error calling the `theRouter.db.BeginTransaction()`: ", zap.Error(err))
+		logger.Log.Debugln("Error calling the `theRouter.db.RestoreUsersUrls()`: ", zap.Error(err))
 		response.WriteHeader(http.StatusInternalServerError)
 
 		return
 	}
 
-	originalURLToCorrelationIDMap := theRouter.getOriginalURLToCorrelationIDMap(requestDTO)
-
-	originalUrls := funk.Keys(originalURLToCorrelationIDMap).([]string)
-
-	existentFullsToShortsMap, err := theRouter.db.FindShortsByFulls(request.Context(), originalUrls, transaction)
-	if err != nil {
-		err2 := theRouter.db.RollbackTransaction(transaction)
-		if err2 != nil {
-			logger.Log.Debugln("Error calling the `theRouter.db.RollbackTransaction()`: ", zap.Error(err2))
-		}
-		logger.Log.Debugln("Error calling the `theRouter.db.FindShortsByFulls()`: ", zap.Error(err))
-		response.WriteHeader(http.StatusInternalServerError)
+	response.Header().Set("Content-Type", "application/json")
 
+	if err := json.NewEncoder(response).Encode(models.RestoreURLsResponse(restored)); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
 		return
 	}
+}
 
-	existentFulls := funk.Keys(existentFullsToShortsMap).([]string)
-	unexistentFulls := differenceStringSlices(originalUrls, existentFulls)
-	unexistentFullsToShortsMap := theRouter.getUnexistentFullsToShortsMap(unexistentFulls)
-	err = theRouter.db.SaveNewFullsAndShorts(request.Context(), unexistentFullsToShortsMap, transaction)
-	if err != nil {
-		err2 := theRouter.db.RollbackTransaction(transaction)
-		if err2 != nil {
-			logger.Log.Debugln("Error calling the `theRouter.db.RollbackTransaction()`: ", zap.Error(err2))
-		}
-		logger.Log.Debugln("Error calling the `theRouter.db.SaveNewFullsAndShorts()`: ", zap.Error(err))
-		response.WriteHeader(http.StatusInternalServerError)
+// PostApiuserurlstags attaches tags to a batch of the user's short URLs.
+// Tags are trimmed and lowercased. Responds with 200 and the list of tagged short URLs, or 401/422/500 on error.
+func (theRouter Router) PostApiuserurlstags(response http.ResponseWriter, request *http.Request) {
+	theRouter.changeUserURLsTags(response, request, "AddUserURLsTags", theRouter.db.AddUserURLsTags)
+}
 
-		return
-	}
+// DeleteApiuserurlstags detaches tags from a batch of the user's short URLs.
+// Responds with 200 and the list of affected short URLs, or 401/422/500 on error.
+func (theRouter Router) DeleteApiuserurlstags(response http.ResponseWriter, request *http.Request) {
+	theRouter.changeUserURLsTags(response, request, "RemoveUserURLsTags", theRouter.db.RemoveUserURLsTags)
+}
 
+// GetApiusertags returns the user's tags with the number of the user's URLs carrying each, ordered by tag.
+// Responds with 200 and the list, 204 if the user has no tags, or 401/500 on error.
+func (theRouter Router) GetApiusertags(response http.ResponseWriter, request *http.Request) {
 	userID, ok := request.Context().Value(auth.UserIDKey).(string)
-	if !ok {
-		logger.Log.Debugln("The `userID` value was not found in the request's context")
+	if !ok || userID == "" {
 		response.WriteHeader(http.StatusUnauthorized)
 
 		return
 	}
 
-	err = theRouter.db.SaveUserUrls(
-		request.Context(),
-		userID,
-		funk.Uniq(funk.Union(existentFulls, unexistentFulls)).([]string),
-		transaction,
-	)
+	responseDTO, err := theRouter.db.GetUserTags(request.Context(), userID)
 	if err != nil {
-		err2 := theRouter.db.RollbackTransaction(transaction)
-		if err2 != nil {
-			logger.Log.Debugln("Error calling the `theRouter.db.RollbackTransaction()`: ", zap.Error(err2))
-		}
-		logger.Log.Debugln("Error calling the `theRouter.db.SaveUserUrls()`: ", zap.Error(err))
+		logger.Log.Debugln("Error calling the `theRouter.db.GetUserTags()`: ", zap.Error(err))
 		response.WriteHeader(http.StatusInternalServerError)
 
 		return
 	}
 
-	responseDTO := theRouter.getPostApishortenbatchResponse(
-		existentFullsToShortsMap,
-		unexistentFullsToShortsMap,
-		originalURLToCorrelationIDMap,
-	)
-
-	err = theRouter.db.CommitTransaction(transaction)
-	if err != nil {
-		logger.Log.Debugln("Error calling the `theRouter.db.CommitTransaction()`: ", zap.Error(err))
-		response.WriteHeader(http.StatusInternalServerError)
+	if len(responseDTO) == 0 {
+		response.WriteHeader(http.StatusNoContent)
 
 		return
 	}
 
 	response.Header().Set("Content-Type", "application/json")
-	response.WriteHeader(http.StatusCreated)
 
 	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
 		logger.Log.Debug("error encoding response", zap.Error(err))
-
 		return
 	}
 }
 
-// GetPing is a healthcheck handler that returns 200 OK if the DB is reachable.
-func (theRouter Router) GetPing(response http.ResponseWriter, request *http.Request) {
-	err := theRouter.db.Ping(request.Context())
+// GetApiuserutm returns the default UTM parameters of the user, completing the `utm` fields
+// of the user's shorten requests. Responds with 200 and the parameters, or 401/500 on error.
+func (theRouter Router) GetApiuserutm(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	responseDTO, err := theRouter.db.GetUserUTMDefaults(request.Context(), userID)
 	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.GetUserUTMDefaults()`: ", zap.Error(err))
 		response.WriteHeader(http.StatusInternalServerError)
 
 		return
 	}
-	response.WriteHeader(http.StatusOK)
+
+	response.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+		return
+	}
 }
 
-// PostApishorten handles API requests to shorten a single URL.
-// Accepts a JSON body and responds with a JSON containing the short URL.
-func (theRouter Router) PostApishorten(response http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodPost {
-		logger.Log.Debug("got request with bad method", zap.String("method", request.Method))
-		response.WriteHeader(http.StatusMethodNotAllowed)
+// PutApiuserutm replaces the default UTM parameters of the user.
+// Responds with 204 if replaced, 401 if unauthenticated, 422 if a parameter is too long, or 500 on error.
+func (theRouter Router) PutApiuserutm(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
 		return
 	}
 
-	var requestDTO models.ShortenRequest
+	var requestDTO models.UTMParams
 	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
 		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
 		response.WriteHeader(http.StatusInternalServerError)
@@ -377,178 +815,2406 @@ func (theRouter Router) PostApishorten(response http.ResponseWriter, request *ht
 		return
 	}
 
-	userID, ok := request.Context().Value(auth.UserIDKey).(string)
-	if !ok {
-		logger.Log.Debugln("The `userID` value was not found in the request's context")
+	err := theRouter.db.SetUserUTMDefaults(request.Context(), userID, requestDTO)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.SetUserUTMDefaults()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// PostApiuserregister registers an account for the current user (models.SignUpRequest), to sign in to
+// with PostApiuserlogin from other browsers and devices and keep the links after the cookies are
+// cleared. The user keeps their ID and links; a user is registered for the requests without one.
+// The user is signed in as with PostApiuserlogin: anonymous users get no refresh token until then.
+// Responds with 201 and the account and its tokens (models.SignUpResponse), 403 if the request is impersonated,
+// 409 if the user has an account already or another account has the email, or 422/500 on error.
+func (theRouter Router) PostApiuserregister(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
 		response.WriteHeader(http.StatusUnauthorized)
+
 		return
 	}
 
-	urlToShort := requestDTO.URL
-	shortKey, err := theRouter.getShortKey(request.Context(), urlToShort, userID)
-	if err != nil && !errors.Is(err, ErrConflict) {
-		logger.Log.Debugln("error while `theRouter.getShortKey()` calling: ", zap.Error(err))
+	if impersonatorID, _ := request.Context().Value(auth.ImpersonatorIDKey).(string); impersonatorID != "" {
+		response.WriteHeader(http.StatusForbidden)
+
+		return
+	}
+
+	var requestDTO models.SignUpRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
 		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	shortURL := theRouter.getShortURL(shortKey)
 
-	responseDTO := models.ShortenResponse{Result: shortURL}
+	validate := validator.New()
+	if err := validate.Struct(requestDTO); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	if isPasswordTooLong(requestDTO.Password) {
+		logger.Log.Debugln("the password is too long", zap.Int("bytes", len(requestDTO.Password)))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
 
-	response.Header().Set("Content-Type", "application/json")
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(requestDTO.Password), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `bcrypt.GenerateFromPassword()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	resultStatus := http.StatusCreated
-	if errors.Is(err, ErrConflict) {
-		resultStatus = http.StatusConflict
+	email := strings.ToLower(requestDTO.Email)
+	err = theRouter.db.RegisterUser(request.Context(), userID, email, string(passwordHash))
+	if errors.Is(err, models.ErrEmailTaken) || errors.Is(err, models.ErrUserAlreadyRegistered) {
+		writeJSONResponse(response, http.StatusConflict, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.RegisterUser()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	response.WriteHeader(resultStatus)
 
-	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
-		logger.Log.Debug("error encoding response", zap.Error(err))
+	tokens, err := theRouter.tokenIssuer.IssueUserTokens(request.Context(), response, userID)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.tokenIssuer.IssueUserTokens()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	writeJSONResponse(response, http.StatusCreated, models.SignUpResponse{
+		AuthTokens: tokens,
+		UserID:     userID,
+		Email:      email,
+	})
 }
 
-// GetRedirecttofullurl redirects short URLs to their original URL if found.
-// Responds with 307 Temporary Redirect or 404 if not found.
-func (theRouter Router) GetRedirecttofullurl(res http.ResponseWriter, req *http.Request) {
-	short := chi.URLParam(req, "short")
-	full, found, err := theRouter.db.FindFullByShort(req.Context(), short)
-	if errors.Is(err, models.ErrURLMarkedAsDeleted) {
-		res.WriteHeader(http.StatusGone)
+// PostApiuserlogin signs in to an account (models.LoginRequest). The links of the anonymous user
+// the request comes from, if any, are claimed into the account, so that the links shortened before
+// signing in are not lost. The access token authenticating the account is returned in the Authorization
+// header, the auth cookie and the JSON body (models.LoginResponse), the refresh token renewing it in
+// the refresh cookie and the JSON body. Responds with 200 and the tokens,
+// 401 if the email or the password is wrong, 403 if the request is impersonated, 429 if too many
+// wrong passwords were given for the email recently, or 422/500 on error.
+func (theRouter Router) PostApiuserlogin(response http.ResponseWriter, request *http.Request) {
+	if impersonatorID, _ := request.Context().Value(auth.ImpersonatorIDKey).(string); impersonatorID != "" {
+		response.WriteHeader(http.StatusForbidden)
+
+		return
+	}
+
+	var requestDTO models.LoginRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(requestDTO); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
+
+	email := strings.ToLower(requestDTO.Email)
+	if theRouter.loginAttemptsLimiter != nil {
+		allowed, retryAfter := theRouter.loginAttemptsLimiter.Allow(email)
+		if !allowed {
+			response.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			response.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	account, err := theRouter.db.GetUserByEmail(request.Context(), email)
 	if err != nil {
-		logger.Log.Debugln("error while `theRouter.db.FindFullByShort()` calling: ", zap.Error(err))
-		res.WriteHeader(http.StatusInternalServerError)
+		logger.Log.Debugln("Error calling the `theRouter.db.GetUserByEmail()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if !found {
-		res.WriteHeader(http.StatusNotFound)
+
+	passwordHash := account.PasswordHash
+	if account.ID == "" {
+		passwordHash = dummyPasswordHash
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(requestDTO.Password)) != nil ||
+		account.ID == "" {
+		if theRouter.loginAttemptsLimiter != nil {
+			theRouter.loginAttemptsLimiter.Fail(email)
+		}
+		logger.Log.Infoln("wrong credentials to sign in", zap.String("email", email))
+		writeJSONResponse(
+			response,
+			http.StatusUnauthorized,
+			models.ErrorResponse{Error: models.ErrInvalidCredentials.Error()},
+		)
+		return
+	}
+
+	var claimed int64
+	if userID, _ := request.Context().Value(auth.UserIDKey).(string); userID != "" && userID != account.ID {
+		claimed, err = theRouter.db.ClaimUserURLs(request.Context(), userID, account.ID)
+		if err != nil {
+			logger.Log.Debugln("Error calling the `theRouter.db.ClaimUserURLs()`: ", zap.Error(err))
+			response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tokens, err := theRouter.tokenIssuer.IssueUserTokens(request.Context(), response, account.ID)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.tokenIssuer.IssueUserTokens()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(res, req, full, http.StatusTemporaryRedirect)
+
+	writeJSONResponse(response, http.StatusOK, models.LoginResponse{
+		AuthTokens:  tokens,
+		UserID:      account.ID,
+		ClaimedURLs: claimed,
+	})
 }
 
-// PostShorten handles plain text full URL.
-// Responds with a plain text short URL or 409 on conflict.
-func (theRouter Router) PostShorten(response http.ResponseWriter, request *http.Request) {
-	urlToShort, err := getURLToShort(request)
+// PostApiuserrefresh exchanges a refresh token for new tokens, returned as on login (models.AuthTokens).
+// The refresh token is given in the optional JSON body (models.RefreshTokenRequest) or the refresh cookie,
+// and is used up. Responds with 200 and the tokens, 401 if the refresh token is unknown, used up
+// or expired, or 500 on error.
+func (theRouter Router) PostApiuserrefresh(response http.ResponseWriter, request *http.Request) {
+	var requestDTO models.RefreshTokenRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil && !errors.Is(err, io.EOF) {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := theRouter.tokenIssuer.RefreshTokens(response, request, requestDTO.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		writeJSONResponse(response, http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
+		return
+	}
 	if err != nil {
-		http.Error(response, err.Error(), http.StatusBadRequest)
+		logger.Log.Debugln("Error calling the `theRouter.tokenIssuer.RefreshTokens()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(response, http.StatusOK, tokens)
+}
+
+// PostApiuserlogout signs out: the access token of the request is revoked, the refresh token given in
+// the optional JSON body (models.RefreshTokenRequest) or the refresh cookie is deleted, and the auth
+// cookies are cleared. Responds with 204, or 500 on error.
+func (theRouter Router) PostApiuserlogout(response http.ResponseWriter, request *http.Request) {
+	var requestDTO models.RefreshTokenRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil && !errors.Is(err, io.EOF) {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err := theRouter.tokenIssuer.Logout(response, request, requestDTO.RefreshToken)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.tokenIssuer.Logout()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// PostApireport files an abuse report on a short URL, with an optional JSON body giving the reason
+// (models.AbuseReportRequest). Anyone may report: a user is registered for reporters without one.
+// The reports land in the moderation queue of the admin API; a repeated report of the same user
+// counts once. Responds with 202 if accepted, 404 if there is no such short URL, or 422/500 on error.
+func (theRouter Router) PostApireport(response http.ResponseWriter, request *http.Request) {
 	userID, ok := request.Context().Value(auth.UserIDKey).(string)
-	if !ok {
-		logger.Log.Debugln("The `userID` value was not found in the request's context")
+	if !ok || userID == "" {
 		response.WriteHeader(http.StatusUnauthorized)
+
 		return
 	}
 
-	shortKey, err := theRouter.getShortKey(request.Context(), urlToShort, userID)
-	if err != nil && !errors.Is(err, ErrConflict) {
-		logger.Log.Debugln("error while `theRouter.getShortKey()` calling: ", zap.Error(err))
-		http.Error(response, err.Error(), http.StatusInternalServerError)
+	var requestDTO models.AbuseReportRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil && !errors.Is(err, io.EOF) {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	resultStatus := http.StatusCreated
-	if errors.Is(err, ErrConflict) {
-		resultStatus = http.StatusConflict
+	validate := validator.New()
+	if err := validate.Struct(requestDTO); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
 	}
-	response.WriteHeader(resultStatus)
 
-	_, err = response.Write([]byte(theRouter.getShortURL(shortKey)))
+	err := theRouter.db.ReportURL(request.Context(), chi.URLParam(request, "short"), userID, requestDTO.Reason)
+	if errors.Is(err, models.ErrURLNotFound) {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(response, err.Error(), http.StatusBadRequest)
+		logger.Log.Debugln("Error calling the `theRouter.db.ReportURL()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	response.WriteHeader(http.StatusAccepted)
 }
 
-func differenceStringSlices(a, b []string) []string {
-	bSet := make(map[string]struct{}, len(b))
-	for _, item := range b {
-		bSet[item] = struct{}{}
+// GetApiadminreports returns the moderation queue: the short URLs with pending abuse reports,
+// the most reported first. Responds with 200 and the queue, 204 if it is empty, or 500 on error.
+func (theRouter Router) GetApiadminreports(response http.ResponseWriter, request *http.Request) {
+	responseDTO, err := theRouter.db.GetAbuseReportsQueue(request.Context(), theRouter.getShortURL)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.GetAbuseReportsQueue()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
 	}
 
-	var diff []string
-	for _, item := range a {
-		if _, found := bSet[item]; !found {
-			diff = append(diff, item)
-		}
+	if len(responseDTO) == 0 {
+		response.WriteHeader(http.StatusNoContent)
+
+		return
 	}
-	return diff
+
+	writeJSONResponse(response, http.StatusOK, responseDTO)
 }
 
-func (theRouter Router) getValidator() *validator.Validate {
-	if theRouter.validator == nil {
-		theRouter.validator = validator.New()
+// PostApiadminurldisable disables any short URL for everyone and marks its pending abuse reports
+// as actioned. Unlike a deletion by its users, which they may undo, the short URL stays disabled
+// and answers with the configured status code (see GetRedirecttofullurl). Served both for the
+// reported short URLs of the moderation queue and for any other one.
+// Responds with 204 if disabled, 404 if there is no such short URL, or 500 on error.
+func (theRouter Router) PostApiadminurldisable(response http.ResponseWriter, request *http.Request) {
+	err := theRouter.db.DisableURL(request.Context(), chi.URLParam(request, "short"))
+	if errors.Is(err, models.ErrURLNotFound) {
+		response.WriteHeader(http.StatusNotFound)
+		return
 	}
-	return theRouter.validator
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.DisableURL()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
 }
 
-func (theRouter Router) fillThePostApishortenbatchResponse(
-	response *models.BatchShortenResponse,
-	fullsToShortsMap map[string]string,
-	originalURLToCorrelationIDMap map[string]string,
-) {
-	for full, short := range fullsToShortsMap {
-		*response = append(
-			*response,
-			models.BatchShortenResponseItem{
-				CorrelationID: originalURLToCorrelationIDMap[full],
-				ShortURL:      theRouter.getShortURL(short),
-			},
-		)
+// PostApiadminreportdismiss dismisses the pending abuse reports on a short URL, taking it out of
+// the moderation queue. Responds with 200 and the number of dismissed reports, 404 if the short URL
+// has no pending reports, or 500 on error.
+func (theRouter Router) PostApiadminreportdismiss(response http.ResponseWriter, request *http.Request) {
+	dismissed, err := theRouter.db.DismissURLReports(request.Context(), chi.URLParam(request, "short"))
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.DismissURLReports()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-}
 
-func (theRouter Router) getPostApishortenbatchResponse(
-	existentFullsToShortsMap map[string]string,
-	unexistentFullsToShortsMap map[string]string,
-	originalURLToCorrelationIDMap map[string]string,
-) models.BatchShortenResponse {
-	result := models.BatchShortenResponse{}
-	theRouter.fillThePostApishortenbatchResponse(&result, existentFullsToShortsMap, originalURLToCorrelationIDMap)
-	theRouter.fillThePostApishortenbatchResponse(&result, unexistentFullsToShortsMap, originalURLToCorrelationIDMap)
+	if dismissed == 0 {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-	return result
+	writeJSONResponse(response, http.StatusOK, models.DismissAbuseReportsResponse{Dismissed: dismissed})
 }
 
-func (theRouter Router) getUnexistentFullsToShortsMap(unexistentFulls []string) map[string]string {
-	result := map[string]string{}
-	for _, full := range unexistentFulls {
-		result[full] = uuid.New().String()
+// GetApiadminurls searches all the short URLs, whoever they belong to, deleted and disabled ones
+// included. Supports the query parameters of GetApiuserurls but `tag`, with `q` matching the short
+// URL as well as the original one. Responds with 200 and the list, 204 if nothing is found,
+// or 422/500 on error.
+func (theRouter Router) GetApiadminurls(response http.ResponseWriter, request *http.Request) {
+	query, err := parseUserURLsQuery(request.URL.Query(), defaultUserURLsPageSize)
+	if err == nil && query.Tag != "" {
+		err = errors.New("the links of all users cannot be filtered by tag")
 	}
+	if err != nil {
+		logger.Log.Debugln("incorrect query parameters", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
 
-	return result
-}
+		return
+	}
 
-func (theRouter Router) getOriginalURLToCorrelationIDMap(requestDTO models.BatchShortenRequest) map[string]string {
-	result := map[string]string{}
-	for _, originalURLToCorrelationID := range requestDTO {
-		result[originalURLToCorrelationID.OriginalURL] = originalURLToCorrelationID.CorrelationID
+	pageSize := query.Limit
+	query.Limit++ // One more URL tells whether there is a next page
+	responseDTO, err := theRouter.db.SearchURLs(request.Context(), query, nil)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.SearchURLs()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
 	}
 
-	return result
+	if len(responseDTO) == 0 {
+		response.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	if len(responseDTO) > pageSize {
+		responseDTO = responseDTO[:pageSize]
+		last := responseDTO[len(responseDTO)-1]
+		nextPageURL := theRouter.getNextUserURLsPageURL(request, models.UserURLsCursor{
+			SortBy:      query.SortBy,
+			Descending:  query.Descending,
+			CreatedAt:   last.CreatedAt,
+			OriginalURL: last.OriginalURL,
+			Short:       last.ShortURL,
+		})
+		response.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPageURL))
+	}
+
+	for i := range responseDTO {
+		responseDTO[i].ShortURL = theRouter.getShortURL(responseDTO[i].ShortURL)
+	}
+
+	writeJSONResponse(response, http.StatusOK, responseDTO)
 }
 
-func (theRouter Router) getShortURL(shortKey string) string {
-	return theRouter.shortURLBase + "/" + shortKey
+// GetApiadminuserurls returns a page of the URLs of any user, as GetApiuserurls does for
+// the user's own ones. Responds with 200 and the list, 204 if the user has no URLs,
+// or 422/500 on error.
+func (theRouter Router) GetApiadminuserurls(response http.ResponseWriter, request *http.Request) {
+	userID := chi.URLParam(request, "userID")
+	if uuid.Validate(userID) != nil {
+		response.WriteHeader(http.StatusUnprocessableEntity)
+
+		return
+	}
+
+	theRouter.writeUserURLsPage(response, request, userID)
 }
 
-func (theRouter Router) getShortKey(ctx context.Context, urlToShort string, userID string) (string, error) {
-	transaction, err := theRouter.db.BeginTransaction()
-	if err != nil {
-		return "", err
+// PutApiadminuserrole changes the role of a user (models.SetUserRoleRequest).
+// Responds with 204 if changed, 404 if there is no such user, or 422/500 on error.
+func (theRouter Router) PutApiadminuserrole(response http.ResponseWriter, request *http.Request) {
+	var requestDTO models.SetUserRoleRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(requestDTO); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
 	}
 
-	short, found, err := theRouter.db.FindShortByFull(ctx, urlToShort, transaction)
+	err := theRouter.db.SetUserRole(request.Context(), chi.URLParam(request, "userID"), requestDTO.Role)
+	if errors.Is(err, models.ErrUserNotFound) {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		_ = theRouter.db.RollbackTransaction(transaction)
+		logger.Log.Debugln("Error calling the `theRouter.db.SetUserRole()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-		return "", err
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// PostApiadminuserimpersonate issues the admin a short-lived token authenticating them as a user,
+// for support. The token is returned in the Authorization header and the JSON body
+// (models.ImpersonationResponse), leaving the admin's own session cookie as is; the requests made
+// with it are audit-logged. Responds with 200 and the token, 403 if the user is an admin,
+// 404 if there is no such user, or 422/500 on error.
+func (theRouter Router) PostApiadminuserimpersonate(response http.ResponseWriter, request *http.Request) {
+	adminID, _ := request.Context().Value(auth.UserIDKey).(string)
+	userID := chi.URLParam(request, "userID")
+	if uuid.Validate(userID) != nil {
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	token, expiresAt, err := theRouter.tokenIssuer.IssueImpersonationToken(request.Context(), adminID, userID)
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		response.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrImpersonatingAdmin):
+		response.WriteHeader(http.StatusForbidden)
+		return
+	case err != nil:
+		logger.Log.Debugln("Error calling the `theRouter.tokenIssuer.IssueImpersonationToken()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Authorization", token)
+	writeJSONResponse(response, http.StatusOK, models.ImpersonationResponse{
+		Token:     token,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// requireAdmin lets through the requests of the users with the admin role only, answering 401
+// to unauthenticated requests and 403 to the other users. It goes after auth.AuthenticateUser.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		userID, ok := request.Context().Value(auth.UserIDKey).(string)
+		if !ok || userID == "" {
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if role, _ := request.Context().Value(auth.UserRoleKey).(string); role != user.RoleAdmin {
+			response.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(response, request)
+	})
+}
+
+// auditAdminRequest logs who made the admin API request, what for, and how it was answered.
+// It goes after auth.AuthenticateUser.
+func auditAdminRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		wrappedResponse := middleware.NewWrapResponseWriter(response, request.ProtoMajor)
+
+		next.ServeHTTP(wrappedResponse, request)
+
+		userID, _ := request.Context().Value(auth.UserIDKey).(string)
+		impersonatorID, _ := request.Context().Value(auth.ImpersonatorIDKey).(string)
+		logger.Log.Infow(
+			"audit: admin API request",
+			"user_id", userID,
+			"impersonator_id", impersonatorID,
+			"method", request.Method,
+			"path", request.URL.Path,
+			"query", request.URL.RawQuery,
+			"status", wrappedResponse.Status(),
+		)
+	})
+}
+
+// PatchApiuserurl changes the original URL of a short URL owned by the user.
+// Accepts a JSON body with the new URL only and responds with 200 and the updated mapping,
+// 401 if unauthenticated, 403 if the link is shared with other users, 404 if the user has no such link,
+// 409 if the new original URL is already shortened, 422 if the body has any other field,
+// or 422/500/503 on error. Like the shortened ones,
+// the new original URL must lead to a destination that may be shortened, be safe to shorten
+// and pass the scan.
+func (theRouter Router) PatchApiuserurl(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	var requestDTO models.RetargetURLRequest
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(requestDTO); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if theRouter.isURLTooLong(requestDTO.URL) {
+		logger.Log.Debugln("the URL is too long", zap.Int("length", len(requestDTO.URL)))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	canonicalURL, err := theRouter.canonicalizeURL(requestDTO.URL)
+	if err != nil {
+		logger.Log.Debugln("error while `theRouter.canonicalizeURL()` calling: ", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	requestDTO.URL = canonicalURL
+
+	if err := theRouter.checkURLToShort(request.Context(), requestDTO.URL); err != nil {
+		logger.Log.Debugln("the URL is rejected", zap.Error(err))
+		writeJSONResponse(response, http.StatusUnprocessableEntity, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := theRouter.scanNewURL(request.Context(), requestDTO.URL); err != nil {
+		writeURLScanError(response, err)
+		return
+	}
+
+	short := chi.URLParam(request, "short")
+	err = theRouter.db.RetargetUserURL(request.Context(), userID, short, requestDTO.URL)
+	switch {
+	case errors.Is(err, models.ErrURLNotFound):
+		response.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrURLNotOwned):
+		response.WriteHeader(http.StatusForbidden)
+		return
+	case errors.Is(err, models.ErrURLAlreadyShortened):
+		response.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		logger.Log.Debugln("Error calling the `theRouter.db.RetargetUserURL()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.Infoln(
+		"short URL retargeted",
+		zap.String("userID", userID),
+		zap.String("short", short),
+		zap.String("url", requestDTO.URL),
+	)
+
+	responseDTO := models.UserURL{
+		ShortURL:    theRouter.getShortURL(short),
+		OriginalURL: requestDTO.URL,
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// PutApiuserurlactivefrom changes the time before which a short URL owned by the user does not redirect.
+// A null time activates the link right away. Responds with 204 if changed, 401 if unauthenticated,
+// 403 if the link is shared with other users, 404 if the user has no such link, or 500 on error.
+func (theRouter Router) PutApiuserurlactivefrom(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	var requestDTO models.ActiveFromRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err := theRouter.db.SetUserURLActiveFrom(
+		request.Context(),
+		userID,
+		chi.URLParam(request, "short"),
+		timeOrZero(requestDTO.ActiveFrom),
+	)
+	switch {
+	case errors.Is(err, models.ErrURLNotFound):
+		response.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrURLNotOwned):
+		response.WriteHeader(http.StatusForbidden)
+		return
+	case err != nil:
+		logger.Log.Debugln("Error calling the `theRouter.db.SetUserURLActiveFrom()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// GetApiuserurlrules returns the redirect rules of the user's short URL, in the order they are evaluated.
+// Responds with 200 and the list, 401 if unauthenticated, 404 if the user has no such link, or 500 on error.
+func (theRouter Router) GetApiuserurlrules(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	responseDTO, err := theRouter.db.GetUserURLRedirectRules(request.Context(), userID, chi.URLParam(request, "short"))
+	switch {
+	case errors.Is(err, models.ErrURLNotFound):
+		response.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Log.Debugln("Error calling the `theRouter.db.GetUserURLRedirectRules()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// PutApiuserurlrules replaces the redirect rules of a short URL owned by the user; an empty list removes them.
+// Responds with 204 if replaced, 401 if unauthenticated, 403 if the link is shared with other users,
+// 404 if the user has no such link, 422 if a rule is invalid or there are too many, or 500 on error.
+func (theRouter Router) PutApiuserurlrules(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	var requestDTO models.RedirectRules
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Var(requestDTO, "max="+strconv.Itoa(maxRedirectRules)+",dive"); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	for _, rule := range requestDTO {
+		if err := theRouter.checkURLToShort(request.Context(), rule.URL); err != nil {
+			logger.Log.Debugln("the URL is rejected", zap.String("url", rule.URL), zap.Error(err))
+			writeJSONResponse(response, http.StatusUnprocessableEntity, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err := theRouter.scanNewURL(request.Context(), rule.URL); err != nil {
+			writeURLScanError(response, err)
+			return
+		}
+	}
+
+	err := theRouter.db.SetUserURLRedirectRules(
+		request.Context(),
+		userID,
+		chi.URLParam(request, "short"),
+		requestDTO,
+	)
+	switch {
+	case errors.Is(err, models.ErrURLNotFound):
+		response.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrURLNotOwned):
+		response.WriteHeader(http.StatusForbidden)
+		return
+	case err != nil:
+		logger.Log.Debugln("Error calling the `theRouter.db.SetUserURLRedirectRules()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// GetApiuserurldestinations returns the A/B destinations of the user's short URL.
+// Responds with 200 and the list, 401 if unauthenticated, 404 if the user has no such link, or 500 on error.
+func (theRouter Router) GetApiuserurldestinations(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	responseDTO, err := theRouter.db.GetUserURLDestinations(request.Context(), userID, chi.URLParam(request, "short"))
+	switch {
+	case errors.Is(err, models.ErrURLNotFound):
+		response.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Log.Debugln("Error calling the `theRouter.db.GetUserURLDestinations()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// PutApiuserurldestinations replaces the A/B destinations of a short URL owned by the user;
+// an empty list turns the split off. Responds with 204 if replaced, 401 if unauthenticated,
+// 403 if the link is shared with other users, 404 if the user has no such link, 422 if
+// a destination is invalid or repeated or there are too many, or 500 on error.
+func (theRouter Router) PutApiuserurldestinations(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	var requestDTO models.Destinations
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Var(requestDTO, "max="+strconv.Itoa(maxDestinations)+",unique=URL,dive"); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	for _, destination := range requestDTO {
+		if err := theRouter.checkURLToShort(request.Context(), destination.URL); err != nil {
+			logger.Log.Debugln("the URL is rejected", zap.String("url", destination.URL), zap.Error(err))
+			writeJSONResponse(response, http.StatusUnprocessableEntity, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err := theRouter.scanNewURL(request.Context(), destination.URL); err != nil {
+			writeURLScanError(response, err)
+			return
+		}
+	}
+
+	err := theRouter.db.SetUserURLDestinations(
+		request.Context(),
+		userID,
+		chi.URLParam(request, "short"),
+		requestDTO,
+	)
+	switch {
+	case errors.Is(err, models.ErrURLNotFound):
+		response.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrURLNotOwned):
+		response.WriteHeader(http.StatusForbidden)
+		return
+	case err != nil:
+		logger.Log.Debugln("Error calling the `theRouter.db.SetUserURLDestinations()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// GetApiuserurls returns a page of user-specific shortened URLs in JSON format.
+// Supports the `limit`, `cursor`, `sort` (created_at or original_url), `order` (asc or desc),
+// `q` (original URL substring) and `tag` query parameters; the next page, if any, is linked
+// via the `Link` header. A cursor is only valid with the sort and order it was issued for.
+// Without `limit` and `cursor` all the URLs are returned, in one page.
+// Responds with 200 and the list, 204 if no URLs exist, or 401/422/500 on error.
+func (theRouter Router) GetApiuserurls(response http.ResponseWriter, request *http.Request) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	theRouter.writeUserURLsPage(response, request, userID)
+}
+
+// writeUserURLsPage answers with the page of the user's URLs defined by the query parameters
+// of the request (see GetApiuserurls).
+func (theRouter Router) writeUserURLsPage(response http.ResponseWriter, request *http.Request, userID string) {
+	query, err := parseUserURLsQuery(request.URL.Query(), 0)
+	if err != nil {
+		logger.Log.Debugln("incorrect query parameters", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+
+		return
+	}
+
+	pageSize := query.Limit
+	if pageSize > 0 {
+		query.Limit++ // One more URL tells whether there is a next page
+	}
+	responseDTO, err := theRouter.db.GetUserUrls(request.Context(), userID, query, nil)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.GetUserUrls()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	if len(responseDTO) == 0 {
+		response.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	if pageSize > 0 && len(responseDTO) > pageSize {
+		responseDTO = responseDTO[:pageSize]
+		last := responseDTO[len(responseDTO)-1]
+		nextPageURL := theRouter.getNextUserURLsPageURL(request, models.UserURLsCursor{
+			SortBy:      query.SortBy,
+			Descending:  query.Descending,
+			CreatedAt:   last.CreatedAt,
+			OriginalURL: last.OriginalURL,
+			Short:       last.ShortURL,
+		})
+		response.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPageURL))
+	}
+
+	for i := range responseDTO {
+		responseDTO[i].ShortURL = theRouter.getShortURL(responseDTO[i].ShortURL)
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(response).Encode(responseDTO)
+	if err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+
+		return
+	}
+}
+
+// PostApishortenbatch handles batch URL shortening via API.
+// Accepts a list of URLs and returns their short mappings.
+// If any of the URLs cannot take its UTM parameters, is too long, leads to a destination domain
+// that may not be shortened, is not safe to shorten or is reported as malicious by the scanner,
+// nothing is shortened and every such URL is listed with the reason in a 422 response. If a new
+// URL could not be scanned, nothing is shortened either and 503 is answered unless the scan fails open.
+func (theRouter Router) PostApishortenbatch(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		logger.Log.Debug("got request with bad method", zap.String("method", request.Method))
+		response.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	var requestDTO models.BatchShortenRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Var(requestDTO, "dive"); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	for _, item := range requestDTO {
+		if isPasswordTooLong(item.Password) {
+			logger.Log.Debugln("the password is too long", zap.Int("bytes", len(item.Password)))
+			response.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		logger.Log.Debugln("The `userID` value was not found in the request's context")
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	var utmDefaults *models.UTMParams
+	var rejectedItems models.BatchShortenErrorResponse
+	for i, item := range requestDTO {
+		if item.UTM == nil {
+			continue
+		}
+		if utmDefaults == nil {
+			defaults, err := theRouter.db.GetUserUTMDefaults(request.Context(), userID)
+			if err != nil {
+				logger.Log.Debugln("Error calling the `theRouter.db.GetUserUTMDefaults()`: ", zap.Error(err))
+				response.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+			utmDefaults = &defaults
+		}
+
+		mergedURL, err := mergeUTM(item.OriginalURL, *item.UTM, *utmDefaults)
+		if err != nil {
+			logger.Log.Debugln("error while `mergeUTM()` calling: ", zap.String("correlation_id", item.CorrelationID), zap.Error(err))
+			rejectedItems = append(rejectedItems, models.BatchShortenErrorItem{
+				CorrelationID: item.CorrelationID,
+				OriginalURL:   item.OriginalURL,
+				Error:         err.Error(),
+			})
+			continue
+		}
+		requestDTO[i].OriginalURL = mergedURL
+	}
+	if len(rejectedItems) > 0 {
+		writeJSONResponse(response, http.StatusUnprocessableEntity, rejectedItems)
+		return
+	}
+
+	for i, item := range requestDTO {
+		canonicalURL, err := theRouter.canonicalizeURL(item.OriginalURL)
+		if err != nil {
+			logger.Log.Debugln("error while `theRouter.canonicalizeURL()` calling: ", zap.Error(err))
+			response.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		requestDTO[i].OriginalURL = canonicalURL
+	}
+
+	for _, item := range requestDTO {
+		err := theRouter.checkURLToShort(request.Context(), item.OriginalURL)
+		if err != nil {
+			logger.Log.Debugln("the URL is rejected", zap.String("correlation_id", item.CorrelationID), zap.Error(err))
+			rejectedItems = append(rejectedItems, models.BatchShortenErrorItem{
+				CorrelationID: item.CorrelationID,
+				OriginalURL:   item.OriginalURL,
+				Error:         err.Error(),
+			})
+		}
+	}
+	if len(rejectedItems) > 0 {
+		writeJSONResponse(response, http.StatusUnprocessableEntity, rejectedItems)
+		return
+	}
+
+	ownerID := theRouter.getLinkOwnerID(userID)
+
+	itemsAttributes, err := theRouter.getBatchItemsAttributes(requestDTO, userID)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.getBatchItemsAttributes()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+	dedicatedShorts := theRouter.getDedicatedBatchShorts(itemsAttributes)
+
+	originalUrls := theRouter.getBatchOriginalURLs(requestDTO, dedicatedShorts)
+
+	// The new URLs are scanned before the transaction is begun not to hold it during the scans.
+	// A URL which link is deleted in the meantime was scanned when the link was created.
+	existentFullsToShortsMap, err := theRouter.db.FindShortsByFulls(request.Context(), originalUrls, ownerID, nil)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.FindShortsByFulls()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+	newURLs := differenceStringSlices(originalUrls, funk.Keys(existentFullsToShortsMap).([]string))
+	for i := range dedicatedShorts {
+		newURLs = append(newURLs, requestDTO[i].OriginalURL)
+	}
+	rejectedItems, err = theRouter.scanNewBatchURLs(request.Context(), requestDTO, funk.UniqString(newURLs))
+	if err != nil {
+		writeURLScanError(response, err)
+		return
+	}
+	if len(rejectedItems) > 0 {
+		writeJSONResponse(response, http.StatusUnprocessableEntity, rejectedItems)
+		return
+	}
+
+	transaction, err := theRouter.db.BeginTransaction()
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.BeginTransaction()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	existentFullsToShortsMap, err = theRouter.db.FindShortsByFulls(
+		request.Context(),
+		originalUrls,
+		ownerID,
+		transaction,
+	)
+	if err != nil {
+		err2 := theRouter.db.RollbackTransaction(transaction)
+		if err2 != nil {
+			logger.Log.Debugln("Error calling the `theRouter.db.RollbackTransaction()`: ", zap.Error(err2))
+		}
+		logger.Log.Debugln("Error calling the `theRouter.db.FindShortsByFulls()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	existentFulls := funk.Keys(existentFullsToShortsMap).([]string)
+	unexistentFulls := differenceStringSlices(originalUrls, existentFulls)
+	unexistentFullsToShortsMap := theRouter.getUnexistentFullsToShortsMap(unexistentFulls)
+	err = theRouter.db.SaveNewFullsAndShorts(
+		request.Context(),
+		unexistentFullsToShortsMap,
+		ownerID,
+		theRouter.getURLAttributes(requestDTO, itemsAttributes, unexistentFullsToShortsMap),
+		transaction,
+	)
+	if err != nil {
+		err2 := theRouter.db.RollbackTransaction(transaction)
+		if err2 != nil {
+			logger.Log.Debugln("Error calling the `theRouter.db.RollbackTransaction()`: ", zap.Error(err2))
+		}
+		logger.Log.Debugln("Error calling the `theRouter.db.SaveNewFullsAndShorts()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	for i, short := range dedicatedShorts {
+		err = theRouter.db.InsertURLMapping(
+			request.Context(),
+			short,
+			requestDTO[i].OriginalURL,
+			ownerID,
+			itemsAttributes[i],
+			transaction,
+		)
+		if err != nil {
+			err2 := theRouter.db.RollbackTransaction(transaction)
+			if err2 != nil {
+				logger.Log.Debugln("Error calling the `theRouter.db.RollbackTransaction()`: ", zap.Error(err2))
+			}
+			logger.Log.Debugln("Error calling the `theRouter.db.InsertURLMapping()`: ", zap.Error(err))
+			response.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	err = theRouter.db.SaveUserUrls(
+		request.Context(),
+		userID,
+		funk.Uniq(
+			funk.Union(
+				funk.Values(existentFullsToShortsMap).([]string),
+				funk.Values(unexistentFullsToShortsMap).([]string),
+				funk.Values(dedicatedShorts).([]string),
+			),
+		).([]string),
+		transaction,
+	)
+	if err != nil {
+		err2 := theRouter.db.RollbackTransaction(transaction)
+		if err2 != nil {
+			logger.Log.Debugln("Error calling the `theRouter.db.RollbackTransaction()`: ", zap.Error(err2))
+		}
+		logger.Log.Debugln("Error calling the `theRouter.db.SaveUserUrls()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	responseDTO := theRouter.getPostApishortenbatchResponse(
+		requestDTO,
+		existentFullsToShortsMap,
+		unexistentFullsToShortsMap,
+		dedicatedShorts,
+	)
+
+	err = theRouter.db.CommitTransaction(transaction)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db.CommitTransaction()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+
+		return
+	}
+}
+
+// GetPing is a healthcheck handler that returns 200 OK if the DB is reachable.
+func (theRouter Router) GetPing(response http.ResponseWriter, request *http.Request) {
+	err := theRouter.db.Ping(request.Context())
+	if err != nil {
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+	response.WriteHeader(http.StatusOK)
+}
+
+// PostApishorten handles API requests to shorten a single URL.
+// Accepts a JSON body and responds with a JSON containing the short URL.
+// A URL leading to a destination domain that may not be shortened, not safe to shorten
+// or reported as malicious by the scanner is answered with 422 and the reason, a new URL
+// that could not be scanned with 503 unless the scan fails open.
+func (theRouter Router) PostApishorten(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		logger.Log.Debug("got request with bad method", zap.String("method", request.Method))
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestDTO, ok := theRouter.decodeShortenRequest(response, request)
+	if !ok {
+		return
+	}
+
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		logger.Log.Debugln("The `userID` value was not found in the request's context")
+		response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	passwordHash, err := hashLinkPassword(requestDTO.Password)
+	if err != nil {
+		logger.Log.Debugln("error while `hashLinkPassword()` calling: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	urlToShort := requestDTO.URL
+	if requestDTO.UTM != nil {
+		utmDefaults, err := theRouter.db.GetUserUTMDefaults(request.Context(), userID)
+		if err != nil {
+			logger.Log.Debugln("Error calling the `theRouter.db.GetUserUTMDefaults()`: ", zap.Error(err))
+			response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		urlToShort, err = mergeUTM(urlToShort, *requestDTO.UTM, utmDefaults)
+		if err != nil {
+			logger.Log.Debugln("error while `mergeUTM()` calling: ", zap.Error(err))
+			writeJSONResponse(response, http.StatusUnprocessableEntity, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	urlToShort, err = theRouter.canonicalizeURL(urlToShort)
+	if err != nil {
+		logger.Log.Debugln("error while `theRouter.canonicalizeURL()` calling: ", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := theRouter.checkURLToShort(request.Context(), urlToShort); err != nil {
+		logger.Log.Debugln("the URL is rejected", zap.Error(err))
+		writeJSONResponse(response, http.StatusUnprocessableEntity, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	shortKey, err := theRouter.getShortKey(request.Context(), urlToShort, userID, models.URLAttributes{
+		Title:        requestDTO.Title,
+		Description:  requestDTO.Description,
+		PasswordHash: passwordHash,
+		MaxClicks:    requestDTO.MaxClicks,
+		ActiveFrom:   timeOrZero(requestDTO.ActiveFrom),
+		RedirectCode: requestDTO.RedirectCode,
+		Passthrough:  requestDTO.Passthrough,
+		Interstitial: requestDTO.Interstitial,
+	})
+	if isURLScanError(err) {
+		writeURLScanError(response, err)
+		return
+	}
+	if err != nil && !errors.Is(err, ErrConflict) {
+		logger.Log.Debugln("error while `theRouter.getShortKey()` calling: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	shortURL := theRouter.getShortURL(shortKey)
+
+	responseDTO := models.ShortenResponse{Result: shortURL}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	resultStatus := http.StatusCreated
+	if errors.Is(err, ErrConflict) {
+		resultStatus = http.StatusConflict
+	}
+	response.WriteHeader(resultStatus)
+
+	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// GetRedirecttofullurl redirects short URLs to their original URL if found.
+// Responds with the redirect code of the link or the configured default one (307 Temporary
+// Redirect unless set otherwise), 404 if not found or not active yet, 410 if deleted
+// or out of the redirects it was limited to, or the configured status code (410 Gone unless
+// set otherwise) if disabled by the moderators. Disabled links are not previewed either.
+//
+// The visitors matching one of the redirect rules of the link are sent to the URL of the first
+// such rule instead of the original one (see matchesRedirectRule). The other ones are split
+// across the A/B destinations of the link by weight, if it has some (see chooseDestination).
+//
+// Permanent redirects (301, 308) may be cached by clients for the configured max age,
+// unless the link is password-protected, limited in clicks, has redirect rules or A/B
+// destinations: every redirect of those must reach the server. All other redirects are
+// not to be cached.
+//
+// The links asking for it, or all of them if so configured, show the "you are leaving" page
+// with 200 instead of redirecting to a domain that is not trusted.
+//
+// A preview page showing the destination, title and creation date of the link is served with
+// 200 instead of redirecting when the short URL is followed by "+" (`/{short}+`) or the request
+// has the `preview=1` query parameter. The preview of a password-protected link does not show
+// its destination. Previews consume no clicks.
+//
+// Depending on the passthrough policy of the link the query parameters of the request are
+// merged into the original URL, and the path following the short URL is appended to its path
+// (see passthroughURL). A path following the short URL of a link that does not pass it
+// through is answered with 404.
+//
+// A password-protected link redirects only once the password is given, either in the
+// X-Link-Password header or in the `password` field of the form served to browsers and
+// posted back to the same URL (redirected with 303 See Other then). A missing or wrong
+// password is answered with 401 and the form, too many wrong ones with 429.
+func (theRouter Router) GetRedirecttofullurl(res http.ResponseWriter, req *http.Request) {
+	short := chi.URLParam(req, "short")
+	isPreview := req.URL.Query().Get("preview") == "1"
+	if trimmedShort, hasPreviewSuffix := strings.CutSuffix(short, "+"); hasPreviewSuffix {
+		short = trimmedShort
+		isPreview = true
+	}
+	redirect, found, err := theRouter.db.FindRedirectByShort(req.Context(), short)
+	if errors.Is(err, models.ErrURLDisabled) {
+		res.WriteHeader(theRouter.getDisabledLinkStatusCode())
+		return
+	}
+	if errors.Is(err, models.ErrURLMarkedAsDeleted) || errors.Is(err, models.ErrURLClicksExhausted) {
+		res.WriteHeader(http.StatusGone)
+		return
+	}
+	if err != nil {
+		logger.Log.Debugln("error while `theRouter.db.FindRedirectByShort()` calling: ", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !found || time.Now().Before(redirect.Attributes.ActiveFrom) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	extraPath := chi.URLParam(req, "*")
+	if req.URL.RawPath != "" {
+		extraPath, err = url.PathUnescape(extraPath)
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if extraPath != "" && redirect.Attributes.Passthrough != models.PassthroughQueryAndPath {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if isPreview {
+		writeLinkPreview(res, redirect)
+		return
+	}
+
+	if redirect.Attributes.PasswordHash != "" &&
+		!theRouter.checkLinkPassword(res, req, short, redirect.Attributes.PasswordHash) {
+		return
+	}
+
+	if redirect.Attributes.MaxClicks > 0 {
+		_, err = theRouter.db.ConsumeURLClick(req.Context(), short)
+		if errors.Is(err, models.ErrURLClicksExhausted) {
+			res.WriteHeader(http.StatusGone)
+			return
+		}
+		if err != nil {
+			logger.Log.Debugln("error while `theRouter.db.ConsumeURLClick()` calling: ", zap.Error(err))
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	target, err := passthroughURL(
+		theRouter.getRedirectDestination(res, req, short, redirect),
+		redirect.Attributes.Passthrough,
+		extraPath,
+		req.URL.Query(),
+	)
+	if err != nil {
+		logger.Log.Debugln("error while `passthroughURL()` calling: ", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	status := redirect.Attributes.RedirectCode
+	if status == 0 {
+		status = theRouter.redirectStatusCode
+	}
+	if status == 0 {
+		status = http.StatusTemporaryRedirect
+	}
+	if req.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	if (redirect.Attributes.Interstitial || theRouter.interstitialForUntrustedDomains) && !theRouter.isTrustedURL(target) {
+		res.Header().Set("Cache-Control", "private, no-store")
+		writeInterstitial(res, target, redirect.Attributes.Title)
+		return
+	}
+
+	res.Header().Set("Cache-Control", theRouter.redirectCacheControl(status, redirect.Attributes))
+	http.Redirect(res, req, target, status)
+}
+
+// isTrustedURL tells whether the URL leads to one of the trusted domains or their subdomains.
+func (theRouter Router) isTrustedURL(target string) bool {
+	parsedTarget, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(parsedTarget.Hostname())
+	for _, domain := range theRouter.trustedDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (theRouter Router) redirectCacheControl(status int, attributes models.URLAttributes) string {
+	isPermanent := status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+	if !isPermanent ||
+		attributes.PasswordHash != "" ||
+		attributes.MaxClicks > 0 ||
+		len(attributes.RedirectRules) > 0 ||
+		len(attributes.Destinations) > 0 ||
+		theRouter.permanentRedirectMaxAge <= 0 {
+		return "private, no-store"
+	}
+
+	return fmt.Sprintf("public, max-age=%d", int(theRouter.permanentRedirectMaxAge.Seconds()))
+}
+
+// PostShorten handles plain text full URL.
+// Responds with a plain text short URL, 409 on conflict or 422 with the reason if the URL
+// is too long, leads to a destination domain that may not be shortened, is not safe to shorten
+// or is reported as malicious by the scanner. A new URL that could not be scanned is answered
+// with 503 unless the scan fails open.
+func (theRouter Router) PostShorten(response http.ResponseWriter, request *http.Request) {
+	urlToShort, err := getURLToShort(request)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	urlToShort, err = theRouter.canonicalizeURL(urlToShort)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := theRouter.checkURLToShort(request.Context(), urlToShort); err != nil {
+		http.Error(response, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		logger.Log.Debugln("The `userID` value was not found in the request's context")
+		response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	shortKey, err := theRouter.getShortKey(request.Context(), urlToShort, userID, models.URLAttributes{})
+	if isURLScanError(err) {
+		http.Error(response, err.Error(), urlScanErrorStatus(err))
+		return
+	}
+	if err != nil && !errors.Is(err, ErrConflict) {
+		logger.Log.Debugln("error while `theRouter.getShortKey()` calling: ", zap.Error(err))
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resultStatus := http.StatusCreated
+	if errors.Is(err, ErrConflict) {
+		resultStatus = http.StatusConflict
+	}
+	response.WriteHeader(resultStatus)
+
+	_, err = response.Write([]byte(theRouter.getShortURL(shortKey)))
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func differenceStringSlices(a, b []string) []string {
+	bSet := make(map[string]struct{}, len(b))
+	for _, item := range b {
+		bSet[item] = struct{}{}
+	}
+
+	var diff []string
+	for _, item := range a {
+		if _, found := bSet[item]; !found {
+			diff = append(diff, item)
+		}
+	}
+	return diff
+}
+
+func (theRouter Router) getValidator() *validator.Validate {
+	if theRouter.validator == nil {
+		theRouter.validator = validator.New()
+	}
+	return theRouter.validator
+}
+
+// getPostApishortenbatchResponse returns the short URL of every batch item in the request order,
+// the items with the same original URL getting the same short URL unless their links are dedicated.
+func (theRouter Router) getPostApishortenbatchResponse(
+	requestDTO models.BatchShortenRequest,
+	existentFullsToShortsMap map[string]string,
+	unexistentFullsToShortsMap map[string]string,
+	dedicatedShorts map[int]string,
+) models.BatchShortenResponse {
+	result := make(models.BatchShortenResponse, 0, len(requestDTO))
+	for i, item := range requestDTO {
+		short, found := dedicatedShorts[i]
+		if !found {
+			short, found = existentFullsToShortsMap[item.OriginalURL]
+		}
+		if !found {
+			short = unexistentFullsToShortsMap[item.OriginalURL]
+		}
+		result = append(result, models.BatchShortenResponseItem{
+			CorrelationID: item.CorrelationID,
+			ShortURL:      theRouter.getShortURL(short),
+		})
+	}
+
+	return result
+}
+
+func (theRouter Router) getUnexistentFullsToShortsMap(unexistentFulls []string) map[string]string {
+	result := map[string]string{}
+	for _, full := range unexistentFulls {
+		result[full] = uuid.New().String()
+	}
+
+	return result
+}
+
+// getBatchOriginalURLs returns the distinct original URLs of the batch items whose links are not dedicated.
+func (theRouter Router) getBatchOriginalURLs(
+	requestDTO models.BatchShortenRequest,
+	dedicatedShorts map[int]string,
+) []string {
+	result := make([]string, 0, len(requestDTO))
+	seen := make(map[string]struct{}, len(requestDTO))
+	for i, item := range requestDTO {
+		if _, dedicated := dedicatedShorts[i]; dedicated {
+			continue
+		}
+		if _, found := seen[item.OriginalURL]; found {
+			continue
+		}
+		seen[item.OriginalURL] = struct{}{}
+		result = append(result, item.OriginalURL)
+	}
+
+	return result
+}
+
+// getURLAttributes returns the attributes of the new batch items, which links are not dedicated,
+// by their original URLs. For a repeated original URL the first item wins.
+func (theRouter Router) getURLAttributes(
+	requestDTO models.BatchShortenRequest,
+	itemsAttributes []models.URLAttributes,
+	unexistentFullsToShortsMap map[string]string,
+) map[string]models.URLAttributes {
+	result := map[string]models.URLAttributes{}
+	for i, item := range requestDTO {
+		if _, exists := result[item.OriginalURL]; exists {
+			continue
+		}
+		if _, unexistent := unexistentFullsToShortsMap[item.OriginalURL]; !unexistent {
+			continue
+		}
+		if itemsAttributes[i].IsDedicated() {
+			continue
+		}
+		result[item.OriginalURL] = itemsAttributes[i]
+	}
+
+	return result
+}
+
+// getDedicatedBatchShorts returns a new short URL for every batch item which link is dedicated,
+// by the item index.
+func (theRouter Router) getDedicatedBatchShorts(itemsAttributes []models.URLAttributes) map[int]string {
+	result := map[int]string{}
+	for i, attributes := range itemsAttributes {
+		if attributes.IsDedicated() {
+			result[i] = uuid.New().String()
+		}
+	}
+
+	return result
+}
+
+// getBatchItemsAttributes returns the attributes of the links asked for by the batch items, in the request order.
+func (theRouter Router) getBatchItemsAttributes(
+	requestDTO models.BatchShortenRequest,
+	userID string,
+) ([]models.URLAttributes, error) {
+	result := make([]models.URLAttributes, 0, len(requestDTO))
+	for _, item := range requestDTO {
+		passwordHash, err := hashLinkPassword(item.Password)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, models.URLAttributes{
+			CreatedBy:    userID,
+			Title:        item.Title,
+			Description:  item.Description,
+			PasswordHash: passwordHash,
+			MaxClicks:    item.MaxClicks,
+			ActiveFrom:   timeOrZero(item.ActiveFrom),
+			RedirectCode: item.RedirectCode,
+			Passthrough:  item.Passthrough,
+			Interstitial: item.Interstitial,
+		})
+	}
+
+	return result, nil
+}
+
+// getLinkOwnerID returns the owner of the links the user creates: the user in the per-user
+// ownership mode, or nobody (an empty ID, meaning shared links) otherwise.
+func (theRouter Router) getLinkOwnerID(userID string) string {
+	if theRouter.urlOwnershipMode == models.URLOwnershipModePerUser {
+		return userID
+	}
+
+	return ""
+}
+
+// decodeShortenRequest decodes and validates a models.ShortenRequest body.
+// On failure it writes the error status to the response and returns false.
+func (theRouter Router) decodeShortenRequest(
+	response http.ResponseWriter,
+	request *http.Request,
+) (models.ShortenRequest, bool) {
+	var requestDTO models.ShortenRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return requestDTO, false
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(requestDTO); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return requestDTO, false
+	}
+
+	if theRouter.isURLTooLong(requestDTO.URL) {
+		logger.Log.Debugln("the URL is too long", zap.Int("length", len(requestDTO.URL)))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return requestDTO, false
+	}
+
+	if isPasswordTooLong(requestDTO.Password) {
+		logger.Log.Debugln("the password is too long", zap.Int("bytes", len(requestDTO.Password)))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return requestDTO, false
+	}
+
+	return requestDTO, true
+}
+
+// changeUserURLsTags decodes a models.TagURLsRequest, applies it with change
+// (named methodName in logs) and responds with the affected short URLs.
+func (theRouter Router) changeUserURLsTags(
+	response http.ResponseWriter,
+	request *http.Request,
+	methodName string,
+	change func(ctx context.Context, userID string, shortURLs []string, tags []string) ([]string, error),
+) {
+	userID, ok := request.Context().Value(auth.UserIDKey).(string)
+	if !ok || userID == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	var requestDTO models.TagURLsRequest
+	if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+		logger.Log.Debugln("cannot decode request JSON body", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for i, tag := range requestDTO.Tags {
+		requestDTO.Tags[i] = normalizeTag(tag)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(requestDTO); err != nil {
+		logger.Log.Debugln("incorrect request structure", zap.Error(err))
+		response.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	changed, err := change(
+		request.Context(),
+		userID,
+		funk.UniqString(requestDTO.ShortURLs),
+		funk.UniqString(requestDTO.Tags),
+	)
+	if err != nil {
+		logger.Log.Debugln("Error calling the `theRouter.db."+methodName+"()`: ", zap.Error(err))
+		response.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(response).Encode(models.TagURLsResponse(changed)); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// checkLinkPassword verifies the password given for the password-protected link.
+// If it is missing or wrong, or too many wrong ones were given recently, it responds
+// accordingly and returns false.
+func (theRouter Router) checkLinkPassword(
+	response http.ResponseWriter,
+	request *http.Request,
+	short string,
+	passwordHash string,
+) bool {
+	password := request.Header.Get(linkPasswordHeader)
+	if password == "" && request.Method == http.MethodPost {
+		password = request.PostFormValue("password")
+	}
+	if password == "" {
+		writeLinkPasswordForm(response, false)
+		return false
+	}
+
+	if theRouter.linkPasswordAttemptsLimiter != nil {
+		allowed, retryAfter := theRouter.linkPasswordAttemptsLimiter.Allow(short)
+		if !allowed {
+			response.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			response.WriteHeader(http.StatusTooManyRequests)
+			return false
+		}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		if theRouter.linkPasswordAttemptsLimiter != nil {
+			theRouter.linkPasswordAttemptsLimiter.Fail(short)
+		}
+		logger.Log.Infoln("wrong password for a password-protected link", zap.String("short", short))
+		writeLinkPasswordForm(response, true)
+		return false
+	}
+
+	return true
+}
+
+func writeLinkPasswordForm(response http.ResponseWriter, wrongPassword bool) {
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.WriteHeader(http.StatusUnauthorized)
+
+	err := linkPasswordFormTemplate.Execute(response, struct{ WrongPassword bool }{wrongPassword})
+	if err != nil {
+		logger.Log.Debug("error rendering the link password form", zap.Error(err))
+	}
+}
+
+func writeLinkPreview(response http.ResponseWriter, redirect models.URLRedirect) {
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.Header().Set("Cache-Control", "private, no-store")
+	response.WriteHeader(http.StatusOK)
+
+	err := linkPreviewTemplate.Execute(response, struct {
+		Title             string
+		Description       string
+		Destination       string
+		PasswordProtected bool
+		MayVary           bool
+		CreatedAt         time.Time
+	}{
+		Title:             redirect.Attributes.Title,
+		Description:       redirect.Attributes.Description,
+		Destination:       redirect.OriginalURL,
+		PasswordProtected: redirect.Attributes.PasswordHash != "",
+		MayVary:           len(redirect.Attributes.RedirectRules) > 0 || len(redirect.Attributes.Destinations) > 0,
+		CreatedAt:         redirect.CreatedAt,
+	})
+	if err != nil {
+		logger.Log.Debug("error rendering the link preview", zap.Error(err))
+	}
+}
+
+func writeInterstitial(response http.ResponseWriter, destination, title string) {
+	host := destination
+	if parsedDestination, err := url.Parse(destination); err == nil {
+		host = parsedDestination.Host
+	}
+
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.WriteHeader(http.StatusOK)
+
+	err := interstitialTemplate.Execute(response, struct {
+		Host        string
+		Title       string
+		Destination string
+	}{
+		Host:        host,
+		Title:       title,
+		Destination: destination,
+	})
+	if err != nil {
+		logger.Log.Debug("error rendering the interstitial page", zap.Error(err))
+	}
+}
+
+func writeJSONResponse(response http.ResponseWriter, status int, responseDTO any) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+
+	if err := json.NewEncoder(response).Encode(responseDTO); err != nil {
+		logger.Log.Debug("error encoding response", zap.Error(err))
+	}
+}
+
+func isURLScanError(err error) bool {
+	return errors.Is(err, urlscanner.ErrMaliciousURL) || errors.Is(err, ErrURLScanUnavailable)
+}
+
+// urlScanErrorStatus returns the status answering a failed scan: 422 Unprocessable Entity
+// for a malicious URL, 503 Service Unavailable for a URL that could not be scanned.
+func urlScanErrorStatus(err error) int {
+	if errors.Is(err, urlscanner.ErrMaliciousURL) {
+		return http.StatusUnprocessableEntity
+	}
+
+	return http.StatusServiceUnavailable
+}
+
+func writeURLScanError(response http.ResponseWriter, err error) {
+	writeJSONResponse(response, urlScanErrorStatus(err), models.ErrorResponse{Error: err.Error()})
+}
+
+// hashLinkPassword returns the bcrypt hash of the link password, or an empty string if there is no password.
+func hashLinkPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// getRedirectDestination returns the URL of the first redirect rule of the link the request
+// matches, or else the A/B destination of the visitor if the link has some, or else the original URL.
+func (theRouter Router) getRedirectDestination(
+	res http.ResponseWriter,
+	req *http.Request,
+	short string,
+	redirect models.URLRedirect,
+) string {
+	if ruleURL, matched := findRedirectRuleURL(redirect.Attributes.RedirectRules, req); matched {
+		return ruleURL
+	}
+
+	if len(redirect.Attributes.Destinations) > 0 {
+		return chooseDestination(res, req, short, redirect.Attributes.Destinations)
+	}
+
+	return redirect.OriginalURL
+}
+
+// findRedirectRuleURL returns the URL of the first of the rules the request matches, if any.
+func findRedirectRuleURL(rules models.RedirectRules, req *http.Request) (string, bool) {
+	if len(rules) == 0 {
+		return "", false
+	}
+
+	client := useragent.Parse(req.UserAgent())
+	preferredLanguage := getPreferredLanguage(req.Header.Get("Accept-Language"))
+	query := req.URL.Query()
+	for _, rule := range rules {
+		if matchesRedirectRule(rule, client, preferredLanguage, query) {
+			return rule.URL, true
+		}
+	}
+
+	return "", false
+}
+
+// chooseDestination returns the A/B destination of the visitor: the one remembered in the
+// variant cookie of the short URL if it is still among the destinations, or else one picked
+// at random by weight and remembered in the cookie. The choice is logged for the experiment reports.
+func chooseDestination(
+	res http.ResponseWriter,
+	req *http.Request,
+	short string,
+	destinations models.Destinations,
+) string {
+	cookieName := abVariantCookiePrefix + short
+	if cookie, err := req.Cookie(cookieName); err == nil {
+		for variant, destination := range destinations {
+			if getVariantID(destination.URL) == cookie.Value {
+				logger.Log.Infoln(
+					"A/B variant chosen",
+					zap.String("short", short),
+					zap.Int("variant", variant),
+					zap.String("url", destination.URL),
+					zap.Bool("sticky", true),
+				)
+				return destination.URL
+			}
+		}
+	}
+
+	variant := pickWeightedDestination(destinations)
+	destination := destinations[variant]
+	http.SetCookie(res, &http.Cookie{
+		Name:     cookieName,
+		Value:    getVariantID(destination.URL),
+		Path:     "/" + short,
+		MaxAge:   int(abVariantCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	logger.Log.Infoln(
+		"A/B variant chosen",
+		zap.String("short", short),
+		zap.Int("variant", variant),
+		zap.String("url", destination.URL),
+		zap.Bool("sticky", false),
+	)
+
+	return destination.URL
+}
+
+// pickWeightedDestination returns the index of a destination picked at random with
+// a probability proportional to its weight.
+func pickWeightedDestination(destinations models.Destinations) int {
+	totalWeight := 0
+	for _, destination := range destinations {
+		totalWeight += destination.Weight
+	}
+
+	point := rand.IntN(totalWeight)
+	for variant, destination := range destinations {
+		point -= destination.Weight
+		if point < 0 {
+			return variant
+		}
+	}
+
+	return len(destinations) - 1
+}
+
+// getVariantID identifies an A/B variant in the variant cookie by its URL, so that
+// reordering or changing the other destinations does not move visitors to another variant.
+func getVariantID(destinationURL string) string {
+	sum := sha256.Sum256([]byte(destinationURL))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// matchesRedirectRule tells whether the visitor matches every condition of the rule.
+//
+// A language condition without a region ("en") matches any region of the visitor's most
+// preferred language, one with a region ("en-GB") only that region. A query parameter
+// condition matches if one of the values of the parameter equals the expected value, or
+// if the parameter is there at all when no value is expected.
+func matchesRedirectRule(
+	rule models.RedirectRule,
+	client useragent.Info,
+	preferredLanguage language.Tag,
+	query url.Values,
+) bool {
+	if rule.OS != "" && rule.OS != client.OS {
+		return false
+	}
+	if rule.Browser != "" && rule.Browser != client.Family {
+		return false
+	}
+	if rule.Language != "" && !matchesLanguage(rule.Language, preferredLanguage) {
+		return false
+	}
+	if rule.QueryParam != "" {
+		values, exists := query[rule.QueryParam]
+		if !exists || rule.QueryValue != "" && !funk.ContainsString(values, rule.QueryValue) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getPreferredLanguage returns the most preferred language of the Accept-Language header,
+// or language.Und if there is none.
+func getPreferredLanguage(acceptLanguage string) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return language.Und
+	}
+
+	return tags[0]
+}
+
+func matchesLanguage(ruleLanguage string, preferredLanguage language.Tag) bool {
+	ruleTag, err := language.Parse(ruleLanguage)
+	if err != nil || preferredLanguage == language.Und {
+		return false
+	}
+
+	ruleBase, _ := ruleTag.Base()
+	preferredBase, _ := preferredLanguage.Base()
+	if ruleBase != preferredBase {
+		return false
+	}
+
+	ruleRegion, ruleRegionConfidence := ruleTag.Region()
+	if ruleRegionConfidence != language.Exact {
+		return true
+	}
+	preferredRegion, preferredRegionConfidence := preferredLanguage.Region()
+
+	return preferredRegionConfidence == language.Exact && preferredRegion == ruleRegion
+}
+
+// utmQueryNames are the query parameter names of the UTM parameters, in the order they are appended.
+var utmQueryNames = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+// mergeUTM merges the UTM parameters into the query of the original URL.
+//
+// A parameter given in the request replaces the one of the same name in the original URL.
+// A parameter not given is taken from the user's defaults, unless the original URL already has it.
+// The rest of the original query is kept byte for byte; the merged parameters are appended to it
+// in the utm_source, utm_medium, utm_campaign, utm_term, utm_content order.
+func mergeUTM(originalURL string, utm, defaults models.UTMParams) (string, error) {
+	target, err := url.Parse(originalURL)
+	if err != nil {
+		return "", err
+	}
+	existing, _ := url.ParseQuery(target.RawQuery)
+
+	values := []string{utm.Source, utm.Medium, utm.Campaign, utm.Term, utm.Content}
+	defaultValues := []string{defaults.Source, defaults.Medium, defaults.Campaign, defaults.Term, defaults.Content}
+	merged := map[string]bool{}
+	appended := []string{}
+	for i, name := range utmQueryNames {
+		value := values[i]
+		if value == "" && !existing.Has(name) {
+			value = defaultValues[i]
+		}
+		if value == "" {
+			continue
+		}
+		merged[name] = true
+		appended = append(appended, url.QueryEscape(name)+"="+url.QueryEscape(value))
+	}
+	if len(appended) == 0 {
+		return originalURL, nil
+	}
+
+	kept := []string{}
+	for _, pair := range strings.Split(target.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(pair, "=")
+		if unescapedName, err := url.QueryUnescape(name); err == nil && merged[unescapedName] {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	target.RawQuery = strings.Join(append(kept, appended...), "&")
+
+	return target.String(), nil
+}
+
+// passthroughURL returns the URL to redirect to from a short URL with the given passthrough policy.
+//
+// With the query passed through, the request parameters absent from the original URL are appended
+// to its query, while the ones the original URL already has are dropped: the values set by the
+// link owner win. The original query itself is kept byte for byte, duplicate parameters included.
+// With the path passed through as well, the cleaned path following the short URL is appended to
+// the original URL path, so that it cannot climb above the latter with `..` segments.
+func passthroughURL(originalURL, policy, extraPath string, query url.Values) (string, error) {
+	if policy != models.PassthroughQuery && policy != models.PassthroughQueryAndPath {
+		return originalURL, nil
+	}
+
+	target, err := url.Parse(originalURL)
+	if err != nil {
+		return "", err
+	}
+
+	if policy == models.PassthroughQueryAndPath && extraPath != "" {
+		cleanedPath := path.Clean("/" + extraPath)
+		if strings.HasSuffix(extraPath, "/") && cleanedPath != "/" {
+			cleanedPath += "/"
+		}
+		target = target.JoinPath(cleanedPath)
+	}
+
+	target.RawQuery = mergeQuery(target.RawQuery, query)
+
+	return target.String(), nil
+}
+
+// mergeQuery appends the parameters absent from the raw query to it, sorted by name.
+func mergeQuery(rawQuery string, query url.Values) string {
+	original, _ := url.ParseQuery(rawQuery)
+	extra := url.Values{}
+	for name, values := range query {
+		if _, exists := original[name]; !exists {
+			extra[name] = values
+		}
+	}
+
+	switch {
+	case len(extra) == 0:
+		return rawQuery
+	case rawQuery == "":
+		return extra.Encode()
+	default:
+		return rawQuery + "&" + extra.Encode()
+	}
+}
+
+// timeOrZero returns the optional time, or the zero time if it is not given.
+func timeOrZero(value *time.Time) time.Time {
+	if value == nil {
+		return time.Time{}
+	}
+
+	return *value
+}
+
+// normalizeTag brings the tag to the form it is stored and searched in.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// getRestoreDeadline returns the time before which deleted URLs can no longer be restored.
+func (theRouter Router) getRestoreDeadline() time.Time {
+	if theRouter.urlRestoreGracePeriod == 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(-theRouter.urlRestoreGracePeriod)
+}
+
+// parseUserURLsQuery builds the models.UserURLsQuery from the GetApiuserurls query parameters.
+// Without the `limit` and `cursor` ones, the number of URLs is limited to defaultLimit, zero
+// meaning no limit.
+func parseUserURLsQuery(values url.Values, defaultLimit int) (models.UserURLsQuery, error) {
+	query := models.UserURLsQuery{
+		Limit:  defaultLimit,
+		SortBy: models.UserURLsSortByCreatedAt,
+		Search: values.Get("q"),
+		Tag:    normalizeTag(values.Get("tag")),
+	}
+
+	if values.Get("cursor") != "" {
+		query.Limit = defaultUserURLsPageSize
+	}
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxUserURLsPageSize {
+			return query, fmt.Errorf("the limit must be between 1 and %d", maxUserURLsPageSize)
+		}
+	}
+
+	switch sortBy := values.Get("sort"); sortBy {
+	case "", models.UserURLsSortByCreatedAt:
+	case models.UserURLsSortByOriginalURL:
+		query.SortBy = sortBy
+	default:
+		return query, fmt.Errorf("unknown sort field %q", sortBy)
+	}
+
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("unknown sort order %q", order)
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := decodeUserURLsCursor(cursor)
+		if err != nil {
+			return query, err
+		}
+		if after.SortBy != query.SortBy || after.Descending != query.Descending {
+			return query, errors.New("the cursor was issued for another sort field or order")
+		}
+		query.After = &after
+	}
+
+	return query, nil
+}
+
+// getNextUserURLsPageURL returns the URL of the GetApiuserurls page starting after the cursor,
+// keeping the other query parameters of the request.
+func (theRouter Router) getNextUserURLsPageURL(request *http.Request, after models.UserURLsCursor) string {
+	values := request.URL.Query()
+	values.Set("cursor", encodeUserURLsCursor(after))
+
+	return theRouter.shortURLBase + request.URL.Path + "?" + values.Encode()
+}
+
+func encodeUserURLsCursor(cursor models.UserURLsCursor) string {
+	cursorJSON, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(cursorJSON)
+}
+
+func decodeUserURLsCursor(encoded string) (models.UserURLsCursor, error) {
+	var cursor models.UserURLsCursor
+
+	cursorJSON, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, fmt.Errorf("malformed cursor: %w", err)
+	}
+
+	err = json.Unmarshal(cursorJSON, &cursor)
+	if err != nil || cursor.Short == "" {
+		return cursor, errors.New("malformed cursor")
+	}
+
+	return cursor, nil
+}
+
+func (theRouter Router) isURLTooLong(url string) bool {
+	return theRouter.maxURLLength > 0 && len(url) > theRouter.maxURLLength
+}
+
+func isPasswordTooLong(password string) bool {
+	return len(password) > maxPasswordBytes
+}
+
+// scanNewURL checks the URL with the scanner, if any. It returns the error of the scanner for
+// a URL reported as malicious, and ErrURLScanUnavailable for a URL that could not be scanned
+// unless the scan fails open.
+func (theRouter Router) scanNewURL(ctx context.Context, url string) error {
+	if theRouter.urlScanner == nil {
+		return nil
+	}
+
+	ctx, cancel := theRouter.withURLScanTimeout(ctx)
+	defer cancel()
+
+	return theRouter.scanURL(ctx, url)
+}
+
+// withURLScanTimeout returns the context limiting the scans to the URL scan timeout, if any.
+func (theRouter Router) withURLScanTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if theRouter.urlScanTimeout > 0 {
+		return context.WithTimeout(ctx, theRouter.urlScanTimeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// scanURL checks the URL with the scanner like scanNewURL does, within the deadline of ctx.
+func (theRouter Router) scanURL(ctx context.Context, url string) error {
+	err := theRouter.urlScanner.Scan(ctx, url)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, urlscanner.ErrMaliciousURL):
+		logger.Log.Infoln("malicious URL rejected", zap.String("url", url), zap.Error(err))
+		return err
+	}
+
+	logger.Log.Errorln("error while `theRouter.urlScanner.Scan()` calling: ", zap.String("url", url), zap.Error(err))
+	if theRouter.urlScanFailOpen {
+		return nil
+	}
+
+	return ErrURLScanUnavailable
+}
+
+// scanNewBatchURLs scans the new URLs of the batch concurrently, all of them within the URL scan
+// timeout, and returns the items with the malicious ones. It fails with ErrURLScanUnavailable
+// when a URL could not be scanned unless the scan fails open.
+func (theRouter Router) scanNewBatchURLs(
+	ctx context.Context,
+	requestDTO models.BatchShortenRequest,
+	newURLs []string,
+) (models.BatchShortenErrorResponse, error) {
+	if theRouter.urlScanner == nil {
+		return nil, nil
+	}
+
+	ctx, cancel := theRouter.withURLScanTimeout(ctx)
+	defer cancel()
+
+	errs := make([]error, len(newURLs))
+	semaphore := make(chan struct{}, maxConcurrentURLScans)
+	var wg sync.WaitGroup
+	for i, newURL := range newURLs {
+		wg.Add(1)
+		go func(i int, newURL string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			errs[i] = theRouter.scanURL(ctx, newURL)
+			if errors.Is(errs[i], ErrURLScanUnavailable) {
+				cancel()
+			}
+		}(i, newURL)
+	}
+	wg.Wait()
+
+	scanErrors := map[string]error{}
+	for i, newURL := range newURLs {
+		if errors.Is(errs[i], ErrURLScanUnavailable) {
+			return nil, errs[i]
+		}
+		if errs[i] != nil {
+			scanErrors[newURL] = errs[i]
+		}
+	}
+
+	var rejectedItems models.BatchShortenErrorResponse
+	for _, item := range requestDTO {
+		if err, found := scanErrors[item.OriginalURL]; found {
+			rejectedItems = append(rejectedItems, models.BatchShortenErrorItem{
+				CorrelationID: item.CorrelationID,
+				OriginalURL:   item.OriginalURL,
+				Error:         err.Error(),
+			})
+		}
+	}
+
+	return rejectedItems, nil
+}
+
+// canonicalizeURL returns the canonical form of the URL, or the URL itself without a canonicalizer.
+func (theRouter Router) canonicalizeURL(url string) (string, error) {
+	if theRouter.urlCanonicalizer == nil {
+		return url, nil
+	}
+
+	return theRouter.urlCanonicalizer.Canonicalize(url)
+}
+
+// checkURLToShort returns ErrURLTooLong for the URLs that are too long
+// and the error of checkDestination for the other ones.
+func (theRouter Router) checkURLToShort(ctx context.Context, url string) error {
+	if theRouter.isURLTooLong(url) {
+		return ErrURLTooLong
+	}
+
+	return theRouter.checkDestination(ctx, url)
+}
+
+// checkDestination returns the reason why the URL may not be shortened
+// according to the destination policy and the URL safety policy, if any.
+func (theRouter Router) checkDestination(ctx context.Context, url string) error {
+	if theRouter.destinationPolicy != nil {
+		if err := theRouter.destinationPolicy.Check(url); err != nil {
+			return err
+		}
+	}
+
+	if theRouter.urlSafetyPolicy != nil {
+		return theRouter.urlSafetyPolicy.Check(ctx, url)
+	}
+
+	return nil
+}
+
+// getDisabledLinkStatusCode returns the status code answering the short URLs disabled by the moderators.
+func (theRouter Router) getDisabledLinkStatusCode() int {
+	if theRouter.disabledLinkStatusCode == 0 {
+		return http.StatusGone
+	}
+
+	return theRouter.disabledLinkStatusCode
+}
+
+func (theRouter Router) getShortURL(shortKey string) string {
+	return theRouter.shortURLBase + "/" + shortKey
+}
+
+func (theRouter Router) getShortKey(
+	ctx context.Context,
+	urlToShort string,
+	userID string,
+	attributes models.URLAttributes,
+) (string, error) {
+	ownerID := theRouter.getLinkOwnerID(userID)
+
+	// The new URL is scanned before the transaction is begun not to hold it during the scan.
+	// A URL which link is deleted in the meantime was scanned when the link was created.
+	var short string
+	var found bool
+	var err error
+	if !attributes.IsDedicated() {
+		_, found, err = theRouter.db.FindShortByFull(ctx, urlToShort, ownerID, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !found {
+		err = theRouter.scanNewURL(ctx, urlToShort)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	transaction, err := theRouter.db.BeginTransaction()
+	if err != nil {
+		return "", err
+	}
+
+	if !attributes.IsDedicated() {
+		short, found, err = theRouter.db.FindShortByFull(ctx, urlToShort, ownerID, transaction)
+		if err != nil {
+			_ = theRouter.db.RollbackTransaction(transaction)
+
+			return "", err
+		}
 	}
 
 	var result string
@@ -561,7 +3227,8 @@ func (theRouter Router) getShortKey(ctx context.Context, urlToShort string, user
 
 	if !found {
 		short = uuid.New().String()
-		err = theRouter.db.InsertURLMapping(ctx, short, urlToShort, transaction)
+		attributes.CreatedBy = userID
+		err = theRouter.db.InsertURLMapping(ctx, short, urlToShort, ownerID, attributes, transaction)
 		if err != nil {
 			_ = theRouter.db.RollbackTransaction(transaction)
 
@@ -571,7 +3238,7 @@ func (theRouter Router) getShortKey(ctx context.Context, urlToShort string, user
 		resultErr = nil
 	}
 
-	err = theRouter.db.SaveUserUrls(ctx, userID, []string{urlToShort}, transaction)
+	err = theRouter.db.SaveUserUrls(ctx, userID, []string{result}, transaction)
 	if err != nil {
 		_ = theRouter.db.RollbackTransaction(transaction)
 
@@ -11,14 +11,18 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
@@ -26,6 +30,8 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/patric-chuzhbe/urlshrt/internal/db/jsondb"
@@ -34,11 +40,16 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/patric-chuzhbe/urlshrt/internal/attemptslimiter"
 	"github.com/patric-chuzhbe/urlshrt/internal/auth"
 	"github.com/patric-chuzhbe/urlshrt/internal/config"
 	"github.com/patric-chuzhbe/urlshrt/internal/db/memorystorage"
+	"github.com/patric-chuzhbe/urlshrt/internal/domainpolicy"
 	"github.com/patric-chuzhbe/urlshrt/internal/logger"
 	"github.com/patric-chuzhbe/urlshrt/internal/models"
+	"github.com/patric-chuzhbe/urlshrt/internal/urlcanonicalizer"
+	"github.com/patric-chuzhbe/urlshrt/internal/urlsafety"
+	"github.com/patric-chuzhbe/urlshrt/internal/urlscanner"
 	"github.com/patric-chuzhbe/urlshrt/internal/user"
 )
 
@@ -50,8 +61,15 @@ const (
 
 type testStorage interface {
 	storage
+	FindFullByShort(ctx context.Context, short string) (string, bool, error)
 	CreateUser(ctx context.Context, usr *user.User, transaction *sql.Tx) (string, error)
 	GetUserByID(ctx context.Context, userID string, transaction *sql.Tx) (*user.User, error)
+	SaveRefreshToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) error
+	ConsumeRefreshToken(ctx context.Context, tokenHash string) (string, error)
+	GetRefreshTokenUserID(ctx context.Context, tokenHash string) (string, error)
+	DeleteRefreshToken(ctx context.Context, tokenHash string) error
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
 	Close() error
 }
 
@@ -65,11 +83,46 @@ func (m *mockAuth) RegisterNewUser(h http.Handler) http.Handler {
 	return h
 }
 
+func (m *mockAuth) IssueImpersonationToken(ctx context.Context, adminID, userID string) (string, time.Time, error) {
+	return adminID + ":" + userID, time.Now().Add(time.Hour), nil
+}
+
+func (m *mockAuth) IssueUserTokens(
+	ctx context.Context,
+	response http.ResponseWriter,
+	userID string,
+) (models.AuthTokens, error) {
+	return models.AuthTokens{Token: userID, ExpiresAt: time.Now().Add(time.Hour), RefreshToken: userID}, nil
+}
+
+func (m *mockAuth) RefreshTokens(
+	response http.ResponseWriter,
+	request *http.Request,
+	refreshToken string,
+) (models.AuthTokens, error) {
+	return models.AuthTokens{Token: refreshToken, ExpiresAt: time.Now().Add(time.Hour), RefreshToken: refreshToken}, nil
+}
+
+func (m *mockAuth) Logout(response http.ResponseWriter, request *http.Request, refreshToken string) error {
+	return nil
+}
+
 type initOption func(*initOptions)
 
 type initOptions struct {
-	mockAuth    bool
-	mockStorage testStorage
+	mockAuth                    bool
+	mockStorage                 testStorage
+	urlOwnershipMode            string
+	linkPasswordAttemptsLimiter attemptsLimiter
+	loginAttemptsLimiter        attemptsLimiter
+	destinationPolicy           destinationPolicy
+	urlSafetyPolicy             urlSafetyPolicy
+	urlCanonicalizer            urlCanonicalizer
+	urlScanner                  urlScanner
+	urlScanFailOpen             bool
+	urlScanTimeout              time.Duration
+	disabledLinkStatusCode      int
+	authOptions                 []auth.InitOption
 }
 
 func getPostApishortenbatchRequest(amountOfURLs int) models.BatchShortenRequest {
@@ -618,6 +671,67 @@ func withMockStorage(db testStorage) initOption {
 	}
 }
 
+func withURLOwnershipMode(value string) initOption {
+	return func(options *initOptions) {
+		options.urlOwnershipMode = value
+	}
+}
+
+func withLinkPasswordAttemptsLimiter(value attemptsLimiter) initOption {
+	return func(options *initOptions) {
+		options.linkPasswordAttemptsLimiter = value
+	}
+}
+
+func withLoginAttemptsLimiter(value attemptsLimiter) initOption {
+	return func(options *initOptions) {
+		options.loginAttemptsLimiter = value
+	}
+}
+
+func withDestinationPolicy(value destinationPolicy) initOption {
+	return func(options *initOptions) {
+		options.destinationPolicy = value
+	}
+}
+
+func withURLSafetyPolicy(value urlSafetyPolicy) initOption {
+	return func(options *initOptions) {
+		options.urlSafetyPolicy = value
+	}
+}
+
+func withURLCanonicalizer(value urlCanonicalizer) initOption {
+	return func(options *initOptions) {
+		options.urlCanonicalizer = value
+	}
+}
+
+func withURLScanner(value urlScanner, failOpen bool) initOption {
+	return func(options *initOptions) {
+		options.urlScanner = value
+		options.urlScanFailOpen = failOpen
+	}
+}
+
+func withURLScanTimeout(value time.Duration) initOption {
+	return func(options *initOptions) {
+		options.urlScanTimeout = value
+	}
+}
+
+func withDisabledLinkStatusCode(value int) initOption {
+	return func(options *initOptions) {
+		options.disabledLinkStatusCode = value
+	}
+}
+
+func withAuthOptions(value ...auth.InitOption) initOption {
+	return func(options *initOptions) {
+		options.authOptions = value
+	}
+}
+
 func withMockAuth(value bool) initOption {
 	return func(options *initOptions) {
 		options.mockAuth = value
@@ -625,7 +739,9 @@ func withMockAuth(value bool) initOption {
 }
 
 func setupTestRouter(t *testing.T, optionsProto ...initOption) (*httptest.Server, testStorage, *chi.Mux, *mockUrlsRemover) {
-	options := &initOptions{}
+	options := &initOptions{
+		urlOwnershipMode: models.URLOwnershipModeShared,
+	}
 	for _, protoOption := range optionsProto {
 		protoOption(options)
 	}
@@ -634,6 +750,9 @@ func setupTestRouter(t *testing.T, optionsProto ...initOption) (*httptest.Server
 	if t != nil {
 		require.NoError(t, err)
 	}
+	if options.urlScanTimeout > 0 {
+		cfg.URLScanTimeout = options.urlScanTimeout
+	}
 
 	var db testStorage
 	if options.mockStorage != nil {
@@ -645,9 +764,10 @@ func setupTestRouter(t *testing.T, optionsProto ...initOption) (*httptest.Server
 			cfg.DBConnectionTimeout,
 			migrationsDir,
 			postgresdb.WithDBPreReset(true),
+			postgresdb.WithURLOwnershipMode(options.urlOwnershipMode),
 		)
 	} else {
-		db, err = memorystorage.New()
+		db, err = memorystorage.New(jsondb.WithURLOwnershipMode(options.urlOwnershipMode))
 	}
 	if t != nil {
 		require.NoError(t, err)
@@ -663,7 +783,7 @@ func setupTestRouter(t *testing.T, optionsProto ...initOption) (*httptest.Server
 	if options.mockAuth {
 		authMiddleware = &mockAuth{}
 	} else {
-		authMiddleware = auth.New(db, cfg.AuthCookieName, authKey)
+		authMiddleware = auth.New(db, cfg.AuthCookieName, authKey, options.authOptions...)
 	}
 
 	urlsRemover := &mockUrlsRemover{}
@@ -673,6 +793,19 @@ func setupTestRouter(t *testing.T, optionsProto ...initOption) (*httptest.Server
 		cfg.ShortURLBase,
 		authMiddleware,
 		urlsRemover,
+		WithMaxURLLength(cfg.MaxURLLength),
+		WithURLOwnershipMode(options.urlOwnershipMode),
+		WithLinkPasswordAttemptsLimiter(options.linkPasswordAttemptsLimiter),
+		WithLoginAttemptsLimiter(options.loginAttemptsLimiter),
+		WithDestinationPolicy(options.destinationPolicy),
+		WithURLSafetyPolicy(options.urlSafetyPolicy),
+		WithURLCanonicalizer(options.urlCanonicalizer),
+		WithURLScanner(options.urlScanner),
+		WithURLScanFailOpen(options.urlScanFailOpen),
+		WithURLScanTimeout(cfg.URLScanTimeout),
+		WithRedirectStatusCode(cfg.RedirectStatusCode),
+		WithPermanentRedirectMaxAge(cfg.PermanentRedirectMaxAge),
+		WithDisabledLinkStatusCode(options.disabledLinkStatusCode),
 	)
 
 	err = logger.Init("debug")
@@ -683,6 +816,38 @@ func setupTestRouter(t *testing.T, optionsProto ...initOption) (*httptest.Server
 	return httptest.NewServer(theRouter), db, theRouter, urlsRemover
 }
 
+// serveAsUser serves a request with the JSON body through r on behalf of the user
+// (put in the context, as the mock auth expects) and returns the recorded response.
+func serveAsUser(r http.Handler, userID, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// decodeShortPath decodes the models.ShortenResponse of rec and returns the path of its short URL.
+func decodeShortPath(t *testing.T, rec *httptest.ResponseRecorder) string {
+	var shortenResponse models.ShortenResponse
+	err := json.NewDecoder(rec.Body).Decode(&shortenResponse)
+	require.NoError(t, err)
+	shortURL, err := url.Parse(shortenResponse.Result)
+	require.NoError(t, err)
+
+	return shortURL.Path
+}
+
+// shortenAsUser posts the body to /api/shorten on behalf of the user, requires the short URL
+// to be created and returns its path.
+func shortenAsUser(t *testing.T, r http.Handler, userID, body string) string {
+	rec := serveAsUser(r, userID, http.MethodPost, "/api/shorten", body)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	return decodeShortPath(t, rec)
+}
+
 func TestPostApishortenbatch(t *testing.T) {
 	server, db, _, _ := setupTestRouter(t)
 	defer server.Close()
@@ -973,6 +1138,7 @@ func TestGetApiuserurls(t *testing.T) {
 			mock.Anything,
 			userID,
 			mock.Anything,
+			mock.Anything,
 		).
 			Return(
 				models.UserUrls(nil),
@@ -987,3 +1153,2189 @@ func TestGetApiuserurls(t *testing.T) {
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 }
+
+func TestMaxURLLength(t *testing.T) {
+	server, _, _, _ := setupTestRouter(t)
+	defer server.Close()
+
+	cfg, err := config.New(config.WithDisableFlagsParsing(true))
+	require.NoError(t, err)
+
+	longURL := "https://example.com/" + strings.Repeat("a", 300)
+	tooLongURL := "https://example.com/" + strings.Repeat("a", cfg.MaxURLLength)
+
+	tests := []struct {
+		name               string
+		path               string
+		contentType        string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{
+			name:               "plain text: URL longer than 255 characters",
+			path:               "/",
+			contentType:        "text/plain",
+			requestBody:        longURL,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "plain text: URL exceeds the limit",
+			path:               "/",
+			contentType:        "text/plain",
+			requestBody:        tooLongURL,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:               "JSON: URL exceeds the limit",
+			path:               "/api/shorten",
+			contentType:        "application/json",
+			requestBody:        fmt.Sprintf(`{"url":%q}`, tooLongURL),
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:               "batch: one of the URLs exceeds the limit",
+			path:               "/api/shorten/batch",
+			contentType:        "application/json",
+			requestBody:        fmt.Sprintf(`[{"correlation_id":"1","original_url":%q}]`, tooLongURL),
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	client := &http.Client{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, server.URL+tt.path, strings.NewReader(tt.requestBody))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", tt.contentType)
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestPerUserOwnershipMode(t *testing.T) {
+	server, db, r, _ := setupTestRouter(
+		t,
+		withMockAuth(true),
+		withURLOwnershipMode(models.URLOwnershipModePerUser),
+	)
+	defer server.Close()
+
+	shorten := func(userID string) (int, string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/shared"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		var responseDTO models.ShortenResponse
+		err := json.NewDecoder(rec.Body).Decode(&responseDTO)
+		require.NoError(t, err)
+
+		return rec.Code, responseDTO.Result
+	}
+
+	userA, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+	userB, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	codeA, shortA := shorten(userA)
+	assert.Equal(t, http.StatusCreated, codeA)
+
+	codeB, shortB := shorten(userB)
+	assert.Equal(t, http.StatusCreated, codeB)
+	assert.NotEqual(t, shortA, shortB)
+
+	codeA, shortAAgain := shorten(userA)
+	assert.Equal(t, http.StatusConflict, codeA)
+	assert.Equal(t, shortA, shortAAgain)
+}
+
+func TestPatchApiuserurl(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	patch := func(userID, short, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/"+short, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	userA, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+	userB, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	err = db.SaveNewFullsAndShorts(
+		context.Background(),
+		map[string]string{"https://example.com/a": "aaaaaaaa", "https://example.com/b": "bbbbbbbb"},
+		"",
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+	err = db.SaveUserUrls(context.Background(), userA, []string{"aaaaaaaa", "bbbbbbbb"}, nil)
+	require.NoError(t, err)
+	err = db.SaveUserUrls(context.Background(), userB, []string{"bbbbbbbb"}, nil)
+	require.NoError(t, err)
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		rec := patch("", "aaaaaaaa", `{"url":"https://example.com/new"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("invalid URL", func(t *testing.T) {
+		rec := patch(userA, "aaaaaaaa", `{"url":"not a url"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	t.Run("fields other than the URL", func(t *testing.T) {
+		for _, body := range []string{
+			`{"url":"https://example.com/new","password":"secret123"}`,
+			`{"url":"https://example.com/new","max_clicks":5}`,
+			`{"url":"https://example.com/new","utm":{"source":"mail"}}`,
+			`{"url":"https://example.com/new","title":"New"}`,
+		} {
+			rec := patch(userA, "aaaaaaaa", body)
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, body)
+		}
+	})
+
+	t.Run("not linked to the user", func(t *testing.T) {
+		rec := patch(userB, "aaaaaaaa", `{"url":"https://example.com/new"}`)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("shared with another user", func(t *testing.T) {
+		rec := patch(userA, "bbbbbbbb", `{"url":"https://example.com/new"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("already shortened", func(t *testing.T) {
+		rec := patch(userA, "aaaaaaaa", `{"url":"https://example.com/b"}`)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("retargeted", func(t *testing.T) {
+		rec := patch(userA, "aaaaaaaa", `{"url":"https://example.com/new"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var responseDTO models.UserURL
+		err := json.NewDecoder(rec.Body).Decode(&responseDTO)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/new", responseDTO.OriginalURL)
+		assert.True(t, strings.HasSuffix(responseDTO.ShortURL, "/aaaaaaaa"))
+
+		full, found, err := db.FindFullByShort(context.Background(), "aaaaaaaa")
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "https://example.com/new", full)
+	})
+}
+
+func TestRestoreApiuserurls(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	post := func(userID, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	err = db.SaveNewFullsAndShorts(
+		context.Background(),
+		map[string]string{
+			"https://example.com/a": "aaaaaaaa",
+			"https://example.com/b": "bbbbbbbb",
+			"https://example.com/c": "cccccccc",
+		},
+		"",
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+	err = db.SaveUserUrls(context.Background(), userID, []string{"aaaaaaaa", "bbbbbbbb", "cccccccc"}, nil)
+	require.NoError(t, err)
+
+	remover, ok := db.(interface {
+		RemoveUsersUrls(ctx context.Context, usersURLs map[string][]string) error
+	})
+	require.True(t, ok)
+	err = remover.RemoveUsersUrls(
+		context.Background(),
+		map[string][]string{userID: {"aaaaaaaa", "bbbbbbbb", "cccccccc"}},
+	)
+	require.NoError(t, err)
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		rec := post("", "/api/user/urls/aaaaaaaa/restore", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("single", func(t *testing.T) {
+		rec := post(userID, "/api/user/urls/aaaaaaaa/restore", "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = post(userID, "/api/user/urls/aaaaaaaa/restore", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		rec := post(userID, "/api/user/urls/restore", `["bbbbbbbb","cccccccc","unknown"]`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var restored models.RestoreURLsResponse
+		err := json.NewDecoder(rec.Body).Decode(&restored)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, models.RestoreURLsResponse{"bbbbbbbb", "cccccccc"}, restored)
+	})
+
+	userUrls, err := db.GetUserUrls(context.Background(), userID, models.UserURLsQuery{}, nil)
+	require.NoError(t, err)
+	assert.Len(t, userUrls, 3)
+}
+
+func TestReshortenDeletedLink(t *testing.T) {
+	for _, mode := range []string{models.URLOwnershipModeShared, models.URLOwnershipModePerUser} {
+		t.Run(mode, func(t *testing.T) {
+			server, db, r, _ := setupTestRouter(t, withMockAuth(true), withURLOwnershipMode(mode))
+			defer server.Close()
+
+			userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+			require.NoError(t, err)
+
+			const originalURL = "https://example.com/deleted"
+			shortPath := shortenAsUser(t, r, userID, `{"url":"`+originalURL+`"}`)
+
+			remover, ok := db.(interface {
+				RemoveUsersUrls(ctx context.Context, usersURLs map[string][]string) error
+			})
+			require.True(t, ok)
+			err = remover.RemoveUsersUrls(
+				context.Background(),
+				map[string][]string{userID: {strings.TrimPrefix(shortPath, "/")}},
+			)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusGone, serveAsUser(r, userID, http.MethodGet, shortPath, "").Code)
+
+			rec := serveAsUser(r, userID, http.MethodPost, "/api/shorten", `{"url":"`+originalURL+`"}`)
+			require.Equal(t, http.StatusConflict, rec.Code)
+			assert.Equal(t, shortPath, decodeShortPath(t, rec))
+
+			rec = serveAsUser(r, userID, http.MethodGet, shortPath, "")
+			assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+			assert.Equal(t, originalURL, rec.Header().Get("Location"))
+
+			userURLs, err := db.GetUserUrls(context.Background(), userID, models.UserURLsQuery{}, nil)
+			require.NoError(t, err)
+			assert.Len(t, userURLs, 1)
+		})
+	}
+}
+
+func TestGetApiuserurlsPagination(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	for _, short := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc", "dddddddd", "eeeeeeee"} {
+		err = db.InsertURLMapping(context.Background(), short, "https://example.com/"+short, "", models.URLAttributes{}, nil)
+		require.NoError(t, err)
+	}
+	err = db.InsertURLMapping(context.Background(), "ffffffff", "https://other.com/ffffffff", "", models.URLAttributes{}, nil)
+	require.NoError(t, err)
+	err = db.SaveUserUrls(
+		context.Background(),
+		userID,
+		[]string{"aaaaaaaa", "bbbbbbbb", "cccccccc", "dddddddd", "eeeeeeee", "ffffffff"},
+		nil,
+	)
+	require.NoError(t, err)
+
+	getPage := func(target string) (*httptest.ResponseRecorder, models.UserUrls) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		var userUrls models.UserUrls
+		if rec.Code == http.StatusOK {
+			err := json.NewDecoder(rec.Body).Decode(&userUrls)
+			require.NoError(t, err)
+		}
+
+		return rec, userUrls
+	}
+
+	nextPage := func(rec *httptest.ResponseRecorder) string {
+		link := rec.Header().Get("Link")
+		if link == "" {
+			return ""
+		}
+		matches := regexp.MustCompile(`^<([^>]+)>; rel="next"$`).FindStringSubmatch(link)
+		require.Len(t, matches, 2)
+		nextURL, err := url.Parse(matches[1])
+		require.NoError(t, err)
+
+		return nextURL.RequestURI()
+	}
+
+	t.Run("walks all pages", func(t *testing.T) {
+		var originalURLs []string
+		target := "/api/user/urls?limit=2&sort=original_url&order=desc&q=EXAMPLE"
+		for target != "" {
+			rec, userUrls := getPage(target)
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.LessOrEqual(t, len(userUrls), 2)
+			for _, userURL := range userUrls {
+				originalURLs = append(originalURLs, userURL.OriginalURL)
+			}
+			target = nextPage(rec)
+		}
+
+		assert.Equal(t, []string{
+			"https://example.com/eeeeeeee",
+			"https://example.com/dddddddd",
+			"https://example.com/cccccccc",
+			"https://example.com/bbbbbbbb",
+			"https://example.com/aaaaaaaa",
+		}, originalURLs)
+	})
+
+	t.Run("rejects a cursor replayed with another sort", func(t *testing.T) {
+		rec, _ := getPage("/api/user/urls?limit=2&sort=original_url&order=desc")
+		require.Equal(t, http.StatusOK, rec.Code)
+		next, err := url.Parse(nextPage(rec))
+		require.NoError(t, err)
+		cursor := next.Query().Get("cursor")
+		require.NotEmpty(t, cursor)
+
+		for _, query := range []string{"sort=original_url", "order=desc", "sort=created_at&order=desc"} {
+			rec, _ := getPage("/api/user/urls?limit=2&" + query + "&cursor=" + cursor)
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, query)
+		}
+		rec, _ = getPage("/api/user/urls?limit=2&sort=original_url&order=desc&cursor=" + cursor)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("defaults to creation order", func(t *testing.T) {
+		rec, userUrls := getPage("/api/user/urls?limit=10")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Link"))
+		require.Len(t, userUrls, 6)
+		assert.True(t, strings.HasSuffix(userUrls[0].ShortURL, "/aaaaaaaa"))
+		assert.True(t, strings.HasSuffix(userUrls[5].ShortURL, "/ffffffff"))
+	})
+
+	t.Run("returns all the URLs without pagination parameters", func(t *testing.T) {
+		otherUserID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+		shorts := make([]string, 0, defaultUserURLsPageSize+1)
+		for i := 0; i <= defaultUserURLsPageSize; i++ {
+			short := fmt.Sprintf("many%04d", i)
+			err = db.InsertURLMapping(context.Background(), short, "https://many.com/"+short, "", models.URLAttributes{}, nil)
+			require.NoError(t, err)
+			shorts = append(shorts, short)
+		}
+		require.NoError(t, db.SaveUserUrls(context.Background(), otherUserID, shorts, nil))
+
+		rec := serveAsUser(r, otherUserID, http.MethodGet, "/api/user/urls", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Link"))
+		var userUrls models.UserUrls
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&userUrls))
+		assert.Len(t, userUrls, defaultUserURLsPageSize+1)
+	})
+
+	for name, target := range map[string]string{
+		"invalid limit":  "/api/user/urls?limit=0",
+		"invalid sort":   "/api/user/urls?sort=short",
+		"invalid order":  "/api/user/urls?order=up",
+		"invalid cursor": "/api/user/urls?cursor=not-a-cursor",
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec, _ := getPage(target)
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		})
+	}
+}
+
+func TestLinkMetadata(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		return serveAsUser(r, userID, method, target, body)
+	}
+
+	t.Run("too long title", func(t *testing.T) {
+		body := `{"url":"https://example.com/long","title":"` + strings.Repeat("a", 256) + `"}`
+		rec := request(http.MethodPost, "/api/shorten", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	rec := request(
+		http.MethodPost,
+		"/api/shorten",
+		`{"url":"https://example.com/meta","title":"Example","description":"An example link"}`,
+	)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = request(http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var userUrls models.UserUrls
+	err = json.NewDecoder(rec.Body).Decode(&userUrls)
+	require.NoError(t, err)
+	require.Len(t, userUrls, 1)
+	assert.Equal(t, "https://example.com/meta", userUrls[0].OriginalURL)
+	assert.Equal(t, "Example", userUrls[0].Title)
+	assert.Equal(t, "An example link", userUrls[0].Description)
+	assert.False(t, userUrls[0].CreatedAt.IsZero())
+	assert.Equal(t, userUrls[0].CreatedAt, userUrls[0].UpdatedAt)
+}
+
+func TestUserURLsTags(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		return serveAsUser(r, userID, method, target, body)
+	}
+
+	err = db.SaveNewFullsAndShorts(
+		context.Background(),
+		map[string]string{
+			"https://example.com/a": "aaaaaaaa",
+			"https://example.com/b": "bbbbbbbb",
+		},
+		"",
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+	err = db.SaveUserUrls(context.Background(), userID, []string{"aaaaaaaa", "bbbbbbbb"}, nil)
+	require.NoError(t, err)
+
+	t.Run("no tags yet", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/user/tags", "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		rec := request(http.MethodPost, "/api/user/urls/tags", `{"short_urls":["aaaaaaaa"],"tags":["  "]}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	t.Run("add and remove", func(t *testing.T) {
+		rec := request(
+			http.MethodPost,
+			"/api/user/urls/tags",
+			`{"short_urls":["aaaaaaaa","bbbbbbbb","unknown"],"tags":[" Work ","news"]}`,
+		)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var tagged models.TagURLsResponse
+		err := json.NewDecoder(rec.Body).Decode(&tagged)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, models.TagURLsResponse{"aaaaaaaa", "bbbbbbbb"}, tagged)
+
+		rec = request(http.MethodDelete, "/api/user/urls/tags", `{"short_urls":["bbbbbbbb"],"tags":["news"]}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = request(http.MethodGet, "/api/user/tags", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var tags models.UserTags
+		err = json.NewDecoder(rec.Body).Decode(&tags)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserTags{{Tag: "news", URLsCount: 1}, {Tag: "work", URLsCount: 2}}, tags)
+	})
+
+	t.Run("filter by tag", func(t *testing.T) {
+		rec := request(http.MethodGet, "/api/user/urls?tag=NEWS", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var userUrls models.UserUrls
+		err := json.NewDecoder(rec.Body).Decode(&userUrls)
+		require.NoError(t, err)
+		require.Len(t, userUrls, 1)
+		assert.Equal(t, "https://example.com/a", userUrls[0].OriginalURL)
+		assert.Equal(t, []string{"news", "work"}, userUrls[0].Tags)
+	})
+}
+
+func TestPasswordProtectedLink(t *testing.T) {
+	server, db, r, _ := setupTestRouter(
+		t,
+		withMockAuth(true),
+		withLinkPasswordAttemptsLimiter(attemptslimiter.New(2, time.Minute)),
+	)
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	t.Run("rejects passwords longer than 72 bytes", func(t *testing.T) {
+		password := strings.Repeat("é", 40)
+		rec := serveAsUser(r, userID, http.MethodPost, "/api/shorten", `{"url":"https://example.com/long","password":"`+password+`"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+		rec = serveAsUser(
+			r,
+			userID,
+			http.MethodPost,
+			"/api/shorten/batch",
+			`[{"correlation_id":"1","original_url":"https://example.com/long","password":"`+password+`"}]`,
+		)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	shortPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/internal","password":"s3cret"}`)
+
+	open := func(method, password string, header bool) *httptest.ResponseRecorder {
+		var body io.Reader
+		if method == http.MethodPost {
+			body = strings.NewReader(url.Values{"password": {password}}.Encode())
+		}
+		req := httptest.NewRequest(method, shortPath, body)
+		if method == http.MethodPost {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if header {
+			req.Header.Set("X-Link-Password", password)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("serves the password form", func(t *testing.T) {
+		rec := open(http.MethodGet, "", false)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, rec.Body.String(), `name="password"`)
+	})
+
+	t.Run("accepts the password in the header", func(t *testing.T) {
+		rec := open(http.MethodGet, "s3cret", true)
+		assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+		assert.Equal(t, "https://example.com/internal", rec.Header().Get("Location"))
+	})
+
+	t.Run("accepts the password from the form", func(t *testing.T) {
+		rec := open(http.MethodPost, "s3cret", false)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "https://example.com/internal", rec.Header().Get("Location"))
+	})
+
+	t.Run("limits wrong passwords", func(t *testing.T) {
+		rec := open(http.MethodPost, "wrong", false)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Wrong password")
+
+		rec = open(http.MethodGet, "wrong", true)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = open(http.MethodGet, "s3cret", true)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	})
+}
+
+func TestDedicatedLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+	}{
+		{
+			name:    "password",
+			options: `"password":"s3cret"`,
+		},
+		{
+			name:    "max clicks",
+			options: `"max_clicks":1`,
+		},
+		{
+			name:    "active from",
+			options: `"active_from":"2100-01-01T00:00:00Z"`,
+		},
+		{
+			name:    "redirect code",
+			options: `"redirect_code":301`,
+		},
+		{
+			name:    "passthrough",
+			options: `"passthrough":"query"`,
+		},
+		{
+			name:    "interstitial",
+			options: `"interstitial":true`,
+		},
+	}
+	for _, mode := range []string{models.URLOwnershipModeShared, models.URLOwnershipModePerUser} {
+		for _, tt := range tests {
+			t.Run(mode+" "+tt.name, func(t *testing.T) {
+				server, db, r, _ := setupTestRouter(t, withMockAuth(true), withURLOwnershipMode(mode))
+				defer server.Close()
+
+				ownerID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+				require.NoError(t, err)
+				otherUserID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+				require.NoError(t, err)
+
+				const originalURL = "https://example.com/dedicated"
+				plainPath := shortenAsUser(t, r, ownerID, `{"url":"`+originalURL+`"}`)
+				dedicatedPath := shortenAsUser(t, r, ownerID, `{"url":"`+originalURL+`",`+tt.options+`}`)
+				assert.NotEqual(t, plainPath, dedicatedPath)
+				otherDedicatedPath := shortenAsUser(t, r, otherUserID, `{"url":"`+originalURL+`",`+tt.options+`}`)
+				assert.NotEqual(t, dedicatedPath, otherDedicatedPath)
+
+				rec := serveAsUser(r, ownerID, http.MethodPost, "/api/shorten", `{"url":"`+originalURL+`"}`)
+				require.Equal(t, http.StatusConflict, rec.Code)
+				assert.Equal(t, plainPath, decodeShortPath(t, rec))
+
+				rec = serveAsUser(
+					r,
+					ownerID,
+					http.MethodPost,
+					"/api/shorten/batch",
+					`[{"correlation_id":"1","original_url":"`+originalURL+`",`+tt.options+`},`+
+						`{"correlation_id":"2","original_url":"`+originalURL+`",`+tt.options+`},`+
+						`{"correlation_id":"3","original_url":"`+originalURL+`"}]`,
+				)
+				require.Equal(t, http.StatusCreated, rec.Code)
+				var batchResponse models.BatchShortenResponse
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&batchResponse))
+				require.Len(t, batchResponse, 3)
+				shortPaths := make([]string, 0, len(batchResponse))
+				for _, item := range batchResponse {
+					shortURL, err := url.Parse(item.ShortURL)
+					require.NoError(t, err)
+					shortPaths = append(shortPaths, shortURL.Path)
+				}
+				assert.NotContains(t, shortPaths[:2], plainPath)
+				assert.NotContains(t, shortPaths[:2], dedicatedPath)
+				assert.NotEqual(t, shortPaths[0], shortPaths[1])
+				assert.Equal(t, plainPath, shortPaths[2])
+			})
+		}
+	}
+}
+
+func TestLinksDedicatedAfterCreation(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		query    string
+		location string
+	}{
+		{
+			name:     "redirect rules",
+			target:   "/rules",
+			body:     `[{"query_param":"campaign","url":"https://evil.example/x"}]`,
+			query:    "?campaign=1",
+			location: "https://evil.example/x",
+		},
+		{
+			name:   "active from",
+			target: "/active_from",
+			body:   `{"active_from":"2099-01-01T00:00:00Z"}`,
+		},
+		{
+			name:     "destinations",
+			target:   "/destinations",
+			body:     `[{"url":"https://evil.example/x","weight":1}]`,
+			location: "https://evil.example/x",
+		},
+	}
+	for _, mode := range []string{models.URLOwnershipModeShared, models.URLOwnershipModePerUser} {
+		for _, tt := range tests {
+			t.Run(mode+" "+tt.name, func(t *testing.T) {
+				server, db, r, _ := setupTestRouter(t, withMockAuth(true), withURLOwnershipMode(mode))
+				defer server.Close()
+
+				ownerID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+				require.NoError(t, err)
+				otherUserID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+				require.NoError(t, err)
+
+				const originalURL = "https://example.com/popular"
+				ownerPath := shortenAsUser(t, r, ownerID, `{"url":"`+originalURL+`"}`)
+				rec := serveAsUser(r, ownerID, http.MethodPut, "/api/user/urls"+ownerPath+tt.target, tt.body)
+				require.Equal(t, http.StatusNoContent, rec.Code)
+
+				otherPath := shortenAsUser(t, r, otherUserID, `{"url":"`+originalURL+`"}`)
+				assert.NotEqual(t, ownerPath, otherPath)
+				rec = serveAsUser(r, otherUserID, http.MethodGet, otherPath+tt.query, "")
+				assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+				assert.Equal(t, originalURL, rec.Header().Get("Location"))
+
+				rec = serveAsUser(r, ownerID, http.MethodGet, ownerPath+tt.query, "")
+				if tt.location == "" {
+					assert.Equal(t, http.StatusNotFound, rec.Code)
+				} else {
+					assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+					assert.Equal(t, tt.location, rec.Header().Get("Location"))
+				}
+
+				rec = serveAsUser(r, ownerID, http.MethodPost, "/api/shorten", `{"url":"`+originalURL+`"}`)
+				assert.NotEqual(t, ownerPath, decodeShortPath(t, rec))
+			})
+		}
+	}
+}
+
+func TestMaxClicksLink(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	t.Run("negative max clicks", func(t *testing.T) {
+		rec := serveAsUser(r, userID, http.MethodPost, "/api/shorten", `{"url":"https://example.com/negative","max_clicks":-1}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	shortPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/secret","max_clicks":1}`)
+
+	rec := serveAsUser(r, userID, http.MethodGet, shortPath, "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/secret", rec.Header().Get("Location"))
+
+	rec = serveAsUser(r, userID, http.MethodGet, shortPath, "")
+	assert.Equal(t, http.StatusGone, rec.Code)
+}
+
+func TestActiveFromLink(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		return serveAsUser(r, userID, method, target, body)
+	}
+
+	activeFrom := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	shortPath := shortenAsUser(
+		t,
+		r,
+		userID,
+		`{"url":"https://example.com/launch","active_from":"`+activeFrom.Format(time.RFC3339)+`"}`,
+	)
+
+	rec := request(http.MethodGet, shortPath, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = request(http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var userUrls models.UserUrls
+	err = json.NewDecoder(rec.Body).Decode(&userUrls)
+	require.NoError(t, err)
+	require.Len(t, userUrls, 1)
+	require.NotNil(t, userUrls[0].ActiveFrom)
+	assert.True(t, activeFrom.Equal(*userUrls[0].ActiveFrom))
+
+	rec = request(http.MethodPut, "/api/user/urls/unknown/active_from", `{"active_from":null}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = request(http.MethodPut, "/api/user/urls"+shortPath+"/active_from", `{"active_from":null}`)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = request(http.MethodGet, shortPath, "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+}
+
+func TestRedirectCode(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		return serveAsUser(r, userID, method, target, body)
+	}
+
+	rec := request(http.MethodPost, "/api/shorten", `{"url":"https://example.com/see-other","redirect_code":303}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	tests := []struct {
+		name             string
+		body             string
+		wantStatusCode   int
+		wantCacheControl string
+	}{
+		{
+			name:             "default code",
+			body:             `{"url":"https://example.com/default"}`,
+			wantStatusCode:   http.StatusTemporaryRedirect,
+			wantCacheControl: "private, no-store",
+		},
+		{
+			name:             "tracking link",
+			body:             `{"url":"https://example.com/tracking","redirect_code":302}`,
+			wantStatusCode:   http.StatusFound,
+			wantCacheControl: "private, no-store",
+		},
+		{
+			name:             "permanent link",
+			body:             `{"url":"https://example.com/seo","redirect_code":301}`,
+			wantStatusCode:   http.StatusMovedPermanently,
+			wantCacheControl: "public, max-age=86400",
+		},
+		{
+			name:             "permanent link limited in clicks",
+			body:             `{"url":"https://example.com/limited","redirect_code":308,"max_clicks":10}`,
+			wantStatusCode:   http.StatusPermanentRedirect,
+			wantCacheControl: "private, no-store",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(http.MethodGet, shortenAsUser(t, r, userID, tt.body), "")
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			assert.Equal(t, tt.wantCacheControl, rec.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestPassthroughURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		originalURL string
+		policy      string
+		extraPath   string
+		query       string
+		want        string
+	}{
+		{
+			name:        "off",
+			originalURL: "https://example.com/landing?a=1",
+			policy:      models.PassthroughOff,
+			query:       "utm_source=x",
+			want:        "https://example.com/landing?a=1",
+		},
+		{
+			name:        "empty policy is off",
+			originalURL: "https://example.com/landing",
+			query:       "utm_source=x",
+			want:        "https://example.com/landing",
+		},
+		{
+			name:        "query appended",
+			originalURL: "https://example.com/landing",
+			policy:      models.PassthroughQuery,
+			query:       "utm_source=x&b=2&b=3",
+			want:        "https://example.com/landing?b=2&b=3&utm_source=x",
+		},
+		{
+			name:        "original parameters win",
+			originalURL: "https://example.com/landing?ref=owner&ref=second#top",
+			policy:      models.PassthroughQuery,
+			query:       "ref=visitor&utm_source=x",
+			want:        "https://example.com/landing?ref=owner&ref=second&utm_source=x#top",
+		},
+		{
+			name:        "path ignored by the query policy",
+			originalURL: "https://example.com/landing",
+			policy:      models.PassthroughQuery,
+			extraPath:   "extra/path",
+			want:        "https://example.com/landing",
+		},
+		{
+			name:        "path appended",
+			originalURL: "https://example.com/docs/",
+			policy:      models.PassthroughQueryAndPath,
+			extraPath:   "extra/path/",
+			query:       "utm_source=x",
+			want:        "https://example.com/docs/extra/path/?utm_source=x",
+		},
+		{
+			name:        "path cannot climb above the original one",
+			originalURL: "https://example.com/docs",
+			policy:      models.PassthroughQueryAndPath,
+			extraPath:   "../../admin",
+			want:        "https://example.com/docs/admin",
+		},
+		{
+			name:        "path appended to the root",
+			originalURL: "https://example.com",
+			policy:      models.PassthroughQueryAndPath,
+			extraPath:   "a b",
+			want:        "https://example.com/a%20b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			got, err := passthroughURL(tt.originalURL, tt.policy, tt.extraPath, query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPassthroughLink(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		return serveAsUser(r, userID, method, target, body)
+	}
+
+	rec := request(http.MethodPost, "/api/shorten", `{"url":"https://example.com/invalid","passthrough":"all"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	plainPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/plain"}`)
+	rec = request(http.MethodGet, plainPath+"?utm_source=x", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/plain", rec.Header().Get("Location"))
+
+	rec = request(http.MethodGet, plainPath+"/extra/path", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	queryPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/query?ref=owner","passthrough":"query"}`)
+	rec = request(http.MethodGet, queryPath+"?utm_source=x&ref=visitor", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/query?ref=owner&utm_source=x", rec.Header().Get("Location"))
+
+	rec = request(http.MethodGet, queryPath+"/extra/path", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	fullPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/docs","passthrough":"query_and_path"}`)
+	rec = request(http.MethodGet, fullPath+"/extra/path?utm_source=x", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "https://example.com/docs/extra/path?utm_source=x", rec.Header().Get("Location"))
+}
+
+func TestMergeUTM(t *testing.T) {
+	tests := []struct {
+		name        string
+		originalURL string
+		utm         models.UTMParams
+		defaults    models.UTMParams
+		want        string
+	}{
+		{
+			name:        "nothing to merge",
+			originalURL: "https://example.com/landing?b=2&a=1",
+			want:        "https://example.com/landing?b=2&a=1",
+		},
+		{
+			name:        "parameters appended in order",
+			originalURL: "https://example.com/landing?b=2&a=1#top",
+			utm:         models.UTMParams{Content: "banner", Source: "news letter", Campaign: "spring"},
+			want:        "https://example.com/landing?b=2&a=1&utm_source=news+letter&utm_campaign=spring&utm_content=banner#top",
+		},
+		{
+			name:        "given parameters replace the original ones",
+			originalURL: "https://example.com/landing?utm_source=old&a=1&utm_source=older",
+			utm:         models.UTMParams{Source: "new"},
+			want:        "https://example.com/landing?a=1&utm_source=new",
+		},
+		{
+			name:        "defaults do not replace the original parameters",
+			originalURL: "https://example.com/landing?utm_medium=social",
+			utm:         models.UTMParams{Campaign: "spring"},
+			defaults:    models.UTMParams{Source: "site", Medium: "email", Campaign: "default"},
+			want:        "https://example.com/landing?utm_medium=social&utm_source=site&utm_campaign=spring",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeUTM(tt.originalURL, tt.utm, tt.defaults)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("unparsable URL", func(t *testing.T) {
+		_, err := mergeUTM("https://example.com/%zz", models.UTMParams{Source: "news"}, models.UTMParams{})
+		assert.Error(t, err)
+	})
+}
+
+func TestUTMDefaults(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		return serveAsUser(r, userID, method, target, body)
+	}
+
+	rec := request(http.MethodPut, "/api/user/utm", `{"source":"`+strings.Repeat("s", 256)+`"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = request(http.MethodPut, "/api/user/utm", `{"source":"site","medium":"email"}`)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = request(http.MethodGet, "/api/user/utm", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var defaults models.UTMParams
+	err = json.NewDecoder(rec.Body).Decode(&defaults)
+	require.NoError(t, err)
+	assert.Equal(t, models.UTMParams{Source: "site", Medium: "email"}, defaults)
+
+	rec = request(http.MethodPost, "/api/shorten", `{"url":"https://example.com/plain"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = request(http.MethodPost, "/api/shorten", `{"url":"https://example.com/promo","utm":{"campaign":"spring"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = request(
+		http.MethodPost,
+		"/api/shorten",
+		`{"url":"https://example.com/promo?utm_source=site&utm_medium=email&utm_campaign=spring"}`,
+	)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = request(
+		http.MethodPost,
+		"/api/shorten/batch",
+		`[{"correlation_id":"1","original_url":"https://example.com/batch","utm":{"medium":"social"}}]`,
+	)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = request(http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var userUrls models.UserUrls
+	err = json.NewDecoder(rec.Body).Decode(&userUrls)
+	require.NoError(t, err)
+	originalURLs := []string{}
+	for _, userURL := range userUrls {
+		originalURLs = append(originalURLs, userURL.OriginalURL)
+	}
+	assert.ElementsMatch(
+		t,
+		[]string{
+			"https://example.com/plain",
+			"https://example.com/promo?utm_source=site&utm_medium=email&utm_campaign=spring",
+			"https://example.com/batch?utm_source=site&utm_medium=social",
+		},
+		originalURLs,
+	)
+}
+
+func TestRedirectRules(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	shortPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/app","redirect_code":301}`)
+	rulesPath := "/api/user/urls" + shortPath + "/rules"
+
+	rec := request(http.MethodGet, rulesPath, "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	rec = request(http.MethodPut, rulesPath, `[{"url":"https://example.com/unconditional"}]`, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = request(http.MethodPut, rulesPath, `[{"os":"symbian","url":"https://example.com/symbian"}]`, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = request(http.MethodPut, "/api/user/urls/unknown/rules", `[{"os":"ios","url":"https://example.com/ios"}]`, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rules := `[
+		{"query_param":"ref","query_value":"partner","url":"https://example.com/partner"},
+		{"os":"ios","url":"https://apps.apple.com/app/id1"},
+		{"os":"android","url":"https://play.google.com/store/apps/details?id=app"},
+		{"language":"de","url":"https://example.com/de/app"},
+		{"language":"pt-BR","browser":"firefox","url":"https://example.com/br/app"}
+	]`
+	rec = request(http.MethodPut, rulesPath, rules, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = request(http.MethodGet, rulesPath, "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, rules, rec.Body.String())
+
+	const (
+		iPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+		android = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.82 Mobile Safari/537.36"
+		firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+	)
+	tests := []struct {
+		name         string
+		target       string
+		headers      map[string]string
+		wantLocation string
+	}{
+		{
+			name:         "fallback",
+			target:       shortPath,
+			headers:      map[string]string{"User-Agent": firefox, "Accept-Language": "en-US,de;q=0.5"},
+			wantLocation: "https://example.com/app",
+		},
+		{
+			name:         "ios",
+			target:       shortPath,
+			headers:      map[string]string{"User-Agent": iPhone, "Accept-Language": "de-DE"},
+			wantLocation: "https://apps.apple.com/app/id1",
+		},
+		{
+			name:         "android",
+			target:       shortPath,
+			headers:      map[string]string{"User-Agent": android},
+			wantLocation: "https://play.google.com/store/apps/details?id=app",
+		},
+		{
+			name:         "query param before the os",
+			target:       shortPath + "?ref=partner",
+			headers:      map[string]string{"User-Agent": iPhone},
+			wantLocation: "https://example.com/partner",
+		},
+		{
+			name:         "language of any region",
+			target:       shortPath,
+			headers:      map[string]string{"User-Agent": firefox, "Accept-Language": "en;q=0.4, de-AT"},
+			wantLocation: "https://example.com/de/app",
+		},
+		{
+			name:         "language of the region and browser",
+			target:       shortPath,
+			headers:      map[string]string{"User-Agent": firefox, "Accept-Language": "pt-BR"},
+			wantLocation: "https://example.com/br/app",
+		},
+		{
+			name:         "language of another region",
+			target:       shortPath,
+			headers:      map[string]string{"User-Agent": firefox, "Accept-Language": "pt-PT"},
+			wantLocation: "https://example.com/app",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(http.MethodGet, tt.target, "", tt.headers)
+			assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+			assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+			assert.Equal(t, "private, no-store", rec.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestPickWeightedDestination(t *testing.T) {
+	destinations := models.Destinations{
+		{URL: "https://example.com/a", Weight: 1},
+		{URL: "https://example.com/b", Weight: 3},
+	}
+
+	const picks = 4000
+	counts := make([]int, len(destinations))
+	for i := 0; i < picks; i++ {
+		counts[pickWeightedDestination(destinations)]++
+	}
+
+	assert.InDelta(t, 0.75, float64(counts[1])/picks, 0.05)
+}
+
+func TestABDestinations(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	shortPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/landing"}`)
+	destinationsPath := "/api/user/urls" + shortPath + "/destinations"
+
+	rec := request(http.MethodPut, destinationsPath, `[{"url":"https://example.com/a","weight":0}]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = request(
+		http.MethodPut,
+		destinationsPath,
+		`[{"url":"https://example.com/a","weight":1},{"url":"https://example.com/a","weight":2}]`,
+	)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	destinations := `[{"url":"https://example.com/a","weight":1},{"url":"https://example.com/b","weight":1}]`
+	rec = request(http.MethodPut, destinationsPath, destinations)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = request(http.MethodGet, destinationsPath, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, destinations, rec.Body.String())
+
+	rec = request(http.MethodGet, shortPath, "")
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "private, no-store", rec.Header().Get("Cache-Control"))
+	location := rec.Header().Get("Location")
+	assert.Contains(t, []string{"https://example.com/a", "https://example.com/b"}, location)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	variantCookie := cookies[0]
+	assert.Equal(t, abVariantCookiePrefix+strings.TrimPrefix(shortPath, "/"), variantCookie.Name)
+	assert.Equal(t, shortPath, variantCookie.Path)
+	err = rec.Result().Body.Close()
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		rec = request(http.MethodGet, shortPath, "", variantCookie)
+		assert.Equal(t, location, rec.Header().Get("Location"))
+		assert.Empty(t, rec.Header().Get("Set-Cookie"))
+	}
+
+	rec = request(http.MethodGet, shortPath, "", &http.Cookie{
+		Name:  variantCookie.Name,
+		Value: getVariantID("https://example.com/b"),
+	})
+	assert.Equal(t, "https://example.com/b", rec.Header().Get("Location"))
+
+	rec = request(http.MethodGet, shortPath, "", &http.Cookie{Name: variantCookie.Name, Value: "stale"})
+	assert.Contains(t, []string{"https://example.com/a", "https://example.com/b"}, rec.Header().Get("Location"))
+	assert.NotEmpty(t, rec.Header().Get("Set-Cookie"))
+
+	rec = request(http.MethodPut, destinationsPath, `[]`)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = request(http.MethodGet, shortPath, "", variantCookie)
+	assert.Equal(t, "https://example.com/landing", rec.Header().Get("Location"))
+}
+
+func TestLinkPreview(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	shortPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/previewed","title":"Previewed <page>","max_clicks":1}`)
+
+	for _, target := range []string{shortPath + "+", shortPath + "?preview=1"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, target)
+		assert.Equal(t, "private, no-store", rec.Header().Get("Cache-Control"))
+		assert.Contains(t, rec.Body.String(), "https://example.com/previewed")
+		assert.Contains(t, rec.Body.String(), "Previewed &lt;page&gt;")
+		assert.Contains(t, rec.Body.String(), "Created on "+time.Now().UTC().Format("2006-01-02"))
+	}
+
+	// Previews consume no clicks.
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, shortPath, nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown+", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	protectedPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/hidden","password":"secret"}`)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, protectedPath+"+", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "https://example.com/hidden")
+}
+
+func TestInterstitial(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	shortPath := shortenAsUser(t, r, userID, `{"url":"https://untrusted.example.net/page","interstitial":true}`)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, shortPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+	assert.Equal(t, "private, no-store", rec.Header().Get("Cache-Control"))
+	assert.Contains(t, rec.Body.String(), `href="https://untrusted.example.net/page"`)
+
+	theRouter := Router{trustedDomains: []string{"Example.net"}}
+	assert.True(t, theRouter.isTrustedURL("https://untrusted.example.net/page"))
+	assert.True(t, theRouter.isTrustedURL("https://example.net"))
+	assert.False(t, theRouter.isTrustedURL("https://badexample.net"))
+	assert.False(t, theRouter.isTrustedURL("https://example.org"))
+}
+
+func TestDestinationPolicy(t *testing.T) {
+	listsPath := filepath.Join(t.TempDir(), "domains.json")
+	err := os.WriteFile(listsPath, []byte(`{"deny": ["phishing.example", "*.phishing.example"]}`), 0o600)
+	require.NoError(t, err)
+	policy, err := domainpolicy.New(listsPath, time.Minute)
+	require.NoError(t, err)
+
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true), withDestinationPolicy(policy))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := request("/", "text/plain", "https://login.phishing.example/")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), domainpolicy.ErrDomainDenied.Error())
+
+	rec = request("/api/shorten", "application/json", `{"url":"https://phishing.example/"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"error":"`+domainpolicy.ErrDomainDenied.Error()+`"}`, rec.Body.String())
+
+	rec = request("/api/shorten", "application/json", `{"url":"https://safe.example/"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = request("/api/shorten/batch", "application/json", `[
+		{"correlation_id":"1","original_url":"https://safe.example/batch"},
+		{"correlation_id":"2","original_url":"https://www.phishing.example/batch"}
+	]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `[{
+		"correlation_id":"2",
+		"original_url":"https://www.phishing.example/batch",
+		"error":"`+domainpolicy.ErrDomainDenied.Error()+`"
+	}]`, rec.Body.String())
+}
+
+type stubResolver map[string]string
+
+func (r stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ip, found := r[host]
+	if !found {
+		return nil, errors.New("no such host")
+	}
+
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func TestURLSafetyPolicy(t *testing.T) {
+	policy := urlsafety.New(urlsafety.WithResolver(stubResolver{
+		"example.com":          "93.184.215.14",
+		"intranet.example.com": "10.0.0.1",
+	}))
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true), withURLSafetyPolicy(policy))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := request(http.MethodPost, "/", "text/plain", "http://169.254.169.254/latest/meta-data/")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), urlsafety.ErrAddressNotAllowed.Error())
+
+	rec = request(http.MethodPost, "/api/shorten", "application/json", `{"url":"ftp://example.com/file"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"error":"`+urlsafety.ErrSchemeNotAllowed.Error()+`"}`, rec.Body.String())
+
+	rec = request(http.MethodPost, "/api/shorten/batch", "application/json", `[
+		{"correlation_id":"1","original_url":"https://example.com/batch"},
+		{"correlation_id":"2","original_url":"https://intranet.example.com/batch"}
+	]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"correlation_id":"2"`)
+	assert.NotContains(t, rec.Body.String(), `"correlation_id":"1"`)
+
+	shortPath := shortenAsUser(t, r, userID, `{"url":"https://example.com/page"}`)
+
+	rec = request(http.MethodPatch, "/api/user/urls"+shortPath, "application/json", `{"url":"http://127.0.0.1/admin"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = request(
+		http.MethodPut,
+		"/api/user/urls"+shortPath+"/rules",
+		"application/json",
+		`[{"os":"ios","url":"https://intranet.example.com/app"}]`,
+	)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = request(
+		http.MethodPut,
+		"/api/user/urls"+shortPath+"/destinations",
+		"application/json",
+		`[{"url":"https://example.com/a","weight":1},{"url":"http://[::1]/b","weight":1}]`,
+	)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestURLCanonicalization(t *testing.T) {
+	server, db, r, _ := setupTestRouter(
+		t,
+		withMockAuth(true),
+		withURLCanonicalizer(urlcanonicalizer.New(urlcanonicalizer.WithStripTrackingParams(true))),
+	)
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	shortPath := shortenAsUser(t, r, userID, `{"url":"HTTP://Example.com:80/a?b=1&a=2"}`)
+
+	rec := request("/api/shorten", "application/json", `{"url":"http://example.com/a?a=2&b=1&gclid=123"}`)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, shortPath, decodeShortPath(t, rec))
+
+	rec = request("/", "text/plain", "http://EXAMPLE.com/a?a=2&b=1")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.True(t, strings.HasSuffix(rec.Body.String(), shortPath))
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, shortPath, nil))
+	assert.Equal(t, "http://example.com/a?a=2&b=1", rec.Header().Get("Location"))
+
+	rec = request("/api/shorten/batch", "application/json", `[
+		{"correlation_id":"1","original_url":"https://example.com/batch?y=1&x=2"},
+		{"correlation_id":"2","original_url":"https://Example.com:443/batch?x=2&y=1"}
+	]`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var batchResponse models.BatchShortenResponse
+	err = json.NewDecoder(rec.Body).Decode(&batchResponse)
+	require.NoError(t, err)
+	require.Len(t, batchResponse, 2)
+	assert.Equal(t, "1", batchResponse[0].CorrelationID)
+	assert.Equal(t, "2", batchResponse[1].CorrelationID)
+	assert.Equal(t, batchResponse[0].ShortURL, batchResponse[1].ShortURL)
+}
+
+type stubURLScanner struct {
+	mu      sync.Mutex
+	scanned []string
+}
+
+func (s *stubURLScanner) Scan(_ context.Context, rawURL string) error {
+	s.mu.Lock()
+	s.scanned = append(s.scanned, rawURL)
+	s.mu.Unlock()
+
+	switch {
+	case strings.Contains(rawURL, "malware"):
+		return fmt.Errorf("%w: malware", urlscanner.ErrMaliciousURL)
+	case strings.Contains(rawURL, "unreachable"):
+		return errors.New("the reputation service is down")
+	}
+
+	return nil
+}
+
+func TestURLScanner(t *testing.T) {
+	scanner := &stubURLScanner{}
+	server, db, r, _ := setupTestRouter(t, withMockAuth(true), withURLScanner(scanner, false))
+	defer server.Close()
+
+	userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+	require.NoError(t, err)
+
+	request := func(target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := request("/", "text/plain", "https://malware.example/")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "malware")
+
+	rec = request("/api/shorten", "application/json", `{"url":"https://malware.example/"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"error":"the URL is reported as malicious: malware"}`, rec.Body.String())
+
+	rec = request("/api/shorten", "application/json", `{"url":"https://unreachable.example/"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = request("/api/shorten", "application/json", `{"url":"https://clean.example/"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	// The URLs already stored are not scanned again.
+	scanned := len(scanner.scanned)
+	rec = request("/api/shorten", "application/json", `{"url":"https://clean.example/"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Len(t, scanner.scanned, scanned)
+
+	rec = request("/api/shorten/batch", "application/json", `[
+		{"correlation_id":"1","original_url":"https://clean.example/"},
+		{"correlation_id":"2","original_url":"https://malware.example/batch"},
+		{"correlation_id":"3","original_url":"https://clean.example/batch"}
+	]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `[{
+		"correlation_id":"2",
+		"original_url":"https://malware.example/batch",
+		"error":"the URL is reported as malicious: malware"
+	}]`, rec.Body.String())
+
+	rec = request("/api/shorten/batch", "application/json", `[
+		{"correlation_id":"1","original_url":"https://unreachable.example/batch"}
+	]`)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	t.Run("fail open", func(t *testing.T) {
+		server, db, r, _ := setupTestRouter(t, withMockAuth(true), withURLScanner(&stubURLScanner{}, true))
+		defer server.Close()
+
+		userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+
+		rec := serveAsUser(r, userID, http.MethodPost, "/api/shorten", `{"url":"https://unreachable.example/open"}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("the URLs of a batch are scanned concurrently within one timeout", func(t *testing.T) {
+		const timeout = 100 * time.Millisecond
+		server, db, r, _ := setupTestRouter(
+			t,
+			withMockAuth(true),
+			withURLScanner(hangingURLScanner{}, true),
+			withURLScanTimeout(timeout),
+		)
+		defer server.Close()
+
+		userID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+		require.NoError(t, err)
+
+		requestDTO := getPostApishortenbatchRequest(4 * maxConcurrentURLScans)
+		body, err := json.Marshal(requestDTO)
+		require.NoError(t, err)
+
+		start := time.Now()
+		rec := serveAsUser(r, userID, http.MethodPost, "/api/shorten/batch", string(body))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Less(t, time.Since(start), 4*timeout)
+	})
+}
+
+// hangingURLScanner is a scanner that does not answer before the deadline of the scan.
+type hangingURLScanner struct{}
+
+func (hangingURLScanner) Scan(ctx context.Context, _ string) error {
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func TestAbuseReports(t *testing.T) {
+	const adminID = "7b0f3c52-4a8e-4d5c-9a57-2f4f3e2e8a11"
+
+	for _, statusCode := range []int{0, http.StatusUnavailableForLegalReasons} {
+		t.Run(strconv.Itoa(statusCode), func(t *testing.T) {
+			options := []initOption{withMockAuth(true)}
+			if statusCode != 0 {
+				options = append(options, withDisabledLinkStatusCode(statusCode))
+			}
+			server, db, r, _ := setupTestRouter(t, options...)
+			defer server.Close()
+
+			do := func(method, userID, target, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, target, strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				ctx := context.WithValue(req.Context(), auth.UserIDKey, userID)
+				if userID == adminID {
+					ctx = context.WithValue(ctx, auth.UserRoleKey, user.RoleAdmin)
+				}
+				req = req.WithContext(ctx)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				return rec
+			}
+
+			err := db.SaveNewFullsAndShorts(
+				context.Background(),
+				map[string]string{
+					"https://phishing.example/login": "aaaaaaaa",
+					"https://example.com/":           "bbbbbbbb",
+				},
+				"",
+				nil,
+				nil,
+			)
+			require.NoError(t, err)
+
+			var reporters []string
+			for i := 0; i < 3; i++ {
+				reporterID, err := db.CreateUser(context.Background(), &user.User{}, nil)
+				require.NoError(t, err)
+				reporters = append(reporters, reporterID)
+			}
+
+			assert.Equal(t, http.StatusAccepted, do(http.MethodPost, reporters[0], "/api/report/aaaaaaaa", `{"reason":"phishing"}`).Code)
+			assert.Equal(t, http.StatusAccepted, do(http.MethodPost, reporters[0], "/api/report/aaaaaaaa", `{"reason":"phishing"}`).Code)
+			assert.Equal(t, http.StatusAccepted, do(http.MethodPost, reporters[1], "/api/report/aaaaaaaa", "").Code)
+			assert.Equal(t, http.StatusAccepted, do(http.MethodPost, reporters[2], "/api/report/bbbbbbbb", `{"reason":"spam"}`).Code)
+			assert.Equal(t, http.StatusNotFound, do(http.MethodPost, reporters[0], "/api/report/unknown", "").Code)
+			assert.Equal(
+				t,
+				http.StatusUnprocessableEntity,
+				do(http.MethodPost, reporters[0], "/api/report/bbbbbbbb", `{"reason":"`+strings.Repeat("a", 1025)+`"}`).Code,
+			)
+
+			assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "", "/api/admin/reports", "").Code)
+			assert.Equal(t, http.StatusForbidden, do(http.MethodGet, reporters[0], "/api/admin/reports", "").Code)
+			assert.Equal(t, http.StatusForbidden, do(http.MethodPost, reporters[0], "/api/admin/reports/aaaaaaaa/disable", "").Code)
+
+			rec := do(http.MethodGet, adminID, "/api/admin/reports", "")
+			require.Equal(t, http.StatusOK, rec.Code)
+			var queue models.AbuseReportsQueue
+			err = json.NewDecoder(rec.Body).Decode(&queue)
+			require.NoError(t, err)
+			require.Len(t, queue, 2)
+			assert.True(t, strings.HasSuffix(queue[0].ShortURL, "/aaaaaaaa"))
+			assert.Equal(t, "https://phishing.example/login", queue[0].OriginalURL)
+			assert.Equal(t, int64(2), queue[0].ReportsCount)
+			assert.Equal(t, []string{"phishing"}, queue[0].Reasons)
+			assert.False(t, queue[0].LastReportedAt.Before(queue[0].FirstReportedAt))
+			assert.Equal(t, int64(1), queue[1].ReportsCount)
+
+			rec = do(http.MethodPost, adminID, "/api/admin/reports/bbbbbbbb/dismiss", "")
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{"dismissed":1}`, rec.Body.String())
+			assert.Equal(t, http.StatusNotFound, do(http.MethodPost, adminID, "/api/admin/reports/bbbbbbbb/dismiss", "").Code)
+
+			assert.Equal(t, http.StatusNoContent, do(http.MethodPost, adminID, "/api/admin/reports/aaaaaaaa/disable", "").Code)
+			assert.Equal(t, http.StatusNotFound, do(http.MethodPost, adminID, "/api/admin/reports/unknown/disable", "").Code)
+			assert.Equal(t, http.StatusNoContent, do(http.MethodGet, adminID, "/api/admin/reports", "").Code)
+
+			expectedStatusCode := statusCode
+			if expectedStatusCode == 0 {
+				expectedStatusCode = http.StatusGone
+			}
+			for _, target := range []string{"/aaaaaaaa", "/aaaaaaaa+"} {
+				rec = httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+				assert.Equal(t, expectedStatusCode, rec.Code)
+				assert.Empty(t, rec.Header().Get("Location"))
+			}
+
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/bbbbbbbb", nil))
+			assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+			// Reports on a disabled short URL are accepted but do not bring it back to the queue.
+			assert.Equal(t, http.StatusAccepted, do(http.MethodPost, reporters[2], "/api/report/aaaaaaaa", "").Code)
+			assert.Equal(t, http.StatusNoContent, do(http.MethodGet, adminID, "/api/admin/reports", "").Code)
+		})
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	server, db, r, _ := setupTestRouter(t)
+	defer server.Close()
+
+	do := func(method, token, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// shorten shortens the URL as a new user and returns the user's token, ID and short URL path.
+	shorten := func(originalURL string) (string, string, string) {
+		rec := do(http.MethodPost, "", "/api/shorten", `{"url":"`+originalURL+`"}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		shortPath := decodeShortPath(t, rec)
+
+		token := rec.Header().Get("Authorization")
+		claims := &auth.Claims{}
+		_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+		require.NoError(t, err)
+
+		return token, claims.UserID, shortPath
+	}
+
+	userToken, userID, userShortPath := shorten("https://example.com/regular")
+	adminToken, adminID, _ := shorten("https://example.org/admin")
+	require.NoError(t, db.SetUserRole(context.Background(), adminID, user.RoleAdmin))
+
+	t.Run("access", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "", "/api/admin/urls", "").Code)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, userToken, "/api/admin/urls", "").Code)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, adminToken, "/api/admin/urls", "").Code)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		rec := do(http.MethodGet, adminToken, "/api/admin/urls?q=REGULAR", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var found models.AdminURLs
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&found))
+		require.Len(t, found, 1)
+		assert.Equal(t, "https://example.com/regular", found[0].OriginalURL)
+		assert.Equal(t, userID, found[0].CreatedBy)
+
+		rec = do(http.MethodGet, adminToken, "/api/admin/urls?limit=1&sort=original_url", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&found))
+		require.Len(t, found, 1)
+		assert.Equal(t, "https://example.com/regular", found[0].OriginalURL)
+		assert.Contains(t, rec.Header().Get("Link"), `rel="next"`)
+
+		assert.Equal(t, http.StatusNoContent, do(http.MethodGet, adminToken, "/api/admin/urls?q=nothing", "").Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, do(http.MethodGet, adminToken, "/api/admin/urls?tag=news", "").Code)
+	})
+
+	t.Run("user URLs", func(t *testing.T) {
+		rec := do(http.MethodGet, adminToken, "/api/admin/users/"+userID+"/urls", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var userURLs models.UserUrls
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&userURLs))
+		require.Len(t, userURLs, 1)
+		assert.Equal(t, "https://example.com/regular", userURLs[0].OriginalURL)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, do(http.MethodGet, adminToken, "/api/admin/users/nobody/urls", "").Code)
+	})
+
+	t.Run("impersonate", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, do(http.MethodPost, adminToken, "/api/admin/users/"+adminID+"/impersonate", "").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodPost, adminToken, "/api/admin/users/"+uuid.NewString()+"/impersonate", "").Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, do(http.MethodPost, adminToken, "/api/admin/users/nobody/impersonate", "").Code)
+
+		rec := do(http.MethodPost, adminToken, "/api/admin/users/"+userID+"/impersonate", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var impersonation models.ImpersonationResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&impersonation))
+		assert.Equal(t, userID, impersonation.UserID)
+		assert.Equal(t, impersonation.Token, rec.Header().Get("Authorization"))
+		assert.True(t, impersonation.ExpiresAt.After(time.Now()))
+
+		rec = do(http.MethodGet, impersonation.Token, "/api/user/urls", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "https://example.com/regular")
+
+		// Impersonated users never get the admin role, even once they have it.
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPut, adminToken, "/api/admin/users/"+userID+"/role", `{"role":"admin"}`).Code)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, userToken, "/api/admin/urls", "").Code)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, impersonation.Token, "/api/admin/urls", "").Code)
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPut, adminToken, "/api/admin/users/"+userID+"/role", `{"role":"user"}`).Code)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, userToken, "/api/admin/urls", "").Code)
+	})
+
+	t.Run("role", func(t *testing.T) {
+		target := "/api/admin/users/" + userID + "/role"
+		assert.Equal(t, http.StatusUnprocessableEntity, do(http.MethodPut, adminToken, target, `{"role":"root"}`).Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodPut, adminToken, "/api/admin/users/"+uuid.NewString()+"/role", `{"role":"user"}`).Code)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		short := strings.TrimPrefix(userShortPath, "/")
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPost, adminToken, "/api/admin/urls/"+short+"/disable", "").Code)
+		assert.Equal(t, http.StatusGone, do(http.MethodGet, "", userShortPath, "").Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodPost, adminToken, "/api/admin/urls/unknown/disable", "").Code)
+
+		rec := do(http.MethodGet, adminToken, "/api/admin/urls?q="+short, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var found models.AdminURLs
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&found))
+		require.Len(t, found, 1)
+		assert.NotNil(t, found[0].DisabledAt)
+	})
+}
+
+func TestAccounts(t *testing.T) {
+	server, _, r, _ := setupTestRouter(t, withLoginAttemptsLimiter(attemptslimiter.New(2, time.Minute)))
+	defer server.Close()
+
+	do := func(method, token, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// shorten shortens the URL, or links the user to its short URL if already shortened,
+	// and returns the token of the user, a new one if token is empty.
+	shorten := func(token, originalURL string) string {
+		rec := do(http.MethodPost, token, "/api/shorten", `{"url":"`+originalURL+`"}`)
+		require.Contains(t, []int{http.StatusCreated, http.StatusConflict}, rec.Code)
+		if token == "" {
+			token = rec.Header().Get("Authorization")
+		}
+
+		return token
+	}
+
+	countUserURLs := func(token string) int {
+		rec := do(http.MethodGet, token, "/api/user/urls", "")
+		if rec.Code == http.StatusNoContent {
+			return 0
+		}
+		require.Equal(t, http.StatusOK, rec.Code)
+		var userURLs models.UserUrls
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&userURLs))
+
+		return len(userURLs)
+	}
+
+	const credentials = `{"email":"Someone@Example.com","password":"correct horse"}`
+
+	accountToken := shorten("", "https://example.com/before-sign-up")
+
+	t.Run("sign up", func(t *testing.T) {
+		rec := do(http.MethodPost, accountToken, "/api/user/register", credentials)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var signUpResponse models.SignUpResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&signUpResponse))
+		assert.Equal(t, "someone@example.com", signUpResponse.Email)
+		assert.Equal(t, 1, countUserURLs(accountToken))
+
+		assert.Equal(t, http.StatusConflict, do(http.MethodPost, accountToken, "/api/user/register", credentials).Code)
+		assert.Equal(t, http.StatusConflict, do(http.MethodPost, "", "/api/user/register", credentials).Code)
+		assert.Equal(
+			t,
+			http.StatusUnprocessableEntity,
+			do(http.MethodPost, "", "/api/user/register", `{"email":"other@example.com","password":"short"}`).Code,
+		)
+		assert.Equal(
+			t,
+			http.StatusUnprocessableEntity,
+			do(
+				http.MethodPost,
+				"",
+				"/api/user/register",
+				`{"email":"other@example.com","password":"`+strings.Repeat("é", 40)+`"}`,
+			).Code,
+			"a password of 40 runes but 80 bytes is too long for bcrypt",
+		)
+	})
+
+	t.Run("login claims the anonymous user's URLs", func(t *testing.T) {
+		anonymousToken := shorten("", "https://example.com/anonymous")
+		shorten(anonymousToken, "https://example.com/before-sign-up")
+
+		rec := do(http.MethodPost, anonymousToken, "/api/user/login", `{"email":"someone@example.com","password":"wrong"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = do(http.MethodPost, anonymousToken, "/api/user/login", credentials)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var loginResponse models.LoginResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&loginResponse))
+		assert.Equal(t, int64(1), loginResponse.ClaimedURLs)
+		assert.Equal(t, loginResponse.Token, rec.Header().Get("Authorization"))
+		assert.NotEmpty(t, rec.Result().Cookies())
+
+		assert.Equal(t, 2, countUserURLs(loginResponse.Token))
+		assert.Equal(t, 0, countUserURLs(anonymousToken))
+	})
+
+	t.Run("login without a session", func(t *testing.T) {
+		rec := do(http.MethodPost, "", "/api/user/login", credentials)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var loginResponse models.LoginResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&loginResponse))
+		assert.Equal(t, int64(0), loginResponse.ClaimedURLs)
+		assert.Equal(t, 2, countUserURLs(loginResponse.Token))
+	})
+
+	t.Run("wrong passwords are limited", func(t *testing.T) {
+		const wrongCredentials = `{"email":"nobody@example.com","password":"wrong"}`
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "", "/api/user/login", wrongCredentials).Code)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "", "/api/user/login", wrongCredentials).Code)
+		assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "", "/api/user/login", wrongCredentials).Code)
+	})
+}
+
+func TestAccountsClaimOwnedURLs(t *testing.T) {
+	server, _, r, _ := setupTestRouter(t, withURLOwnershipMode(models.URLOwnershipModePerUser))
+	defer server.Close()
+
+	do := func(method, token, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	const credentials = `{"email":"owner@example.com","password":"correct horse"}`
+
+	rec := do(http.MethodPost, "", "/api/user/register", credentials)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = do(http.MethodPost, "", "/api/shorten", `{"url":"https://example.com/owned"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	anonymousToken := rec.Header().Get("Authorization")
+	shortPath := decodeShortPath(t, rec)
+
+	rec = do(http.MethodPost, anonymousToken, "/api/user/login", credentials)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var loginResponse models.LoginResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&loginResponse))
+	assert.Equal(t, int64(1), loginResponse.ClaimedURLs)
+
+	// The account owns the claimed short URL, so it may retarget it, and the anonymous user may not anymore.
+	target := "/api/user/urls" + shortPath
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, anonymousToken, target, `{"url":"https://example.com/a"}`).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPatch, loginResponse.Token, target, `{"url":"https://example.com/b"}`).Code)
+}
+
+func TestAuthTokens(t *testing.T) {
+	cfg, err := config.New(config.WithDisableFlagsParsing(true))
+	require.NoError(t, err)
+	authKey, err := base64.URLEncoding.DecodeString(cfg.AuthCookieSigningSecretKey)
+	require.NoError(t, err)
+
+	do := func(r *chi.Mux, method, token, target, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	cookieOf := func(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+
+		return nil
+	}
+
+	// signToken signs the claims as the server does, to forge expired and legacy tokens.
+	signToken := func(claims auth.Claims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(authKey)
+		require.NoError(t, err)
+
+		return token
+	}
+
+	// newUser shortens a URL as a new user, registers an account for them and returns
+	// the response, holding the user's tokens.
+	newUser := func(r *chi.Mux) (*httptest.ResponseRecorder, string) {
+		rec := do(r, http.MethodPost, "", "/api/shorten", `{"url":"https://example.com/`+uuid.NewString()+`"}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		rec = do(
+			r,
+			http.MethodPost,
+			rec.Header().Get("Authorization"),
+			"/api/user/register",
+			`{"email":"`+uuid.NewString()+`@example.com","password":"correct horse"}`,
+		)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		claims := &auth.Claims{}
+		_, _, err := jwt.NewParser().ParseUnverified(rec.Header().Get("Authorization"), claims)
+		require.NoError(t, err)
+		require.NotNil(t, claims.ExpiresAt)
+		require.NotEmpty(t, claims.ID)
+
+		return rec, claims.UserID
+	}
+
+	refreshCookieName := cfg.AuthCookieName + "_refresh"
+
+	server, _, r, _ := setupTestRouter(t)
+	defer server.Close()
+
+	t.Run("anonymous users get no refresh token", func(t *testing.T) {
+		rec := do(r, http.MethodPost, "", "/api/shorten", `{"url":"https://example.com/anonymous"}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		assert.Nil(t, cookieOf(rec, refreshCookieName))
+		claims := &auth.Claims{}
+		_, _, err := jwt.NewParser().ParseUnverified(rec.Header().Get("Authorization"), claims)
+		require.NoError(t, err)
+		require.NotNil(t, claims.ExpiresAt)
+		assert.True(t, claims.ExpiresAt.After(time.Now().Add(cfg.AccessTokenLifetime)))
+	})
+
+	t.Run("refresh tokens are used up", func(t *testing.T) {
+		rec, _ := newUser(r)
+		refreshCookie := cookieOf(rec, refreshCookieName)
+		require.NotNil(t, refreshCookie)
+		assert.True(t, refreshCookie.HttpOnly)
+
+		rec = do(r, http.MethodPost, "", "/api/user/refresh", `{"refresh_token":"`+refreshCookie.Value+`"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var tokens models.AuthTokens
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&tokens))
+		assert.NotEqual(t, refreshCookie.Value, tokens.RefreshToken)
+		assert.Equal(t, http.StatusOK, do(r, http.MethodGet, tokens.Token, "/api/user/utm", "").Code)
+
+		rec = do(r, http.MethodPost, "", "/api/user/refresh", `{"refresh_token":"`+refreshCookie.Value+`"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = do(r, http.MethodPost, "", "/api/user/refresh", "", &http.Cookie{Name: refreshCookieName, Value: tokens.RefreshToken})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("expired access tokens are rejected and renewed from the refresh cookie", func(t *testing.T) {
+		rec, userID := newUser(r)
+		refreshCookie := cookieOf(rec, refreshCookieName)
+		require.NotNil(t, refreshCookie)
+		expiredToken := signToken(auth.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        uuid.NewString(),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			},
+			UserID: userID,
+		})
+
+		assert.Equal(t, http.StatusUnauthorized, do(r, http.MethodGet, expiredToken, "/api/user/utm", "").Code)
+
+		rec = do(
+			r,
+			http.MethodGet,
+			"",
+			"/api/user/utm",
+			"",
+			&http.Cookie{Name: cfg.AuthCookieName, Value: expiredToken},
+			refreshCookie,
+		)
+		require.Equal(t, http.StatusOK, rec.Code)
+		renewedToken := rec.Header().Get("Authorization")
+		require.NotEmpty(t, renewedToken)
+		assert.Equal(t, http.StatusOK, do(r, http.MethodGet, renewedToken, "/api/user/utm", "").Code)
+		assert.Nil(t, cookieOf(rec, refreshCookieName), "the refresh token is kept on renewing the access token")
+	})
+
+	t.Run("logout revokes the tokens", func(t *testing.T) {
+		rec, _ := newUser(r)
+		token := rec.Header().Get("Authorization")
+		refreshCookie := cookieOf(rec, refreshCookieName)
+		require.NotNil(t, refreshCookie)
+
+		rec = do(r, http.MethodPost, token, "/api/user/logout", `{"refresh_token":"`+refreshCookie.Value+`"}`)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		clearedCookie := cookieOf(rec, cfg.AuthCookieName)
+		require.NotNil(t, clearedCookie)
+		assert.Negative(t, clearedCookie.MaxAge)
+
+		assert.Equal(t, http.StatusUnauthorized, do(r, http.MethodGet, token, "/api/user/utm", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, do(r, http.MethodPost, "", "/api/user/refresh", "", refreshCookie).Code)
+	})
+
+	t.Run("legacy tokens are accepted and exchanged in the cookie", func(t *testing.T) {
+		_, userID := newUser(r)
+		legacyToken := signToken(auth.Claims{UserID: userID})
+
+		rec := do(r, http.MethodGet, legacyToken, "/api/user/utm", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Authorization"))
+
+		rec = do(r, http.MethodGet, "", "/api/user/utm", "", &http.Cookie{Name: cfg.AuthCookieName, Value: legacyToken})
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("Authorization"))
+		assert.NotNil(t, cookieOf(rec, refreshCookieName))
+	})
+
+	t.Run("legacy tokens are rejected after the deadline", func(t *testing.T) {
+		server, _, r, _ := setupTestRouter(t, withAuthOptions(auth.WithLegacyTokensDeadline(time.Now().Add(-time.Hour))))
+		defer server.Close()
+
+		_, userID := newUser(r)
+		legacyToken := signToken(auth.Claims{UserID: userID})
+
+		assert.Equal(t, http.StatusUnauthorized, do(r, http.MethodGet, legacyToken, "/api/user/utm", "").Code)
+	})
+}
@@ -0,0 +1,73 @@
+// Package tokenspurger periodically removes the expired refresh tokens and the revoked
+// access token IDs that have outlived the tokens they revoke.
+package tokenspurger
+
+import (
+	"context"
+	"time"
+
+	"github.com/patric-chuzhbe/urlshrt/internal/logger"
+)
+
+type tokensKeeper interface {
+	PurgeExpiredTokens(ctx context.Context, expiredBefore time.Time) (int64, error)
+}
+
+// TokensPurger removes the expired refresh tokens and revoked access token IDs.
+// It runs the purge periodically in the background.
+type TokensPurger struct {
+	db            tokensKeeper
+	purgeInterval time.Duration
+	errorChannel  chan error
+}
+
+// New initializes and returns a new instance of TokensPurger.
+func New(
+	db tokensKeeper,
+	purgeInterval time.Duration,
+) *TokensPurger {
+	return &TokensPurger{
+		db:            db,
+		purgeInterval: purgeInterval,
+		errorChannel:  make(chan error, 1),
+	}
+}
+
+// ListenErrors starts a goroutine that listens for errors from the internal
+// error channel and passes them to the provided callback function.
+//
+// The callback is invoked for each error as it arrives. This method returns immediately,
+// and the listening continues in the background.
+func (p *TokensPurger) ListenErrors(callback func(error)) {
+	go func() {
+		for err := range p.errorChannel {
+			callback(err)
+		}
+	}()
+}
+
+// Run starts a background goroutine that periodically purges the expired tokens. The method
+// returns immediately and continues processing in the background until the provided context is canceled.
+func (p *TokensPurger) Run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(p.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Log.Infoln("TokensPurger.Run() stopped")
+				return
+			case <-ticker.C:
+				purged, err := p.db.PurgeExpiredTokens(ctx, time.Now())
+				if err != nil {
+					p.errorChannel <- err
+					continue
+				}
+				if purged > 0 {
+					logger.Log.Infof("purged %d expired tokens", purged)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,166 @@
+// Package urlcanonicalizer brings URLs to a canonical form, so that the URLs leading
+// to the same resource but written differently are shortened once.
+package urlcanonicalizer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// trackingParams are the query parameters identifying the click rather than the resource,
+// stripped if so configured. The utm_* parameters are kept: they describe the campaign
+// the link belongs to and are built by the service itself.
+var trackingParams = map[string]struct{}{
+	"fbclid":  {},
+	"gclid":   {},
+	"dclid":   {},
+	"gbraid":  {},
+	"wbraid":  {},
+	"msclkid": {},
+	"yclid":   {},
+	"twclid":  {},
+	"igshid":  {},
+	"mc_cid":  {},
+	"mc_eid":  {},
+	"_hsenc":  {},
+	"_hsmi":   {},
+	"mkt_tok": {},
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Canonicalizer brings URLs to their canonical form.
+type Canonicalizer struct {
+	stripTrackingParams bool
+}
+
+// InitOption defines a functional option for configuring the Canonicalizer.
+type InitOption func(*Canonicalizer)
+
+// WithStripTrackingParams enables or disables removing the click tracking query parameters,
+// such as fbclid or gclid.
+func WithStripTrackingParams(value bool) InitOption {
+	return func(canonicalizer *Canonicalizer) {
+		canonicalizer.stripTrackingParams = value
+	}
+}
+
+// New initializes and returns a new instance of Canonicalizer.
+func New(optionsProto ...InitOption) *Canonicalizer {
+	canonicalizer := &Canonicalizer{}
+	for _, protoOption := range optionsProto {
+		protoOption(canonicalizer)
+	}
+
+	return canonicalizer
+}
+
+// Canonicalize returns the canonical form of the URL: the scheme and the host lowercased,
+// the host converted to punycode, the default port of the scheme and the trailing dot of
+// the host removed, an empty path replaced with "/", and the query parameters sorted by name,
+// keeping the order of the values of a repeated one. The parameters are kept as written, not
+// re-encoded, so that "?flag" stays different from "?flag=". A query that cannot be parsed
+// is kept as is.
+func (c *Canonicalizer) Canonicalize(rawURL string) (string, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf(
+			"in internal/urlcanonicalizer/urlcanonicalizer.go/Canonicalize(): error while `url.Parse()` calling: %w",
+			err,
+		)
+	}
+	if parsedURL.Opaque != "" {
+		return parsedURL.String(), nil
+	}
+
+	parsedURL.Scheme = strings.ToLower(parsedURL.Scheme)
+	parsedURL.Host = canonicalizeHost(parsedURL.Scheme, parsedURL.Host)
+
+	if parsedURL.Path == "" && parsedURL.Host != "" {
+		parsedURL.Path = "/"
+		parsedURL.RawPath = ""
+	}
+
+	if parsedURL.RawQuery != "" {
+		parsedURL.RawQuery = c.canonicalizeQuery(parsedURL.RawQuery)
+	}
+	if parsedURL.RawQuery == "" {
+		parsedURL.ForceQuery = false
+	}
+
+	return parsedURL.String(), nil
+}
+
+// canonicalizeQuery sorts the parameters of the raw query by their decoded names, dropping
+// the empty ones and, if so configured, the tracking ones.
+func (c *Canonicalizer) canonicalizeQuery(rawQuery string) string {
+	if _, err := url.ParseQuery(rawQuery); err != nil {
+		return rawQuery
+	}
+
+	type param struct {
+		name string
+		raw  string
+	}
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		rawName, _, _ := strings.Cut(raw, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			return rawQuery
+		}
+		if _, found := trackingParams[strings.ToLower(name)]; found && c.stripTrackingParams {
+			continue
+		}
+		params = append(params, param{name: name, raw: raw})
+	}
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+
+	raws := make([]string, 0, len(params))
+	for _, p := range params {
+		raws = append(raws, p.raw)
+	}
+
+	return strings.Join(raws, "&")
+}
+
+func canonicalizeHost(scheme, host string) string {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname, port = host, ""
+	}
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	hostname = strings.TrimSuffix(strings.TrimPrefix(hostname, "["), "]")
+
+	if port == defaultPorts[scheme] {
+		port = ""
+	}
+
+	if net.ParseIP(hostname) == nil {
+		asciiHostname, err := idna.Lookup.ToASCII(hostname)
+		if err == nil {
+			hostname = asciiHostname
+		}
+	} else if strings.Contains(hostname, ":") {
+		hostname = "[" + hostname + "]"
+	}
+
+	if port == "" {
+		return hostname
+	}
+
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(hostname, "["), "]"), port)
+}
@@ -0,0 +1,112 @@
+package urlcanonicalizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name                string
+		url                 string
+		stripTrackingParams bool
+		want                string
+	}{
+		{
+			name: "scheme, host, default port and query order",
+			url:  "HTTP://Example.com:80/a?b=1&a=2",
+			want: "http://example.com/a?a=2&b=1",
+		},
+		{
+			name: "already canonical",
+			url:  "http://example.com/a?a=2&b=1",
+			want: "http://example.com/a?a=2&b=1",
+		},
+		{
+			name: "https default port and empty path",
+			url:  "https://EXAMPLE.com.:443",
+			want: "https://example.com/",
+		},
+		{
+			name: "non-default port kept",
+			url:  "https://example.com:8443/a",
+			want: "https://example.com:8443/a",
+		},
+		{
+			name: "internationalized domain",
+			url:  "https://Bücher.example/katalog",
+			want: "https://xn--bcher-kva.example/katalog",
+		},
+		{
+			name: "ipv6 literal",
+			url:  "http://[2001:DB8::1]:80/",
+			want: "http://[2001:db8::1]/",
+		},
+		{
+			name: "ipv6 literal with port",
+			url:  "http://[2001:db8::1]:8080/",
+			want: "http://[2001:db8::1]:8080/",
+		},
+		{
+			name: "repeated parameter keeps the order of its values",
+			url:  "https://example.com/?tag=b&id=1&tag=a",
+			want: "https://example.com/?id=1&tag=b&tag=a",
+		},
+		{
+			name: "path case and fragment kept",
+			url:  "https://example.com/Path/To?x=1#Section",
+			want: "https://example.com/Path/To?x=1#Section",
+		},
+		{
+			name: "tracking parameters kept by default",
+			url:  "https://example.com/?gclid=abc&id=1",
+			want: "https://example.com/?gclid=abc&id=1",
+		},
+		{
+			name:                "tracking parameters stripped",
+			url:                 "https://example.com/?gclid=abc&id=1&FBCLID=x&utm_source=news",
+			stripTrackingParams: true,
+			want:                "https://example.com/?id=1&utm_source=news",
+		},
+		{
+			name:                "query emptied by stripping",
+			url:                 "https://example.com/page?fbclid=x",
+			stripTrackingParams: true,
+			want:                "https://example.com/page",
+		},
+		{
+			name: "parameters without a value kept as written",
+			url:  "https://example.com/?flag&a=1",
+			want: "https://example.com/?a=1&flag",
+		},
+		{
+			name: "escaping kept as written",
+			url:  "https://example.com/?q=a+b&p=%20c%2Fd",
+			want: "https://example.com/?p=%20c%2Fd&q=a+b",
+		},
+		{
+			name: "sorted by the decoded names",
+			url:  "https://example.com/?%62=1&a=2",
+			want: "https://example.com/?a=2&%62=1",
+		},
+		{
+			name: "empty parameters dropped",
+			url:  "https://example.com/?b=1&&a=2&",
+			want: "https://example.com/?a=2&b=1",
+		},
+		{
+			name: "unparsable query kept",
+			url:  "https://example.com/?b=%zz&a=1",
+			want: "https://example.com/?b=%zz&a=1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canonical, err := New(WithStripTrackingParams(test.stripTrackingParams)).Canonicalize(test.url)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, canonical)
+		})
+	}
+}
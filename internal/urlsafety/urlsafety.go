@@ -0,0 +1,178 @@
+// Package urlsafety keeps the service from shortening URLs that lead to internal addresses,
+// such as loopback, link-local or private ones, or use schemes other than HTTP(S),
+// so that short links cannot be used to reach the network the service runs in.
+package urlsafety
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ErrSchemeNotAllowed is returned for the URLs with a scheme that is not allowed.
+var ErrSchemeNotAllowed = errors.New("the URL scheme is not allowed")
+
+// ErrAddressNotAllowed is returned for the URLs leading to an internal address.
+var ErrAddressNotAllowed = errors.New("the URL leads to an address that is not allowed")
+
+// ErrHostNotResolved is returned for the URLs with a host that cannot be resolved.
+var ErrHostNotResolved = errors.New("the URL host cannot be resolved")
+
+// ErrNoHost is returned for the URLs without a host.
+var ErrNoHost = errors.New("the URL has no host")
+
+var defaultAllowedSchemes = []string{"http", "https"}
+
+type resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Policy checks the URLs against the allowed schemes and the addresses their hosts resolve to.
+// It is safe for concurrent use.
+type Policy struct {
+	resolver        resolver
+	allowedSchemes  []string
+	allowedNetworks []*net.IPNet
+}
+
+// InitOption defines a functional option for configuring the Policy.
+type InitOption func(*Policy)
+
+// WithResolver sets the resolver of the URL hosts. Defaults to net.DefaultResolver.
+func WithResolver(value resolver) InitOption {
+	return func(policy *Policy) {
+		policy.resolver = value
+	}
+}
+
+// WithAllowedSchemes sets the URL schemes allowed. Defaults to http and https.
+func WithAllowedSchemes(value []string) InitOption {
+	return func(policy *Policy) {
+		if len(value) == 0 {
+			return
+		}
+		policy.allowedSchemes = make([]string, len(value))
+		for i, scheme := range value {
+			policy.allowedSchemes[i] = strings.ToLower(scheme)
+		}
+	}
+}
+
+// WithAllowedNetworks sets the networks allowed even though they are internal,
+// such as the private network of a corporate deployment.
+func WithAllowedNetworks(value []*net.IPNet) InitOption {
+	return func(policy *Policy) {
+		policy.allowedNetworks = value
+	}
+}
+
+// New initializes and returns a new instance of Policy.
+func New(optionsProto ...InitOption) *Policy {
+	policy := &Policy{
+		resolver:       net.DefaultResolver,
+		allowedSchemes: defaultAllowedSchemes,
+	}
+	for _, protoOption := range optionsProto {
+		protoOption(policy)
+	}
+
+	return policy
+}
+
+// ParseNetworks parses the CIDRs or IP addresses into networks, as expected by WithAllowedNetworks.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("in internal/urlsafety/urlsafety.go/ParseNetworks(): invalid IP address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("in internal/urlsafety/urlsafety.go/ParseNetworks(): error while `net.ParseCIDR()` calling: %w", err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Check tells whether the URL is safe to shorten: it returns ErrSchemeNotAllowed if its scheme
+// is not allowed, ErrNoHost if it has no host, ErrHostNotResolved if its host cannot be resolved,
+// ErrAddressNotAllowed if its host is or resolves to an internal address that is not explicitly
+// allowed, and nil otherwise.
+func (p *Policy) Check(ctx context.Context, rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("in internal/urlsafety/urlsafety.go/Check(): error while `url.Parse()` calling: %w", err)
+	}
+
+	if !slices.Contains(p.allowedSchemes, strings.ToLower(parsedURL.Scheme)) {
+		return ErrSchemeNotAllowed
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(parsedURL.Hostname()), ".")
+	if host == "" {
+		return ErrNoHost
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !p.isAddressAllowed(ip) {
+			return ErrAddressNotAllowed
+		}
+
+		return nil
+	}
+
+	addresses, err := p.resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return ErrHostNotResolved
+	}
+
+	for _, address := range addresses {
+		if !p.isAddressAllowed(address.IP) {
+			return ErrAddressNotAllowed
+		}
+	}
+
+	return nil
+}
+
+func (p *Policy) isAddressAllowed(ip net.IP) bool {
+	for _, network := range p.allowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return !isInternalAddress(ip)
+}
+
+func isInternalAddress(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), internal to the provider networks.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
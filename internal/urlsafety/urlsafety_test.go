@@ -0,0 +1,87 @@
+package urlsafety
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, found := r[host]
+	if !found {
+		return nil, errors.New("no such host")
+	}
+
+	addresses := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addresses[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+
+	return addresses, nil
+}
+
+func TestCheck(t *testing.T) {
+	resolver := stubResolver{
+		"example.com":          {"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"},
+		"localhost":            {"127.0.0.1", "::1"},
+		"rebind.example.com":   {"93.184.215.14", "10.0.0.1"},
+		"intranet.example.com": {"10.1.2.3"},
+	}
+	policy := New(WithResolver(resolver))
+
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{url: "https://example.com/page", want: nil},
+		{url: "HTTP://EXAMPLE.COM./", want: nil},
+		{url: "http://93.184.215.14/", want: nil},
+		{url: "ftp://example.com/file", want: ErrSchemeNotAllowed},
+		{url: "file:///etc/passwd", want: ErrSchemeNotAllowed},
+		{url: "javascript:alert(1)", want: ErrSchemeNotAllowed},
+		{url: "http:///path", want: ErrNoHost},
+		{url: "http://unknown.example.com/", want: ErrHostNotResolved},
+		{url: "http://localhost:8080/", want: ErrAddressNotAllowed},
+		{url: "http://127.0.0.1/", want: ErrAddressNotAllowed},
+		{url: "http://[::1]/", want: ErrAddressNotAllowed},
+		{url: "http://[::ffff:127.0.0.1]/", want: ErrAddressNotAllowed},
+		{url: "http://169.254.169.254/latest/meta-data/", want: ErrAddressNotAllowed},
+		{url: "http://192.168.1.1/", want: ErrAddressNotAllowed},
+		{url: "http://100.64.0.1/", want: ErrAddressNotAllowed},
+		{url: "http://0.0.0.0/", want: ErrAddressNotAllowed},
+		{url: "http://[fe80::1]/", want: ErrAddressNotAllowed},
+		{url: "http://rebind.example.com/", want: ErrAddressNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			assert.ErrorIs(t, policy.Check(context.Background(), test.url), test.want)
+		})
+	}
+}
+
+func TestCheckWithExceptions(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	policy := New(
+		WithResolver(stubResolver{"intranet.example.com": {"10.1.2.3"}}),
+		WithAllowedSchemes([]string{"HTTPS", "ftp"}),
+		WithAllowedNetworks(networks),
+	)
+
+	assert.NoError(t, policy.Check(context.Background(), "https://intranet.example.com/"))
+	assert.NoError(t, policy.Check(context.Background(), "ftp://192.168.1.1/file"))
+	assert.ErrorIs(t, policy.Check(context.Background(), "https://192.168.1.2/"), ErrAddressNotAllowed)
+	assert.ErrorIs(t, policy.Check(context.Background(), "http://intranet.example.com/"), ErrSchemeNotAllowed)
+
+	_, err = ParseNetworks([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseNetworks([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package urlscanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// BlocklistScanner reports as malicious the URLs found in a local blocklist file.
+//
+// Each line of the file is either a host, matching the URLs leading to it or its subdomains,
+// or a URL with a scheme, matching the URLs starting with it. Empty lines and the lines
+// starting with "#" are ignored. Hosts and schemes are compared case-insensitively.
+type BlocklistScanner struct {
+	hosts       map[string]struct{}
+	urlPrefixes []string
+}
+
+// NewBlocklistScanner initializes and returns a new instance of BlocklistScanner
+// loaded from the file at path.
+func NewBlocklistScanner(path string) (*BlocklistScanner, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("in internal/urlscanner/blocklist.go/NewBlocklistScanner(): error while `os.Open()` calling: %w", err)
+	}
+	defer file.Close()
+
+	scanner := &BlocklistScanner{hosts: map[string]struct{}{}}
+
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.Contains(line, "://") {
+			scanner.urlPrefixes = append(scanner.urlPrefixes, lowercaseSchemeAndHost(line))
+			continue
+		}
+		scanner.hosts[strings.TrimSuffix(strings.ToLower(line), ".")] = struct{}{}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, fmt.Errorf("in internal/urlscanner/blocklist.go/NewBlocklistScanner(): error while reading %s: %w", path, err)
+	}
+
+	return scanner, nil
+}
+
+// Scan reports the URL as malicious if its host or one of its parent domains is blocklisted,
+// or if it starts with one of the blocklisted URLs.
+func (s *BlocklistScanner) Scan(_ context.Context, rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("in internal/urlscanner/blocklist.go/Scan(): error while `url.Parse()` calling: %w", err)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(parsedURL.Hostname()), ".")
+	for host != "" {
+		if _, found := s.hosts[host]; found {
+			return newMaliciousURLError("the host is blocklisted")
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+
+	normalizedURL := lowercaseSchemeAndHost(rawURL)
+	for _, prefix := range s.urlPrefixes {
+		if strings.HasPrefix(normalizedURL, prefix) {
+			return newMaliciousURLError("the URL is blocklisted")
+		}
+	}
+
+	return nil
+}
+
+// lowercaseSchemeAndHost lowercases the part of the URL up to the end of its host,
+// leaving the case-sensitive rest as is.
+func lowercaseSchemeAndHost(rawURL string) string {
+	scheme, rest, found := strings.Cut(rawURL, "://")
+	if !found {
+		return rawURL
+	}
+
+	hostEnd := strings.IndexAny(rest, "/?#")
+	if hostEnd == -1 {
+		hostEnd = len(rest)
+	}
+
+	return strings.ToLower(scheme) + "://" + strings.ToLower(rest[:hostEnd]) + rest[hostEnd:]
+}
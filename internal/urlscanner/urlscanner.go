@@ -0,0 +1,41 @@
+// Package urlscanner checks URLs against reputation sources before they are shortened.
+// It provides a scanner matching URLs against a local blocklist file, a scanner asking
+// an HTTP webhook, and a chain of scanners.
+package urlscanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrMaliciousURL is wrapped by the errors returned for the URLs reported as malicious.
+var ErrMaliciousURL = errors.New("the URL is reported as malicious")
+
+// Scanner checks a URL. Scan returns an error wrapping ErrMaliciousURL if the URL is reported
+// as malicious, any other error if it could not be checked, and nil otherwise.
+type Scanner interface {
+	Scan(ctx context.Context, rawURL string) error
+}
+
+// Chain is a Scanner checking a URL with every scanner in turn, stopping at the first error.
+type Chain []Scanner
+
+// Scan checks the URL with every scanner of the chain in turn.
+func (c Chain) Scan(ctx context.Context, rawURL string) error {
+	for _, scanner := range c {
+		if err := scanner.Scan(ctx, rawURL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newMaliciousURLError(reason string) error {
+	if reason == "" {
+		return ErrMaliciousURL
+	}
+
+	return fmt.Errorf("%w: %s", ErrMaliciousURL, reason)
+}
@@ -0,0 +1,125 @@
+package urlscanner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlocklistScanner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	err := os.WriteFile(path, []byte(`# phishing
+malware.example
+Phishing.Example.COM.
+
+HTTPS://Files.Example.org/Payloads/
+`), 0o600)
+	require.NoError(t, err)
+
+	scanner, err := NewBlocklistScanner(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		url       string
+		malicious bool
+	}{
+		{url: "https://malware.example/", malicious: true},
+		{url: "http://cdn.MALWARE.example/x.exe", malicious: true},
+		{url: "https://phishing.example.com/login", malicious: true},
+		{url: "https://example.com/", malicious: false},
+		{url: "https://notmalware.example/", malicious: false},
+		{url: "https://files.example.org/Payloads/x.exe", malicious: true},
+		{url: "https://files.example.org/payloads/x.exe", malicious: false},
+		{url: "https://files.example.org/Docs/", malicious: false},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			err := scanner.Scan(context.Background(), test.url)
+			if test.malicious {
+				assert.ErrorIs(t, err, ErrMaliciousURL)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	_, err = NewBlocklistScanner(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestWebhookScanner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost || request.Header.Get("Authorization") != "Bearer secret" {
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var requestDTO webhookRequest
+		if err := json.NewDecoder(request.Body).Decode(&requestDTO); err != nil {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch requestDTO.URL {
+		case "https://phishing.example/":
+			_, _ = response.Write([]byte(`{"malicious":true,"reason":"phishing"}`))
+		case "https://slow.example/":
+			time.Sleep(200 * time.Millisecond)
+			_, _ = response.Write([]byte(`{"malicious":false}`))
+		case "https://broken.example/":
+			response.WriteHeader(http.StatusInternalServerError)
+		default:
+			_, _ = response.Write([]byte(`{"malicious":false}`))
+		}
+	}))
+	defer server.Close()
+
+	scanner := NewWebhookScanner(server.URL, WithAuthToken("secret"), WithHTTPClient(server.Client()))
+
+	assert.NoError(t, scanner.Scan(context.Background(), "https://example.com/"))
+
+	err := scanner.Scan(context.Background(), "https://phishing.example/")
+	assert.ErrorIs(t, err, ErrMaliciousURL)
+	assert.EqualError(t, err, "the URL is reported as malicious: phishing")
+
+	err = scanner.Scan(context.Background(), "https://broken.example/")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrMaliciousURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = scanner.Scan(ctx, "https://slow.example/")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = NewWebhookScanner(server.URL).Scan(context.Background(), "https://example.com/")
+	assert.Error(t, err)
+}
+
+func TestChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	err := os.WriteFile(path, []byte("malware.example\n"), 0o600)
+	require.NoError(t, err)
+	blocklistScanner, err := NewBlocklistScanner(path)
+	require.NoError(t, err)
+
+	webhookCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		webhookCalls++
+		_, _ = response.Write([]byte(`{"malicious":false}`))
+	}))
+	defer server.Close()
+
+	chain := Chain{blocklistScanner, NewWebhookScanner(server.URL)}
+	assert.ErrorIs(t, chain.Scan(context.Background(), "https://malware.example/"), ErrMaliciousURL)
+	assert.Equal(t, 0, webhookCalls)
+	assert.NoError(t, chain.Scan(context.Background(), "https://example.com/"))
+	assert.Equal(t, 1, webhookCalls)
+}
@@ -0,0 +1,102 @@
+package urlscanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxWebhookResponseSize limits the webhook responses read, which are expected to be tiny.
+const maxWebhookResponseSize = 64 * 1024
+
+type webhookRequest struct {
+	URL string `json:"url"`
+}
+
+type webhookResponse struct {
+	Malicious bool   `json:"malicious"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// WebhookScanner asks an HTTP webhook about the URLs.
+//
+// The webhook is sent a POST request with the JSON body {"url": "<the URL>"} and is expected to
+// answer with 200 and the JSON body {"malicious": <true or false>, "reason": "<optional reason>"}.
+// Any other answer is an error.
+type WebhookScanner struct {
+	endpoint   string
+	authToken  string
+	httpClient *http.Client
+}
+
+// WebhookInitOption defines a functional option for configuring the WebhookScanner.
+type WebhookInitOption func(*WebhookScanner)
+
+// WithAuthToken sets the token sent to the webhook in the `Authorization: Bearer` header.
+func WithAuthToken(value string) WebhookInitOption {
+	return func(scanner *WebhookScanner) {
+		scanner.authToken = value
+	}
+}
+
+// WithHTTPClient sets the client the webhook is called with. Defaults to http.DefaultClient.
+func WithHTTPClient(value *http.Client) WebhookInitOption {
+	return func(scanner *WebhookScanner) {
+		scanner.httpClient = value
+	}
+}
+
+// NewWebhookScanner initializes and returns a new instance of WebhookScanner calling endpoint.
+func NewWebhookScanner(endpoint string, optionsProto ...WebhookInitOption) *WebhookScanner {
+	scanner := &WebhookScanner{
+		endpoint:   endpoint,
+		httpClient: http.DefaultClient,
+	}
+	for _, protoOption := range optionsProto {
+		protoOption(scanner)
+	}
+
+	return scanner
+}
+
+// Scan asks the webhook whether the URL is malicious.
+func (s *WebhookScanner) Scan(ctx context.Context, rawURL string) error {
+	requestBody, err := json.Marshal(webhookRequest{URL: rawURL})
+	if err != nil {
+		return fmt.Errorf("in internal/urlscanner/webhook.go/Scan(): error while `json.Marshal()` calling: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return fmt.Errorf("in internal/urlscanner/webhook.go/Scan(): error while `http.NewRequestWithContext()` calling: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if s.authToken != "" {
+		request.Header.Set("Authorization", "Bearer "+s.authToken)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("in internal/urlscanner/webhook.go/Scan(): error while `s.httpClient.Do()` calling: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("in internal/urlscanner/webhook.go/Scan(): the webhook answered with %d", response.StatusCode)
+	}
+
+	var responseDTO webhookResponse
+	err = json.NewDecoder(io.LimitReader(response.Body, maxWebhookResponseSize)).Decode(&responseDTO)
+	if err != nil {
+		return fmt.Errorf("in internal/urlscanner/webhook.go/Scan(): error while decoding the webhook answer: %w", err)
+	}
+
+	if responseDTO.Malicious {
+		return newMaliciousURLError(responseDTO.Reason)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+// Package urlspurger permanently removes the soft-deleted URLs once their restore grace
+// period is over.
+package urlspurger
+
+import (
+	"context"
+	"time"
+
+	"github.com/patric-chuzhbe/urlshrt/internal/logger"
+)
+
+type userUrlsKeeper interface {
+	PurgeDeletedUrls(ctx context.Context, deletedBefore time.Time) (int64, error)
+}
+
+// URLsPurger permanently removes deleted URLs once their restore grace period is over.
+// It runs the purge periodically in the background.
+type URLsPurger struct {
+	db            userUrlsKeeper
+	gracePeriod   time.Duration
+	purgeInterval time.Duration
+	errorChannel  chan error
+}
+
+// New initializes and returns a new instance of URLsPurger.
+// A zero gracePeriod keeps deleted URLs forever.
+func New(
+	db userUrlsKeeper,
+	gracePeriod time.Duration,
+	purgeInterval time.Duration,
+) *URLsPurger {
+	return &URLsPurger{
+		db:            db,
+		gracePeriod:   gracePeriod,
+		purgeInterval: purgeInterval,
+		errorChannel:  make(chan error, 1),
+	}
+}
+
+// ListenErrors starts a goroutine that listens for errors from the internal
+// error channel and passes them to the provided callback function.
+//
+// The callback is invoked for each error as it arrives. This method returns immediately,
+// and the listening continues in the background.
+func (p *URLsPurger) ListenErrors(callback func(error)) {
+	go func() {
+		for err := range p.errorChannel {
+			callback(err)
+		}
+	}()
+}
+
+// Run starts a background goroutine that periodically purges the URLs deleted longer than
+// the grace period ago. The method returns immediately and continues processing in the background
+// until the provided context is canceled.
+func (p *URLsPurger) Run(ctx context.Context) {
+	if p.gracePeriod == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(p.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Log.Infoln("URLsPurger.Run() stopped")
+				return
+			case <-ticker.C:
+				purged, err := p.db.PurgeDeletedUrls(ctx, time.Now().Add(-p.gracePeriod))
+				if err != nil {
+					p.errorChannel <- err
+					continue
+				}
+				if purged > 0 {
+					logger.Log.Infof("purged %d deleted URLs", purged)
+				}
+			}
+		}
+	}()
+}
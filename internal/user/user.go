@@ -2,9 +2,38 @@
 // particularly for authentication and user-specific URL storage.
 package user
 
+// User role constants. See every constant description.
+const (
+	// RoleUser is the role of the regular users. Users stored without a role have it.
+	RoleUser = "user"
+
+	// RoleAdmin is the role of the users allowed to use the admin API.
+	RoleAdmin = "admin"
+)
+
 // User represents a system user.
 // It contains the unique identifier used to associate shortened URLs and sessions.
 type User struct {
 	// ID is the unique identifier of the user, meaning a UUID.
 	ID string
+
+	// Role is one of the user role constants.
+	Role string
+
+	// Email is the lowercase email the user signs in with, empty for anonymous users.
+	Email string
+
+	// PasswordHash is the bcrypt hash of the user's password, empty for anonymous users.
+	PasswordHash string
+}
+
+// IsAdmin tells whether the user is allowed to use the admin API.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// IsRegistered tells whether the user has an account to sign in with, as opposed to an anonymous user
+// known by their token only.
+func (u *User) IsRegistered() bool {
+	return u.Email != ""
 }
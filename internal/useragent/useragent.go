@@ -0,0 +1,87 @@
+// Package useragent tells the operating system and the browser family of a client
+// by its User-Agent header. It only recognizes the families redirect rules match on.
+package useragent
+
+import "strings"
+
+// Operating systems recognized by Parse.
+const (
+	OSIOS     = "ios"
+	OSAndroid = "android"
+	OSWindows = "windows"
+	OSMacOS   = "macos"
+	OSLinux   = "linux"
+)
+
+// Browser families recognized by Parse. FamilyBot stands for crawlers and other robots.
+const (
+	FamilyChrome  = "chrome"
+	FamilyFirefox = "firefox"
+	FamilySafari  = "safari"
+	FamilyEdge    = "edge"
+	FamilyOpera   = "opera"
+	FamilyBot     = "bot"
+)
+
+// Info describes a client. A field is empty if it was not recognized.
+type Info struct {
+	OS     string // One of the OS constants
+	Family string // One of the Family constants
+}
+
+// Parse returns what it recognizes of the client by its User-Agent header.
+// The checks go from the most specific tokens to the most generic ones, since
+// user agents mention the browsers and systems they claim compatibility with.
+func Parse(userAgent string) Info {
+	return Info{
+		OS:     parseOS(userAgent),
+		Family: parseFamily(userAgent),
+	}
+}
+
+func parseOS(userAgent string) string {
+	switch {
+	case containsAny(userAgent, "iPhone", "iPad", "iPod"):
+		return OSIOS
+	case strings.Contains(userAgent, "Android"):
+		return OSAndroid
+	case strings.Contains(userAgent, "Windows"):
+		return OSWindows
+	case containsAny(userAgent, "Macintosh", "Mac OS X"):
+		return OSMacOS
+	case strings.Contains(userAgent, "Linux"):
+		return OSLinux
+	default:
+		return ""
+	}
+}
+
+func parseFamily(userAgent string) string {
+	lowered := strings.ToLower(userAgent)
+	switch {
+	case containsAny(lowered, "bot", "crawler", "spider", "slurp"):
+		return FamilyBot
+	case containsAny(userAgent, "Edg/", "Edge/", "EdgiOS/", "EdgA/"):
+		return FamilyEdge
+	case containsAny(userAgent, "OPR/", "Opera"):
+		return FamilyOpera
+	case containsAny(userAgent, "Firefox/", "FxiOS/"):
+		return FamilyFirefox
+	case containsAny(userAgent, "Chrome/", "CriOS/", "Chromium/"):
+		return FamilyChrome
+	case strings.Contains(userAgent, "Safari/"):
+		return FamilySafari
+	default:
+		return ""
+	}
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE url_redirects ADD COLUMN deleted_at TIMESTAMPTZ NULL;
+ALTER TABLE users_urls ADD COLUMN deleted_at TIMESTAMPTZ NULL;
+
+-- URLs deleted before the column existed start their restore grace period now.
+UPDATE url_redirects SET deleted_at = now() WHERE is_deleted;
+UPDATE users_urls SET deleted_at = now() WHERE is_deleted;
+
+CREATE INDEX ix_url_redirects_deleted_at ON url_redirects (deleted_at) WHERE is_deleted;
+CREATE INDEX ix_users_urls_deleted_at ON users_urls (deleted_at) WHERE is_deleted;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX ix_users_urls_deleted_at;
+DROP INDEX ix_url_redirects_deleted_at;
+
+ALTER TABLE users_urls DROP COLUMN deleted_at;
+ALTER TABLE url_redirects DROP COLUMN deleted_at;
+-- +goose StatementEnd
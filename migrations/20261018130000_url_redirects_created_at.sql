@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE url_redirects ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX ix_url_redirects_created_at ON url_redirects (created_at, short);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX ix_url_redirects_created_at;
+
+ALTER TABLE url_redirects DROP COLUMN created_at;
+-- +goose StatementEnd
@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    ADD COLUMN updated_at  TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    ADD COLUMN created_by  UUID         NULL,
+    ADD COLUMN title       VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN description TEXT         NOT NULL DEFAULT '';
+
+ALTER TABLE url_redirects
+    ADD CONSTRAINT FK_URL_REDI_CREATED_BY_REFERENCE_USERS FOREIGN KEY (created_by)
+        REFERENCES users (user_id)
+        ON DELETE SET NULL ON UPDATE CASCADE;
+
+UPDATE url_redirects SET updated_at = created_at;
+
+-- The creator of an existing link is known only for owned links and shared links used by a single user.
+UPDATE url_redirects
+    SET created_by = owner_id
+    WHERE owner_id IS NOT NULL;
+
+UPDATE url_redirects
+    SET created_by = single_users.user_id
+    FROM (
+        SELECT short, (array_agg(user_id))[1] AS user_id
+            FROM users_urls
+            GROUP BY short
+            HAVING COUNT(*) = 1
+    ) AS single_users
+    WHERE url_redirects.short = single_users.short
+        AND url_redirects.owner_id IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects DROP CONSTRAINT FK_URL_REDI_CREATED_BY_REFERENCE_USERS;
+
+ALTER TABLE url_redirects
+    DROP COLUMN description,
+    DROP COLUMN title,
+    DROP COLUMN created_by,
+    DROP COLUMN updated_at;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Zero redirect_code means the globally configured redirect status code is used.
+ALTER TABLE url_redirects
+    ADD COLUMN redirect_code SMALLINT NOT NULL DEFAULT 0;
+
+ALTER TABLE url_redirects
+    ADD CONSTRAINT CK_URL_REDI_REDIRECT_CODE CHECK (redirect_code IN (0, 301, 302, 307, 308));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    DROP CONSTRAINT CK_URL_REDI_REDIRECT_CODE,
+    DROP COLUMN redirect_code;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    ADD COLUMN passthrough VARCHAR(16) NOT NULL DEFAULT 'off';
+
+ALTER TABLE url_redirects
+    ADD CONSTRAINT CK_URL_REDI_PASSTHROUGH CHECK (passthrough IN ('off', 'query', 'query_and_path'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    DROP CONSTRAINT CK_URL_REDI_PASSTHROUGH,
+    DROP COLUMN passthrough;
+-- +goose StatementEnd
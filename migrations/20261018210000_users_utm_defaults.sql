@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE users_utm_defaults
+(
+    user_id  UUID         NOT NULL,
+    source   VARCHAR(255) NOT NULL DEFAULT '',
+    medium   VARCHAR(255) NOT NULL DEFAULT '',
+    campaign VARCHAR(255) NOT NULL DEFAULT '',
+    term     VARCHAR(255) NOT NULL DEFAULT '',
+    content  VARCHAR(255) NOT NULL DEFAULT '',
+    CONSTRAINT PK_USERS_UTM_DEFAULTS PRIMARY KEY (user_id)
+);
+
+ALTER TABLE users_utm_defaults
+    ADD CONSTRAINT FK_USERS_UT_REFERENCE_USERS FOREIGN KEY (user_id)
+        REFERENCES users (user_id)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE users_utm_defaults;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Weighted A/B variants the visitors are split across instead of original_url, see models.Destination.
+ALTER TABLE url_redirects
+    ADD COLUMN destinations JSONB NOT NULL DEFAULT '[]';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE url_redirects
+    DROP COLUMN destinations;
+-- +goose StatementEnd
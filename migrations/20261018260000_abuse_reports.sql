@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A link disabled by the moderators stops redirecting for everyone, independently of its deletion.
+ALTER TABLE url_redirects
+    ADD COLUMN disabled_at TIMESTAMPTZ NULL;
+
+CREATE TABLE abuse_reports
+(
+    id          BIGSERIAL     NOT NULL,
+    short       VARCHAR(255)  NOT NULL,
+    reporter_id UUID          NULL,
+    reason      VARCHAR(1024) NOT NULL DEFAULT '',
+    status      VARCHAR(16)   NOT NULL DEFAULT 'pending',
+    created_at  TIMESTAMPTZ   NOT NULL DEFAULT now(),
+    CONSTRAINT PK_ABUSE_REPORTS PRIMARY KEY (id),
+    CONSTRAINT CK_ABUSE_REPORTS_STATUS CHECK (status IN ('pending', 'dismissed', 'actioned'))
+);
+
+-- A user has at most one pending report per link.
+CREATE UNIQUE INDEX ux_abuse_reports_pending_short_reporter_id ON abuse_reports (short, reporter_id)
+    WHERE status = 'pending';
+
+ALTER TABLE abuse_reports
+    ADD CONSTRAINT FK_ABUSE_RE_REFERENCE_URL_REDI FOREIGN KEY (short)
+        REFERENCES url_redirects (short)
+        ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE abuse_reports
+    ADD CONSTRAINT FK_ABUSE_RE_REFERENCE_USERS FOREIGN KEY (reporter_id)
+        REFERENCES users (user_id)
+        ON DELETE SET NULL ON UPDATE CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE abuse_reports;
+
+ALTER TABLE url_redirects
+    DROP COLUMN disabled_at;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Registered users have an email and a password, anonymous ones have neither.
+ALTER TABLE users
+    ADD COLUMN email         VARCHAR(254) NULL,
+    ADD COLUMN password_hash VARCHAR(255) NULL;
+
+CREATE UNIQUE INDEX ux_users_email ON users (email);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX ux_users_email;
+
+ALTER TABLE users
+    DROP COLUMN email,
+    DROP COLUMN password_hash;
+-- +goose StatementEnd
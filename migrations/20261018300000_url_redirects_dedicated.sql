@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A dedicated link carries options of its own (a password, a clicks budget, ...), so it is
+-- never handed out to another shortening of the same original URL.
+ALTER TABLE url_redirects
+    ADD COLUMN dedicated BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE url_redirects
+    SET dedicated = TRUE
+    WHERE password_hash <> ''
+        OR max_clicks > 0
+        OR active_from IS NOT NULL
+        OR redirect_code <> 0
+        OR passthrough <> 'off'
+        OR interstitial
+        OR redirect_rules <> '[]'
+        OR destinations <> '[]';
+
+DROP INDEX uq_shared_original_url_hash;
+DROP INDEX uq_owned_original_url_hash;
+CREATE UNIQUE INDEX uq_shared_original_url_hash ON url_redirects (original_url_hash)
+    WHERE owner_id IS NULL AND NOT dedicated;
+CREATE UNIQUE INDEX uq_owned_original_url_hash ON url_redirects (original_url_hash, owner_id)
+    WHERE owner_id IS NOT NULL AND NOT dedicated;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Fails while several links of the same original URL exist: they must be removed first.
+DROP INDEX uq_owned_original_url_hash;
+DROP INDEX uq_shared_original_url_hash;
+CREATE UNIQUE INDEX uq_shared_original_url_hash ON url_redirects (original_url_hash) WHERE owner_id IS NULL;
+CREATE UNIQUE INDEX uq_owned_original_url_hash ON url_redirects (original_url_hash, owner_id) WHERE owner_id IS NOT NULL;
+
+ALTER TABLE url_redirects
+    DROP COLUMN dedicated;
+-- +goose StatementEnd